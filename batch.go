@@ -0,0 +1,116 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"time"
+)
+
+// batchCommand is a redis command added to the Batch
+type batchCommand struct {
+	kind    operationPrefix
+	payload payload
+}
+
+// Batch collects redis commands to be queued at once with Submit,
+// all commands of the batch are executed in the same pipeline
+type Batch struct {
+	cache *cache
+	cmds  []batchCommand
+}
+
+// Submit queues all commands of the batch and returns their futures in the order commands were added,
+// the batch may be submitted again to queue the same commands once more
+func (b *Batch) Submit(ctx context.Context) []*Future {
+	handles := b.cache.hookedBatch(ctx, b.cmds, b.cache.enqueueBatch(ctx, b.cmds))
+	futures := make([]*Future, len(handles))
+	for i, h := range handles {
+		futures[i] = newFuture(h)
+	}
+	return futures
+}
+
+// SubmitTx executes all commands of the batch atomically in a single MULTI/EXEC transaction and returns their futures
+// in the order commands were added, commands are executed by redis client directly, so they're not coalesced
+// with queued ones, in redis cluster commands are atomic per hash slot only
+func (b *Batch) SubmitTx(ctx context.Context) []*Future {
+	handles := b.cache.hookedBatch(ctx, b.cmds, b.cache.submitTx(ctx, b.cmds))
+	futures := make([]*Future, len(handles))
+	for i, h := range handles {
+		futures[i] = newFuture(h)
+	}
+	return futures
+}
+
+// Len returns the number of commands in the batch
+func (b *Batch) Len() int {
+	return len(b.cmds)
+}
+
+func (b *Batch) add(kind operationPrefix, p payload) *Batch {
+	b.cmds = append(b.cmds, batchCommand{kind: kind, payload: p})
+	return b
+}
+
+func (b *Batch) HDel(key string, fields ...string) *Batch {
+	return b.add(HDel, transformHDel(key, fields...))
+}
+
+func (b *Batch) Expire(key string, expiration time.Duration) *Batch {
+	return b.add(Expire, transformExpire(key, expiration))
+}
+
+func (b *Batch) HGet(key, field string) *Batch {
+	return b.add(HGet, transformHGet(key, field))
+}
+
+func (b *Batch) HGetAll(key string) *Batch {
+	return b.add(HGetAll, transformHGetAll(key))
+}
+
+func (b *Batch) Get(key string) *Batch {
+	return b.add(Get, transformGet(key))
+}
+
+func (b *Batch) Del(keys ...string) *Batch {
+	return b.add(Del, transformDel(keys...))
+}
+
+func (b *Batch) SMembers(key string) *Batch {
+	return b.add(SMembers, transformSMembers(key))
+}
+
+func (b *Batch) MGet(keys ...string) *Batch {
+	return b.add(MGet, transformMGet(keys...))
+}
+
+func (b *Batch) EvalRO(script string, keys []string, args ...interface{}) *Batch {
+	return b.add(EvalRO, transformScript(script, keys, args...))
+}
+
+func (b *Batch) FCallRO(function string, keys []string, args ...interface{}) *Batch {
+	return b.add(FCallRO, transformScript(function, keys, args...))
+}
+
+func (b *Batch) Exists(keys ...string) *Batch {
+	return b.add(Exists, transformExists(keys...))
+}
+
+func (b *Batch) Time() *Batch {
+	return b.add(Time, transformTime())
+}
+
+func (b *Batch) Echo(message interface{}) *Batch {
+	return b.add(Echo, transformEcho(message))
+}
+
+func (b *Batch) SafeDel(key, expectedType string) *Batch {
+	return b.add(SafeDel, transformSafeDel(key, expectedType))
+}
+
+func (b *Batch) Set(key string, value interface{}, expiration time.Duration) *Batch {
+	return b.add(Set, transformSet(key, value, expiration))
+}
+
+func (b *Batch) Do(args ...interface{}) *Batch {
+	return b.add(Do, transformDo(args...))
+}
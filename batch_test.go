@@ -0,0 +1,260 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBatchSubmit(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("a").SetVal("john")
+	mock.ExpectHGet("h", "f").SetVal("jane")
+	mock.ExpectDel("b").SetVal(1)
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	b := c.NewBatch()
+	b.Get("a").HGet("h", "f")
+	b.Del("b")
+	assert.Equal(t, 3, b.Len())
+
+	results := b.Submit(ctx)
+	assert.Len(t, results, 3)
+	cmd, err := results[0].Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "john", cmd.(*redis.StringCmd).Val())
+	cmd, err = results[1].Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "jane", cmd.(*redis.StringCmd).Val())
+	cmd, err = results[2].Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), cmd.(*redis.IntCmd).Val())
+
+	// all commands are executed in the same pipeline
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.Pipelines)
+	assert.Equal(t, uint64(3), stats.Commands)
+}
+
+func TestGroup(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key").SetVal("john")
+	mock.ExpectExpire("key", time.Minute).SetVal(true)
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(1))
+	assert.Nil(t, err)
+
+	// size threshold is exceeded by the group, but it's flushed as a whole
+	results := c.Group(ctx, func(b *Batch) {
+		b.Get("key")
+		b.Expire("key", time.Minute)
+	})
+	assert.Len(t, results, 2)
+	cmd, err := results[0].Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "john", cmd.(*redis.StringCmd).Val())
+	cmd, err = results[1].Await(ctx)
+	assert.Nil(t, err)
+	assert.True(t, cmd.(*redis.BoolCmd).Val())
+	assert.Equal(t, uint64(1), c.Stats().Pipelines)
+}
+
+func TestBatchStopped(t *testing.T) {
+	var ctx = context.TODO()
+	db, _ := redismock.NewClientMock()
+
+	c, err := NewAutoPipeline(db)
+	assert.Nil(t, err)
+	c.Stop()
+
+	results := c.NewBatch().Get("a").Time().Submit(ctx)
+	assert.Len(t, results, 2)
+	for _, f := range results {
+		assert.ErrorIs(t, f.Err(), ErrCacheStopped)
+	}
+}
+
+func TestGroupNotSplit(t *testing.T) {
+	ctx := context.TODO()
+	// keys of the group are served by different nodes
+	var get, del string
+	for i := 0; get == "" || del == ""; i++ {
+		key := fmt.Sprintf("group%d", i)
+		if node, _ := twoNodes(ctx, key); node == "a" && get == "" {
+			get = key
+		} else if node == "b" && del == "" {
+			del = key
+		}
+	}
+
+	tests := []struct {
+		name    string
+		options []func(a *Autopipeline)
+	}{
+		{name: "max commands per pipeline", options: []func(a *Autopipeline){WithMaxCommandsPerPipeline(1)}},
+		{name: "workers", options: []func(a *Autopipeline){WithWorkers(2)}},
+		{
+			name: "node batching",
+			options: []func(a *Autopipeline){WithNodeBatching(true), func(a *Autopipeline) {
+				a.cnf.masterForKey = twoNodes
+			}},
+		},
+		{name: "replica reads", options: []func(a *Autopipeline){WithReplicaReads(true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &pipelinesHook{}
+			db := redis.NewClient(&redis.Options{})
+			db.AddHook(hook)
+			c, err := NewAutoPipeline(db, append(tt.options, WithCacheTTL(time.Microsecond*100))...)
+			assert.Nil(t, err)
+			defer c.Stop()
+
+			c.Pause()
+			resChs := make([]<-chan interface{}, 4)
+			for i := range resChs {
+				resChs[i] = c.GetAsync(ctx, fmt.Sprintf("key%d", i))
+			}
+			futures := c.Group(ctx, func(b *Batch) {
+				b.Get(get)
+				b.Del(del)
+			})
+			c.Resume()
+			for _, resCh := range resChs {
+				<-resCh
+			}
+			cmd, err := futures[0].Await(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, get, cmd.(*redis.StringCmd).Val())
+			cmd, err = futures[1].Await(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(1), cmd.(*redis.IntCmd).Val())
+
+			// flush is split, but commands of the group are executed in the same pipeline
+			pipelines := hook.get()
+			assert.Greater(t, len(pipelines), 1)
+			for _, pipeline := range pipelines {
+				if slices.Contains(pipeline, get) {
+					assert.Contains(t, pipeline, del)
+				}
+			}
+		})
+	}
+}
+
+func TestChunk(t *testing.T) {
+	a, b := pipeGroup{node: "a"}, pipeGroup{node: "b"}
+	tests := []struct {
+		name      string
+		ops       []gatheredOp
+		limit     int
+		grouped   bool
+		wantPipes []int
+		wantSizes []int
+	}{
+		{
+			name:      "unlimited",
+			ops:       []gatheredOp{{}, {}, {}},
+			wantPipes: []int{0, 0, 0},
+			wantSizes: []int{3},
+		},
+		{
+			name:      "limited",
+			ops:       []gatheredOp{{}, {}, {}},
+			limit:     2,
+			wantPipes: []int{0, 0, 1},
+			wantSizes: []int{2, 1},
+		},
+		{
+			name:      "batch isn't split",
+			ops:       []gatheredOp{{}, {batches: []uint64{1}}, {}, {batches: []uint64{1}}},
+			limit:     2,
+			wantPipes: []int{0, 1, 2, 1},
+			wantSizes: []int{1, 2, 1},
+		},
+		{
+			name:      "batch exceeds limit",
+			ops:       []gatheredOp{{batches: []uint64{1}}, {batches: []uint64{1}}, {batches: []uint64{1}}},
+			limit:     2,
+			wantPipes: []int{0, 0, 0},
+			wantSizes: []int{3},
+		},
+		{
+			name: "batches sharing command",
+			ops: []gatheredOp{
+				{batches: []uint64{1}}, {batches: []uint64{2}}, {batches: []uint64{1, 2}}, {batches: []uint64{3}},
+			},
+			limit:     1,
+			wantPipes: []int{0, 0, 0, 1},
+			wantSizes: []int{3, 1},
+		},
+		{
+			name:      "pipelines by target",
+			ops:       []gatheredOp{{group: a}, {group: b}, {group: a}},
+			grouped:   true,
+			wantPipes: []int{0, 1, 0},
+			wantSizes: []int{2, 1},
+		},
+		{
+			name: "batch of targets",
+			ops: []gatheredOp{
+				{group: a}, {group: a, batches: []uint64{1}}, {group: b, batches: []uint64{1}},
+			},
+			grouped:   true,
+			wantPipes: []int{0, 1, 1},
+			wantSizes: []int{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantSizes, chunk(tt.ops, tt.limit, tt.grouped))
+			pipes := make([]int, len(tt.ops))
+			for i, op := range tt.ops {
+				pipes[i] = op.pipe
+			}
+			assert.Equal(t, tt.wantPipes, pipes)
+		})
+	}
+}
+
+func TestGroupNotJoinedToExecutedPipeline(t *testing.T) {
+	ctx := context.TODO()
+	hook := &gateHook{started: make(chan struct{}, 2), release: make(chan struct{})}
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(hook)
+	c, err := NewAutoPipeline(db, WithCacheTTL(time.Microsecond*100))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	resCh := c.GetAsync(ctx, "key")
+	<-hook.started
+	// identical command of the group isn't joined to the pipeline being executed, as the other one isn't there
+	futures := c.Group(ctx, func(b *Batch) {
+		b.Get("key")
+		b.Get("other")
+	})
+	close(hook.release)
+	assert.Equal(t, "key", (<-resCh).(*redis.StringCmd).Val())
+	for i, want := range []string{"key", "other"} {
+		cmd, err := futures[i].Await(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, want, cmd.(*redis.StringCmd).Val())
+	}
+	assert.Equal(t, uint64(2), c.Stats().Pipelines)
+	assert.Equal(t, uint64(3), c.Stats().Commands)
+}
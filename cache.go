@@ -4,6 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/maphash"
+	"io"
+	"math"
+	"math/rand"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -11,207 +19,2044 @@ import (
 
 // redisOperation is a main and only one member of cache storage
 // it contains type of redis command (kind)
-// arguments of redis command (args)
+// typed arguments of redis command (payload)
 // and list of receivers of redis command (listeners)
 type redisOperation struct {
-	args      []string           // arguments of redis command, f.e. for HGetAll("prefix") argument is "prefix"
+	payload   payload            // arguments of redis command, f.e. for HGetAll("prefix") key of payload is "prefix"
 	listeners []chan interface{} // collection of listeners awaiting redis operation to be executed. Result goes here.
 	kind      operationPrefix    // redis command, e.g. HSet, Del, HGet and so on
+	created   time.Time          // time when operation was put to the storage
+	awaiters  int                // number of handles awaiting the result, see Handle
+	done      chan struct{}      // closed once result is set, created for the first awaiter only
+	result    interface{}        // result of redis command for awaiters
+	callers   []context.Context  // contexts of callers, tracked only if duplicates are not coalesced silently
+	admitted  []context.Context  // contexts of listeners and awaiters admitted by admitter, released on delivery
+	deadline  time.Time          // earliest deadline of callers contexts, tracked only if deadline propagation is enabled
+	cmd       redis.Cmder        // pre-built redis command, which is pipelined verbatim, see EnqueueCmd
+	size      int64              // estimated size of serialized command, tracked only if max batch bytes is set
+	seq       uint64             // number of the latest call of Set, which queued or joined the operation, see setBatch
+	retries   int                // number of times read-only operation was requeued after transient errors
+	switched  bool               // operation was requeued after switch of redis client, see WithStandby
+	key       string             // key of operation in shard storage, which may differ from its hash, see dedupWindow
+	inflight  bool               // operation is gathered to the pipeline being executed, it's taken once executed
+	batches   []uint64           // batches, which commands the operation serves, they're executed in the same pipeline
+}
+
+// waiter is a location of operation, which listener channel awaits the result of, see abandon
+type waiter struct {
+	lane *cache
+	key  string
+}
+
+// estimateSize returns the size of redis command serialized as RESP array of bulk strings,
+// f.e. GET key is "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n"
+func estimateSize(kind operationPrefix, args []string) int64 {
+	size := bulkSize(len(kind.String())) + int64(len(strconv.Itoa(len(args)+1))) + 3
+	for _, arg := range args {
+		size += bulkSize(len(arg))
+	}
+	return size
+}
+
+// bulkSize returns the size of RESP bulk string of n bytes
+func bulkSize(n int) int64 {
+	return int64(len(strconv.Itoa(n)) + n + 5)
+}
+
+// shard is a partition of cache storage with its own mutex, operations are distributed between shards by hash,
+// so enqueues of different commands don't contend on the same mutex
+type shard struct {
+	mx      *sync.RWMutex              // mutex of shard storage
+	storage map[string]*redisOperation // storage of scheduled redis command to be pipelined
+	peak    int                        // max number of operations in storage since it was created, see shrink
+	latest  map[string]string          // storage keys of the latest operations by hash, if they differ, see dedupWindow
+}
+
+const (
+	// shrinkMinPeak is the min peak number of operations in shard storage to recreate it, smaller maps are kept
+	shrinkMinPeak = 1024
+	// shrinkRatio is how many times storage should be smaller than at its peak to be recreated
+	shrinkRatio = 4
+)
+
+// shrink recreates storage of shard, if it's drained after a large batch, as map only grows and never shrinks,
+// so long-running process doesn't retain memory of traffic spike forever, mutex of shard should be locked
+func (sh *shard) shrink() bool {
+	if sh.peak < shrinkMinPeak || len(sh.storage) > sh.peak/shrinkRatio {
+		return false
+	}
+	storage := make(map[string]*redisOperation, len(sh.storage))
+	for hash, op := range sh.storage {
+		storage[hash] = op
+	}
+	sh.storage = storage
+	sh.peak = len(storage)
+	return true
 }
 
 // cache is a core structure of this package
 // it contains storage, cache params and methods to use them all
 type cache struct {
-	mx                   *sync.RWMutex              // shared mutex
-	client               *redis.Client              // go-redis client
-	storage              map[string]*redisOperation // storage of scheduled redis command to be pipelined
-	activeListeners      atomic.Int32               // number of active listeners in storage
-	storageThresholdSize int32                      // number of stored redis requests to run redis pipeline
-	storageThresholdTime time.Duration              // time interval to run redis pipeline
-	runInterval          time.Duration              // sleep time between checks to run pipeline
-	lastPipeline         atomic.Int64               // execution time of last redis pipeline, in microseconds
-	log                  Logger                     // logger interface
-	done                 atomic.Bool                // marks this cache instance as stopped
+	batchMx              *sync.RWMutex                // keeps runPipeline from sweeping shards while batch is enqueued
+	endpoints            *endpoints                   // go-redis clients, commands are executed by the one in use
+	executor             Executor                     // builds and executes pipelines
+	middleware           *middlewareChain             // middlewares of commands and pipelines, nil if disabled
+	hooks                []redis.Hook                 // hooks run around every logical command, nil if disabled
+	forwards             *sync.Map                    // listener channels by result channels of callers, see hooked
+	waiters              *sync.Map                    // lanes and storage keys of operations by listener channels, see abandon
+	transactional        bool                         // execute every pipeline as MULTI/EXEC transaction
+	shards               []*shard                     // partitions of storage of scheduled redis commands
+	seed                 maphash.Seed                 // seed of hash, which distributes operations between shards
+	noDedup              map[operationPrefix]struct{} // operations, identical commands of which are not coalesced
+	unique               atomic.Uint64                // sequence, which makes hashes of operations excluded from deduplication unique
+	batchIDs             atomic.Uint64                // sequence of ids of batches, see Batch
+	dedupWindow          time.Duration                // max age of operation, which new listeners are attached to, zero means no limit
+	operations           atomic.Int32                 // number of operations in all shards
+	queue                *commandQueue                // lock-free ingestion of commands, nil if disabled
+	activeListeners      atomic.Int32                 // number of active listeners in storage
+	storageThresholdSize atomic.Int32                 // number of stored redis requests to run redis pipeline
+	storageThresholdTime atomic.Int64                 // time interval to run redis pipeline, time.Duration
+	runInterval          atomic.Int64                 // sleep time between checks to run pipeline, time.Duration
+	operationTTL         time.Duration                // max lifetime of operation in storage, 0 means unlimited
+	splitCrossSlot       bool                         // split multi-key commands with keys in different hash slots
+	coalesceGets         bool                         // execute Get commands of single pipeline as MGet
+	mergeDels            bool                         // execute Del commands of single pipeline as a single Del
+	mergeSets            bool                         // execute plain Set commands of single pipeline as a single MSet
+	mergeHashReads       bool                         // serve HGet commands by HGetAll of the same key
+	duplicatePolicy      DuplicatePolicy              // behavior for identical commands enqueued with the same ctx
+	keyPrefix            string                       // prefix added to every key of queued commands
+	adaptiveTTL          float64                      // factor of round-trip time, which makes ttl window, 0 means static ttl
+	flushJitter          float64                      // max fraction of ttl window, by which ttl trigger is fired earlier, 0 means no jitter
+	jitterSample         atomic.Uint64                // random number in range [0, 1) of the current batch, float64 bits
+	setCalls             atomic.Uint64                // number of Set calls, which orders Sets of the same key merged into MSet
+	maxDelay             time.Duration                // max time between enqueue and pipeline execution, 0 means no limit
+	oldestPending        atomic.Int64                 // enqueue time of the oldest command not gathered to pipeline, unix micro, 0 if none
+	soloGrace            time.Duration                // grace period of solitary command before it's executed, 0 means disabled
+	lastEnqueue          atomic.Int64                 // time of the last enqueued listener, unix micro, tracked only if soloGrace is set
+	maxCommands          int                          // max number of commands in single pipeline, 0 means unlimited
+	workers              int                          // number of pipelines executed concurrently by a single flush
+	deliveryWorkers      int                          // number of goroutines delivering results of a flush, 0 means the flush goroutine
+	delivering           sync.WaitGroup               // delivery workers of the last flush
+	maxBatchBytes        int64                        // estimated size of queued commands to run redis pipeline, 0 means no limit
+	pendingBytes         atomic.Int64                 // estimated size of queued commands, tracked only if maxBatchBytes is set
+	sequencer            *sequencer                   // orders delivery of results per caller ctx, nil if disabled
+	sender               *sender                      // puts results to listener channels without wedging the flush path
+	propagateDeadlines   bool                         // execute pipeline with the earliest deadline of callers
+	admitter             Admitter                     // admission control of commands, nil if disabled
+	limiter              RateLimiter                  // rate limiting of pipeline executions, nil if disabled
+	deadLetterHook       func(DeadLetter)             // receives commands dropped without execution, nil if disabled
+	readRetries          int                          // max number of requeues of read-only operation, 0 means disabled
+	router               *nodeRouter                  // groups commands by cluster node, nil if disabled
+	replicaReads         bool                         // pipeline read-only commands apart from mutating ones
+	failoverPause        time.Duration                // pause of flushing after failover errors, 0 means disabled
+	failoverUntil        time.Time                    // end of the current failover pause, used by background goroutine only
+	tuner                *tuner                       // adjusts ttl and max size to the observed load, nil if disabled
+	batchHook            func(BatchRecord)            // receives records of executed pipelines, nil if disabled
+	onFlush              func(FlushStats)             // receives stats of every flush, nil if disabled
+	execTimeout          time.Duration                // timeout of pipeline execution, 0 means no timeout
+	injectedLatency      time.Duration                // artificial delay of pipeline execution, 0 means no delay
+	debugSleep           time.Duration                // duration of DEBUG SLEEP added to pipeline, 0 means no command
+	lastFlush            atomic.Int64                 // execution time of last successful redis pipeline, in microseconds, 0 if none
+	lastError            atomic.Pointer[error]        // error of last failed redis pipeline, nil if none
+	log                  Logger                       // logger interface
+	logLevel             LogLevel                     // min level of messages logged
+	clock                Clock                        // source of time
+	wait                 waitFunc                     // waiting primitive between checks to run pipeline
+	dumpWriter           io.Writer                    // destination of crash dumps, nil if disabled
+	dumpEncoder          SnapshotEncoder              // serializer of crash dumps
+	done                 atomic.Bool                  // marks this cache instance as stopped
+	paused               atomic.Bool                  // marks this cache instance as paused, pipelines are not executed
+	pauseLimit           int32                        // max number of active listeners while cache is paused
+	maxPending           int32                        // max number of active listeners, 0 means no limit
+	overflowPolicy       OverflowPolicy               // behavior for commands, which find the cache full
+	backpressure         *backpressure                // blocked callers of full cache, nil if max pending is disabled
+	ctx                  context.Context              // context of background goroutine
+	lifecycleMx          *sync.Mutex                  // protects start and stop of background goroutine
+	stopCh               chan struct{}                // closed to stop background goroutine
+	finished             chan struct{}                // closed once background goroutine is finished
+	stats                *stats                       // batching metrics
+	writes               *cache                       // lane of mutating commands, nil if disabled, see WithWriteLane
+	wake                 chan struct{}                // signals background goroutine to check pipeline triggers
+	timer                Timer                        // timer of background goroutine, used by it only
 }
 
 // newCache returns a pointer to a new cache storage
-// and runs it in background
-func newCache(c *redis.Client, ctx context.Context, ttl, runInterval time.Duration, size uint, l Logger) *cache {
+// and runs it in background, mutating commands are queued to the separate cache, if write lane is enabled
+func newCache(c redis.UniversalClient, cnf *config) *cache {
+	e := newEndpoints(c, cnf.standby, cnf.standbyFailures, cnf.configChanged)
+	st := newStats()
+	st.sink = newSink(cnf.metricsSinks)
+	var chain *middlewareChain
+	if len(cnf.middlewares) > 0 {
+		chain = newMiddlewareChain(cnf.middlewares, cnf.executor)
+	}
+	cc := newLane(e, cnf, st, nil, chain)
+	cc.forwards = &sync.Map{}
+	cc.waiters = &sync.Map{}
+	if cnf.writeLaneTTL > 0 {
+		lane := *cnf
+		lane.ttl = cnf.writeLaneTTL
+		lane.maxSize = cnf.writeLaneSize
+		lane.autoTuning = AutoTuning{}
+		// lanes share clients, metrics, order of results and middlewares
+		cc.writes = newLane(e, &lane, cc.stats, cc.sequencer, chain)
+		cc.writes.forwards = cc.forwards
+		cc.writes.waiters = cc.waiters
+	}
+	return cc
+}
+
+// newLane returns a pointer to a new cache storage with the given clients, metrics, sequencer of results
+// and middlewares, and runs it in background
+func newLane(e *endpoints, cnf *config, st *stats, seq *sequencer, chain *middlewareChain) *cache {
 	cc := cache{
-		client:               c,
-		storage:              make(map[string]*redisOperation),
-		mx:                   &sync.RWMutex{},
-		storageThresholdTime: ttl,
-		storageThresholdSize: int32(size),
-		runInterval:          runInterval,
-		log:                  l,
-	}
-	cc.lastPipeline.Store(time.Now().UnixMicro()) // let's set imaginary pipeline run at startup
-	go cc.run(ctx)
+		endpoints:          e,
+		executor:           cnf.executor,
+		hooks:              cnf.commandHooks,
+		transactional:      cnf.transactional,
+		batchMx:            &sync.RWMutex{},
+		seed:               maphash.MakeSeed(),
+		operationTTL:       cnf.operationTTL,
+		splitCrossSlot:     cnf.splitCrossSlot,
+		coalesceGets:       cnf.coalesceGets,
+		dedupWindow:        cnf.dedupWindow,
+		mergeDels:          cnf.mergeDels,
+		mergeSets:          cnf.mergeSets,
+		mergeHashReads:     cnf.mergeHashReads,
+		duplicatePolicy:    cnf.duplicatePolicy,
+		keyPrefix:          cnf.keyPrefix,
+		adaptiveTTL:        cnf.adaptiveTTL,
+		flushJitter:        cnf.flushJitter,
+		maxDelay:           cnf.maxDelay,
+		soloGrace:          cnf.soloBypass,
+		maxCommands:        int(cnf.maxCommandsPerPipeline),
+		workers:            int(cnf.workers),
+		deliveryWorkers:    int(cnf.deliveryWorkers),
+		maxBatchBytes:      int64(cnf.maxBatchBytes),
+		admitter:           cnf.admitter,
+		limiter:            cnf.limiter,
+		deadLetterHook:     cnf.deadLetterHook,
+		readRetries:        int(cnf.readRetries),
+		failoverPause:      cnf.failoverPause,
+		replicaReads:       cnf.replicaReads,
+		batchHook:          cnf.batchHook,
+		onFlush:            cnf.onFlush,
+		propagateDeadlines: cnf.propagateDeadlines,
+		execTimeout:        cnf.pipelineTimeout(),
+		injectedLatency:    cnf.injectedLatency,
+		debugSleep:         cnf.debugSleep,
+		pauseLimit:         int32(cnf.pauseLimit),
+		maxPending:         int32(cnf.maxPending),
+		overflowPolicy:     cnf.overflowPolicy,
+		log:                cnf.logger,
+		logLevel:           cnf.logLevel,
+		clock:              cnf.clock,
+		dumpWriter:         cnf.dumpWriter,
+		dumpEncoder:        cnf.dumpEncoder,
+		ctx:                cnf.ctx,
+		lifecycleMx:        &sync.Mutex{},
+		stats:              st,
+		wake:               make(chan struct{}, 1),
+	}
+	if cnf.nodeBatching {
+		masterForKey := cnf.masterForKey
+		// nodes of the primary client are used, f.e. if standby isn't a cluster
+		if client, ok := e.clients[0].(*redis.ClusterClient); ok && masterForKey == nil {
+			masterForKey = clusterMasterForKey(client)
+		}
+		if masterForKey != nil {
+			cc.router = newNodeRouter(masterForKey)
+		}
+	}
+	if cnf.autoTuning.enabled() {
+		cc.tuner = newTuner(cnf.autoTuning, cnf.configChanged)
+	}
+	if chain != nil {
+		cc.middleware = chain
+		cc.executor = chain
+	}
+	if cc.maxPending > 0 {
+		cc.backpressure = &backpressure{mx: &sync.Mutex{}, room: make(chan struct{})}
+	}
+	cc.sender = &sender{
+		timeout: cnf.deliveryTimeout,
+		policy:  cnf.dropPolicy,
+		clock:   cnf.clock,
+		log:     cnf.logger,
+		stats:   cc.stats,
+	}
+	for _, name := range cnf.noDedup {
+		if kind, ok := operationKind(name); ok {
+			if cc.noDedup == nil {
+				cc.noDedup = make(map[operationPrefix]struct{})
+			}
+			cc.noDedup[kind] = struct{}{}
+		}
+	}
+	cc.shards = make([]*shard, cnf.storageShards)
+	for i := range cc.shards {
+		cc.shards[i] = &shard{mx: &sync.RWMutex{}, storage: make(map[string]*redisOperation)}
+	}
+	cc.sequencer = seq
+	if cnf.orderedDelivery && seq == nil {
+		cc.sequencer = newSequencer(cc.sender.send)
+	}
+	if cnf.lockFreeEnqueue {
+		cc.queue = &commandQueue{}
+	}
+	cc.wait = cnf.wait
+	if cc.wait == nil {
+		cc.wait = cc.clockWait
+	}
+	cc.setThresholdTime(cnf.ttl)
+	cc.setThresholdSize(cnf.maxSize)
+	cc.setRunInterval(cnf.runInterval)
+	cc.sampleJitter()
+	// error is possible only for already cancelled context, cache stays stopped then
+	_ = cc.start()
 	return &cc
 }
 
+// setThresholdTime sets time interval to run redis pipeline, it's safe to call while cache is running
+// and returns the previous value
+func (c *cache) setThresholdTime(ttl time.Duration) time.Duration {
+	return time.Duration(c.storageThresholdTime.Swap(int64(ttl)))
+}
+
+// setThresholdSize sets number of listeners to run redis pipeline, it's safe to call while cache is running
+// and returns the previous value
+func (c *cache) setThresholdSize(size uint) uint {
+	return uint(c.storageThresholdSize.Swap(int32(size)))
+}
+
+// setRunInterval sets sleep time between checks to run pipeline, it's safe to call while cache is running
+// and returns the previous value
+func (c *cache) setRunInterval(interval time.Duration) time.Duration {
+	return time.Duration(c.runInterval.Swap(int64(interval)))
+}
+
+// start runs background goroutine of the cache, if it's not running yet
+func (c *cache) start() error {
+	c.lifecycleMx.Lock()
+	defer c.lifecycleMx.Unlock()
+	if c.isRunning() {
+		return nil
+	}
+	// cache can't be started with cancelled context
+	if err := c.ctx.Err(); err != nil {
+		c.done.Store(true)
+		return err
+	}
+	c.stopCh = make(chan struct{})
+	c.finished = make(chan struct{})
+	c.done.Store(false)
+	if c.tuner != nil {
+		// rate of pipelines isn't measured across stop
+		c.tuner.last = time.Time{}
+	}
+	go c.run(c.ctx, c.stopCh, c.finished)
+	return nil
+}
+
+// stop stops background goroutine of the cache and waits until last pipeline is executed
+func (c *cache) stop() {
+	c.lifecycleMx.Lock()
+	defer c.lifecycleMx.Unlock()
+	if !c.isRunning() {
+		return
+	}
+	close(c.stopCh)
+	<-c.finished
+}
+
+// running reports whether background goroutine is alive
+func (c *cache) running() bool {
+	c.lifecycleMx.Lock()
+	defer c.lifecycleMx.Unlock()
+	return c.isRunning()
+}
+
+// isRunning reports whether background goroutine is alive, lifecycleMx should be locked
+func (c *cache) isRunning() bool {
+	if c.finished == nil {
+		return false
+	}
+	select {
+	case <-c.finished:
+		return false
+	default:
+		return true
+	}
+}
+
 // run is a controller goroutine, which observe state of storage and triggers runPipeline function
-// if certain conditions met
-func (c *cache) run(ctx context.Context) {
+// if certain conditions met,
+// it works until ctx is done or stop channel is closed
+func (c *cache) run(ctx context.Context, stop, finished chan struct{}) {
+	defer close(finished)
+	// results of the last flush are delivered before the cache is considered stopped
+	defer c.delivering.Wait()
+	defer c.dumpOnPanic()
 	for {
-		select {
-		case <-ctx.Done():
-			// stop receiving new commands
-			c.done.Store(true)
-			// and run pipeline for a last time
-			if c.activeListeners.Load() > 0 {
-				c.runPipeline(ctx)
-			}
+		// put this goroutine to a waiting state until the next flush is due or enqueue signals a trigger,
+		// so idle cache doesn't burn CPU, and commands enqueued meanwhile are executed in the same pipeline
+		// waiting is interrupted as soon as ctx is done or stop channel is closed
+		ok := c.wait(ctx, stop, c.wake, c.nextCheck())
+		// commands of lock-free queue are moved to the storage, so triggers take them into account
+		c.drainQueue()
+		if !ok {
+			c.shutdown(ctx)
+			return
+		}
+		// fail operations which live in storage for too long
+		if c.operationTTL > 0 {
+			c.purgeExpired()
+		}
+		// adjust thresholds to the observed load
+		if c.tuner != nil {
+			c.tune()
+		}
+		// keep commands in storage while paused
+		if c.paused.Load() {
+			continue
+		}
+		// keep commands in storage while master is switched over
+		if c.failingOver(c.clock.Now()) {
+			continue
+		}
+		// check number of listeners threshold
+		if c.activeListeners.Load() > c.storageThresholdSize.Load() {
+			c.runPipeline(ctx, triggerSize)
+			continue
+		}
+		// check estimated size of queued commands, so a few huge commands don't make pathological batch
+		if c.bytesExceeded() {
+			c.runPipeline(ctx, triggerBytes)
+			continue
+		}
+		// check time threshold of the oldest command, timer of nextCheck fires right at it
+		if deadline, ok := c.ttlDeadline(); ok && !deadline.After(c.clock.Now()) && c.activeListeners.Load() > 0 {
+			c.runPipeline(ctx, triggerTTL)
+			continue
+		}
+		// check max delay of the oldest command
+		if deadline, ok := c.delayDeadline(); ok && !deadline.After(c.clock.Now()) {
+			c.runPipeline(ctx, triggerMaxDelay)
+			continue
+		}
+		// execute solitary command without waiting for ttl, if nothing else arrived within grace period
+		if deadline, ok := c.soloDeadline(); ok && !deadline.After(c.clock.Now()) {
+			c.runPipeline(ctx, triggerSolo)
+		}
+	}
+}
+
+// bytesExceeded reports whether estimated size of queued commands exceeds maxBatchBytes
+func (c *cache) bytesExceeded() bool {
+	return c.maxBatchBytes > 0 && c.pendingBytes.Load() > c.maxBatchBytes
+}
+
+// ttlDeadline returns the time, when the oldest pending command waits for ttl window,
+// so latency of commands doesn't depend on how close to each other pipelines are executed,
+// false is returned if there are no pending commands
+func (c *cache) ttlDeadline() (time.Time, bool) {
+	oldest := c.oldestPending.Load()
+	if oldest == 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(oldest).Add(c.jittered(c.window())), true
+}
+
+// jittered shortens ttl window by random fraction up to flushJitter, which is sampled once per batch,
+// so instances started simultaneously don't run pipelines on the same cadence,
+// window is never extended, so ttl stays the max time commands wait
+func (c *cache) jittered(window time.Duration) time.Duration {
+	if c.flushJitter == 0 {
+		return window
+	}
+	sample := math.Float64frombits(c.jitterSample.Load())
+	return window - time.Duration(float64(window)*c.flushJitter*sample)
+}
+
+// sampleJitter samples random jitter of the next batch
+func (c *cache) sampleJitter() {
+	if c.flushJitter > 0 {
+		c.jitterSample.Store(math.Float64bits(rand.Float64()))
+	}
+}
+
+// delayDeadline returns the time, when the oldest pending command waits for maxDelay,
+// false is returned if max delay is disabled or there are no pending commands
+func (c *cache) delayDeadline() (time.Time, bool) {
+	oldest := c.oldestPending.Load()
+	if c.maxDelay == 0 || oldest == 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(oldest).Add(c.maxDelay), true
+}
+
+// trackPending sets enqueue time of the oldest pending command, if the command is older than tracked one
+func (c *cache) trackPending(created time.Time) {
+	t := created.UnixMicro()
+	for {
+		oldest := c.oldestPending.Load()
+		if oldest != 0 && oldest <= t {
 			return
+		}
+		if c.oldestPending.CompareAndSwap(oldest, t) {
+			return
+		}
+	}
+}
+
+// soloDeadline returns the time, when the only pending command is executed without batching,
+// false is returned if solo bypass is disabled or concurrent traffic is detected, so commands are batched as usual
+func (c *cache) soloDeadline() (time.Time, bool) {
+	if c.soloGrace == 0 || c.activeListeners.Load() != 1 {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(c.lastEnqueue.Load()).Add(c.soloGrace), true
+}
+
+// nextCheck returns the time to wait until the next check of pipeline triggers,
+// it's negative if there is nothing to check until enqueue signals the wake channel
+// checks are never more frequent than runInterval, so pipelines are not run in a busy loop
+func (c *cache) nextCheck() time.Duration {
+	if c.activeListeners.Load() == 0 {
+		return -1
+	}
+	interval := time.Duration(c.runInterval.Load())
+	if c.paused.Load() {
+		// operations are only purged while paused
+		if c.operationTTL > 0 {
+			return max(c.operationTTL, interval)
+		}
+		return -1
+	}
+	now := c.clock.Now()
+	if c.failingOver(now) {
+		return max(c.failoverUntil.Sub(now), interval)
+	}
+	// listeners without pending command are possible for a moment only, f.e. while results are delivered
+	next := c.window()
+	if deadline, ok := c.ttlDeadline(); ok {
+		next = deadline.Sub(now)
+	}
+	if deadline, ok := c.delayDeadline(); ok {
+		next = min(next, deadline.Sub(now))
+	}
+	if deadline, ok := c.soloDeadline(); ok {
+		next = min(next, deadline.Sub(now))
+	}
+	return max(next, interval)
+}
+
+// window returns time interval to run redis pipeline, in adaptive mode it's a factor of measured round-trip time,
+// but not longer than ttl
+func (c *cache) window() time.Duration {
+	ttl := time.Duration(c.storageThresholdTime.Load())
+	if c.adaptiveTTL == 0 {
+		return ttl
+	}
+	rtt := c.stats.smoothedRTT()
+	if rtt == 0 {
+		// nothing is measured yet
+		return ttl
+	}
+	return min(time.Duration(c.adaptiveTTL*float64(rtt)), ttl)
+}
+
+// signal wakes background goroutine up to check pipeline triggers, it never blocks
+func (c *cache) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// waitFunc puts current goroutine to a waiting state for d, or until wake channel is signaled,
+// negative d means waiting without timeout,
+// it returns false if waiting was interrupted by done ctx or closed stop channel
+type waitFunc func(ctx context.Context, stop, wake <-chan struct{}, d time.Duration) bool
+
+// clockWait is a default waitFunc, which waits on a single timer of the clock, it's reset before every wait,
+// so the timer is not allocated on every check
+func (c *cache) clockWait(ctx context.Context, stop, wake <-chan struct{}, d time.Duration) bool {
+	// don't start a timer if we are asked to stop already
+	select {
+	case <-ctx.Done():
+		return false
+	case <-stop:
+		return false
+	default:
+	}
+	// nil channel blocks forever, so there is no timeout
+	var timeout <-chan time.Time
+	if d >= 0 {
+		timeout = c.resetTimer(d)
+	}
+	select {
+	case <-timeout:
+		return true
+	case <-wake:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-stop:
+		return false
+	}
+}
+
+// resetTimer resets the timer of background goroutine to fire after d, the timer is created on first use,
+// value of the timer fired during previous wait is drained, so it doesn't cut the next wait short
+func (c *cache) resetTimer(d time.Duration) <-chan time.Time {
+	if c.timer == nil {
+		c.timer = c.clock.NewTimer(d)
+		return c.timer.C()
+	}
+	if !c.timer.Stop() {
+		select {
+		case <-c.timer.C():
 		default:
-			// put this goroutine to a waiting state for short period of time
-			// this will allow most (but not 100% all) incoming simultaneous async redis requests from client goroutines
-			// to be executed in same pipeline
-			time.Sleep(c.runInterval)
-			// check number of listeners threshold
-			if c.activeListeners.Load() > c.storageThresholdSize {
-				c.runPipeline(ctx)
-				continue
-			}
-			// check time threshold
-			lastRun := time.UnixMicro(c.lastPipeline.Load())
-			if lastRun.Add(c.storageThresholdTime).Before(time.Now()) && c.activeListeners.Load() > 0 {
-				c.runPipeline(ctx)
-			}
 		}
 	}
+	c.timer.Reset(d)
+	return c.timer.C()
+}
+
+// shutdown stops receiving new commands and runs pipeline for a last time
+func (c *cache) shutdown(ctx context.Context) {
+	c.done.Store(true)
+	// blocked callers of full cache don't wait for the stopped one
+	c.completed()
+	if c.activeListeners.Load() == 0 {
+		return
+	}
+	// pipeline can't be executed with done context, pending commands are dropped then
+	if ctx.Err() != nil {
+		c.purge(func(*redisOperation) bool { return true }, context.Cause(ctx))
+		return
+	}
+	c.runPipeline(ctx, triggerShutdown)
 }
 
 // runPipeline gather all existed redis commands from the storage,
 // put all of them into single redis pipeline, executes it,
 // and returns a results of execution to a respective listeners
 // nolint:cyclop
-func (c *cache) runPipeline(ctx context.Context) {
-	pipe := c.client.Pipeline()
-	// prepare pipe, do not cast a types, let's have a set of maps
-	// more code, but more readable, slightly better compiling and performance
-	intCmds := map[string]*redis.IntCmd{}
-	sliceCmds := map[string]*redis.SliceCmd{}
-	stringCmds := map[string]*redis.StringCmd{}
-	stringStringMapCmds := map[string]*redis.MapStringStringCmd{}
-	stringSliceCmds := map[string]*redis.StringSliceCmd{}
-	boolCmds := map[string]*redis.BoolCmd{}
-	// lock the mutex for read, and gather all commands to be pipelined in redis
-	c.mx.RLock()
-	for hash, op := range c.storage {
+func (c *cache) runPipeline(ctx context.Context, trigger flushTrigger) {
+	// oversized batch is split into several pipelines of at most maxCommands commands, which are executed sequentially,
+	// unless there are several workers, every target of commands has its own pipelines, see pipeGroup and chunk
+	endpoint, client := c.pipelineClient(c.transactional)
+	if c.router != nil {
+		// cluster state is loaded before the storage is locked
+		c.router.reset(ctx)
+	}
+	grouped := c.router != nil || c.replicaReads
+	// commands are kept in maps by hash of operation, maps are taken from the pool, so they are not allocated
+	// for every flush
+	f := &flushCmds{pipelineCmds: pipelineCmdsPool.Get().(*pipelineCmds)}
+	defer releasePipelineCmds(f.pipelineCmds)
+	// lock mutexes of shards for read, and gather all commands to be pipelined in redis
+	var rec *batchRecorder
+	if c.batchHook != nil {
+		rec = newBatchRecorder(trigger)
+	}
+	var kinds map[operationPrefix]int // numbers of commands by operation, if flushes are logged
+	deduped := 0                      // number of calls joined to identical commands, if flushes are reported
+	if c.logEnabled(LogDebug) {
+		kinds = make(map[operationPrefix]int)
+	}
+	// shards are swept one by one, batches are not enqueued meanwhile, so they are not split between pipelines
+	c.batchMx.Lock()
+	// all pending commands are gathered, commands enqueued later wait for the next pipeline
+	c.oldestPending.Store(0)
+	c.sampleJitter()
+	limit := c.pipelineLimit()
+	var deadline time.Time
+	// operations are gathered before they're put to pipelines, so commands of a batch get the same pipeline
+	gathered := make([]gatheredOp, 0, c.operations.Load())
+	for _, sh := range c.shards {
+		sh.mx.RLock()
+		for hash, op := range sh.storage {
+			if rec != nil {
+				rec.add(hash, op)
+			}
+			if kinds != nil {
+				kinds[op.kind]++
+			}
+			if c.onFlush != nil {
+				deduped += max(len(op.listeners)+op.awaiters-1, 0)
+			}
+			// operation abandoned by its listeners during execution stays in storage till its result is taken
+			op.inflight = true
+			g := gatheredOp{hash: hash, op: op, batches: op.batches, seq: op.seq}
+			if grouped {
+				g.group = c.group(ctx, op)
+			}
+			gathered = append(gathered, g)
+		}
+		deadline = c.deadline(sh, deadline)
+		sh.mx.RUnlock()
+	}
+	c.batchMx.Unlock()
+	sizes := chunk(gathered, limit, grouped) // numbers of commands by pipeline
+	pipes := make([]redis.Pipeliner, len(sizes))
+	for i := range pipes {
+		pipes[i] = c.executor.Pipeline(client)
+	}
+	c.buildPipelines(ctx, f, gathered, pipes, sizes)
+	if len(pipes) == 0 {
+		pipes = append(pipes, c.executor.Pipeline(client))
+		sizes = append(sizes, 0)
+	}
+	if kinds != nil {
+		c.logFlush(trigger, kinds, len(pipes))
+	}
+	// rate limiter is waited for before exec timeout is started, so throttled pipelines don't time out
+	limitErr := c.throttle(ctx, len(pipes))
+	if c.execTimeout > 0 {
+		var cancel context.CancelFunc
+		// cause tells the exec timeout from deadlines of callers, see WithDeadlinePropagation
+		ctx, cancel = context.WithTimeoutCause(ctx, c.execTimeout, ErrPipelineTimeout)
+		defer cancel()
+	}
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	// redis is slowed down on purpose, result of the command is not interesting
+	if c.debugSleep > 0 {
+		for _, pipe := range pipes {
+			pipe.Do(ctx, "debug", "sleep", strconv.FormatFloat(c.debugSleep.Seconds(), 'f', -1, 64))
+		}
+	}
+
+	// exec pipe, no need to lock mutex while we perform redis request, too long
+	// if any upcoming request came in meantime, and if we already have this request in pipeline (duplicated)
+	// we will return result from existed pipeline, so we'll save time and one request
+	// and if this is a new request - it will be added to storage and served in next run of this function
+	// time of execution is a round-trip time, as building of pipeline and delivering of results are excluded
+	// commands of failed pipeline are completed with their own errors set by go-redis, so every listener
+	// gets an answer, commands of timed out pipeline and of pipeline, which wasn't executed, fail with its error
+	execStart := c.clock.Now()
+	// pipelines aren't executed, if rate limiter didn't permit them
+	waitErr := limitErr
+	if waitErr == nil {
+		waitErr = c.injectLatency(ctx)
+	}
+	errs, failures, rtts := c.execPipelines(ctx, pipes, waitErr, grouped, execStart)
+	var err error // the first error of pipelines
+	succeeded := 0
+	smoothedRTT := c.stats.smoothedRTT() // estimate before this flush, which slow pipelines are compared to
+	for i := range pipes {
+		// redis nil is a result of some command, not a pipeline error
+		if errors.Is(errs[i], redis.Nil) {
+			errs[i] = nil
+		}
+		c.stats.pipeline(trigger, sizes[i], rtts[i], errs[i])
+		c.logPipeline(trigger, sizes[i], rtts[i], smoothedRTT, errs[i])
+		if errs[i] != nil {
+			if errors.Is(context.Cause(ctx), ErrPipelineTimeout) {
+				failures[i] = ErrPipelineTimeout
+			} else if waitErr != nil {
+				failures[i] = waitErr
+			}
+			c.lastError.Store(&errs[i])
+			c.stats.failed(c.clock.Now(), errs[i])
+			if err == nil {
+				err = errs[i]
+			}
+		} else {
+			c.stats.rtt(rtts[i])
+			succeeded++
+		}
+	}
+	// commands of the flush, which made the switch, are executed again by the other client, see WithStandby
+	switched := c.endpoints.flushed(endpoint, waitErr == nil && unreachable(succeeded, err))
+	if switched {
+		c.stats.switchover()
+	}
+	execEnd := c.clock.Now()
+	if rec != nil {
+		rec.executed(execStart, execEnd.Sub(execStart), err)
+	}
+	if succeeded > 0 {
+		// store the time of last successful redis pipeline
+		c.lastFlush.Store(execEnd.UnixMicro())
+	}
+	// operations are removed from the storage while results are collected, results are delivered at once, see dispatch
+	deliveries := c.collect(ctx, f, errs, failures, waitErr, switched, rec)
+	c.shrinkStorage()
+	c.dispatch(deliveries)
+	c.exportBatch(rec)
+	if c.onFlush != nil {
+		commands := 0
+		for _, n := range sizes {
+			commands += n
+		}
+		c.onFlush(FlushStats{
+			Time:      execStart,
+			Trigger:   trigger.String(),
+			Commands:  commands,
+			Pipelines: len(pipes),
+			Deduped:   deduped,
+			Exec:      execEnd.Sub(execStart),
+			Err:       err,
+		})
+	}
+}
+
+// execPipelines executes pipelines, one after another or concurrently by workers, and returns
+// their errors, errors of pipelines, results of which are unknown, and round-trip times
+func (c *cache) execPipelines(ctx context.Context, pipes []redis.Pipeliner, waitErr error, grouped bool,
+	execStart time.Time,
+) (errs, failures []error, rtts []time.Duration) {
+	errs = make([]error, len(pipes))
+	failures = make([]error, len(pipes)) // errors of pipelines, results of which are unknown
+	rtts = make([]time.Duration, len(pipes))
+	exec := func(i int, start time.Time) time.Time {
+		errs[i] = waitErr
+		if errs[i] == nil {
+			var cmds []redis.Cmder
+			cmds, errs[i] = c.executor.Exec(ctx, pipes[i])
+			// error isn't set to commands, f.e. by a hook, which failed the pipeline itself
+			if errs[i] != nil && !cmdsFailed(cmds) {
+				failures[i] = errs[i]
+			}
+		}
+		end := c.clock.Now()
+		rtts[i] = end.Sub(start)
+		return end
+	}
+	workers := c.workers
+	if grouped {
+		// pipelines of different targets don't wait for each other
+		workers = max(workers, len(pipes))
+	}
+	if workers > 1 && len(pipes) > 1 {
+		// pipelines are executed concurrently, at most one per worker, each of them takes its own connection
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, workers)
+		for i := range pipes {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer c.dumpOnPanic()
+				exec(i, c.clock.Now())
+				<-sem
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		start := execStart
+		for i := range pipes {
+			start = exec(i, start)
+		}
+	}
+	return errs, failures, rtts
+}
+
+// flushCmds contains commands of a single flush by hash of operation, including ones merged into a single command
+type flushCmds struct {
+	*pipelineCmds
+	gets  map[int]*getBatch // Get commands to coalesce by index of pipeline, see WithGetCoalescing
+	dels  map[int]*delBatch // Del commands to merge by index of pipeline, see WithDelMerging
+	sets  map[int]*setBatch // Set commands to merge by index of pipeline, see WithSetMerging
+	reads hashReads         // HGet commands to serve by HGetAll, see WithHashReadMerging
+}
+
+// buildPipelines puts commands of gathered operations to their pipelines, commands merged with others, f.e. Get
+// coalesced into MGet, are added once all of them are known, sizes of pipelines are reduced by merged commands
+func (c *cache) buildPipelines(ctx context.Context, f *flushCmds, gathered []gatheredOp, pipes []redis.Pipeliner, sizes []int) {
+	f.gets = make(map[int]*getBatch)
+	f.dels = make(map[int]*delBatch)
+	f.sets = make(map[int]*setBatch)
+	// kind and payload of operation in flight aren't changed, so they are read without mutex of shard
+	for _, g := range gathered {
+		hash, op, i := g.hash, g.op, g.pipe
+		pipe := pipes[i]
+		f.chunks[hash] = i
 		switch op.kind {
-		case HDel:
-			key, fields := normalizeHDel(op.args)
-			intCmds[hash] = pipe.HDel(ctx, key, fields...)
 		case Del:
-			keys := normalizeDel(op.args)
-			intCmds[hash] = pipe.Del(ctx, keys...)
-		case MGet:
-			keys := normalizeMGet(op.args)
-			sliceCmds[hash] = pipe.MGet(ctx, keys...)
+			if c.mergeDels {
+				b, ok := f.dels[i]
+				if !ok {
+					b = &delBatch{}
+					f.dels[i] = b
+				}
+				b.add(hash, op.payload.(keysPayload))
+				continue
+			}
 		case HGet:
-			key, field := normalizeHGet(op.args)
-			stringCmds[hash] = pipe.HGet(ctx, key, field)
+			if c.mergeHashReads {
+				f.reads.addGet(hash, op.payload.(hgetPayload), i)
+				continue
+			}
 		case Get:
-			key := normalizeGet(op.args)
-			stringCmds[hash] = pipe.Get(ctx, key)
+			if c.coalesceGets {
+				b, ok := f.gets[i]
+				if !ok {
+					b = &getBatch{}
+					f.gets[i] = b
+				}
+				b.add(hash, op.payload.(keyPayload).key)
+				continue
+			}
 		case HGetAll:
-			key := normalizeHGetAll(op.args)
-			stringStringMapCmds[hash] = pipe.HGetAll(ctx, key)
-		case SMembers:
-			key := normalizeSMembers(op.args)
-			stringSliceCmds[hash] = pipe.SMembers(ctx, key)
-		case Expire:
-			key, duration := normalizeExpire(op.args)
-			boolCmds[hash] = pipe.Expire(ctx, key, duration)
+			if c.mergeHashReads {
+				key := op.payload.(keyPayload).key
+				f.stringStringMapCmds[hash] = pipe.HGetAll(ctx, key)
+				f.reads.addAll(hash, key)
+				continue
+			}
+		case Set:
+			// Set with expiration or KEEPTTL has no MSet counterpart
+			if p := op.payload.(setPayload); c.mergeSets && p.expiration == 0 {
+				b, ok := f.sets[i]
+				if !ok {
+					b = &setBatch{}
+					f.sets[i] = b
+				}
+				b.add(hash, p.key, p.value, g.seq)
+				continue
+			}
+		case Cmd:
+			// error of previous execution is reset, f.e. if the command is enqueued again
+			op.cmd.SetErr(nil)
+			_ = pipe.Process(ctx, op.cmd)
+			f.cmders[hash] = op.cmd
+			continue
 		}
+		if piped := c.pipeCmd(ctx, pipe, op.kind, op.payload); !piped.empty() {
+			f.piped[hash] = piped
+		}
+	}
+	for i, b := range f.gets {
+		sizes[i] -= len(b.keys) - b.build(ctx, pipes[i], c.slotGroups(b.keys), f.stringCmds)
 	}
-	c.mx.RUnlock()
+	for i, b := range f.dels {
+		sizes[i] -= len(b.keys) - b.build(ctx, pipes[i], c.slotGroups)
+	}
+	for i, b := range f.sets {
+		sizes[i] -= len(b.keys) - b.build(ctx, pipes[i], c.slotGroups(b.keys))
+	}
+	f.reads.build(ctx, pipes, sizes, f.chunks, f.stringCmds)
+}
 
-	// exec pipe, no need to lock mutex while we perform redis request, too long
-	// if any upcoming request came in meantime, and if we already have this request in pipeline (duplicated)
-	// we will return result from existed pipeline, so we'll save time and one request
-	// and if this is a new request - it will be added to storage and served in next run of this function
-	_, err := pipe.Exec(ctx)
-	if err != nil && !errors.Is(err, redis.Nil) {
-		c.log.Error(err)
+// pipedCmd is a command added to pipeline, result of which is either the command itself, or is merged
+// from several commands once pipeline is executed, f.e. of MGet with keys in different hash slots
+type pipedCmd struct {
+	cmd   redis.Cmder
+	merge func(ctx context.Context) redis.Cmder
+}
+
+// empty reports whether no command was added to pipeline, f.e. for unknown custom operation
+func (p pipedCmd) empty() bool {
+	return p.cmd == nil && p.merge == nil
+}
+
+// result returns the result of the command, it's called once pipeline is executed
+func (p pipedCmd) result(ctx context.Context) redis.Cmder {
+	if p.merge != nil {
+		return p.merge(ctx)
+	}
+	return p.cmd
+}
+
+// pipeCmd adds the command of the kind to the pipeline as is, commands are never coalesced or merged here,
+// but multi-key commands are still split by hash slot, see WithCrossSlotSplitting
+func (c *cache) pipeCmd(ctx context.Context, pipe redis.Pipeliner, kind operationPrefix, p payload) pipedCmd {
+	switch kind {
+	case HDel:
+		p := p.(hdelPayload)
+		return pipedCmd{cmd: pipe.HDel(ctx, p.key, p.fields...)}
+	case Del:
+		keys := p.(keysPayload)
+		if groups := c.slotGroups(keys); len(groups) > 1 {
+			d := newCrossSlotDel(ctx, pipe, keys, groups)
+			return pipedCmd{merge: func(ctx context.Context) redis.Cmder { return d.merge(ctx) }}
+		}
+		return pipedCmd{cmd: pipe.Del(ctx, keys...)}
+	case MGet:
+		keys := p.(keysPayload)
+		if groups := c.slotGroups(keys); len(groups) > 1 {
+			m := newCrossSlotMGet(ctx, pipe, keys, groups)
+			return pipedCmd{merge: func(ctx context.Context) redis.Cmder { return m.merge(ctx) }}
+		}
+		return pipedCmd{cmd: pipe.MGet(ctx, keys...)}
+	case HGet:
+		p := p.(hgetPayload)
+		return pipedCmd{cmd: pipe.HGet(ctx, p.key, p.field)}
+	case Get:
+		return pipedCmd{cmd: pipe.Get(ctx, p.(keyPayload).key)}
+	case Set:
+		p := p.(setPayload)
+		return pipedCmd{cmd: pipe.Set(ctx, p.key, p.value, p.expiration)}
+	case HGetAll:
+		return pipedCmd{cmd: pipe.HGetAll(ctx, p.(keyPayload).key)}
+	case SMembers:
+		return pipedCmd{cmd: pipe.SMembers(ctx, p.(keyPayload).key)}
+	case Expire:
+		p := p.(expirePayload)
+		return pipedCmd{cmd: pipe.Expire(ctx, p.key, p.expiration)}
+	case Exists:
+		return pipedCmd{cmd: pipe.Exists(ctx, p.(keysPayload)...)}
+	case Time:
+		return pipedCmd{cmd: pipe.Time(ctx)}
+	case Echo:
+		return pipedCmd{cmd: pipe.Echo(ctx, p.(valuePayload).value)}
+	case EvalRO:
+		p := p.(scriptPayload)
+		return pipedCmd{cmd: pipe.EvalRO(ctx, p.script, p.keys, interfaceArgs(p.args)...)}
+	case FCallRO:
+		p := p.(scriptPayload)
+		return pipedCmd{cmd: pipe.FCallRO(ctx, p.script, p.keys, interfaceArgs(p.args)...)}
+	case SafeDel:
+		p := p.(safeDelPayload)
+		d := newSafeDel(ctx, pipe, p.key, p.expectedType)
+		return pipedCmd{merge: func(ctx context.Context) redis.Cmder { return d.merge(ctx) }}
+	case Do:
+		return pipedCmd{cmd: pipe.Do(ctx, interfaceArgs(p.(opaquePayload))...)}
+	case Cmd:
+		cmd := p.(cmdPayload).cmd
+		_ = pipe.Process(ctx, cmd)
+		return pipedCmd{cmd: cmd}
+	}
+	if custom, ok := lookupOperationKind(kind); ok {
+		return pipedCmd{cmd: custom.build(ctx, pipe, p.(opaquePayload))}
+	}
+	return pipedCmd{}
+}
+
+// collect takes operations of the flush from the storage with their results: operations of pipelines, which
+// failed as a whole, get errors of pipelines, commands, which failed with transient errors, are put back
+// to the cache instead, see requeue; results are returned to be delivered at once, see dispatch
+func (c *cache) collect(ctx context.Context, f *flushCmds, errs, failures []error, waitErr error, switched bool, rec *batchRecorder) []delivery {
+	deliveries := make([]delivery, 0, len(f.chunks))
+	if slices.ContainsFunc(failures, func(err error) bool { return err != nil }) {
+		for hash, i := range f.chunks {
+			if failures[i] == nil {
+				continue
+			}
+			if c.requeue(hash, failures[i], switched) {
+				continue
+			}
+			if o, ok := c.take(hash); ok {
+				// pipeline wasn't executed at all
+				if waitErr != nil || c.exhausted(o, failures[i]) {
+					c.dropped(o.kind, o.payload, failures[i])
+				}
+				deliveries = append(deliveries, delivery{op: o, result: c.failedResult(o, failures[i])})
+			}
+		}
+	}
+	// collect the results of executed pipelines, including failed commands
+	f.results(ctx, func(hash string, cmd redis.Cmder) {
+		if failures[f.chunks[hash]] != nil {
+			return
+		}
+		if rec != nil {
+			rec.result(hash, cmd)
+		}
+		if errs[f.chunks[hash]] != nil && c.requeue(hash, cmd.Err(), switched) {
+			return
+		}
+		if o, ok := c.take(hash); ok {
+			if c.exhausted(o, cmd.Err()) {
+				c.dropped(o.kind, o.payload, cmd.Err())
+			}
+			deliveries = append(deliveries, delivery{op: o, result: cmd})
+		}
+	})
+	return deliveries
+}
+
+// results passes results of all commands of the flush to send, merged commands are split back
+func (f *flushCmds) results(ctx context.Context, send func(hash string, cmd redis.Cmder)) {
+	for hash, piped := range f.piped {
+		send(hash, piped.result(ctx))
+	}
+	for hash, cmd := range f.stringCmds {
+		send(hash, cmd)
+	}
+	for hash, cmd := range f.stringStringMapCmds {
+		send(hash, cmd)
+	}
+	for hash, cmd := range f.cmders {
+		send(hash, cmd)
+	}
+	for _, b := range f.gets {
+		b.results(ctx, send)
+	}
+	for _, b := range f.dels {
+		b.results(ctx, send)
+	}
+	for _, b := range f.sets {
+		b.results(ctx, send)
+	}
+	f.reads.results(ctx, f.stringStringMapCmds, send)
+}
+
+// cmdsFailed reports whether any of commands has an error set
+func cmdsFailed(cmds []redis.Cmder) bool {
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// delivery is a result of operation removed from the storage, which is not delivered to listeners yet
+type delivery struct {
+	op     *redisOperation
+	result interface{}
+}
+
+// dispatch delivers results of the flush to listeners by the flush goroutine itself, or hands them off
+// to delivery workers, so one slow receiver doesn't delay others and the next flush, workers of the previous flush
+// are waited for, so at most deliveryWorkers goroutines deliver results
+func (c *cache) dispatch(deliveries []delivery) {
+	if c.deliveryWorkers == 0 {
+		for _, d := range deliveries {
+			c.deliver(d.op, d.result)
+		}
+		return
+	}
+	c.delivering.Wait()
+	if len(deliveries) == 0 {
 		return
 	}
+	n := min(c.deliveryWorkers, len(deliveries))
+	size := (len(deliveries) + n - 1) / n
+	for start := 0; start < len(deliveries); start += size {
+		part := deliveries[start:min(start+size, len(deliveries))]
+		c.delivering.Add(1)
+		go func() {
+			defer c.delivering.Done()
+			defer c.dumpOnPanic()
+			for _, d := range part {
+				c.deliver(d.op, d.result)
+			}
+		}()
+	}
+}
+
+// shrinkStorage recreates storages of shards drained by the flush, see shrink
+func (c *cache) shrinkStorage() {
+	for _, sh := range c.shards {
+		sh.mx.Lock()
+		sh.shrink()
+		sh.mx.Unlock()
+	}
+}
+
+// pipelineLimit returns the max number of commands in single pipeline of the flush, 0 means unlimited,
+// batch is split evenly between workers, so all of them are busy
+func (c *cache) pipelineLimit() int {
+	if c.workers <= 1 {
+		return c.maxCommands
+	}
+	limit := max((int(c.operations.Load())+c.workers-1)/c.workers, 1)
+	if c.maxCommands > 0 {
+		return min(limit, c.maxCommands)
+	}
+	return limit
+}
+
+// gatheredOp is an operation gathered by runPipeline with the pipeline it's put to
+type gatheredOp struct {
+	hash    string
+	op      *redisOperation
+	batches []uint64  // batches, which commands the operation serves, see Batch
+	seq     uint64    // number of the latest call of Set, which joined the operation, see setBatch
+	group   pipeGroup // target of the command, if commands are grouped by target
+	pipe    int       // index of pipeline
+}
+
+// chunk puts gathered operations to pipelines of at most limit commands, 0 means unlimited, operations of every target
+// get their own pipelines, if grouped, commands of the same batch are put to the same pipeline, even if it exceeds
+// the limit, or its commands have different targets, and returns numbers of commands by pipeline
+func chunk(ops []gatheredOp, limit int, grouped bool) []int {
+	var sizes []int
+	var current map[pipeGroup]int // index of the pipeline being filled by target of commands, if grouped
+	if grouped {
+		current = make(map[pipeGroup]int)
+	}
+	put := func(members []int) {
+		group := ops[members[0]].group
+		for _, k := range members[1:] {
+			group = group.join(ops[k].group)
+		}
+		i, ok := len(sizes)-1, true
+		if grouped {
+			i, ok = current[group]
+		}
+		if !ok || i < 0 || (limit > 0 && sizes[i]+len(members) > limit) {
+			sizes = append(sizes, 0)
+			i = len(sizes) - 1
+			if grouped {
+				current[group] = i
+			}
+		}
+		for _, k := range members {
+			ops[k].pipe = i
+		}
+		sizes[i] += len(members)
+	}
+	members := batchMembers(ops)
+	for k := range ops {
+		switch {
+		case members == nil || len(ops[k].batches) == 0:
+			put([]int{k})
+		case members[k] != nil:
+			put(members[k])
+		}
+	}
+	return sizes
+}
 
-	// store the time of last redis pipeline
-	c.lastPipeline.Store(time.Now().UnixMicro())
-	// send the results to listeners
-	for hash, cmd := range intCmds {
-		c.sendResult(hash, cmd)
+// batchMembers returns indexes of operations, which are put to the same pipeline, by index of the first of them,
+// operations serving the same batch are joined, and so are batches sharing an operation, nil is returned
+// if there are no batches
+func batchMembers(ops []gatheredOp) map[int][]int {
+	var parent []int
+	first := make(map[uint64]int) // index of the first operation by batch
+	find := func(k int) int {
+		for parent[k] != k {
+			parent[k] = parent[parent[k]]
+			k = parent[k]
+		}
+		return k
+	}
+	for k, g := range ops {
+		for _, b := range g.batches {
+			if parent == nil {
+				parent = make([]int, len(ops))
+				for i := range parent {
+					parent[i] = i
+				}
+			}
+			j, ok := first[b]
+			if !ok {
+				first[b] = k
+				continue
+			}
+			// the earliest operation is a root, so members are listed in order of gathering
+			if r, s := find(j), find(k); r != s {
+				parent[max(r, s)] = min(r, s)
+			}
+		}
 	}
-	for hash, cmd := range sliceCmds {
-		c.sendResult(hash, cmd)
+	if parent == nil {
+		return nil
+	}
+	members := make(map[int][]int)
+	for k, g := range ops {
+		if len(g.batches) > 0 {
+			r := find(k)
+			members[r] = append(members[r], k)
+		}
+	}
+	return members
+}
+
+// exportBatch passes the record of executed pipeline to the batch hook, if record is collected
+func (c *cache) exportBatch(rec *batchRecorder) {
+	if rec != nil {
+		c.batchHook(rec.record)
 	}
-	for hash, cmd := range stringCmds {
-		c.sendResult(hash, cmd)
+}
+
+// deadline returns the earliest of the given deadline and deadlines of callers in shard, which are still waiting
+// for results, zero time is returned if deadline propagation is disabled or there are no deadlines,
+// mutex of shard should be locked
+func (c *cache) deadline(sh *shard, deadline time.Time) time.Time {
+	if !c.propagateDeadlines {
+		return deadline
 	}
-	for hash, cmd := range stringStringMapCmds {
-		c.sendResult(hash, cmd)
+	now := c.clock.Now()
+	for _, op := range sh.storage {
+		// callers with expired deadline have given up already, they shouldn't fail other commands
+		if op.deadline.IsZero() || op.deadline.Before(now) {
+			continue
+		}
+		if deadline.IsZero() || op.deadline.Before(deadline) {
+			deadline = op.deadline
+		}
 	}
-	for hash, cmd := range stringSliceCmds {
-		c.sendResult(hash, cmd)
+	return deadline
+}
+
+// throttle waits for the rate limiter to permit execution of n pipelines
+func (c *cache) throttle(ctx context.Context, n int) error {
+	if c.limiter == nil {
+		return nil
 	}
-	for hash, cmd := range boolCmds {
-		c.sendResult(hash, cmd)
+	for i := 0; i < n; i++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (c *cache) sendResult(hash string, redisCmd interface{}) {
+// injectLatency waits for injected latency or until ctx is done
+func (c *cache) injectLatency(ctx context.Context) error {
+	if c.injectedLatency <= 0 {
+		return nil
+	}
+	t := c.clock.NewTimer(c.injectedLatency)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// slotGroups returns indexes of keys grouped by hash slot if cross slot splitting is enabled
+func (c *cache) slotGroups(keys []string) [][]int {
+	if !c.splitCrossSlot {
+		return nil
+	}
+	return groupBySlot(keys)
+}
+
+// requeue keeps read-only operation failed with transient error in the storage, so it's executed again
+// by the next flush without waiting for ttl, up to readRetries times, see WithReadRetries,
+// operation of any kind failed with failover error is kept until it's expired, and flushing is paused,
+// see WithFailoverPause, operation of any kind failed with transient error by the flush, which switched redis client,
+// is kept once, so it's executed by the other client, see WithStandby
+func (c *cache) requeue(hash string, err error, switched bool) bool {
+	failover := c.failoverPause > 0 && failoverError(err)
+	switched = switched && retryable(err)
+	if !failover && !switched && (c.readRetries == 0 || !retryable(err)) {
+		return false
+	}
+	sh := c.shard(hash)
+	sh.mx.Lock()
+	defer sh.mx.Unlock()
+	o, ok := sh.storage[hash]
+	// operation abandoned by all its listeners during execution isn't executed again for nobody
+	if !ok || (len(o.listeners) == 0 && o.awaiters == 0) {
+		return false
+	}
+	switch {
+	case failover:
+		c.failover()
+	case switched && !o.switched:
+		o.switched = true
+	default:
+		if !o.kind.readOnly() || o.retries >= c.readRetries {
+			return false
+		}
+		o.retries++
+	}
+	o.inflight = false
+	c.trackPending(o.created)
+	c.stats.requeue()
+	return true
+}
+
+// exhausted reports whether operation failed with transient error after all its requeues
+func (c *cache) exhausted(o *redisOperation, err error) bool {
+	return c.readRetries > 0 && o.retries >= c.readRetries && o.kind.readOnly() && retryable(err)
+}
+
+// take removes operation from the storage by its hash and returns it,
+// mutex isn't locked while the result is delivered, as delivering of results may be time-consuming
+func (c *cache) take(hash string) (*redisOperation, bool) {
+	sh := c.shard(hash)
+	sh.mx.Lock()
+	defer sh.mx.Unlock()
+	o, ok := sh.storage[hash]
+	// should never happen, as operations of the pipeline being executed are kept until taken, see abandonOperation
+	if !ok {
+		c.log.Error(fmt.Errorf("%w: %s", ErrHashNotFound, hash))
+		return nil, false
+	}
+	c.remove(sh, hash, o)
+	return o, true
+}
+
+// deliver sends the result of operation to all its listeners and awaiters,
+// operation should be already removed from the storage
+func (c *cache) deliver(o *redisOperation, redisCmd interface{}) {
 	// For case of unexpected write to a closed channel
 	defer func() {
 		if r := recover(); r != nil {
 			c.log.Error("recovered:", r)
 		}
 	}()
-	c.mx.Lock()
-	o, ok := c.storage[hash]
-	// should never happen, as only one pipe could be processed at the time
-	if !ok {
-		c.mx.Unlock()
-		c.log.Error(fmt.Errorf("%w: %s", ErrHashNotFound, hash))
-		return
+	// quota is released before results are delivered, so callers may queue next commands right away
+	for _, ctx := range o.admitted {
+		c.admitter.Done(ctx, o.kind.String())
+	}
+	// awaiters are served first, as it can't fail
+	if o.done != nil {
+		o.result = redisCmd
+		close(o.done)
+		c.activeListeners.Add(-int32(o.awaiters))
 	}
-
-	delete(c.storage, hash)
-	// TODO: recreate storage map, as map only grows and never shrink?
-	c.mx.Unlock()
-
-	// here we don't want to lock the mutex, as delivering of results may be time-consuming
 	for _, r := range o.listeners {
-		r <- redisCmd
+		c.release(r, redisCmd)
 		// decrement the listeners number
 		c.activeListeners.Add(-1)
 	}
+	c.completed()
+	releaseOperation(o)
+}
+
+// release sends the result to listener channel and closes it,
+// with ordered delivery the result waits for results of commands enqueued earlier with the same ctx
+func (c *cache) release(ch chan interface{}, result interface{}) {
+	if c.sequencer != nil {
+		c.sequencer.release(ch, result)
+		return
+	}
+	c.sender.send(ch, result)
+}
+
+// snapshot returns a state of the cache, arguments of operations are included only if withArgs is set
+func (c *cache) snapshot(withArgs bool) *QueueSnapshot {
+	now := c.clock.Now()
+	s := &QueueSnapshot{
+		Time:            now,
+		ActiveListeners: c.activeListeners.Load(),
+		Operations:      make([]OperationSnapshot, 0, c.len()),
+	}
+	for _, sh := range c.shards {
+		sh.mx.RLock()
+		for _, op := range sh.storage {
+			o := OperationSnapshot{
+				Kind:      op.kind.String(),
+				Listeners: len(op.listeners) + op.awaiters,
+				Age:       now.Sub(op.created),
+			}
+			if withArgs {
+				o.Args = payloadArgs(op.payload)
+			}
+			s.Operations = append(s.Operations, o)
+		}
+		sh.mx.RUnlock()
+	}
+	return s
+}
+
+// dumpOnPanic writes the snapshot of the cache to dumpWriter and panics again, if the goroutine panics,
+// it's deferred by every goroutine of the cache: the run loop, pipeline workers, delivery workers and command hooks
+func (c *cache) dumpOnPanic() {
+	if c.dumpWriter == nil {
+		return
+	}
+	if r := recover(); r != nil {
+		c.dumpPending()
+		panic(r)
+	}
+}
+
+// dumpPending writes the snapshot of the cache to dumpWriter
+func (c *cache) dumpPending() {
+	if err := c.dumpEncoder.Encode(c.dumpWriter, c.snapshot(false)); err != nil {
+		c.log.Error(err)
+	}
+}
+
+// purgeExpired removes operations which live in storage longer than operationTTL
+// and sends the ErrOperationExpired error to their listeners
+func (c *cache) purgeExpired() {
+	now := c.clock.Now()
+	c.purge(func(op *redisOperation) bool {
+		return now.Sub(op.created) > c.operationTTL
+	}, ErrOperationExpired)
+}
+
+// purge removes operations matching the filter, sends the error to their listeners
+// and passes them to dead letter hook
+func (c *cache) purge(match func(op *redisOperation) bool, err error) {
+	var purged []*redisOperation
+	for _, sh := range c.shards {
+		sh.mx.Lock()
+		for hash, op := range sh.storage {
+			if match(op) {
+				purged = append(purged, op)
+				c.remove(sh, hash, op)
+			}
+		}
+		sh.mx.Unlock()
+	}
+
+	for _, op := range purged {
+		c.dropped(op.kind, op.payload, err)
+		c.failOperation(op, err)
+	}
+}
+
+// failOperation sends a redis command of proper type with the error set to all listeners and awaiters of operation
+// pre-built command is completed with the error in place
+func (c *cache) failOperation(o *redisOperation, err error) {
+	c.deliver(o, c.failedResult(o, err))
+}
+
+// failedResult returns a result of operation with the error set, pre-built command is completed with the error in place
+func (c *cache) failedResult(o *redisOperation, err error) interface{} {
+	if o.cmd != nil {
+		o.cmd.SetErr(err)
+		return o.cmd
+	}
+	return c.failedCmd(o.kind, o.payload, err)
+}
+
+// failedCmd returns a redis command of the type expected by listeners of operation with the error set,
+// command of custom operation is built on a pipeline, which is never executed
+func (c *cache) failedCmd(kind operationPrefix, p payload, err error) interface{} {
+	if custom, ok := lookupOperationKind(kind); ok {
+		cmd := custom.build(context.Background(), c.client().Pipeline(), p.(opaquePayload))
+		cmd.SetErr(err)
+		return cmd
+	}
+	return newFailedCmd(kind, err)
+}
+
+// newFailedCmd returns an empty redis command of the type expected by listeners of operation kind
+// with the error set
+func newFailedCmd(kind operationPrefix, err error) interface{} {
+	switch kind {
+	case HDel, Del, Exists, SafeDel:
+		cmd := &redis.IntCmd{}
+		cmd.SetErr(err)
+		return cmd
+	case MGet:
+		cmd := &redis.SliceCmd{}
+		cmd.SetErr(err)
+		return cmd
+	case HGet, Get, Echo:
+		cmd := &redis.StringCmd{}
+		cmd.SetErr(err)
+		return cmd
+	case HGetAll:
+		cmd := &redis.MapStringStringCmd{}
+		cmd.SetErr(err)
+		return cmd
+	case SMembers:
+		cmd := &redis.StringSliceCmd{}
+		cmd.SetErr(err)
+		return cmd
+	case Expire:
+		cmd := &redis.BoolCmd{}
+		cmd.SetErr(err)
+		return cmd
+	case Set:
+		cmd := &redis.StatusCmd{}
+		cmd.SetErr(err)
+		return cmd
+	case EvalRO, FCallRO, Do:
+		cmd := &redis.Cmd{}
+		cmd.SetErr(err)
+		return cmd
+	case Time:
+		cmd := &redis.TimeCmd{}
+		cmd.SetErr(err)
+		return cmd
+	}
+	return nil
 }
 
 // enqueue puts the request to the cache, to be executed in next runPipeline execution
-func (c *cache) enqueue(kind operationPrefix, args []string) chan interface{} {
+func (c *cache) enqueue(ctx context.Context, kind operationPrefix, p payload) chan interface{} {
+	if lane := c.lane(kind); lane != c {
+		return lane.enqueue(ctx, kind, p)
+	}
+	return c.hooked(ctx, kind, p, c.listener(ctx, kind, p))
+}
+
+// listener puts the request to the cache and returns a channel of its listener
+func (c *cache) listener(ctx context.Context, kind operationPrefix, p payload) chan interface{} {
 	resultCh := make(chan interface{}, resultChannelBufferSize)
-	// don't schedule anything if cache is stopped
-	if c.done.Load() {
-		c.log.Error(ErrCacheStopped)
+	if err := c.accepts(); err != nil {
+		c.rejected(kind, p, err)
+		c.log.Error(err)
 		close(resultCh)
 		return resultCh
 	}
-	h := hashStringSlice(kind, args)
-	c.mx.Lock()
-	defer c.mx.Unlock()
-	if _, ok := c.storage[h]; !ok {
-		c.storage[h] = &redisOperation{
-			kind:      kind,
-			args:      args,
-			listeners: []chan interface{}{resultCh},
-		}
-		c.activeListeners.Add(1)
+	direct, err := c.overflow(ctx)
+	switch {
+	case direct:
+		resultCh <- c.direct(ctx, kind, p)
+		close(resultCh)
 		return resultCh
+	case err != nil:
+		c.rejected(kind, p, err)
+	case c.queue != nil:
+		err = c.push(ctx, kind, p, resultCh)
+	default:
+		err = c.listen(ctx, kind, p, resultCh)
+	}
+	if err != nil {
+		// result channel is buffered, so listener receives the error without blocking us
+		resultCh <- c.failedCmd(kind, p, err)
+		close(resultCh)
 	}
-	c.storage[h].listeners = append(c.storage[h].listeners, resultCh)
-	c.activeListeners.Add(1)
 	return resultCh
 }
+
+// tryEnqueue puts the request to the cache, same as enqueue does, but reports synchronously
+// why the request wasn't queued, f.e. ErrCacheStopped or ErrPauseLimitExceeded, channel is nil then
+func (c *cache) tryEnqueue(ctx context.Context, kind operationPrefix, p payload) (chan interface{}, error) {
+	if lane := c.lane(kind); lane != c {
+		return lane.tryEnqueue(ctx, kind, p)
+	}
+	if err := c.accepts(); err != nil {
+		c.rejected(kind, p, err)
+		return nil, err
+	}
+	resultCh := make(chan interface{}, resultChannelBufferSize)
+	if direct, err := c.overflow(ctx); err != nil {
+		c.rejected(kind, p, err)
+		return nil, err
+	} else if direct {
+		resultCh <- c.direct(ctx, kind, p)
+		close(resultCh)
+		return c.hooked(ctx, kind, p, resultCh), nil
+	}
+	if err := c.listen(ctx, kind, p, resultCh); err != nil {
+		return nil, err
+	}
+	return c.hooked(ctx, kind, p, resultCh), nil
+}
+
+// listen admits the request and adds resultCh to listeners of its operation
+func (c *cache) listen(ctx context.Context, kind operationPrefix, p payload, resultCh chan interface{}) error {
+	p, err := c.admit(ctx, kind, p)
+	if err != nil {
+		return err
+	}
+	p = prefixPayload(c.keyPrefix, p)
+	h := c.hash(kind, p)
+	sh := c.shard(h)
+	sh.mx.Lock()
+	defer sh.mx.Unlock()
+	op, err := c.operation(ctx, sh, h, kind, p, 0)
+	if err != nil {
+		c.unadmit(ctx, kind)
+		return err
+	}
+	if c.sequencer != nil {
+		c.sequencer.register(ctx, resultCh)
+	}
+	c.addListener(op, resultCh)
+	return nil
+}
+
+// enqueueCmd puts pre-built redis command to the cache, command is pipelined verbatim and completed in place,
+// it's sent to the listener once completed, commands are coalesced only if the same command is enqueued twice
+func (c *cache) enqueueCmd(ctx context.Context, cmd redis.Cmder) chan interface{} {
+	if lane := c.lane(Cmd); lane != c {
+		return lane.enqueueCmd(ctx, cmd)
+	}
+	return c.hooked(ctx, Cmd, cmdPayload{cmd: cmd}, c.cmdListener(ctx, cmd))
+}
+
+// cmdListener puts pre-built redis command to the cache and returns a channel of its listener
+func (c *cache) cmdListener(ctx context.Context, cmd redis.Cmder) chan interface{} {
+	resultCh := make(chan interface{}, resultChannelBufferSize)
+	if err := c.accepts(); err != nil {
+		c.rejected(Cmd, cmdPayload{cmd: cmd}, err)
+		c.log.Error(err)
+		close(resultCh)
+		return resultCh
+	}
+	if direct, err := c.overflow(ctx); err != nil {
+		c.rejected(Cmd, cmdPayload{cmd: cmd}, err)
+		cmd.SetErr(err)
+		resultCh <- cmd
+		close(resultCh)
+		return resultCh
+	} else if direct {
+		resultCh <- c.direct(ctx, Cmd, cmdPayload{cmd: cmd})
+		close(resultCh)
+		return resultCh
+	}
+	if _, err := c.admit(ctx, Cmd, cmdPayload{cmd: cmd}); err != nil {
+		cmd.SetErr(err)
+		resultCh <- cmd
+		close(resultCh)
+		return resultCh
+	}
+	h := hashCmd(cmd)
+	sh := c.shard(h)
+	sh.mx.Lock()
+	defer sh.mx.Unlock()
+	op, err := c.operation(ctx, sh, h, Cmd, transformCmd(cmd), 0)
+	if err != nil {
+		c.unadmit(ctx, Cmd)
+		cmd.SetErr(err)
+		resultCh <- cmd
+		close(resultCh)
+		return resultCh
+	}
+	op.cmd = cmd
+	if c.sequencer != nil {
+		c.sequencer.register(ctx, resultCh)
+	}
+	c.addListener(op, resultCh)
+	return resultCh
+}
+
+// enqueueHandle puts the request to the cache, same as enqueue does,
+// but returns a Handle, which doesn't require any channel to be read or closed
+func (c *cache) enqueueHandle(ctx context.Context, kind operationPrefix, p payload) *Handle {
+	if lane := c.lane(kind); lane != c {
+		return lane.enqueueHandle(ctx, kind, p)
+	}
+	return c.hookedHandle(ctx, kind, p, c.awaiter(ctx, kind, p))
+}
+
+// awaiter puts the request to the cache and returns a Handle of its awaiter
+func (c *cache) awaiter(ctx context.Context, kind operationPrefix, p payload) *Handle {
+	if err := c.accepts(); err != nil {
+		c.rejected(kind, p, err)
+		return &Handle{err: err}
+	}
+	if direct, err := c.overflow(ctx); err != nil {
+		c.rejected(kind, p, err)
+		return &Handle{err: err}
+	} else if direct {
+		return completedHandle(c.direct(ctx, kind, p))
+	}
+	p, err := c.admit(ctx, kind, p)
+	if err != nil {
+		return &Handle{err: err}
+	}
+	return c.handle(ctx, kind, p, 0)
+}
+
+// enqueueBatch puts all commands to the cache at once, so they are executed in the same runPipeline execution,
+// handles are returned in the order of commands
+func (c *cache) enqueueBatch(ctx context.Context, cmds []batchCommand) []*Handle {
+	if lane := c.batchLane(cmds); lane != c {
+		return lane.enqueueBatch(ctx, cmds)
+	}
+	handles := make([]*Handle, len(cmds))
+	if err := c.accepts(); err != nil {
+		for i, cmd := range cmds {
+			c.rejected(cmd.kind, cmd.payload, err)
+			handles[i] = &Handle{err: err}
+		}
+		return handles
+	}
+	// batch takes room of a single command, so it's not split between the queue and direct execution
+	if direct, err := c.overflow(ctx); err != nil {
+		for i, cmd := range cmds {
+			c.rejected(cmd.kind, cmd.payload, err)
+			handles[i] = &Handle{err: err}
+		}
+		return handles
+	} else if direct {
+		return c.directBatch(ctx, cmds, c.transactional)
+	}
+	// admitter is asked before the mutex is locked, as it may be time-consuming
+	payloads := make([]payload, len(cmds))
+	for i, cmd := range cmds {
+		var err error
+		if payloads[i], err = c.admit(ctx, cmd.kind, cmd.payload); err != nil {
+			handles[i] = &Handle{err: err}
+		}
+	}
+	// commands of the batch are put to the same pipeline, see chunk
+	var batch uint64
+	if len(cmds) > 1 {
+		batch = c.batchIDs.Add(1)
+	}
+	// runPipeline doesn't sweep shards meanwhile
+	c.batchMx.RLock()
+	defer c.batchMx.RUnlock()
+	for i, cmd := range cmds {
+		if handles[i] == nil {
+			handles[i] = c.handle(ctx, cmd.kind, payloads[i], batch)
+		}
+	}
+	return handles
+}
+
+// handle puts admitted command to the cache and returns a Handle for it,
+// batch is id of the batch, which command belongs to, zero for command queued on its own
+func (c *cache) handle(ctx context.Context, kind operationPrefix, p payload, batch uint64) *Handle {
+	p = prefixPayload(c.keyPrefix, p)
+	h := c.hash(kind, p)
+	sh := c.shard(h)
+	sh.mx.Lock()
+	defer sh.mx.Unlock()
+	op, err := c.operation(ctx, sh, h, kind, p, batch)
+	if err != nil {
+		c.unadmit(ctx, kind)
+		return &Handle{err: err}
+	}
+	if op.done == nil {
+		op.done = make(chan struct{})
+	}
+	op.awaiters++
+	return &Handle{op: op}
+}
+
+// accepts returns an error if new commands can't be put to the cache right now
+func (c *cache) accepts() error {
+	// don't schedule anything if cache is stopped
+	if c.done.Load() {
+		return ErrCacheStopped
+	}
+	// don't overgrow storage while cache is paused
+	if c.paused.Load() && c.activeListeners.Load() >= c.pauseLimit {
+		return ErrPauseLimitExceeded
+	}
+	return nil
+}
+
+// addListener adds listener channel to operation and remembers where the operation is stored,
+// so abandon goes straight to it, mutex of shard should be locked
+func (c *cache) addListener(op *redisOperation, ch chan interface{}) {
+	op.listeners = append(op.listeners, ch)
+	c.waiters.Store((<-chan interface{})(ch), waiter{lane: c, key: op.key})
+}
+
+// abandon removes the listener of caller, who doesn't wait for the result anymore, and closes its channel,
+// operation without listeners and awaiters is removed from the storage, so it's not executed for nobody
+func (c *cache) abandon(ctx context.Context, ch <-chan interface{}) {
+	ch = c.forwarded(ch)
+	// listener, which isn't found, has got its result already or is still queued, see WithLockFreeEnqueue
+	if w, ok := c.waiters.LoadAndDelete(ch); ok {
+		w.(waiter).lane.abandonOperation(ctx, w.(waiter).key, ch)
+	}
+}
+
+// abandonOperation removes the listener from operation stored by the key, if it's still there
+func (c *cache) abandonOperation(ctx context.Context, key string, ch <-chan interface{}) {
+	sh := c.shard(key)
+	sh.mx.Lock()
+	defer sh.mx.Unlock()
+	op, ok := sh.storage[key]
+	if !ok {
+		return
+	}
+	for i, l := range op.listeners {
+		if l != ch {
+			continue
+		}
+		op.listeners = append(op.listeners[:i], op.listeners[i+1:]...)
+		c.activeListeners.Add(-1)
+		c.completed()
+		op.callers, _ = removeContext(op.callers, ctx)
+		var admitted bool
+		if op.admitted, admitted = removeContext(op.admitted, ctx); admitted {
+			c.unadmit(ctx, op.kind)
+		}
+		// operation of the pipeline being executed is kept, so its result is taken once executed
+		removed := len(op.listeners) == 0 && op.awaiters == 0 && !op.inflight
+		if removed {
+			c.remove(sh, key, op)
+		}
+		if c.sequencer != nil {
+			c.sequencer.remove(l)
+		}
+		close(l)
+		if removed {
+			releaseOperation(op)
+		}
+		return
+	}
+}
+
+// removeContext removes the first occurrence of ctx from ctxs and reports whether it was found
+func removeContext(ctxs []context.Context, ctx context.Context) ([]context.Context, bool) {
+	for i, c := range ctxs {
+		if c == ctx {
+			return append(ctxs[:i], ctxs[i+1:]...), true
+		}
+	}
+	return ctxs, false
+}
+
+// admit passes command of the caller through middlewares and asks admitter whether it may be queued,
+// payload is returned with keys rewritten by middlewares
+func (c *cache) admit(ctx context.Context, kind operationPrefix, p payload) (payload, error) {
+	if c.middleware != nil {
+		var err error
+		if p, err = c.middleware.enqueue(ctx, kind, p); err != nil {
+			return p, err
+		}
+	}
+	if c.admitter == nil {
+		return p, nil
+	}
+	return p, c.admitter.Admit(ctx, kind.String())
+}
+
+// unadmit releases admitted command, which wasn't queued
+func (c *cache) unadmit(ctx context.Context, kind operationPrefix) {
+	if c.admitter != nil {
+		c.admitter.Done(ctx, kind.String())
+	}
+}
+
+// operation returns operation from the shard by its hash, creating it if not exists,
+// and counts one more active listener of it, mutex of shard should be locked
+// ErrDuplicateCommand is returned if caller with the same ctx already awaits this operation
+// and duplicatePolicy is DuplicateReject, batch is id of the batch, which command belongs to, or zero
+func (c *cache) operation(ctx context.Context, sh *shard, h string, kind operationPrefix, p payload, batch uint64) (*redisOperation, error) {
+	key := h
+	if latest, ok := sh.latest[h]; ok {
+		key = latest
+	}
+	op, ok := sh.storage[key]
+	// batch doesn't join operation in flight, as other commands of the batch would be executed by another pipeline,
+	// neither does Set, as the executed one doesn't overwrite Sets of the same key queued meanwhile
+	if ok && ((c.dedupWindow > 0 && c.clock.Now().Sub(op.created) > c.dedupWindow) || ((batch != 0 || kind == Set) && op.inflight)) {
+		// operation is too old to share its result, it's executed as is, and the new one takes another key
+		ok = false
+		key = h
+		if _, taken := sh.storage[h]; taken {
+			key = h + "#" + strconv.FormatUint(c.unique.Add(1), 10)
+		}
+	}
+	if ok {
+		if err := c.checkDuplicate(ctx, op); err != nil {
+			return nil, err
+		}
+		c.stats.dedupHit()
+	} else {
+		op = newOperation(kind, p)
+		op.created = c.clock.Now()
+		op.key = key
+		sh.storage[key] = op
+		c.setLatest(sh, h, key)
+		sh.peak = max(sh.peak, len(sh.storage))
+		// the first operation of empty cache replaces enqueue time of removed operations
+		if c.operations.Add(1) == 1 {
+			c.oldestPending.Store(op.created.UnixMicro())
+		} else {
+			c.oldestPending.CompareAndSwap(0, op.created.UnixMicro())
+		}
+		if c.maxBatchBytes > 0 {
+			op.size = estimateSize(kind, payloadArgs(p))
+			c.pendingBytes.Add(op.size)
+		}
+	}
+	// Set of the latest call wins, if Sets of the same key are merged
+	if kind == Set {
+		op.seq = c.setCalls.Add(1)
+	}
+	if batch != 0 {
+		op.batches = append(op.batches, batch)
+	}
+	// callers are tracked only if duplicates are not coalesced silently
+	if c.duplicatePolicy != DuplicateCoalesce {
+		op.callers = append(op.callers, ctx)
+	}
+	if c.admitter != nil {
+		op.admitted = append(op.admitted, ctx)
+	}
+	if c.propagateDeadlines {
+		if d, ok := ctx.Deadline(); ok && (op.deadline.IsZero() || d.Before(op.deadline)) {
+			op.deadline = d
+		}
+	}
+	c.stats.enqueued(kind, ok)
+	if c.soloGrace > 0 {
+		c.lastEnqueue.Store(c.clock.Now().UnixMicro())
+	}
+	// background goroutine is woken up to arm TTL trigger for the first listener and to flush the full cache
+	if n := c.activeListeners.Add(1); n == 1 || n > c.storageThresholdSize.Load() || c.bytesExceeded() {
+		c.signal()
+	}
+	return op, nil
+}
+
+// hash returns hash of the operation, identical commands of operations excluded from deduplication
+// get unique hashes, so each of them is executed separately
+func (c *cache) hash(kind operationPrefix, p payload) string {
+	h := hashPayload(kind, p)
+	if _, ok := c.noDedup[kind]; ok {
+		h += "#" + strconv.FormatUint(c.unique.Add(1), 10)
+	}
+	return h
+}
+
+// setLatest remembers the storage key of the latest operation with the hash, if it differs from the hash
+func (c *cache) setLatest(sh *shard, h, key string) {
+	if key != h {
+		if sh.latest == nil {
+			sh.latest = make(map[string]string)
+		}
+		sh.latest[h] = key
+		return
+	}
+	if sh.latest != nil {
+		delete(sh.latest, h)
+	}
+}
+
+// remove deletes operation from shard, mutex of shard should be locked
+func (c *cache) remove(sh *shard, hash string, op *redisOperation) {
+	delete(sh.storage, hash)
+	for _, l := range op.listeners {
+		c.waiters.Delete((<-chan interface{})(l))
+	}
+	if h, _, ok := strings.Cut(hash, "#"); ok && sh.latest[h] == hash {
+		delete(sh.latest, h)
+	}
+	c.pendingBytes.Add(-op.size)
+	c.operations.Add(-1)
+}
+
+// checkDuplicate applies duplicatePolicy if caller with the same ctx already awaits the operation
+func (c *cache) checkDuplicate(ctx context.Context, op *redisOperation) error {
+	if c.duplicatePolicy == DuplicateCoalesce {
+		return nil
+	}
+	for _, caller := range op.callers {
+		if caller != ctx {
+			continue
+		}
+		if c.duplicatePolicy == DuplicateReject {
+			return fmt.Errorf("%w: %s", ErrDuplicateCommand, op.kind)
+		}
+		c.log.Error(fmt.Sprintf("%s: %s\n%s", ErrDuplicateCommand, op.kind, debug.Stack()))
+		return nil
+	}
+	return nil
+}
+
+// len returns the number of operations in storage
+func (c *cache) len() int {
+	return int(c.operations.Load())
+}
+
+// shard returns the shard of storage, which contains operation with the given hash
+func (c *cache) shard(hash string) *shard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	// unique keys of operations with the same hash are placed in the same shard
+	hash, _, _ = strings.Cut(hash, "#")
+	return c.shards[maphash.String(c.seed, hash)%uint64(len(c.shards))]
+}
+
+// drain waits until there are no active listeners left in storage
+// it checks the number of listeners every runInterval, same as run does
+func (c *cache) drain(ctx context.Context) error {
+	for c.activeListeners.Load() > 0 {
+		t := c.clock.NewTimer(time.Duration(c.runInterval.Load()))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C():
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"time"
+)
+
+// CheckedClient is a mode of the Client, where async commands report synchronously why they weren't queued,
+// f.e. ErrCacheStopped or ErrPauseLimitExceeded, so callers may retry elsewhere without reading the channel,
+// channel is nil then, otherwise it's the same channel as returned by *Async methods of Client
+type CheckedClient interface {
+	HDelAsync(ctx context.Context, key string, fields ...string) (<-chan interface{}, error)
+	ExpireAsync(ctx context.Context, key string, expiration time.Duration) (<-chan interface{}, error)
+	HGetAsync(ctx context.Context, key, field string) (<-chan interface{}, error)
+	HGetAllAsync(ctx context.Context, key string) (<-chan interface{}, error)
+	GetAsync(ctx context.Context, key string) (<-chan interface{}, error)
+	DelAsync(ctx context.Context, keys ...string) (<-chan interface{}, error)
+	SMembersAsync(ctx context.Context, key string) (<-chan interface{}, error)
+	MGetAsync(ctx context.Context, keys ...string) (<-chan interface{}, error)
+	EvalROAsync(ctx context.Context, script string, keys []string, args ...interface{}) (<-chan interface{}, error)
+	FCallROAsync(ctx context.Context, function string, keys []string, args ...interface{}) (<-chan interface{}, error)
+	ExistsAsync(ctx context.Context, keys ...string) (<-chan interface{}, error)
+	TimeAsync(ctx context.Context) (<-chan interface{}, error)
+	EchoAsync(ctx context.Context, message interface{}) (<-chan interface{}, error)
+	SafeDelAsync(ctx context.Context, key, expectedType string) (<-chan interface{}, error)
+	SetAsync(ctx context.Context, key string, value interface{}, expiration time.Duration) (<-chan interface{}, error)
+	DoAsync(ctx context.Context, args ...interface{}) (<-chan interface{}, error)
+}
+
+type checkedClient struct {
+	cache *cache
+}
+
+func (a checkedClient) HDelAsync(ctx context.Context, key string, fields ...string) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, HDel, transformHDel(key, fields...))
+}
+
+func (a checkedClient) ExpireAsync(ctx context.Context, key string, expiration time.Duration) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, Expire, transformExpire(key, expiration))
+}
+
+func (a checkedClient) HGetAsync(ctx context.Context, key, field string) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, HGet, transformHGet(key, field))
+}
+
+func (a checkedClient) HGetAllAsync(ctx context.Context, key string) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, HGetAll, transformHGetAll(key))
+}
+
+func (a checkedClient) GetAsync(ctx context.Context, key string) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, Get, transformGet(key))
+}
+
+func (a checkedClient) DelAsync(ctx context.Context, keys ...string) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, Del, transformDel(keys...))
+}
+
+func (a checkedClient) SMembersAsync(ctx context.Context, key string) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, SMembers, transformSMembers(key))
+}
+
+func (a checkedClient) MGetAsync(ctx context.Context, keys ...string) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, MGet, transformMGet(keys...))
+}
+
+func (a checkedClient) EvalROAsync(ctx context.Context, script string, keys []string, args ...interface{}) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, EvalRO, transformScript(script, keys, args...))
+}
+
+func (a checkedClient) FCallROAsync(ctx context.Context, function string, keys []string, args ...interface{}) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, FCallRO, transformScript(function, keys, args...))
+}
+
+func (a checkedClient) ExistsAsync(ctx context.Context, keys ...string) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, Exists, transformExists(keys...))
+}
+
+func (a checkedClient) TimeAsync(ctx context.Context) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, Time, transformTime())
+}
+
+func (a checkedClient) EchoAsync(ctx context.Context, message interface{}) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, Echo, transformEcho(message))
+}
+
+func (a checkedClient) SafeDelAsync(ctx context.Context, key, expectedType string) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, SafeDel, transformSafeDel(key, expectedType))
+}
+
+func (a checkedClient) SetAsync(ctx context.Context, key string, value interface{}, expiration time.Duration) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, Set, transformSet(key, value, expiration))
+}
+
+func (a checkedClient) DoAsync(ctx context.Context, args ...interface{}) (<-chan interface{}, error) {
+	return a.cache.tryEnqueue(ctx, Do, transformDo(args...))
+}
@@ -0,0 +1,59 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestChecked(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key1").SetVal("john")
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200),
+		WithPauseLimit(1),
+		WithLogger(&errorRecorder{}))
+	assert.Nil(t, err)
+
+	c.Pause()
+	resCh, err := c.Checked().GetAsync(ctx, "key1")
+	assert.Nil(t, err)
+	// paused cache is full
+	failedCh, err := c.Checked().GetAsync(ctx, "key2")
+	assert.ErrorIs(t, err, ErrPauseLimitExceeded)
+	assert.Nil(t, failedCh)
+	c.Resume()
+
+	res, ok := <-resCh
+	assert.True(t, ok)
+	assert.Equal(t, "john", res.(*redis.StringCmd).Val())
+
+	c.Stop()
+	failedCh, err = c.Checked().HDelAsync(ctx, "key1", "name")
+	assert.ErrorIs(t, err, ErrCacheStopped)
+	assert.Nil(t, failedCh)
+}
+
+func TestCheckedDuplicate(t *testing.T) {
+	var ctx = context.TODO()
+	db, _ := redismock.NewClientMock()
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200),
+		WithDuplicatePolicy(DuplicateReject))
+	assert.Nil(t, err)
+
+	c.Pause()
+	_, err = c.Checked().GetAsync(ctx, "key1")
+	assert.Nil(t, err)
+	_, err = c.Checked().GetAsync(ctx, "key1")
+	assert.ErrorIs(t, err, ErrDuplicateCommand)
+	assert.Equal(t, 1, c.Pending())
+}
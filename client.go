@@ -5,6 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"github.com/redis/go-redis/v9"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -20,44 +26,285 @@ const (
 	Del
 	SMembers
 	MGet
+	EvalRO
+	FCallRO
+	Exists
+	Time
+	Echo
+	SafeDel
+	Set
+	Do
+	Cmd
 
-	defaultCacheTTL         = time.Microsecond * 1000
-	defaultCacheSize   uint = 100
-	defaultRunInterval      = 50 * time.Microsecond
+	defaultCacheTTL           = time.Microsecond * 1000
+	defaultCacheSize     uint = 100
+	defaultRunInterval        = 50 * time.Microsecond
+	defaultPauseLimit    uint = 10000
+	defaultStorageShards uint = 1
+	maxStorageShards     uint = 1024
+	defaultWorkers       uint = 1
+	maxWorkers           uint = 256
+	// defaultReadTimeout is a read timeout go-redis applies to clients without one
+	defaultReadTimeout = 3 * time.Second
 
 	resultChannelBufferSize = 1
 )
 
+// DuplicatePolicy defines behavior for identical commands enqueued twice by the same caller before the flush,
+// callers are identified by ctx passed to the command
+type DuplicatePolicy byte
+
+const (
+	// DuplicateCoalesce serves duplicates with the single redis command silently, it's a default behavior
+	DuplicateCoalesce DuplicatePolicy = iota
+	// DuplicateWarn serves duplicates with the single redis command, but logs them with the stack trace
+	DuplicateWarn
+	// DuplicateReject fails duplicates with ErrDuplicateCommand
+	DuplicateReject
+)
+
+// DropPolicy defines behavior for results, which listeners don't receive within delivery timeout,
+// f.e. because the result channel was closed by the caller; such results are dropped anyway, so one bad consumer
+// can't wedge delivery of others, and counted in Stats.DroppedResults
+type DropPolicy byte
+
+const (
+	// DropCount drops results counting them in stats only, it's a default behavior
+	DropCount DropPolicy = iota
+	// DropLog drops results counting them in stats and logs ErrResultDropped
+	DropLog
+)
+
+// OverflowPolicy defines behavior for commands, which find the cache full, see WithMaxPending
+type OverflowPolicy byte
+
+const (
+	// OverflowBlock blocks the caller until a pending command is completed, ctx is done or cache is stopped,
+	// it's a default behavior
+	OverflowBlock OverflowPolicy = iota
+	// OverflowReject fails commands with ErrMaxPendingExceeded
+	OverflowReject
+	// OverflowDirect executes commands directly by redis client, without batching
+	OverflowDirect
+)
+
+// operationNames contains names of supported redis commands
+var operationNames = map[operationPrefix]string{
+	HDel:     "HDel",
+	Expire:   "Expire",
+	HGet:     "HGet",
+	HGetAll:  "HGetAll",
+	Get:      "Get",
+	Del:      "Del",
+	SMembers: "SMembers",
+	MGet:     "MGet",
+	EvalRO:   "EvalRO",
+	FCallRO:  "FCallRO",
+	Exists:   "Exists",
+	Time:     "Time",
+	Echo:     "Echo",
+	SafeDel:  "SafeDel",
+	Set:      "Set",
+	Do:       "Do",
+	Cmd:      "Cmd",
+}
+
+// operationKind returns kind of built-in or registered custom operation by its name
+func operationKind(name string) (operationPrefix, bool) {
+	for kind, builtIn := range operationNames {
+		if builtIn == name {
+			return kind, true
+		}
+	}
+	if custom, ok := lookupOperation(name); ok {
+		return custom.kind, true
+	}
+	return 0, false
+}
+
+func (o operationPrefix) String() string {
+	if name, ok := operationNames[o]; ok {
+		return name
+	}
+	if custom, ok := lookupOperationKind(o); ok {
+		return custom.name
+	}
+	return "Unknown"
+}
+
+// readOnly reports whether operation doesn't modify data,
+// so it may be served by a replica
+func (o operationPrefix) readOnly() bool {
+	switch o {
+	case HGet, HGetAll, Get, SMembers, MGet, EvalRO, FCallRO, Exists, Time, Echo:
+		return true
+	}
+	return false
+}
+
 var (
 	ErrChannelClosed = errors.New("unexpected error: channel closed")
 	ErrRedisIsNil    = errors.New("redis client is nil")
 	ErrHashNotFound  = errors.New("hash  not found")
 	ErrCacheStopped  = errors.New("cache is stopped")
+	// ErrOperationExpired is returned to listeners of operation which was not executed within operation TTL
+	ErrOperationExpired = errors.New("operation expired in cache")
+	// ErrPauseLimitExceeded is logged when command is rejected because paused cache is full
+	ErrPauseLimitExceeded = errors.New("cache is paused and pause limit exceeded")
+	// ErrDuplicateCommand is returned for identical command enqueued twice with the same ctx, see DuplicateReject
+	ErrDuplicateCommand = errors.New("duplicate command from the same caller")
+	// ErrTypeMismatch is returned by SafeDel if the key has another type than expected, the key is kept
+	ErrTypeMismatch = errors.New("type of key mismatch")
+	// ErrCommandTimeout is returned by synchronous commands, which didn't receive the result within command timeout
+	ErrCommandTimeout = errors.New("command timeout")
+	// ErrPipelineTimeout is returned to listeners of commands, which pipeline wasn't executed within exec timeout,
+	// it's a context.DeadlineExceeded error
+	ErrPipelineTimeout = fmt.Errorf("pipeline execution timeout: %w", context.DeadlineExceeded)
+	// ErrResultDropped is logged when listener doesn't receive the result, see WithDropPolicy
+	ErrResultDropped = errors.New("result dropped, listener didn't receive it")
+	// ErrMaxPendingExceeded is returned for commands rejected because the cache is full, see OverflowReject
+	ErrMaxPendingExceeded = errors.New("max pending commands exceeded")
+
+	// Errors of options validation, returned by NewAutoPipeline
+	ErrInvalidContext         = errors.New("invalid context")
+	ErrInvalidTTL             = errors.New("invalid cache ttl")
+	ErrInvalidMaxSize         = errors.New("invalid max size")
+	ErrInvalidRunInterval     = errors.New("invalid run interval")
+	ErrInvalidOperationTTL    = errors.New("invalid operation ttl")
+	ErrInvalidCommandTimeout  = errors.New("invalid command timeout")
+	ErrInvalidExecTimeout     = errors.New("invalid pipeline exec timeout")
+	ErrInvalidPauseLimit      = errors.New("invalid pause limit")
+	ErrInvalidAdaptiveTTL     = errors.New("invalid adaptive ttl factor")
+	ErrInvalidFlushJitter     = errors.New("invalid flush jitter fraction")
+	ErrInvalidStorageShards   = errors.New("invalid number of storage shards")
+	ErrInvalidWorkers         = errors.New("invalid number of workers")
+	ErrInvalidDeliveryWorkers = errors.New("invalid number of delivery workers")
+	ErrInvalidDeliveryTimeout = errors.New("invalid delivery timeout")
+	ErrInvalidMaxDelay        = errors.New("invalid max delay")
+	ErrInvalidNoDedup         = errors.New("invalid operation excluded from deduplication")
+	ErrInvalidKeyPrefix       = errors.New("invalid key prefix")
+	ErrInvalidDedupWindow     = errors.New("invalid dedup window")
+	ErrInvalidWriteLane       = errors.New("invalid write lane")
+	ErrInvalidMaxPending      = errors.New("invalid max pending")
+	ErrInvalidAutoTuning      = errors.New("invalid auto tuning")
+	ErrInvalidFailoverPause   = errors.New("invalid failover pause")
+	ErrInvalidTransactional   = errors.New("invalid transactional mode")
+	ErrInvalidStandby         = errors.New("invalid standby")
+	ErrInvalidSoloBypass      = errors.New("invalid solo bypass grace period")
+	ErrInvalidLogger          = errors.New("invalid logger")
+	ErrInvalidClock           = errors.New("invalid clock")
+	ErrInvalidEncoder         = errors.New("invalid snapshot encoder")
+	ErrInvalidExecutor        = errors.New("invalid executor")
+	ErrInvalidExpvar          = errors.New("invalid expvar name")
 )
 
 type Client interface {
+	Cmdable
 	HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd
-	HDelAsync(ctx context.Context, key string, fields ...string) chan interface{}
+	HDelAsync(ctx context.Context, key string, fields ...string) <-chan interface{}
+	HDelFuture(ctx context.Context, key string, fields ...string) *Future
 	Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd
-	ExpireAsync(ctx context.Context, key string, expiration time.Duration) chan interface{}
+	ExpireAsync(ctx context.Context, key string, expiration time.Duration) <-chan interface{}
+	ExpireFuture(ctx context.Context, key string, expiration time.Duration) *Future
 	HGet(ctx context.Context, key, field string) *redis.StringCmd
-	HGetAsync(ctx context.Context, key, field string) chan interface{}
+	HGetAsync(ctx context.Context, key, field string) <-chan interface{}
+	HGetFuture(ctx context.Context, key, field string) *Future
 	HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd
-	HGetAllAsync(ctx context.Context, key string) chan interface{}
+	HGetAllAsync(ctx context.Context, key string) <-chan interface{}
+	HGetAllFuture(ctx context.Context, key string) *Future
 	Get(ctx context.Context, key string) *redis.StringCmd
-	GetAsync(ctx context.Context, key string) chan interface{}
+	GetAsync(ctx context.Context, key string) <-chan interface{}
+	GetFuture(ctx context.Context, key string) *Future
 	Del(ctx context.Context, keys ...string) *redis.IntCmd
-	DelAsync(ctx context.Context, keys ...string) chan interface{}
+	DelAsync(ctx context.Context, keys ...string) <-chan interface{}
+	DelFuture(ctx context.Context, keys ...string) *Future
 	SMembers(ctx context.Context, key string) *redis.StringSliceCmd
-	SMembersAsync(ctx context.Context, key string) chan interface{}
+	SMembersAsync(ctx context.Context, key string) <-chan interface{}
+	SMembersFuture(ctx context.Context, key string) *Future
 	MGet(ctx context.Context, keys ...string) *redis.SliceCmd
-	MGetAsync(ctx context.Context, keys ...string) chan interface{}
+	MGetAsync(ctx context.Context, keys ...string) <-chan interface{}
+	MGetFuture(ctx context.Context, keys ...string) *Future
+	EvalRO(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd
+	EvalROAsync(ctx context.Context, script string, keys []string, args ...interface{}) <-chan interface{}
+	EvalROFuture(ctx context.Context, script string, keys []string, args ...interface{}) *Future
+	FCallRO(ctx context.Context, function string, keys []string, args ...interface{}) *redis.Cmd
+	FCallROAsync(ctx context.Context, function string, keys []string, args ...interface{}) <-chan interface{}
+	FCallROFuture(ctx context.Context, function string, keys []string, args ...interface{}) *Future
+	Exists(ctx context.Context, keys ...string) *redis.IntCmd
+	ExistsAsync(ctx context.Context, keys ...string) <-chan interface{}
+	ExistsFuture(ctx context.Context, keys ...string) *Future
+	ExistsMany(ctx context.Context, keys []string) (map[string]bool, error)
+	Sample(ctx context.Context, n int) ([]string, error)
+	Time(ctx context.Context) *redis.TimeCmd
+	TimeAsync(ctx context.Context) <-chan interface{}
+	TimeFuture(ctx context.Context) *Future
+	Echo(ctx context.Context, message interface{}) *redis.StringCmd
+	EchoAsync(ctx context.Context, message interface{}) <-chan interface{}
+	EchoFuture(ctx context.Context, message interface{}) *Future
+	SafeDel(ctx context.Context, key, expectedType string) *redis.IntCmd
+	SafeDelAsync(ctx context.Context, key, expectedType string) <-chan interface{}
+	SafeDelFuture(ctx context.Context, key, expectedType string) *Future
+	SetAsync(ctx context.Context, key string, value interface{}, expiration time.Duration) <-chan interface{}
+	SetFuture(ctx context.Context, key string, value interface{}, expiration time.Duration) *Future
+	Do(ctx context.Context, args ...interface{}) *redis.Cmd
+	DoAsync(ctx context.Context, args ...interface{}) <-chan interface{}
+	DoFuture(ctx context.Context, args ...interface{}) *Future
+	EnqueueCmd(ctx context.Context, cmd redis.Cmder) <-chan interface{}
+	Operation(ctx context.Context, name string, args ...interface{}) redis.Cmder
+	OperationAsync(ctx context.Context, name string, args ...interface{}) <-chan interface{}
+	Drain(ctx context.Context) error
+	Start() error
+	Stop()
+	Pause()
+	Resume()
+	Stats() Stats
+	Len() int
+	Pending() int
+	DumpPending() []OperationSnapshot
+	AwaitOnly() AwaitClient
+	Checked() CheckedClient
+	Typed() TypedClient
+	NewBatch() *Batch
+	Group(ctx context.Context, fn func(b *Batch)) []*Future
+	TxGroup(ctx context.Context, fn func(b *Batch)) []*Future
+	SetCacheTTL(ttl time.Duration) error
+	SetMaxSize(size uint) error
+	SetRunInterval(interval time.Duration) error
+	Config() Config
+	IsRunning() bool
+	LastFlushTime() time.Time
+	LastError() error
+	DebugHandler() http.Handler
 }
 
 type Logger interface {
 	Error(args ...interface{})
 }
 
+// LeveledLogger is an optional extension of Logger, which gets messages of lower levels too, see WithLogLevel:
+// flush decisions and composition of batches at debug level, config changes at info level and slow pipelines
+// at warn level; logger may implement some of these methods only, f.e. Info, messages of other levels are skipped
+type LeveledLogger interface {
+	Logger
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warn(args ...interface{})
+}
+
+// LogLevel is a min level of messages logged by LeveledLogger, see WithLogLevel
+type LogLevel int8
+
+const (
+	// LogDebug logs flush decisions, composition of batches and executed pipelines
+	LogDebug LogLevel = iota - 1
+	// LogInfo logs config changes, it's a default level
+	LogInfo
+	// LogWarn logs slow pipelines, which are executed much longer than the rolling RTT estimate
+	LogWarn
+	// LogError logs errors only
+	LogError
+)
+
 type defaultLogger struct{}
 
 func (l *defaultLogger) Error(args ...interface{}) {
@@ -69,54 +316,425 @@ type config struct {
 	// ctx is a context
 	ctx context.Context
 	// ttl is time to live of cached redis queries
-	// on end of ttl this package will perform a pipelined call to a redis
+	// once the oldest query lives for ttl this package will perform a pipelined call to a redis
 	// which will contain all queries from the cache
 	ttl time.Duration
 	// maxSize is a number of queries to keep in cache
 	// once cache storageThresholdSize is bigger than maxSize this package will perform a pipelined call to a redis
 	// which will contain all queries from the cache
 	maxSize uint
-	// runInterval is an interval between runs of main runtime
-	// where we check certain conditions (ttl or maxSize) and run redis pipeline
+	// runInterval is a min interval between checks of certain conditions (ttl or maxSize) of main runtime,
+	// checks are triggered by enqueue and timers, so idle cache doesn't wake up at all
 	runInterval time.Duration
+	// operationTTL is a max lifetime of a single operation in the cache
+	// if operation was not executed in this time (f.e. because autopipeline is paused)
+	// its listeners receive ErrOperationExpired, and operation is removed from the cache
+	// zero value disables this check
+	operationTTL time.Duration
+	// splitCrossSlot enables splitting of multi-key commands (MGet, Del) with keys in different
+	// redis cluster hash slots into per-slot commands, results of which are merged back
+	splitCrossSlot bool
+	// noDedup contains names of operations, identical commands of which are not coalesced
+	noDedup []string
+	// dedupWindow is a max age of queued operation, which identical commands are attached to, zero means no limit
+	dedupWindow time.Duration
+	// writeLaneTTL is a time interval to run redis pipeline of mutating commands, which are queued separately
+	// from read commands, zero means lanes are disabled
+	writeLaneTTL time.Duration
+	// writeLaneSize is a number of listeners of mutating commands to run redis pipeline, see writeLaneTTL
+	writeLaneSize uint
+	// maxPending is a max number of listeners in the cache, zero means no limit
+	maxPending uint
+	// overflowPolicy defines behavior for commands, which find the cache full
+	overflowPolicy OverflowPolicy
+	// readRetries is a max number of requeues of read-only command failed with transient error, zero disables requeues
+	readRetries uint
+	// nodeBatching makes commands grouped into one pipeline per cluster node of their keys
+	nodeBatching bool
+	// masterForKey resolves cluster node of the key, nil means the one of cluster client
+	masterForKey masterForKeyFunc
+	// replicaReads makes read-only commands pipelined apart from mutating ones, so they may be served by replicas
+	replicaReads bool
+	// transactional makes every flush executed as a single MULTI/EXEC transaction
+	transactional bool
+	// failoverPause is a pause of flushing after commands fail with failover errors, zero means disabled
+	failoverPause time.Duration
+	// standby is a client, which commands are switched to, when the primary one is unreachable, nil means disabled
+	standby redis.UniversalClient
+	// standbyFailures is a number of consecutive failed flushes, after which the client in use is switched
+	standbyFailures uint
+	// autoTuning contains targets and bounds of ttl and max size controller, disabled if no target is set
+	autoTuning AutoTuning
+	// coalesceGets makes Get commands of single pipeline executed as a single MGet command
+	coalesceGets bool
+	// mergeDels makes Del commands of single pipeline executed as a single Del command
+	mergeDels bool
+	// mergeSets makes Set commands queued and plain ones of single pipeline executed as a single MSet command
+	mergeSets bool
+	// mergeHashReads makes HGet commands served by HGetAll of the same key queued for the same flush
+	mergeHashReads bool
+	// duplicatePolicy defines behavior for identical commands enqueued twice with the same ctx
+	duplicatePolicy DuplicatePolicy
+	// propagateDeadlines makes pipeline execution honor the earliest deadline of callers contexts
+	propagateDeadlines bool
+	// adaptiveTTL is a factor of measured round-trip time, which makes time interval to run redis pipeline,
+	// zero means static ttl
+	adaptiveTTL float64
+	// flushJitter is a max fraction of time interval to run redis pipeline, by which pipeline is run earlier,
+	// zero means no jitter
+	flushJitter float64
+	// storageShards is a number of partitions of storage, each with its own mutex
+	storageShards uint
+	// lockFreeEnqueue puts commands of channel based methods to the lock-free queue instead of the storage
+	lockFreeEnqueue bool
+	// workers is a number of pipelines executed concurrently by a single flush
+	workers uint
+	// deliveryWorkers is a number of goroutines delivering results of a flush, zero means the background goroutine
+	deliveryWorkers uint
+	// deliveryTimeout is a max time a result waits for the listener, which doesn't receive it, zero means no wait
+	deliveryTimeout time.Duration
+	// dropPolicy defines behavior for results, which listeners don't receive within deliveryTimeout
+	dropPolicy DropPolicy
+	// maxDelay is a max time between enqueue of command and execution of its pipeline, zero means no limit
+	maxDelay time.Duration
+	// soloBypass is a grace period, after which the only pending command is executed without waiting for ttl,
+	// zero means disabled
+	soloBypass time.Duration
+	// maxCommandsPerPipeline is a max number of commands in single pipeline, bigger flush is split, zero means unlimited
+	maxCommandsPerPipeline uint
+	// maxBatchBytes is an estimated size of queued commands in bytes to run redis pipeline, zero means no limit
+	maxBatchBytes uint
+	// keyPrefix is added to every key of queued commands
+	keyPrefix string
+	// fallback makes commands, which aren't batched, executed directly by redis client
+	fallback bool
+	// admitter controls admission of commands, f.e. to enforce per-tenant quotas
+	admitter Admitter
+	// limiter caps the rate of pipelines sent to redis
+	limiter RateLimiter
+	// orderedDelivery makes results of async commands available in the order they were enqueued
+	// with the same ctx
+	orderedDelivery bool
+	// injectedLatency is an artificial delay of every pipeline execution, used to rehearse slow batching
+	injectedLatency time.Duration
+	// debugSleep is a duration of DEBUG SLEEP command added to every pipeline, used to rehearse slow redis
+	debugSleep time.Duration
+	// commandTimeout is a max time synchronous commands wait for the result, zero value means no timeout
+	commandTimeout time.Duration
+	// execTimeout is a timeout of single pipeline execution, zero value means no timeout
+	// by default it's derived from read and write timeouts of go-redis client
+	execTimeout time.Duration
+	// pipelineExecTimeout is a configured timeout of single pipeline execution, zero value means execTimeout
+	pipelineExecTimeout time.Duration
+	// pauseLimit is a max number of listeners to keep in the cache while it's paused
+	// commands above this limit are rejected until cache is resumed
+	pauseLimit uint
 	// Basic logger interface
 	logger Logger
+	// logLevel is a min level of messages logged, if logger supports it
+	logLevel LogLevel
+	// clock is a source of time
+	clock Clock
+	// wait is a waiting primitive of the background goroutine, nil means waiting on clock timer
+	wait waitFunc
+	// batchHook receives records of executed pipelines
+	batchHook func(BatchRecord)
+	// onFlush receives stats of every flush
+	onFlush func(FlushStats)
+	// deadLetterHook receives commands dropped without execution
+	deadLetterHook func(DeadLetter)
+	// configHook receives changes of runtime-tunable settings
+	configHook func(ConfigChange)
+	// dumpWriter is a destination for the snapshot of pending queue in case of panic
+	// nil value disables crash dumps
+	dumpWriter io.Writer
+	// dumpEncoder serializes the snapshot of pending queue to dumpWriter
+	dumpEncoder SnapshotEncoder
+	// executor builds and executes pipelines
+	executor Executor
+	// middlewares wrap enqueueing of commands and execution of pipelines
+	middlewares []Middleware
+	// commandHooks are run around every logical command with ctx of its caller
+	commandHooks []redis.Hook
+	// metricsSinks receive events of autopipeline, empty means disabled
+	metricsSinks []MetricsSink
+	// expvar is a name stats are published under to expvar, empty means disabled
+	expvar string
+	// onCreate hooks are called once autopipeline is created
+	onCreate []func(a *Autopipeline) error
 }
 
 type Autopipeline struct {
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
 	cache       *cache
 	cnf         *config
 }
 
-func NewAutoPipeline(redisClient *redis.Client, options ...func(a *Autopipeline)) (Client, error) {
-	if redisClient == nil {
+// NewAutoPipeline returns autopipeline, which batches commands to redisClient, f.e. single node or Sentinel
+// *redis.Client, *redis.ClusterClient or *redis.Ring, pipelines of which route commands to nodes by their keys,
+// so client returned by redis.NewUniversalClient may be passed as is; in redis cluster enable
+// WithCrossSlotSplitting, so multi-key commands don't fail with CROSSSLOT error
+func NewAutoPipeline(redisClient redis.UniversalClient, options ...func(a *Autopipeline)) (Client, error) {
+	if isNilClient(redisClient) {
 		return nil, ErrRedisIsNil
 	}
 	var logger Logger = &defaultLogger{}
 	a := &Autopipeline{
 		redisClient: redisClient,
 		cnf: &config{
-			ctx:         context.TODO(),
-			ttl:         defaultCacheTTL,
-			maxSize:     defaultCacheSize,
-			runInterval: defaultRunInterval,
-			logger:      logger,
+			ctx:           context.TODO(),
+			ttl:           defaultCacheTTL,
+			maxSize:       defaultCacheSize,
+			writeLaneSize: defaultCacheSize,
+			runInterval:   defaultRunInterval,
+			pauseLimit:    defaultPauseLimit,
+			storageShards: defaultStorageShards,
+			workers:       defaultWorkers,
+			execTimeout:   execTimeoutFromClient(redisClient),
+			logger:        logger,
+			dumpEncoder:   JSONSnapshotEncoder{},
+			executor:      PipelineExecutor{},
+			clock:         realClock{},
 		},
 	}
 	for _, o := range options {
 		o(a)
 	}
-	a.cache = newCache(a.redisClient, a.cnf.ctx, a.cnf.ttl, a.cnf.runInterval, a.cnf.maxSize, a.cnf.logger)
+	if err := a.cnf.validate(); err != nil {
+		return nil, err
+	}
+	a.cache = newCache(a.redisClient, a.cnf)
+	if a.cnf.expvar != "" {
+		if err := publishExpvar(a.cnf.expvar, *a); err != nil {
+			a.Stop()
+			return nil, err
+		}
+	}
+	for _, hook := range a.cnf.onCreate {
+		if err := hook(a); err != nil {
+			a.Stop()
+			return nil, err
+		}
+	}
 	return a, nil
 }
 
+// NewAutoPipelineFromConfig returns autopipeline configured with cfg,
+// options are applied after the config, f.e. to set a context or a logger
+func NewAutoPipelineFromConfig(redisClient redis.UniversalClient, cfg Config, options ...func(a *Autopipeline)) (Client, error) {
+	return NewAutoPipeline(redisClient, append(cfg.options(), options...)...)
+}
+
+// validate checks that configuration doesn't lead to busy-loops or never-flushing cache
+func (c *config) validate() error {
+	if c.ctx == nil {
+		return fmt.Errorf("%w: context is nil", ErrInvalidContext)
+	}
+	if err := validateTTL(c.ttl); err != nil {
+		return err
+	}
+	if err := validateMaxSize(c.maxSize); err != nil {
+		return err
+	}
+	if err := validateRunInterval(c.runInterval); err != nil {
+		return err
+	}
+	// operation should have a chance to be executed before it's expired
+	if c.operationTTL < 0 || (c.operationTTL > 0 && c.operationTTL <= c.ttl) {
+		return fmt.Errorf("%w: %s, should be zero or greater than cache ttl %s", ErrInvalidOperationTTL, c.operationTTL, c.ttl)
+	}
+	if c.commandTimeout < 0 {
+		return fmt.Errorf("%w: %s, should be zero or positive", ErrInvalidCommandTimeout, c.commandTimeout)
+	}
+	if c.pipelineExecTimeout < 0 {
+		return fmt.Errorf("%w: %s, should be zero or positive", ErrInvalidExecTimeout, c.pipelineExecTimeout)
+	}
+	if c.pauseLimit == 0 || c.pauseLimit > math.MaxInt32 {
+		return fmt.Errorf("%w: %d, should be in range [1, %d]", ErrInvalidPauseLimit, c.pauseLimit, math.MaxInt32)
+	}
+	if c.storageShards == 0 || c.storageShards > maxStorageShards {
+		return fmt.Errorf("%w: %d, should be in range [1, %d]", ErrInvalidStorageShards, c.storageShards, maxStorageShards)
+	}
+	if c.workers == 0 || c.workers > maxWorkers {
+		return fmt.Errorf("%w: %d, should be in range [1, %d]", ErrInvalidWorkers, c.workers, maxWorkers)
+	}
+	if c.deliveryWorkers > maxWorkers {
+		return fmt.Errorf("%w: %d, should be in range [0, %d]", ErrInvalidDeliveryWorkers, c.deliveryWorkers, maxWorkers)
+	}
+	if c.deliveryTimeout < 0 {
+		return fmt.Errorf("%w: %s, should be zero or positive", ErrInvalidDeliveryTimeout, c.deliveryTimeout)
+	}
+	// hash tag of the prefix would be hashed instead of the one of the key, so keys would be moved between slots
+	if strings.Contains(c.keyPrefix, "{") {
+		return fmt.Errorf("%w: %q, should not contain \"{\"", ErrInvalidKeyPrefix, c.keyPrefix)
+	}
+	for _, name := range c.noDedup {
+		if _, ok := operationKind(name); !ok {
+			return fmt.Errorf("%w: %s, should be a name of built-in or registered operation", ErrInvalidNoDedup, name)
+		}
+	}
+	if c.writeLaneTTL < 0 {
+		return fmt.Errorf("%w: ttl %s, should be zero or positive", ErrInvalidWriteLane, c.writeLaneTTL)
+	}
+	if c.writeLaneTTL > 0 && (c.writeLaneSize == 0 || c.writeLaneSize > math.MaxInt32) {
+		return fmt.Errorf("%w: max size %d, should be in range [1, %d]", ErrInvalidWriteLane, c.writeLaneSize, math.MaxInt32)
+	}
+	if c.maxPending > math.MaxInt32 {
+		return fmt.Errorf("%w: %d, should be in range [0, %d]", ErrInvalidMaxPending, c.maxPending, math.MaxInt32)
+	}
+	if c.dedupWindow < 0 {
+		return fmt.Errorf("%w: %s, should be zero or positive", ErrInvalidDedupWindow, c.dedupWindow)
+	}
+	// commands are requeued through failover until they are expired
+	if c.failoverPause < 0 || (c.failoverPause > 0 && c.operationTTL == 0) {
+		return fmt.Errorf("%w: %s, should be zero or positive with non-zero operation ttl", ErrInvalidFailoverPause, c.failoverPause)
+	}
+	// transaction is a single pipeline
+	if c.transactional && (c.maxCommandsPerPipeline > 0 || c.nodeBatching || c.replicaReads) {
+		return fmt.Errorf("%w: flush can't be split by max commands per pipeline, node batching or replica reads", ErrInvalidTransactional)
+	}
+	if c.standby != nil && (isNilClient(c.standby) || c.standbyFailures == 0 || c.standbyFailures > math.MaxInt32) {
+		return fmt.Errorf("%w: %d failures, should be non-nil client and failures in range [1, %d]", ErrInvalidStandby, c.standbyFailures, math.MaxInt32)
+	}
+	if err := c.validateAutoTuning(); err != nil {
+		return err
+	}
+	if c.maxDelay < 0 {
+		return fmt.Errorf("%w: %s, should be zero or positive", ErrInvalidMaxDelay, c.maxDelay)
+	}
+	if c.soloBypass < 0 {
+		return fmt.Errorf("%w: %s, should be zero or positive", ErrInvalidSoloBypass, c.soloBypass)
+	}
+	if !(c.adaptiveTTL >= 0) {
+		return fmt.Errorf("%w: %v, should be zero or positive", ErrInvalidAdaptiveTTL, c.adaptiveTTL)
+	}
+	if !(c.flushJitter >= 0 && c.flushJitter < 1) {
+		return fmt.Errorf("%w: %v, should be in range [0, 1)", ErrInvalidFlushJitter, c.flushJitter)
+	}
+	if c.clock == nil {
+		return fmt.Errorf("%w: clock is nil", ErrInvalidClock)
+	}
+	if c.logger == nil {
+		return fmt.Errorf("%w: logger is nil", ErrInvalidLogger)
+	}
+	if c.dumpWriter != nil && c.dumpEncoder == nil {
+		return fmt.Errorf("%w: encoder is nil", ErrInvalidEncoder)
+	}
+	if c.executor == nil {
+		return fmt.Errorf("%w: executor is nil", ErrInvalidExecutor)
+	}
+	return nil
+}
+
+// validateTTL checks ttl of the cache, it's shared by constructor and SetCacheTTL
+func validateTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("%w: %s, should be positive", ErrInvalidTTL, ttl)
+	}
+	return nil
+}
+
+// validateMaxSize checks max size of the cache, it's shared by constructor and SetMaxSize
+func validateMaxSize(size uint) error {
+	if size == 0 || size > math.MaxInt32 {
+		return fmt.Errorf("%w: %d, should be in range [1, %d]", ErrInvalidMaxSize, size, math.MaxInt32)
+	}
+	return nil
+}
+
+// validateRunInterval checks run interval, it's shared by constructor and SetRunInterval
+func validateRunInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("%w: %s, should be positive", ErrInvalidRunInterval, interval)
+	}
+	return nil
+}
+
+// validateAutoTuning checks that targets are not negative and bounds of enabled tuning are sane
+func (c *config) validateAutoTuning() error {
+	t := c.autoTuning
+	if t.TargetLatency < 0 || !(t.TargetRate >= 0) || t.Interval < 0 {
+		return fmt.Errorf("%w: targets and interval should be zero or positive", ErrInvalidAutoTuning)
+	}
+	if !t.enabled() {
+		return nil
+	}
+	if t.MinTTL <= 0 || t.MinTTL > t.MaxTTL {
+		return fmt.Errorf("%w: ttl bounds [%s, %s], should be positive and ordered", ErrInvalidAutoTuning, t.MinTTL, t.MaxTTL)
+	}
+	if t.MinSize == 0 || t.MinSize > t.MaxSize || t.MaxSize > math.MaxInt32 {
+		return fmt.Errorf("%w: max size bounds [%d, %d], should be ordered and in range [1, %d]", ErrInvalidAutoTuning, t.MinSize, t.MaxSize, math.MaxInt32)
+	}
+	// operation should have a chance to be executed before it's expired with the longest ttl
+	if c.operationTTL > 0 && c.operationTTL <= t.MaxTTL {
+		return fmt.Errorf("%w: max ttl %s, should be less than operation ttl %s", ErrInvalidAutoTuning, t.MaxTTL, c.operationTTL)
+	}
+	return nil
+}
+
+// isNilClient reports whether redis client is nil, including nil pointers of known clients
+func isNilClient(c redis.UniversalClient) bool {
+	switch c := c.(type) {
+	case *redis.Client:
+		return c == nil
+	case *redis.ClusterClient:
+		return c == nil
+	case *redis.Ring:
+		return c == nil
+	}
+	return c == nil
+}
+
+// execTimeoutFromClient returns a timeout of pipeline execution derived from options of go-redis client,
+// zero value is returned for clients, options of which are unknown
+func execTimeoutFromClient(c redis.UniversalClient) time.Duration {
+	switch c := c.(type) {
+	case *redis.Client:
+		return execTimeoutFromOptions(c.Options())
+	case *redis.ClusterClient:
+		opt := c.Options()
+		return execTimeoutFromOptions(&redis.Options{ReadTimeout: opt.ReadTimeout, WriteTimeout: opt.WriteTimeout})
+	case *redis.Ring:
+		// ring passes timeouts to clients of shards, which apply defaults of go-redis to zero values
+		opt := c.Options()
+		read, write := opt.ReadTimeout, opt.WriteTimeout
+		if read == 0 {
+			read = defaultReadTimeout
+		}
+		if write == 0 {
+			write = read
+		}
+		return execTimeoutFromOptions(&redis.Options{ReadTimeout: read, WriteTimeout: write})
+	}
+	return 0
+}
+
+// execTimeoutFromOptions returns a timeout of pipeline execution, which is enough
+// to write all commands and read all replies with timeouts of go-redis client,
+// zero value is returned if go-redis client has no timeouts
+func execTimeoutFromOptions(opt *redis.Options) time.Duration {
+	if opt.ReadTimeout <= 0 || opt.WriteTimeout <= 0 {
+		return 0
+	}
+	return opt.ReadTimeout + opt.WriteTimeout
+}
+
+// pipelineTimeout returns a timeout of single pipeline execution, configured one or derived from go-redis client
+func (c *config) pipelineTimeout() time.Duration {
+	if c.pipelineExecTimeout > 0 {
+		return c.pipelineExecTimeout
+	}
+	return c.execTimeout
+}
+
 func WithContext(ctx context.Context) func(a *Autopipeline) {
 	return func(a *Autopipeline) {
 		a.cnf.ctx = ctx
 	}
 }
 
+// WithCacheTTL sets max time the oldest queued command waits for the pipeline, it's measured since enqueue
+// of the command, not since the last pipeline, so back to back pipelines don't skew latency
 func WithCacheTTL(timeout time.Duration) func(a *Autopipeline) {
 	return func(a *Autopipeline) {
 		a.cnf.ttl = timeout
@@ -135,143 +753,1182 @@ func WithMaxSize(size uint) func(a *Autopipeline) {
 	}
 }
 
+// WithOperationTTL sets max lifetime of a single operation in the cache, operations aren't expired by default
+func WithOperationTTL(ttl time.Duration) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.operationTTL = ttl
+	}
+}
+
+// WithInjectedLatency delays every pipeline execution by d, it's intended for staging environments only,
+// to rehearse how services behave when the batching layer slows down
+func WithInjectedLatency(d time.Duration) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.injectedLatency = d
+	}
+}
+
+// WithDebugSleep adds DEBUG SLEEP command for d to every pipeline, so redis itself is slowed down,
+// it's intended for staging environments only, DEBUG command should be enabled in redis config
+func WithDebugSleep(d time.Duration) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.debugSleep = d
+	}
+}
+
+// WithCommandTimeout sets max time synchronous commands wait for the result,
+// they return a command with ErrCommandTimeout after it, f.e. if pipeline execution keeps failing
+// it may be overridden per call with ContextWithCommandTimeout, zero value means no timeout
+func WithCommandTimeout(timeout time.Duration) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.commandTimeout = timeout
+	}
+}
+
+// WithPipelineExecTimeout sets max time of single pipeline execution, so slow or unresponsive redis can't stall
+// the background goroutine, listeners of commands of timed out pipeline receive ErrPipelineTimeout and the next
+// pipeline is executed as usual, zero value (default) means the timeout derived from read and write timeouts
+// of go-redis client
+func WithPipelineExecTimeout(timeout time.Duration) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.pipelineExecTimeout = timeout
+	}
+}
+
+func WithPauseLimit(limit uint) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.pauseLimit = limit
+	}
+}
+
+func WithDuplicatePolicy(p DuplicatePolicy) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.duplicatePolicy = p
+	}
+}
+
+// WithAdmission sets an admission control of commands, which may reject commands of a caller identified
+// from ctx, f.e. to enforce per-tenant quotas with TenantQuota, rejected commands fail with the admitter's error
+func WithAdmission(admitter Admitter) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.admitter = admitter
+	}
+}
+
+// WithRateLimiter caps the rate of pipelines sent to redis, f.e. for shared clusters with an operations budget,
+// flush waits for the limiter before pipelines are executed, a flush split into several pipelines waits for each
+// of them, commands enqueued meanwhile wait for the next flush, so batches grow under heavy load instead of
+// the rate; waiting doesn't count towards pipeline exec timeout, lanes share the limiter, see WithWriteLane
+func WithRateLimiter(limiter RateLimiter) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.limiter = limiter
+	}
+}
+
+// WithDeadlinePropagation makes pipeline execution honor the earliest deadline of contexts of queued commands,
+// so tight caller deadlines are enforced against redis, keep in mind that the whole pipeline fails then
+func WithDeadlinePropagation(enabled bool) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.propagateDeadlines = enabled
+	}
+}
+
+// WithAdaptiveTTL makes time interval to run redis pipeline adapt to the measured round-trip time of pipelines,
+// interval is factor × rolling estimate of round-trip time, but not longer than ttl, see WithCacheTTL,
+// ttl is used until the first pipeline is executed, zero factor (default) means static ttl
+func WithAdaptiveTTL(factor float64) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.adaptiveTTL = factor
+	}
+}
+
+// WithFlushJitter runs redis pipeline earlier than ttl by random fraction of ttl up to the given one,
+// f.e. 0.1 makes ttl of 1ms random in range (0.9ms, 1ms], so many instances started simultaneously
+// don't make synchronized load spikes on redis, ttl is never extended, zero (default) means no jitter
+func WithFlushJitter(fraction float64) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.flushJitter = fraction
+	}
+}
+
+// WithStorageShards partitions storage of queued commands into n shards by hash of command, each with its own mutex,
+// so goroutines enqueueing different commands don't serialize on one lock at high enqueue rates,
+// f.e. runtime.GOMAXPROCS(0), default is 1
+func WithStorageShards(n uint) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.storageShards = n
+	}
+}
+
+// WithWorkers splits every flush into n pipelines, which are executed concurrently, each on its own connection
+// of redis client pool, it increases throughput when batches are large and round-trip time of redis dominates,
+// pipelines are split further if WithMaxCommandsPerPipeline is smaller, commands of Batch aren't split, default is 1
+func WithWorkers(n uint) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.workers = n
+	}
+}
+
+// WithDeliveryWorkers hands results of every flush off to n goroutines, which deliver them to listeners concurrently,
+// so one slow receiver, f.e. admitter or ordered delivery, doesn't delay others, and the next batch is collected
+// right away; results of the next flush wait for workers of the previous one, zero (default) means results
+// are delivered by the background goroutine itself
+func WithDeliveryWorkers(n uint) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.deliveryWorkers = n
+	}
+}
+
+// WithDeliveryTimeout sets a max time a result waits for the listener, which doesn't receive it, the result is dropped
+// then according to WithDropPolicy; result channels are buffered, so it matters only for misbehaving listeners,
+// f.e. ones writing to the channel themselves, zero (default) means the result is dropped right away;
+// with WithOrderedDelivery the wait delays results of other callers too
+func WithDeliveryTimeout(d time.Duration) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.deliveryTimeout = d
+	}
+}
+
+// WithDropPolicy sets behavior for results, which listeners don't receive within WithDeliveryTimeout,
+// results written to channels closed by the caller are dropped too, default is DropCount
+func WithDropPolicy(p DropPolicy) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.dropPolicy = p
+	}
+}
+
+// WithLockFreeEnqueue makes sync and *Async methods put commands to the lock-free queue without locking any mutex,
+// background goroutine coalesces identical commands while moving them to the storage, it's handy for write-heavy
+// workloads, where mutex and map insert dominate; await-only handles, batches, EnqueueCmd and checked mode
+// still lock the storage, as well as ordered delivery locks its own mutex
+func WithLockFreeEnqueue(enabled bool) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.lockFreeEnqueue = enabled
+	}
+}
+
+// WithMaxDelay guarantees that no command waits more than d between enqueue and pipeline execution,
+// independently of ttl and max size, f.e. when ttl is raised at runtime,
+// zero (default) means no limit
+func WithMaxDelay(d time.Duration) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.maxDelay = d
+	}
+}
+
+// WithSoloBypass executes solitary command right after grace period, if no other command arrives meanwhile,
+// so low traffic doesn't wait for ttl, commands are batched as usual as soon as concurrent traffic is detected,
+// grace should be much shorter than ttl, f.e. 20µs, zero (default) disables bypass
+func WithSoloBypass(grace time.Duration) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.soloBypass = grace
+	}
+}
+
+// WithMaxCommandsPerPipeline splits a flush of more than n commands into several pipelines of at most n commands,
+// which are executed sequentially, so thousands of accumulated commands don't make huge response buffers
+// and don't block other clients of redis, results of succeeded pipelines are delivered even if other pipelines fail,
+// commands of Batch aren't split, so their pipeline may exceed n, zero (default) means unlimited
+func WithMaxCommandsPerPipeline(n uint) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.maxCommandsPerPipeline = n
+	}
+}
+
+// WithMaxBatchBytes runs redis pipeline as soon as estimated size of queued commands serialized to redis protocol
+// exceeds n bytes, besides maxSize, so a few MGet or Del with large lists of keys don't make pathological batch,
+// zero (default) means no limit
+func WithMaxBatchBytes(n uint) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.maxBatchBytes = n
+	}
+}
+
+// WithKeyPrefix adds the prefix to every key of queued commands and of fallback commands, see WithFallback,
+// f.e. "tenant1:", so services may share redis, keys returned by Sample are stripped of it, arguments of Do,
+// EnqueueCmd and custom operations are not prefixed; the prefix can't contain "{", as hash tag of the prefix would
+// place all keys in the same slot of redis cluster, while hash tags of keys would be ignored
+func WithKeyPrefix(prefix string) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.keyPrefix = prefix
+	}
+}
+
+// WithFallback makes commands of Cmdable, which aren't batched, f.e. Set, executed directly by redis client,
+// otherwise they fail with ErrUnsupportedCommand
+func WithFallback(enabled bool) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.fallback = enabled
+	}
+}
+
+// WithOrderedDelivery makes results of async commands enqueued with the same ctx available
+// in the order they were enqueued, so caller may read result channels sequentially
+// without observing later results first
+func WithOrderedDelivery(enabled bool) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.orderedDelivery = enabled
+	}
+}
+
+// WithoutDedup excludes operations from deduplication, so identical commands of them are executed separately,
+// f.e. custom write operations like INCR, which must not collapse into one; operations are identified by names
+// of built-in commands, f.e. "Do", or of custom operations, which should be registered before
+func WithoutDedup(operations ...string) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.noDedup = append(a.cnf.noDedup, operations...)
+	}
+}
+
+// WithDedupWindow sets a max age of queued command, which identical commands are coalesced with, so a shared
+// result is at most d older than the call, even if the flush window is longer; older command is executed as is,
+// and identical commands start a new one, zero (default) means commands are coalesced until the flush
+func WithDedupWindow(d time.Duration) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.dedupWindow = d
+	}
+}
+
+// WithWriteLane queues mutating commands separately from read commands, each lane is flushed by its own background
+// goroutine, mutating commands with ttl and maxSize thresholds, read ones with WithCacheTTL and WithMaxSize,
+// so latency-sensitive reads aren't delayed behind invalidation bursts and vice versa; read commands are
+// the ones eligible for replicas, f.e. Get and HGetAll, everything else including Do, EnqueueCmd and custom
+// operations is mutating, batch with any mutating command is queued to the write lane; zero ttl (default)
+// means all commands are queued together
+func WithWriteLane(ttl time.Duration, maxSize uint) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.writeLaneTTL = ttl
+		a.cnf.writeLaneSize = maxSize
+	}
+}
+
+// WithMaxPending bounds the number of commands awaiting results, so the storage doesn't grow without limit
+// while redis is slow, commands above the limit are handled according to WithOverflowPolicy,
+// the limit is soft, concurrent callers may exceed it by their number; every lane has its own limit,
+// see WithWriteLane, zero (default) means no limit
+func WithMaxPending(n uint) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.maxPending = n
+	}
+}
+
+// WithOverflowPolicy sets behavior for commands, which find the cache full, see WithMaxPending,
+// default is OverflowBlock, batch is handled as a single command, overflowed commands are counted in Stats.Overflows
+func WithOverflowPolicy(p OverflowPolicy) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.overflowPolicy = p
+	}
+}
+
+// WithReadRetries puts read-only commands, f.e. Get, HGetAll or MGet, which failed with transient errors,
+// f.e. connection reset or i/o timeout, back to the cache for the next flush instead of failing them,
+// up to n times per command, so a blip of connection doesn't reach callers; mutating commands aren't requeued,
+// as they may be applied already, neither are commands of timed out pipelines and errors replied by redis,
+// commands, which exhausted requeues, fail with the last error and are passed to dead letter hook,
+// zero (default) disables requeues
+func WithReadRetries(n uint) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.readRetries = n
+	}
+}
+
+// WithNodeBatching makes commands of *redis.ClusterClient grouped into one pipeline per cluster node, which serves
+// their keys, pipelines of different nodes are executed concurrently, so batches are kept local to each shard instead
+// of being split by go-redis; commands without keys, f.e. Time or Do, are pipelined together and routed by cluster
+// client itself, so are commands of Batch with keys of different nodes, as they aren't split, flush may be split
+// between nodes; it does nothing for other clients, disabled by default
+func WithNodeBatching(enabled bool) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.nodeBatching = enabled
+	}
+}
+
+// WithReplicaReads makes read-only commands, f.e. Get, HGet, MGet, SMembers or HGetAll, pipelined apart from
+// mutating ones, f.e. Del or Expire, as go-redis routes pipeline to replicas only if all its commands are read-only;
+// pair it with *redis.ClusterClient configured with ReadOnly, RouteByLatency or RouteRandomly, f.e. one returned by
+// NewFailoverClusterClient, so reads are served by replicas, while writes go to primaries; pipelines of reads and
+// writes are executed concurrently, flush may be split between them, but commands of Batch aren't, they're pipelined
+// with writes, if any of them is mutating, disabled by default
+func WithReplicaReads(enabled bool) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.replicaReads = enabled
+	}
+}
+
+// WithTransactional makes every flush executed by TxPipeline as a single MULTI/EXEC transaction, so batched commands
+// are applied atomically, f.e. Del and Expire of invalidation flows; every lane is a transaction of its own,
+// see WithWriteLane, batches executed directly on overflow are transactions too, see OverflowDirect; in redis
+// cluster commands are atomic per hash slot only; flush can't be split, so it's incompatible with
+// WithMaxCommandsPerPipeline, WithNodeBatching and WithReplicaReads; use TxGroup for atomic groups of commands only,
+// disabled by default
+func WithTransactional(enabled bool) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.transactional = enabled
+	}
+}
+
+// WithFailoverPause makes commands, which fail with errors replied by redis during failover, f.e. Sentinel
+// switching master over (READONLY, LOADING or MASTERDOWN), kept in the cache and flushing paused for d,
+// so the buffered batch is executed against the new master instead of failing a whole window of commands;
+// such errors are replied without executing the command, so commands of any kind are requeued, until they're
+// expired after WithOperationTTL, which should be set; every lane is paused on its own, see WithWriteLane;
+// redirects of redis cluster are followed by *redis.ClusterClient itself, zero (default) disables pauses
+func WithFailoverPause(d time.Duration) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.failoverPause = d
+	}
+}
+
+// WithStandby makes commands executed by the standby client, f.e. a passive redis of active/passive setup without
+// Sentinel, after failures flushes in a row failed with transient errors, f.e. connection refused, as if the primary
+// client is unreachable; commands of the flush, which made the switch, are requeued once and executed by the standby,
+// so mutating ones may be executed twice, if the primary applied them before it failed; if the standby becomes
+// unreachable the same way, commands are switched back to the primary; both lanes switch together, see WithWriteLane,
+// switches are reported to WithConfigChangeHook as "client" setting and counted in Stats().Switchovers,
+// nil client (default) disables switching
+func WithStandby(client redis.UniversalClient, failures uint) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.standby = client
+		a.cnf.standbyFailures = failures
+	}
+}
+
+// WithAutoTuning makes ttl and max size adjusted to the observed load once per interval within the given bounds,
+// so they don't have to be tuned empirically for workloads with diurnal load patterns: thresholds are shrunk,
+// while latency of commands (ttl window plus p95 execution time of pipelines) exceeds the target latency
+// or pipelines are sent to redis slower than the target rate, and grown, while pipelines are sent faster than
+// the target rate or latency is well under the target one; configured ttl and max size are initial values,
+// changes are reported to WithConfigChangeHook, thresholds of write lane are not tuned, see WithWriteLane,
+// no target (default) disables tuning
+func WithAutoTuning(t AutoTuning) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.autoTuning = t
+	}
+}
+
+// WithGetCoalescing makes Get commands with distinct keys, which are queued for the same pipeline, executed
+// as a single MGet command, results of which are split back to listeners of every Get, so read-heavy key/value
+// workloads send much fewer commands to redis; unlike Get, MGet doesn't fail for keys of other types,
+// such keys are reported as missing with redis.Nil; in redis cluster enable WithCrossSlotSplitting as well,
+// so there is one MGet per hash slot
+func WithGetCoalescing(enabled bool) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.coalesceGets = enabled
+	}
+}
+
+// WithDelMerging makes Del commands, which are queued for the same pipeline, executed as a single Del command
+// with the union of their keys, so bulk invalidation sends much fewer commands to redis; as redis doesn't report
+// which keys were deleted, result of every merged Del is the total number of keys deleted by the merged command;
+// in redis cluster enable WithCrossSlotSplitting as well, so there is one Del per hash slot
+func WithDelMerging(enabled bool) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.mergeDels = enabled
+	}
+}
+
+// WithSetMerging makes Set commands of Cmdable queued instead of executed directly, see WithFallback,
+// and ones without expiration, which are queued for the same pipeline, executed as a single MSet command,
+// so bulk writes send much fewer commands to redis; every merged Set gets OK or the error of MSet;
+// Sets of the same key are last-writer-wins: the value of the latest call is written, f.e. of identical Set,
+// which joined a queued one; in redis cluster enable WithCrossSlotSplitting as well, so there is one MSet
+// per hash slot
+func WithSetMerging(enabled bool) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.mergeSets = enabled
+	}
+}
+
+// WithHashReadMerging makes HGet commands served by HGetAll of the same key, which is queued for the same flush,
+// so the field isn't fetched from redis twice, f.e. when one caller reads the whole object and others read its fields
+func WithHashReadMerging(enabled bool) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.mergeHashReads = enabled
+	}
+}
+
+// WithCrossSlotSplitting enables splitting of multi-key commands with keys in different hash slots,
+// so they don't fail with CROSSSLOT error in redis cluster
+func WithCrossSlotSplitting(enabled bool) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.splitCrossSlot = enabled
+	}
+}
+
+// WithClock replaces a source of time, f.e. to control time-based behavior in tests
+func WithClock(c Clock) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.clock = c
+	}
+}
+
+// withWait replaces a waiting primitive of the background goroutine, it's used in tests
+func withWait(w waitFunc) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.wait = w
+	}
+}
+
+// WithBatchExport sets a hook, which receives a record of every executed pipeline for offline analysis,
+// f.e. written with WriteBatchRecord, hook is called by background goroutine, so it should be fast
+func WithBatchExport(hook func(BatchRecord)) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.batchHook = hook
+	}
+}
+
+// WithOnFlush sets a hook, which receives stats of every flush once its results are dispatched: trigger,
+// numbers of commands and deduplicated calls, execution time and error, so alerting or adaptive logic is built
+// on top of any metrics system; hook is called by background goroutine, so it should be fast
+func WithOnFlush(hook func(FlushStats)) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.onFlush = hook
+	}
+}
+
+// WithDeadLetterHook sets a hook, which receives commands dropped without execution, so the application
+// may compensate, f.e. re-issue them later or emit an alert: commands rejected by stopped, paused or full cache,
+// expired ones, ones of pipelines not permitted by rate limiter, ones pending on stop with done context
+// and read-only commands, which exhausted requeues of WithReadRetries;
+// commands failed by redis, timed out pipelines and commands abandoned by callers are not dead letters,
+// hook is called synchronously by enqueuing or background goroutine, so it should be fast and must not
+// re-issue commands right away
+func WithDeadLetterHook(hook func(DeadLetter)) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.deadLetterHook = hook
+	}
+}
+
+// WithConfigChangeHook sets a hook, which receives every change of runtime-tunable settings,
+// f.e. to audit configuration drift in production, changes made by WithAutoTuning are reported
+// by background goroutine, so the hook should be fast
+func WithConfigChangeHook(hook func(ConfigChange)) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.configHook = hook
+	}
+}
+
+// WithCrashDump enables writing of redacted snapshot of pending queue to w
+// in case of panic in background goroutines of the cache: the run loop, pipeline workers, delivery workers
+// and goroutines of command hooks, panics in goroutines of callers, f.e. in commands executed directly, aren't covered
+func WithCrashDump(w io.Writer) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.dumpWriter = w
+	}
+}
+
+// WithExecutor replaces building and execution of pipelines, f.e. to wrap PipelineExecutor with instrumentation
+// or custom retries, or to route pipelines to a proxy, commands executed directly on overflow are pipelined by it too,
+// see OverflowDirect, PipelineExecutor is used by default
+func WithExecutor(e Executor) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.executor = e
+	}
+}
+
+// WithMiddleware wraps enqueueing of every logical command and execution of every pipeline with middlewares,
+// f.e. for auditing, per-tenant quotas, rewriting of keys or blocking of dangerous commands, see Handler;
+// the first middleware is the outermost one, they're run before admitter, see WithAdmission, and around executor,
+// see WithExecutor; middlewares are built once and shared by lanes, see WithWriteLane
+func WithMiddleware(middlewares ...Middleware) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.middlewares = middlewares
+	}
+}
+
+// WithCommandHooks makes hooks, f.e. tracing or metrics middleware of go-redis, run around every logical command
+// with ctx of its caller, as pipelines owned by autopipeline are seen by ProcessPipelineHook of the client only:
+// ProcessHook of every hook is called once the command is queued and its next returns once the result is delivered,
+// so it observes the whole lifetime of the command; hooks get *redis.Cmd with name and arguments of the command
+// (pre-built command of EnqueueCmd as is), error of the result is set to it, values are returned to the caller;
+// an error returned without calling next fails the command, which isn't executed, unless its pipeline is gathered
+// already, results of futures and handles are delivered regardless of hooks;
+// first hook is the outermost one, as with redis.Client.AddHook; every command takes a goroutine for hooks,
+// and results are forwarded in no particular order, see WithOrderedDelivery
+func WithCommandHooks(hooks ...redis.Hook) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.commandHooks = hooks
+	}
+}
+
+// WithMetricsSink makes sinks receive events of autopipeline: queued commands, executed and failed pipelines,
+// dropped results and overflows, so they're exported to any metrics system, f.e. by NewStatsdSink or promstats.New;
+// sinks are added to ones of previous calls, and every event is passed to all of them in order, nil sinks are skipped;
+// events of lanes are joined, see WithWriteLane; no sinks (default) disables it
+func WithMetricsSink(sinks ...MetricsSink) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		for _, sink := range sinks {
+			if sink != nil {
+				a.cnf.metricsSinks = append(a.cnf.metricsSinks, sink)
+			}
+		}
+	}
+}
+
+// WithOnCreate adds a hook, which is called once autopipeline is created, f.e. to register it with a metrics system,
+// see promstats.WithMetrics; hooks are called in order, and NewAutoPipeline fails with the error of the hook,
+// autopipeline is stopped then
+func WithOnCreate(hook func(a *Autopipeline) error) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.onCreate = append(a.cnf.onCreate, hook)
+	}
+}
+
+// WithExpvar publishes stats of autopipeline and the number of queued commands to expvar under the name,
+// so /debug/vars shows its health in services without prometheus, see promstats; expvar can't unpublish
+// a name, so it's kept after Stop and every autopipeline needs a name of its own, empty name (default) disables it
+func WithExpvar(name string) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.expvar = name
+	}
+}
+
+// WithSnapshotEncoder sets the serialization of crash dumps, json is used by default
+func WithSnapshotEncoder(e SnapshotEncoder) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.dumpEncoder = e
+	}
+}
+
 func WithLogger(l Logger) func(a *Autopipeline) {
 	return func(a *Autopipeline) {
 		a.cnf.logger = l
 	}
 }
 
+// WithLogLevel sets a min level of messages logged by LeveledLogger or slog logger, see WithSlog,
+// default is LogInfo; plain Logger gets errors only
+func WithLogLevel(level LogLevel) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		a.cnf.logLevel = level
+	}
+}
+
+// WithSlog makes autopipeline log to l instead of Logger set by WithLogger: errors are logged at error level,
+// config changes at info level with setting, old and new fields, flushes at debug level with flush_reason,
+// batch_size, pipelines and composition fields, and executed pipelines at debug level (slow ones at warn level,
+// failed ones at error level) with batch_size, flush_reason and duration fields, see WithLogLevel
+func WithSlog(l *slog.Logger) func(a *Autopipeline) {
+	return func(a *Autopipeline) {
+		if l == nil {
+			a.cnf.logger = nil
+			return
+		}
+		a.cnf.logger = slogLogger{l: l}
+	}
+}
+
 func (a Autopipeline) HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd {
 	resCh := a.HDelAsync(ctx, key, fields...)
-	res, ok := <-resCh
-	if !ok {
+	res, err := a.await(ctx, resCh)
+	if err != nil {
 		resp := redis.IntCmd{}
-		resp.SetErr(ErrChannelClosed)
+		resp.SetErr(err)
 		return &resp
 	}
-	defer close(resCh)
 	return res.(*redis.IntCmd)
 }
 
-func (a Autopipeline) HDelAsync(ctx context.Context, key string, fields ...string) chan interface{} {
+func (a Autopipeline) HDelAsync(ctx context.Context, key string, fields ...string) <-chan interface{} {
 	args := transformHDel(key, fields...)
-	return a.cache.enqueue(HDel, args)
+	return a.cache.enqueue(ctx, HDel, args)
 }
 
 func (a Autopipeline) Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
 	resCh := a.ExpireAsync(ctx, key, expiration)
-	res, ok := <-resCh
-	if !ok {
+	res, err := a.await(ctx, resCh)
+	if err != nil {
 		resp := redis.BoolCmd{}
-		resp.SetErr(ErrChannelClosed)
+		resp.SetErr(err)
 		return &resp
 	}
-	defer close(resCh)
 	return res.(*redis.BoolCmd)
 }
 
-func (a Autopipeline) ExpireAsync(ctx context.Context, key string, expiration time.Duration) chan interface{} {
+func (a Autopipeline) ExpireAsync(ctx context.Context, key string, expiration time.Duration) <-chan interface{} {
 	args := transformExpire(key, expiration)
-	return a.cache.enqueue(Expire, args)
+	return a.cache.enqueue(ctx, Expire, args)
 }
 
 func (a Autopipeline) HGet(ctx context.Context, key, field string) *redis.StringCmd {
 	resCh := a.HGetAsync(ctx, key, field)
-	res, ok := <-resCh
-	if !ok {
+	res, err := a.await(ctx, resCh)
+	if err != nil {
 		resp := redis.StringCmd{}
-		resp.SetErr(ErrChannelClosed)
+		resp.SetErr(err)
 		return &resp
 	}
-	defer close(resCh)
 	return res.(*redis.StringCmd)
 }
 
-func (a Autopipeline) HGetAsync(ctx context.Context, key, field string) chan interface{} {
+func (a Autopipeline) HGetAsync(ctx context.Context, key, field string) <-chan interface{} {
 	args := transformHGet(key, field)
-	return a.cache.enqueue(HGet, args)
+	return a.cache.enqueue(ctx, HGet, args)
 }
 
 func (a Autopipeline) HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd {
 	resCh := a.HGetAllAsync(ctx, key)
-	res, ok := <-resCh
-	if !ok {
+	res, err := a.await(ctx, resCh)
+	if err != nil {
 		resp := redis.MapStringStringCmd{}
-		resp.SetErr(ErrChannelClosed)
+		resp.SetErr(err)
 		return &resp
 	}
-	defer close(resCh)
 	return res.(*redis.MapStringStringCmd)
 }
 
-func (a Autopipeline) HGetAllAsync(ctx context.Context, key string) chan interface{} {
+func (a Autopipeline) HGetAllAsync(ctx context.Context, key string) <-chan interface{} {
 	args := transformHGetAll(key)
-	return a.cache.enqueue(HGetAll, args)
+	return a.cache.enqueue(ctx, HGetAll, args)
 }
 
 func (a Autopipeline) Get(ctx context.Context, key string) *redis.StringCmd {
 	resCh := a.GetAsync(ctx, key)
-	res, ok := <-resCh
-	if !ok {
+	res, err := a.await(ctx, resCh)
+	if err != nil {
 		resp := redis.StringCmd{}
-		resp.SetErr(ErrChannelClosed)
+		resp.SetErr(err)
 		return &resp
 	}
-	defer close(resCh)
 	return res.(*redis.StringCmd)
 }
-func (a Autopipeline) GetAsync(ctx context.Context, key string) chan interface{} {
+func (a Autopipeline) GetAsync(ctx context.Context, key string) <-chan interface{} {
 	args := transformGet(key)
-	return a.cache.enqueue(Get, args)
+	return a.cache.enqueue(ctx, Get, args)
 }
 
 func (a Autopipeline) Del(ctx context.Context, keys ...string) *redis.IntCmd {
 	resCh := a.DelAsync(ctx, keys...)
-	res, ok := <-resCh
-	if !ok {
+	res, err := a.await(ctx, resCh)
+	if err != nil {
 		resp := redis.IntCmd{}
-		resp.SetErr(ErrChannelClosed)
+		resp.SetErr(err)
 		return &resp
 	}
-	defer close(resCh)
 	return res.(*redis.IntCmd)
 }
 
-func (a Autopipeline) DelAsync(ctx context.Context, keys ...string) chan interface{} {
+func (a Autopipeline) DelAsync(ctx context.Context, keys ...string) <-chan interface{} {
 	args := transformDel(keys...)
-	return a.cache.enqueue(Del, args)
+	return a.cache.enqueue(ctx, Del, args)
 }
 
 func (a Autopipeline) SMembers(ctx context.Context, key string) *redis.StringSliceCmd {
 	resCh := a.SMembersAsync(ctx, key)
-	res, ok := <-resCh
-	if !ok {
+	res, err := a.await(ctx, resCh)
+	if err != nil {
 		resp := redis.StringSliceCmd{}
-		resp.SetErr(ErrChannelClosed)
+		resp.SetErr(err)
 		return &resp
 	}
-	defer close(resCh)
 	return res.(*redis.StringSliceCmd)
 }
 
-func (a Autopipeline) SMembersAsync(ctx context.Context, key string) chan interface{} {
+func (a Autopipeline) SMembersAsync(ctx context.Context, key string) <-chan interface{} {
 	args := transformSMembers(key)
-	return a.cache.enqueue(SMembers, args)
+	return a.cache.enqueue(ctx, SMembers, args)
 }
 
 func (a Autopipeline) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
 	resCh := a.MGetAsync(ctx, keys...)
-	res, ok := <-resCh
-	if !ok {
+	res, err := a.await(ctx, resCh)
+	if err != nil {
 		resp := redis.SliceCmd{}
-		resp.SetErr(ErrChannelClosed)
+		resp.SetErr(err)
 		return &resp
 	}
-	defer close(resCh)
 	return res.(*redis.SliceCmd)
 }
 
-func (a Autopipeline) MGetAsync(ctx context.Context, keys ...string) chan interface{} {
+func (a Autopipeline) MGetAsync(ctx context.Context, keys ...string) <-chan interface{} {
 	args := transformMGet(keys...)
-	return a.cache.enqueue(MGet, args)
+	return a.cache.enqueue(ctx, MGet, args)
+}
+
+func (a Autopipeline) EvalRO(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	resCh := a.EvalROAsync(ctx, script, keys, args...)
+	res, err := a.await(ctx, resCh)
+	if err != nil {
+		resp := redis.Cmd{}
+		resp.SetErr(err)
+		return &resp
+	}
+	return res.(*redis.Cmd)
+}
+
+func (a Autopipeline) EvalROAsync(ctx context.Context, script string, keys []string, args ...interface{}) <-chan interface{} {
+	cmdArgs := transformScript(script, keys, args...)
+	return a.cache.enqueue(ctx, EvalRO, cmdArgs)
+}
+
+func (a Autopipeline) FCallRO(ctx context.Context, function string, keys []string, args ...interface{}) *redis.Cmd {
+	resCh := a.FCallROAsync(ctx, function, keys, args...)
+	res, err := a.await(ctx, resCh)
+	if err != nil {
+		resp := redis.Cmd{}
+		resp.SetErr(err)
+		return &resp
+	}
+	return res.(*redis.Cmd)
+}
+
+func (a Autopipeline) FCallROAsync(ctx context.Context, function string, keys []string, args ...interface{}) <-chan interface{} {
+	cmdArgs := transformScript(function, keys, args...)
+	return a.cache.enqueue(ctx, FCallRO, cmdArgs)
+}
+
+func (a Autopipeline) Exists(ctx context.Context, keys ...string) *redis.IntCmd {
+	resCh := a.ExistsAsync(ctx, keys...)
+	res, err := a.await(ctx, resCh)
+	if err != nil {
+		resp := redis.IntCmd{}
+		resp.SetErr(err)
+		return &resp
+	}
+	return res.(*redis.IntCmd)
+}
+
+func (a Autopipeline) ExistsAsync(ctx context.Context, keys ...string) <-chan interface{} {
+	args := transformExists(keys...)
+	return a.cache.enqueue(ctx, Exists, args)
+}
+
+func (a Autopipeline) Time(ctx context.Context) *redis.TimeCmd {
+	resCh := a.TimeAsync(ctx)
+	res, err := a.await(ctx, resCh)
+	if err != nil {
+		resp := redis.TimeCmd{}
+		resp.SetErr(err)
+		return &resp
+	}
+	return res.(*redis.TimeCmd)
+}
+
+func (a Autopipeline) TimeAsync(ctx context.Context) <-chan interface{} {
+	args := transformTime()
+	return a.cache.enqueue(ctx, Time, args)
+}
+
+func (a Autopipeline) Echo(ctx context.Context, message interface{}) *redis.StringCmd {
+	resCh := a.EchoAsync(ctx, message)
+	res, err := a.await(ctx, resCh)
+	if err != nil {
+		resp := redis.StringCmd{}
+		resp.SetErr(err)
+		return &resp
+	}
+	return res.(*redis.StringCmd)
+}
+
+func (a Autopipeline) EchoAsync(ctx context.Context, message interface{}) <-chan interface{} {
+	args := transformEcho(message)
+	return a.cache.enqueue(ctx, Echo, args)
+}
+
+// SafeDel deletes the key only if it has the expected type, as reported by TYPE command, f.e. "hash",
+// both check and delete are done atomically by a lua script in the same pipeline as other commands
+// ErrTypeMismatch is returned if the key has another type, missing key is not an error
+func (a Autopipeline) SafeDel(ctx context.Context, key, expectedType string) *redis.IntCmd {
+	resCh := a.SafeDelAsync(ctx, key, expectedType)
+	res, err := a.await(ctx, resCh)
+	if err != nil {
+		resp := redis.IntCmd{}
+		resp.SetErr(err)
+		return &resp
+	}
+	return res.(*redis.IntCmd)
+}
+
+func (a Autopipeline) SafeDelAsync(ctx context.Context, key, expectedType string) <-chan interface{} {
+	args := transformSafeDel(key, expectedType)
+	return a.cache.enqueue(ctx, SafeDel, args)
+}
+
+// SetAsync queues Set even if Set merging is disabled, unlike Set of Cmdable, see WithSetMerging
+func (a Autopipeline) SetAsync(ctx context.Context, key string, value interface{}, expiration time.Duration) <-chan interface{} {
+	args := transformSet(key, value, expiration)
+	return a.cache.enqueue(ctx, Set, args)
+}
+
+// Do executes an arbitrary redis command, which isn't wrapped by the package, in the same pipeline as other commands,
+// arguments are formatted the same way go-redis does it, so result values are strings, as for EvalRO
+// identical commands queued before the flush are executed once, as any other command,
+// so non-idempotent commands, f.e. INCR, should be executed by redis client directly
+func (a Autopipeline) Do(ctx context.Context, args ...interface{}) *redis.Cmd {
+	resCh := a.DoAsync(ctx, args...)
+	res, err := a.await(ctx, resCh)
+	if err != nil {
+		resp := redis.Cmd{}
+		resp.SetErr(err)
+		return &resp
+	}
+	return res.(*redis.Cmd)
+}
+
+func (a Autopipeline) DoAsync(ctx context.Context, args ...interface{}) <-chan interface{} {
+	cmdArgs := transformDo(args...)
+	return a.cache.enqueue(ctx, Do, cmdArgs)
+}
+
+// EnqueueCmd puts pre-built redis command, f.e. created with redis.NewIntCmd, to the pipeline verbatim,
+// command is completed in place and sent to the channel, so it shouldn't be used until it's received,
+// channel is closed without result if command can't be queued, same as for *Async methods
+func (a Autopipeline) EnqueueCmd(ctx context.Context, cmd redis.Cmder) <-chan interface{} {
+	return a.cache.enqueueCmd(ctx, cmd)
+}
+
+// ExistsMany reports presence of each key, all keys are checked with separate Exists commands
+// in the same pipeline (excepting some really rare occasions)
+func (a Autopipeline) ExistsMany(ctx context.Context, keys []string) (map[string]bool, error) {
+	resChs := make([]<-chan interface{}, len(keys))
+	for i, key := range keys {
+		resChs[i] = a.ExistsAsync(ctx, key)
+	}
+	var err error
+	present := make(map[string]bool, len(keys))
+	for i, resCh := range resChs {
+		res, awaitErr := a.await(ctx, resCh)
+		if awaitErr != nil {
+			err = awaitErr
+			continue
+		}
+		n, cmdErr := res.(*redis.IntCmd).Result()
+		if cmdErr != nil {
+			err = cmdErr
+			continue
+		}
+		present[keys[i]] = n > 0
+	}
+	if err != nil {
+		return nil, err
+	}
+	return present, nil
+}
+
+// Start resumes receiving and executing of commands after Stop
+// it does nothing if autopipeline is already running
+// and returns an error if the context autopipeline was created with is done
+func (a Autopipeline) Start() error {
+	for _, lane := range a.cache.lanes() {
+		if err := lane.start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops receiving of new commands, executes already queued ones
+// and waits until background goroutine is finished
+// while stopped, all commands fail with ErrChannelClosed, and ErrCacheStopped is logged
+func (a Autopipeline) Stop() {
+	for _, lane := range a.cache.lanes() {
+		lane.stop()
+	}
+}
+
+// Pause stops execution of pipelines, but keeps receiving commands, up to the pause limit,
+// so they are executed after Resume
+func (a Autopipeline) Pause() {
+	for _, lane := range a.cache.lanes() {
+		lane.paused.Store(true)
+	}
+}
+
+// Resume continues execution of pipelines after Pause
+func (a Autopipeline) Resume() {
+	for _, lane := range a.cache.lanes() {
+		lane.paused.Store(false)
+		lane.signal()
+	}
+}
+
+// Stats returns batching metrics collected since autopipeline creation
+func (a Autopipeline) Stats() Stats {
+	return a.cache.stats.snapshot()
+}
+
+// Len returns the number of queued redis operations, identical commands are counted once
+func (a Autopipeline) Len() int {
+	n := 0
+	for _, lane := range a.cache.lanes() {
+		n += lane.len()
+	}
+	return n
+}
+
+// Pending returns the number of listeners awaiting results
+func (a Autopipeline) Pending() int {
+	n := 0
+	for _, lane := range a.cache.lanes() {
+		n += int(lane.activeListeners.Load())
+	}
+	return n
+}
+
+// DumpPending returns a copy of currently queued operations with their arguments,
+// it's intended for debugging of stuck batches
+func (a Autopipeline) DumpPending() []OperationSnapshot {
+	var ops []OperationSnapshot
+	for _, lane := range a.cache.lanes() {
+		ops = append(ops, lane.snapshot(true).Operations...)
+	}
+	return ops
+}
+
+// commandTimeoutKey is a context key of per call command timeout
+type commandTimeoutKey struct{}
+
+// ContextWithCommandTimeout returns a context, which overrides command timeout of synchronous commands
+// called with it, see WithCommandTimeout
+func ContextWithCommandTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, commandTimeoutKey{}, timeout)
+}
+
+// await returns the result of command from resCh, waiting not longer than command timeout
+// or until ctx is done, then the listener is removed, as caller doesn't wait for the result anymore
+func (a Autopipeline) await(ctx context.Context, resCh <-chan interface{}) (interface{}, error) {
+	timeout := a.cnf.commandTimeout
+	if d, ok := ctx.Value(commandTimeoutKey{}).(time.Duration); ok {
+		timeout = d
+	}
+	var err error
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		t := a.cnf.clock.NewTimer(timeout)
+		defer t.Stop()
+		timeoutCh = t.C()
+	}
+	select {
+	case res, ok := <-resCh:
+		if !ok {
+			return nil, ErrChannelClosed
+		}
+		return res, nil
+	case <-timeoutCh:
+		err = fmt.Errorf("%w: no result in %s", ErrCommandTimeout, timeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	a.cache.abandon(ctx, resCh)
+	// result may be delivered meanwhile
+	select {
+	case res, ok := <-resCh:
+		if ok {
+			return res, nil
+		}
+	default:
+	}
+	return nil, err
+}
+
+// AwaitOnly returns await-only mode of the client, where commands return a Handle instead of a channel
+func (a Autopipeline) AwaitOnly() AwaitClient {
+	return awaitClient{cache: a.cache}
+}
+
+// Checked returns checked mode of the client, where async commands return an error,
+// if they can't be queued right now, instead of the closed channel
+func (a Autopipeline) Checked() CheckedClient {
+	return checkedClient{cache: a.cache}
+}
+
+// Typed returns typed mode of the client, where async commands return channels of concrete redis commands
+func (a Autopipeline) Typed() TypedClient {
+	return typedClient{cache: a.cache}
+}
+
+// NewBatch returns an empty batch of commands, which are queued at once and executed in the same pipeline
+func (a Autopipeline) NewBatch() *Batch {
+	return &Batch{cache: a.cache}
+}
+
+// Group queues commands added by fn at once, so they are executed in the same pipeline round-trip,
+// f.e. Get and Expire of the same key, futures are returned in the order commands were added
+func (a Autopipeline) Group(ctx context.Context, fn func(b *Batch)) []*Future {
+	b := a.NewBatch()
+	fn(b)
+	return b.Submit(ctx)
+}
+
+// TxGroup executes commands added by fn atomically in a single MULTI/EXEC transaction, f.e. Del and Expire
+// which should be applied together, futures are returned in the order commands were added, see Batch.SubmitTx
+func (a Autopipeline) TxGroup(ctx context.Context, fn func(b *Batch)) []*Future {
+	b := a.NewBatch()
+	fn(b)
+	return b.SubmitTx(ctx)
+}
+
+// SetCacheTTL changes time interval which triggers redis pipeline execution, see WithCacheTTL
+// it's safe to call while autopipeline is running, thresholds of write lane are not changed, see WithWriteLane;
+// ttl is checked like by NewAutoPipeline, it should be less than operation ttl, if it's set, and within ttl bounds
+// of auto tuning, if it's enabled, see WithAutoTuning
+func (a Autopipeline) SetCacheTTL(ttl time.Duration) error {
+	if err := validateTTL(ttl); err != nil {
+		return err
+	}
+	if a.cnf.operationTTL > 0 && ttl >= a.cnf.operationTTL {
+		return fmt.Errorf("%w: %s, should be less than operation ttl %s", ErrInvalidTTL, ttl, a.cnf.operationTTL)
+	}
+	if t := a.cnf.autoTuning; t.enabled() && (ttl < t.MinTTL || ttl > t.MaxTTL) {
+		return fmt.Errorf("%w: %s, should be in range of auto tuning [%s, %s]", ErrInvalidTTL, ttl, t.MinTTL, t.MaxTTL)
+	}
+	old := a.cache.setThresholdTime(ttl)
+	a.cache.signal()
+	a.cnf.configChanged("ttl", Duration(old), Duration(ttl))
+	return nil
+}
+
+// SetMaxSize changes number of active listeners which triggers redis pipeline execution, see WithMaxSize
+// it's safe to call while autopipeline is running, thresholds of write lane are not changed, see WithWriteLane;
+// size is checked like by NewAutoPipeline, it should be within max size bounds of auto tuning, if it's enabled,
+// see WithAutoTuning
+func (a Autopipeline) SetMaxSize(size uint) error {
+	if err := validateMaxSize(size); err != nil {
+		return err
+	}
+	if t := a.cnf.autoTuning; t.enabled() && (size < t.MinSize || size > t.MaxSize) {
+		return fmt.Errorf("%w: %d, should be in range of auto tuning [%d, %d]", ErrInvalidMaxSize, size, t.MinSize, t.MaxSize)
+	}
+	old := a.cache.setThresholdSize(size)
+	a.cache.signal()
+	a.cnf.configChanged("max_size", old, size)
+	return nil
+}
+
+// SetRunInterval changes min interval between checks of pipeline triggers, see WithRunInterval
+// it's safe to call while autopipeline is running, interval is checked like by NewAutoPipeline
+func (a Autopipeline) SetRunInterval(interval time.Duration) error {
+	if err := validateRunInterval(interval); err != nil {
+		return err
+	}
+	old := a.cache.setRunInterval(interval)
+	if a.cache.writes != nil {
+		a.cache.writes.setRunInterval(interval)
+	}
+	a.cnf.configChanged("run_interval", Duration(old), Duration(interval))
+	return nil
+}
+
+// configChanged reports change of runtime-tunable setting to the config change hook and to the logger,
+// if it supports Info level
+func (c *config) configChanged(setting string, old, new interface{}) {
+	if old == new {
+		return
+	}
+	change := ConfigChange{
+		Time:    c.clock.Now(),
+		Setting: setting,
+		Old:     old,
+		New:     new,
+	}
+	if c.configHook != nil {
+		c.configHook(change)
+	}
+	if l, ok := c.logger.(slogLogger); ok {
+		if c.logLevel <= LogInfo {
+			l.configChange(change)
+		}
+		return
+	}
+	logAt(c.logger, c.logLevel, LogInfo, change.String())
+}
+
+// Config returns a snapshot of the current configuration, including runtime changes
+func (a Autopipeline) Config() Config {
+	return Config{
+		TTL:                    Duration(a.cache.storageThresholdTime.Load()),
+		MaxSize:                uint(a.cache.storageThresholdSize.Load()),
+		RunInterval:            Duration(a.cache.runInterval.Load()),
+		OperationTTL:           Duration(a.cnf.operationTTL),
+		CommandTimeout:         Duration(a.cnf.commandTimeout),
+		PipelineExecTimeout:    Duration(a.cnf.pipelineExecTimeout),
+		PauseLimit:             a.cnf.pauseLimit,
+		CrossSlotSplitting:     a.cnf.splitCrossSlot,
+		GetCoalescing:          a.cnf.coalesceGets,
+		NoDedup:                slices.Clone(a.cnf.noDedup),
+		DedupWindow:            Duration(a.cnf.dedupWindow),
+		WriteLaneTTL:           Duration(a.cnf.writeLaneTTL),
+		WriteLaneMaxSize:       a.cnf.writeLaneSize,
+		MaxPending:             a.cnf.maxPending,
+		OverflowPolicy:         a.cnf.overflowPolicy,
+		ReadRetries:            a.cnf.readRetries,
+		FailoverPause:          Duration(a.cnf.failoverPause),
+		NodeBatching:           a.cnf.nodeBatching,
+		ReplicaReads:           a.cnf.replicaReads,
+		Transactional:          a.cnf.transactional,
+		LogLevel:               a.cnf.logLevel,
+		Expvar:                 a.cnf.expvar,
+		AutoTuneLatency:        Duration(a.cnf.autoTuning.TargetLatency),
+		AutoTuneRate:           a.cnf.autoTuning.TargetRate,
+		AutoTuneMinTTL:         Duration(a.cnf.autoTuning.MinTTL),
+		AutoTuneMaxTTL:         Duration(a.cnf.autoTuning.MaxTTL),
+		AutoTuneMinSize:        a.cnf.autoTuning.MinSize,
+		AutoTuneMaxSize:        a.cnf.autoTuning.MaxSize,
+		AutoTuneInterval:       Duration(a.cnf.autoTuning.Interval),
+		DelMerging:             a.cnf.mergeDels,
+		SetMerging:             a.cnf.mergeSets,
+		HashReadMerging:        a.cnf.mergeHashReads,
+		DuplicatePolicy:        a.cnf.duplicatePolicy,
+		OrderedDelivery:        a.cnf.orderedDelivery,
+		DeadlinePropagation:    a.cnf.propagateDeadlines,
+		Fallback:               a.cnf.fallback,
+		KeyPrefix:              a.cnf.keyPrefix,
+		AdaptiveTTL:            a.cnf.adaptiveTTL,
+		FlushJitter:            a.cnf.flushJitter,
+		StorageShards:          uint(len(a.cache.shards)),
+		LockFreeEnqueue:        a.cnf.lockFreeEnqueue,
+		Workers:                a.cnf.workers,
+		DeliveryWorkers:        a.cnf.deliveryWorkers,
+		DeliveryTimeout:        Duration(a.cnf.deliveryTimeout),
+		DropPolicy:             a.cnf.dropPolicy,
+		MaxDelay:               Duration(a.cnf.maxDelay),
+		SoloBypass:             Duration(a.cnf.soloBypass),
+		MaxCommandsPerPipeline: a.cnf.maxCommandsPerPipeline,
+		MaxBatchBytes:          a.cnf.maxBatchBytes,
+	}
+}
+
+// IsRunning reports whether background goroutine, which executes pipelines, is alive
+func (a Autopipeline) IsRunning() bool {
+	for _, lane := range a.cache.lanes() {
+		if !lane.running() {
+			return false
+		}
+	}
+	return true
+}
+
+// LastFlushTime returns the time of the last successfully executed pipeline,
+// zero time is returned if there were no pipelines yet
+func (a Autopipeline) LastFlushTime() time.Time {
+	var t int64
+	for _, lane := range a.cache.lanes() {
+		t = max(t, lane.lastFlush.Load())
+	}
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(t)
+}
+
+// LastError returns the error of the last failed pipeline, nil if no pipeline has failed yet,
+// compare with LastFlushTime to find out whether autopipeline has recovered since
+func (a Autopipeline) LastError() error {
+	for _, lane := range a.cache.lanes() {
+		if err := lane.lastError.Load(); err != nil {
+			return *err
+		}
+	}
+	return nil
+}
+
+// Drain blocks until all pending listeners have received their results
+// or ctx is done, in which case the context error is returned
+func (a Autopipeline) Drain(ctx context.Context) error {
+	for _, lane := range a.cache.lanes() {
+		if err := lane.drain(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
 }
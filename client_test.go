@@ -2,7 +2,14 @@ package redis_autopipeline
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -48,11 +55,8 @@ func TestClientSizeTriggered(t *testing.T) {
 
 	runTime := time.Now()
 	resCh1 := c.HDelAsync(ctx, "key1")
-	defer close(resCh1)
 	resCh2 := c.HDelAsync(ctx, "key2")
-	defer close(resCh2)
 	resCh3 := c.HDelAsync(ctx, "key3")
-	defer close(resCh3)
 	// Ensure all 3 commands executed in same pipeline call
 	assert.True(t, runTime.Add(200*time.Microsecond).After(time.Now()))
 
@@ -87,17 +91,12 @@ func TestClientMultipleListenersForSameCommand(t *testing.T) {
 
 	runTime := time.Now()
 	resCh1 := c.HDelAsync(ctx, "key1")
-	defer close(resCh1)
 	resCh2 := c.HDelAsync(ctx, "key2")
-	defer close(resCh2)
 
 	// one command in pipeline, but multiple listeners
 	resCh3 := c.HDelAsync(ctx, "key3")
-	defer close(resCh3)
 	resCh4 := c.HDelAsync(ctx, "key3")
-	defer close(resCh4)
 	resCh5 := c.HDelAsync(ctx, "key3")
-	defer close(resCh5)
 
 	// Ensure all 3 commands executed in same pipeline call
 	assert.True(t, runTime.Add(200*time.Microsecond).After(time.Now()))
@@ -124,7 +123,7 @@ func TestClientMultipleListenersForSameCommand(t *testing.T) {
 	assert.Equal(t, int64(3), r5)
 }
 
-func TestClosedChannelRecovery(t *testing.T) {
+func TestAbandonedChannel(t *testing.T) {
 	var ctx = context.TODO()
 	db, mock := redismock.NewClientMock()
 	mock.ExpectHDel("key", "f1", "f2").SetVal(2)
@@ -135,16 +134,93 @@ func TestClosedChannelRecovery(t *testing.T) {
 		WithCacheTTL(time.Microsecond*100),
 		WithMaxSize(200))
 	assert.Nil(t, err)
-	resCh := c.HDelAsync(ctx, "key", "f1", "f2")
-	close(resCh)
+	// result channel is owned by the library, so it can't be closed, but it may be abandoned
+	c.HDelAsync(ctx, "key", "f1", "f2")
 	resCh1 := c.HGetAsync(ctx, "key1", "name")
-	defer close(resCh1)
 	cmd := <-resCh1
 	r1, err := cmd.(*redis.StringCmd).Result()
 	assert.Nil(t, err)
 	assert.Equal(t, "john", r1)
 }
 
+func TestAbandonedDuringExecution(t *testing.T) {
+	tests := []struct {
+		name      string
+		duplicate bool
+	}{
+		{name: "nobody waits"},
+		{name: "duplicate joins executed command", duplicate: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &gateHook{started: make(chan struct{}, 1), release: make(chan struct{})}
+			db := redis.NewClient(&redis.Options{})
+			db.AddHook(hook)
+			logger := &leveledRecorder{}
+			c, err := NewAutoPipeline(db,
+				WithCacheTTL(time.Microsecond*100),
+				WithMaxSize(200),
+				WithLogger(logger))
+			assert.Nil(t, err)
+			defer c.Stop()
+
+			ctx, cancel := context.WithCancel(context.TODO())
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- c.Get(ctx, "key").Err()
+			}()
+			<-hook.started
+			cancel()
+			assert.ErrorIs(t, <-errCh, context.Canceled)
+			var resCh <-chan interface{}
+			if tt.duplicate {
+				resCh = c.GetAsync(context.TODO(), "key")
+			}
+			close(hook.release)
+
+			if tt.duplicate {
+				assert.Equal(t, "key", (<-resCh).(*redis.StringCmd).Val())
+			}
+			assert.Eventually(t, func() bool {
+				return c.Len() == 0
+			}, time.Second, time.Millisecond)
+			// result of abandoned command is taken once executed, it's neither lost nor executed again
+			assert.Equal(t, uint64(1), c.Stats().Pipelines)
+			for _, message := range logger.get() {
+				assert.NotContains(t, message, ErrHashNotFound.Error())
+			}
+		})
+	}
+}
+
+func TestChannelClosedAfterDelivery(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHGet("key1", "name").SetVal("john")
+	mock.ExpectHGet("key2", "name").SetVal("jane")
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	resCh1 := c.HGetAsync(ctx, "key1", "name")
+	res, ok := <-resCh1
+	assert.True(t, ok)
+	assert.Equal(t, "john", res.(*redis.StringCmd).Val())
+	_, ok = <-resCh1
+	assert.False(t, ok)
+
+	var results []interface{}
+	for res := range c.HGetAsync(ctx, "key2", "name") {
+		results = append(results, res)
+	}
+	assert.Len(t, results, 1)
+	assert.Equal(t, "jane", results[0].(*redis.StringCmd).Val())
+}
+
 func TestIdleRecipients(t *testing.T) {
 	var ctx = context.TODO()
 	db, mock := redismock.NewClientMock()
@@ -162,7 +238,6 @@ func TestIdleRecipients(t *testing.T) {
 	c.HDelAsync(ctx, "key1")
 	c.HDelAsync(ctx, "key2")
 	resCh3 := c.HDelAsync(ctx, "key3")
-	defer close(resCh3)
 	// Ensure all 3 commands executed in same pipeline call
 	assert.True(t, runTime.Add(200*time.Microsecond).After(time.Now()))
 
@@ -191,6 +266,847 @@ func TestContextDone(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestStopStart(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHDel("key1").SetVal(1)
+	mock.ExpectHDel("key2").SetVal(2)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	// queued command is executed on stop
+	resCh := c.HDelAsync(ctx, "key1")
+	c.Stop()
+	r1, err := (<-resCh).(*redis.IntCmd).Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), r1)
+
+	_, err = c.HDel(ctx, "key2").Result()
+	assert.ErrorIs(t, err, ErrChannelClosed)
+
+	assert.Nil(t, c.Start())
+	r2, err := c.HDel(ctx, "key2").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), r2)
+}
+
+func TestStartWithCancelledContext(t *testing.T) {
+	db, _ := redismock.NewClientMock()
+
+	pipeCtx, cancel := context.WithCancel(context.Background())
+	c, err := NewAutoPipeline(db, WithContext(pipeCtx))
+	assert.Nil(t, err)
+	cancel()
+	c.Stop()
+	assert.ErrorIs(t, c.Start(), context.Canceled)
+}
+
+func TestStopInterruptsWait(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHDel("key1").SetVal(1)
+
+	c, err := NewAutoPipeline(db, WithRunInterval(time.Hour))
+	assert.Nil(t, err)
+
+	// queued command is executed on stop without waiting out the run interval
+	resCh := c.HDelAsync(ctx, "key1")
+	start := time.Now()
+	c.Stop()
+	assert.Less(t, time.Since(start), time.Second)
+	r, err := (<-resCh).(*redis.IntCmd).Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), r)
+	assert.False(t, c.IsRunning())
+}
+
+func TestContextDoneInterruptsWait(t *testing.T) {
+	db, _ := redismock.NewClientMock()
+	waiting := make(chan struct{}, 1)
+	interrupted := make(chan struct{})
+	wait := func(ctx context.Context, stop, wake <-chan struct{}, d time.Duration) bool {
+		waiting <- struct{}{}
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		close(interrupted)
+		return false
+	}
+
+	pipeCtx, cancel := context.WithCancel(context.Background())
+	c, err := NewAutoPipeline(db, WithContext(pipeCtx), withWait(wait))
+	assert.Nil(t, err)
+	<-waiting
+	assert.True(t, c.IsRunning())
+
+	cancel()
+	select {
+	case <-interrupted:
+	case <-time.After(time.Second):
+		t.Fatal("wait was not interrupted by cancelled context")
+	}
+	c.Stop()
+	assert.False(t, c.IsRunning())
+}
+
+func TestEventDrivenWait(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key").SetVal("john")
+	waits := make(chan time.Duration, 10)
+	wait := func(ctx context.Context, stop, wake <-chan struct{}, d time.Duration) bool {
+		waits <- d
+		select {
+		case <-wake:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Second),
+		WithMaxSize(200),
+		withWait(wait))
+	assert.Nil(t, err)
+	// idle cache waits without timeout
+	assert.Less(t, <-waits, time.Duration(0))
+
+	// first command arms the ttl timer
+	resCh := c.GetAsync(ctx, "key")
+	d := <-waits
+	assert.Greater(t, d, time.Duration(0))
+	assert.LessOrEqual(t, d, time.Second)
+
+	// pipeline is executed on stop
+	c.Stop()
+	assert.Equal(t, "john", (<-resCh).(*redis.StringCmd).Val())
+}
+
+func TestCommandTimeout(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key").SetVal("john")
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithCommandTimeout(time.Millisecond))
+	assert.Nil(t, err)
+
+	// result is not delivered while paused
+	c.Pause()
+	_, err = c.Get(ctx, "key").Result()
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+	_, err = c.ExistsMany(ctx, []string{"key"})
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+
+	// per call override
+	start := time.Now()
+	_, err = c.HGet(ContextWithCommandTimeout(ctx, 10*time.Millisecond), "key", "field").Result()
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+	// commands which timed out are not executed
+	assert.Equal(t, 0, c.Pending())
+	assert.Equal(t, 0, c.Len())
+
+	c.Resume()
+	r, err := c.Get(ContextWithCommandTimeout(ctx, time.Second), "key").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "john", r)
+}
+
+func TestCallerContextDone(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key").SetVal("john")
+
+	c, err := NewAutoPipeline(db, WithCacheTTL(time.Microsecond*100))
+	assert.Nil(t, err)
+
+	c.Pause()
+	ctx, cancel := context.WithCancel(context.TODO())
+	otherCtx, otherCancel := context.WithCancel(context.TODO())
+	defer otherCancel()
+	resCh := c.GetAsync(otherCtx, "key")
+	go func() {
+		for c.Pending() < 2 {
+			time.Sleep(time.Microsecond * 100)
+		}
+		cancel()
+	}()
+	// caller which gives up is unregistered, other listeners of the same command are kept
+	_, err = c.Get(ctx, "key").Result()
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, c.Pending())
+	assert.Equal(t, 1, c.Len())
+
+	c.Resume()
+	assert.Equal(t, "john", (<-resCh).(*redis.StringCmd).Val())
+
+	// already cancelled context
+	c.Pause()
+	_, err = c.Get(ctx, "key").Result()
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, c.Pending())
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestPauseResume(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHDel("key1").SetVal(1)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithPauseLimit(1),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	c.Pause()
+	resCh := c.HDelAsync(ctx, "key1")
+	// over the pause limit
+	_, err = c.HDel(ctx, "key2").Result()
+	assert.ErrorIs(t, err, ErrChannelClosed)
+
+	time.Sleep(time.Millisecond)
+	assert.Len(t, resCh, 0)
+
+	c.Resume()
+	r, err := (<-resCh).(*redis.IntCmd).Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), r)
+}
+
+func TestLenPending(t *testing.T) {
+	var ctx = context.TODO()
+	db, _ := redismock.NewClientMock()
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Second),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, c.Len())
+	assert.Equal(t, 0, c.Pending())
+
+	c.HDelAsync(ctx, "key1")
+	c.HDelAsync(ctx, "key1")
+	c.GetAsync(ctx, "key2")
+	assert.Equal(t, 2, c.Len())
+	assert.Equal(t, 3, c.Pending())
+}
+
+func TestRuntimeReconfiguration(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHDel("key1").SetVal(1)
+	mock.ExpectHDel("key2").SetVal(2)
+	mock.ExpectHDel("key3").SetVal(3)
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Second),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	resCh := c.HDelAsync(ctx, "key1")
+	resCh3 := c.HDelAsync(ctx, "key3")
+	assert.Nil(t, c.SetRunInterval(time.Microsecond*10))
+	// pipeline is triggered by size now
+	assert.Nil(t, c.SetMaxSize(1))
+	r1, err := (<-resCh).(*redis.IntCmd).Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), r1)
+	assert.Equal(t, int64(3), (<-resCh3).(*redis.IntCmd).Val())
+
+	// and by ttl
+	assert.Nil(t, c.SetMaxSize(200))
+	assert.Nil(t, c.SetCacheTTL(time.Microsecond*100))
+	r2, err := c.HDel(ctx, "key2").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), r2)
+	assert.Equal(t, uint64(1), c.Stats().TTLFlushes)
+}
+
+func TestRuntimeReconfigurationValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     func(c Client) error
+		wantErr error
+	}{
+		{name: "zero ttl", set: func(c Client) error { return c.SetCacheTTL(0) }, wantErr: ErrInvalidTTL},
+		{name: "negative ttl", set: func(c Client) error { return c.SetCacheTTL(-time.Second) }, wantErr: ErrInvalidTTL},
+		{
+			name:    "ttl not less than operation ttl",
+			set:     func(c Client) error { return c.SetCacheTTL(time.Second) },
+			wantErr: ErrInvalidTTL,
+		},
+		{name: "zero max size", set: func(c Client) error { return c.SetMaxSize(0) }, wantErr: ErrInvalidMaxSize},
+		{
+			name:    "max size above int32",
+			set:     func(c Client) error { return c.SetMaxSize(math.MaxInt32 + 1) },
+			wantErr: ErrInvalidMaxSize,
+		},
+		{name: "zero run interval", set: func(c Client) error { return c.SetRunInterval(0) }, wantErr: ErrInvalidRunInterval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := redismock.NewClientMock()
+			c, err := NewAutoPipeline(db, WithOperationTTL(time.Second))
+			assert.Nil(t, err)
+			defer c.Stop()
+
+			assert.ErrorIs(t, tt.set(c), tt.wantErr)
+			// rejected values aren't applied
+			cnf := c.Config()
+			assert.Equal(t, Duration(defaultCacheTTL), cnf.TTL)
+			assert.Equal(t, defaultCacheSize, cnf.MaxSize)
+			assert.Equal(t, Duration(defaultRunInterval), cnf.RunInterval)
+		})
+	}
+}
+
+func TestRuntimeReconfigurationAutoTuning(t *testing.T) {
+	db, _ := redismock.NewClientMock()
+	c, err := NewAutoPipeline(db, WithAutoTuning(AutoTuning{
+		TargetLatency: time.Millisecond,
+		MinTTL:        time.Microsecond * 100,
+		MaxTTL:        time.Millisecond * 10,
+		MinSize:       10,
+		MaxSize:       1000,
+		Interval:      time.Hour,
+	}))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	// values out of bounds of auto tuning are rejected
+	assert.ErrorIs(t, c.SetCacheTTL(time.Microsecond*50), ErrInvalidTTL)
+	assert.ErrorIs(t, c.SetCacheTTL(time.Millisecond*20), ErrInvalidTTL)
+	assert.ErrorIs(t, c.SetMaxSize(5), ErrInvalidMaxSize)
+	assert.ErrorIs(t, c.SetMaxSize(2000), ErrInvalidMaxSize)
+	assert.Equal(t, Duration(defaultCacheTTL), c.Config().TTL)
+	assert.Equal(t, defaultCacheSize, c.Config().MaxSize)
+
+	assert.Nil(t, c.SetCacheTTL(time.Millisecond*10))
+	assert.Nil(t, c.SetMaxSize(10))
+	assert.Equal(t, Duration(time.Millisecond*10), c.Config().TTL)
+	assert.Equal(t, uint(10), c.Config().MaxSize)
+}
+
+func TestDuplicatePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  DuplicatePolicy
+		wantErr error
+	}{
+		{
+			name:   "coalesce",
+			policy: DuplicateCoalesce,
+		},
+		{
+			name:   "warn",
+			policy: DuplicateWarn,
+		},
+		{
+			name:    "reject",
+			policy:  DuplicateReject,
+			wantErr: ErrDuplicateCommand,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			otherCtx, otherCancel := context.WithCancel(context.TODO())
+			defer otherCancel()
+			db, mock := redismock.NewClientMock()
+			mock.ExpectGet("key").SetVal("john")
+
+			c, err := NewAutoPipeline(db,
+				WithCacheTTL(time.Millisecond*5),
+				WithDuplicatePolicy(tt.policy),
+				WithMaxSize(200))
+			assert.Nil(t, err)
+
+			resCh1 := c.GetAsync(ctx, "key")
+			// other caller is never a duplicate
+			resCh2 := c.GetAsync(otherCtx, "key")
+			resCh3 := c.GetAsync(ctx, "key")
+
+			assert.Equal(t, "john", (<-resCh1).(*redis.StringCmd).Val())
+			assert.Equal(t, "john", (<-resCh2).(*redis.StringCmd).Val())
+			_, err = (<-resCh3).(*redis.StringCmd).Result()
+			assert.ErrorIs(t, err, tt.wantErr)
+			_, ok := <-resCh3
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestWithoutDedup(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      []func(a *Autopipeline)
+		wantCommands uint64
+	}{
+		{
+			name:         "deduplicated",
+			wantCommands: 1,
+		},
+		{
+			name:         "excluded from deduplication",
+			options:      []func(a *Autopipeline){WithoutDedup("Do")},
+			wantCommands: 3,
+		},
+		{
+			name:         "excluded from deduplication with lock-free enqueue",
+			options:      []func(a *Autopipeline){WithoutDedup("Do"), WithLockFreeEnqueue(true)},
+			wantCommands: 3,
+		},
+		{
+			name:         "other operation excluded from deduplication",
+			options:      []func(a *Autopipeline){WithoutDedup("EvalRO")},
+			wantCommands: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctx = context.TODO()
+			db, mock := redismock.NewClientMock()
+			for i := uint64(1); i <= tt.wantCommands; i++ {
+				mock.ExpectDo("incr", "counter").SetVal(int64(i))
+			}
+			c, err := NewAutoPipeline(db, append([]func(a *Autopipeline){WithCacheTTL(time.Microsecond * 100)}, tt.options...)...)
+			assert.Nil(t, err)
+			defer c.Stop()
+
+			c.Pause()
+			chs := make([]<-chan interface{}, 3)
+			for i := range chs {
+				chs[i] = c.DoAsync(ctx, "incr", "counter")
+			}
+			c.Resume()
+
+			var sum int64
+			for _, ch := range chs {
+				res := (<-ch).(*redis.Cmd)
+				assert.Nil(t, res.Err())
+				sum += res.Val().(int64)
+			}
+			// every call is incremented separately, otherwise all of them receive the same value
+			if tt.wantCommands == 1 {
+				assert.Equal(t, int64(3), sum)
+			} else {
+				assert.Equal(t, int64(6), sum)
+			}
+			assert.Equal(t, tt.wantCommands, c.Stats().Commands)
+			assert.Nil(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	for i := 0; i < 3; i++ {
+		mock.ExpectGet("key").SetVal("john")
+	}
+	mock.ExpectGet("other").SetVal("mike")
+	mock.MatchExpectationsInOrder(false)
+	clock := newFakeClock()
+	c, err := NewAutoPipeline(db,
+		WithClock(clock),
+		WithCacheTTL(time.Second),
+		WithOperationTTL(0),
+		WithStorageShards(4),
+		WithDedupWindow(time.Millisecond))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, Duration(time.Millisecond), c.Config().DedupWindow)
+
+	var chs []<-chan interface{}
+	c.Pause()
+	// commands within the window are coalesced, later ones start a new command
+	for i := 0; i < 3; i++ {
+		chs = append(chs, c.GetAsync(ctx, "key"), c.GetAsync(ctx, "key"))
+		clock.Advance(time.Millisecond / 2)
+		chs = append(chs, c.GetAsync(ctx, "key"))
+		clock.Advance(time.Millisecond)
+	}
+	chs = append(chs, c.GetAsync(ctx, "other"))
+	assert.Equal(t, 4, c.Len())
+	c.Resume()
+
+	for _, ch := range chs {
+		for len(ch) == 0 {
+			clock.Advance(time.Second)
+			time.Sleep(time.Microsecond * 100)
+		}
+		assert.Nil(t, (<-ch).(*redis.StringCmd).Err())
+	}
+	assert.Equal(t, uint64(4), c.Stats().Commands)
+	for _, sh := range c.(*Autopipeline).cache.shards {
+		sh.mx.RLock()
+		assert.Empty(t, sh.latest)
+		sh.mx.RUnlock()
+	}
+}
+
+func TestDrain(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHDel("key1").SetVal(1)
+	mock.ExpectHDel("key2").SetVal(2)
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*500),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	resCh1 := c.HDelAsync(ctx, "key1")
+	resCh2 := c.HDelAsync(ctx, "key2")
+
+	drainCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	assert.Nil(t, c.Drain(drainCtx))
+	// results are already delivered, so channels are ready to read
+	assert.Len(t, resCh1, 1)
+	assert.Len(t, resCh2, 1)
+}
+
+func TestDrainContextExpired(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHDel("key1").SetVal(1)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Second),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	c.HDelAsync(ctx, "key1")
+
+	drainCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c.Drain(drainCtx), context.DeadlineExceeded)
+}
+
+func TestOperationExpired(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHDel("key1").SetErr(errors.New("connection refused"))
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithOperationTTL(time.Millisecond),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	_, err = c.HDel(ctx, "key1").Result()
+	assert.ErrorIs(t, err, ErrOperationExpired)
+}
+
+func TestNewAutoPipelineValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []func(a *Autopipeline)
+		wantErr error
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:    "nil context",
+			options: []func(a *Autopipeline){WithContext(nil)},
+			wantErr: ErrInvalidContext,
+		},
+		{
+			name:    "zero ttl",
+			options: []func(a *Autopipeline){WithCacheTTL(0)},
+			wantErr: ErrInvalidTTL,
+		},
+		{
+			name:    "zero max size",
+			options: []func(a *Autopipeline){WithMaxSize(0)},
+			wantErr: ErrInvalidMaxSize,
+		},
+		{
+			name:    "zero run interval",
+			options: []func(a *Autopipeline){WithRunInterval(0)},
+			wantErr: ErrInvalidRunInterval,
+		},
+		{
+			name:    "operation ttl less than cache ttl",
+			options: []func(a *Autopipeline){WithCacheTTL(time.Second), WithOperationTTL(time.Millisecond)},
+			wantErr: ErrInvalidOperationTTL,
+		},
+		{
+			name:    "operation ttl disabled",
+			options: []func(a *Autopipeline){WithCacheTTL(time.Second), WithOperationTTL(0)},
+		},
+		{
+			name:    "negative command timeout",
+			options: []func(a *Autopipeline){WithCommandTimeout(-time.Second)},
+			wantErr: ErrInvalidCommandTimeout,
+		},
+		{
+			name:    "zero pause limit",
+			options: []func(a *Autopipeline){WithPauseLimit(0)},
+			wantErr: ErrInvalidPauseLimit,
+		},
+		{
+			name:    "negative adaptive ttl",
+			options: []func(a *Autopipeline){WithAdaptiveTTL(-1)},
+			wantErr: ErrInvalidAdaptiveTTL,
+		},
+		{
+			name:    "flush jitter out of range",
+			options: []func(a *Autopipeline){WithFlushJitter(1)},
+			wantErr: ErrInvalidFlushJitter,
+		},
+		{
+			name:    "zero storage shards",
+			options: []func(a *Autopipeline){WithStorageShards(0)},
+			wantErr: ErrInvalidStorageShards,
+		},
+		{
+			name:    "negative pipeline exec timeout",
+			options: []func(a *Autopipeline){WithPipelineExecTimeout(-time.Second)},
+			wantErr: ErrInvalidExecTimeout,
+		},
+		{
+			name:    "too many delivery workers",
+			options: []func(a *Autopipeline){WithDeliveryWorkers(257)},
+			wantErr: ErrInvalidDeliveryWorkers,
+		},
+		{
+			name:    "negative delivery timeout",
+			options: []func(a *Autopipeline){WithDeliveryTimeout(-time.Millisecond)},
+			wantErr: ErrInvalidDeliveryTimeout,
+		},
+		{
+			name:    "unknown operation excluded from deduplication",
+			options: []func(a *Autopipeline){WithoutDedup("Incr")},
+			wantErr: ErrInvalidNoDedup,
+		},
+		{
+			name:    "key prefix with hash tag",
+			options: []func(a *Autopipeline){WithKeyPrefix("{tenant1}:")},
+			wantErr: ErrInvalidKeyPrefix,
+		},
+		{
+			name:    "negative dedup window",
+			options: []func(a *Autopipeline){WithDedupWindow(-time.Microsecond)},
+			wantErr: ErrInvalidDedupWindow,
+		},
+		{
+			name:    "negative write lane ttl",
+			options: []func(a *Autopipeline){WithWriteLane(-time.Millisecond, 100)},
+			wantErr: ErrInvalidWriteLane,
+		},
+		{
+			name:    "zero write lane max size",
+			options: []func(a *Autopipeline){WithWriteLane(time.Millisecond, 0)},
+			wantErr: ErrInvalidWriteLane,
+		},
+		{
+			name:    "too large max pending",
+			options: []func(a *Autopipeline){WithMaxPending(math.MaxInt32 + 1)},
+			wantErr: ErrInvalidMaxPending,
+		},
+		{
+			name:    "negative failover pause",
+			options: []func(a *Autopipeline){WithFailoverPause(-time.Second)},
+			wantErr: ErrInvalidFailoverPause,
+		},
+		{
+			name:    "failover pause without operation ttl",
+			options: []func(a *Autopipeline){WithOperationTTL(0), WithFailoverPause(time.Second)},
+			wantErr: ErrInvalidFailoverPause,
+		},
+		{
+			name:    "negative auto tuning rate",
+			options: []func(a *Autopipeline){WithAutoTuning(AutoTuning{TargetRate: -1})},
+			wantErr: ErrInvalidAutoTuning,
+		},
+		{
+			name: "auto tuning without ttl bounds",
+			options: []func(a *Autopipeline){WithAutoTuning(AutoTuning{
+				TargetLatency: time.Millisecond, MinSize: 1, MaxSize: 100,
+			})},
+			wantErr: ErrInvalidAutoTuning,
+		},
+		{
+			name: "auto tuning with unordered max size bounds",
+			options: []func(a *Autopipeline){WithAutoTuning(AutoTuning{
+				TargetRate: 1000, MinTTL: time.Microsecond, MaxTTL: time.Millisecond, MinSize: 100, MaxSize: 10,
+			})},
+			wantErr: ErrInvalidAutoTuning,
+		},
+		{
+			name: "auto tuning max ttl above operation ttl",
+			options: []func(a *Autopipeline){WithOperationTTL(5 * time.Second), WithAutoTuning(AutoTuning{
+				TargetRate: 1000, MinTTL: time.Microsecond, MaxTTL: 10 * time.Second, MinSize: 1, MaxSize: 100,
+			})},
+			wantErr: ErrInvalidAutoTuning,
+		},
+		{
+			name:    "transactional with max commands per pipeline",
+			options: []func(a *Autopipeline){WithTransactional(true), WithMaxCommandsPerPipeline(10)},
+			wantErr: ErrInvalidTransactional,
+		},
+		{
+			name:    "transactional with replica reads",
+			options: []func(a *Autopipeline){WithTransactional(true), WithReplicaReads(true)},
+			wantErr: ErrInvalidTransactional,
+		},
+		{
+			name:    "nil standby",
+			options: []func(a *Autopipeline){WithStandby((*redis.Client)(nil), 3)},
+			wantErr: ErrInvalidStandby,
+		},
+		{
+			name:    "zero standby failures",
+			options: []func(a *Autopipeline){WithStandby(redis.NewClient(&redis.Options{}), 0)},
+			wantErr: ErrInvalidStandby,
+		},
+		{
+			name:    "nil executor",
+			options: []func(a *Autopipeline){WithExecutor(nil)},
+			wantErr: ErrInvalidExecutor,
+		},
+		{
+			name:    "zero workers",
+			options: []func(a *Autopipeline){WithWorkers(0)},
+			wantErr: ErrInvalidWorkers,
+		},
+		{
+			name:    "negative max delay",
+			options: []func(a *Autopipeline){WithMaxDelay(-time.Second)},
+			wantErr: ErrInvalidMaxDelay,
+		},
+		{
+			name:    "negative solo bypass",
+			options: []func(a *Autopipeline){WithSoloBypass(-time.Second)},
+			wantErr: ErrInvalidSoloBypass,
+		},
+		{
+			name:    "nil logger",
+			options: []func(a *Autopipeline){WithLogger(nil)},
+			wantErr: ErrInvalidLogger,
+		},
+		{
+			name:    "nil slog logger",
+			options: []func(a *Autopipeline){WithSlog(nil)},
+			wantErr: ErrInvalidLogger,
+		},
+		{
+			name:    "nil encoder",
+			options: []func(a *Autopipeline){WithCrashDump(io.Discard), WithSnapshotEncoder(nil)},
+			wantErr: ErrInvalidEncoder,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := redismock.NewClientMock()
+			c, err := NewAutoPipeline(db, tt.options...)
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr != nil {
+				assert.Nil(t, c)
+				return
+			}
+			c.Stop()
+		})
+	}
+}
+
+func TestExecTimeoutFromOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  *redis.Options
+		want time.Duration
+	}{
+		{
+			name: "default timeouts",
+			opt:  redis.NewClient(&redis.Options{}).Options(),
+			want: 6 * time.Second,
+		},
+		{
+			name: "custom timeouts",
+			opt:  redis.NewClient(&redis.Options{ReadTimeout: time.Second, WriteTimeout: 2 * time.Second}).Options(),
+			want: 3 * time.Second,
+		},
+		{
+			name: "no read timeout",
+			opt:  redis.NewClient(&redis.Options{ReadTimeout: -1, WriteTimeout: time.Second}).Options(),
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, execTimeoutFromOptions(tt.opt))
+		})
+	}
+}
+
+func TestUniversalClient(t *testing.T) {
+	tests := []struct {
+		name   string
+		client redis.UniversalClient
+		want   time.Duration
+	}{
+		{
+			name:   "single node",
+			client: redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{":6379"}}),
+			want:   6 * time.Second,
+		},
+		{
+			name: "sentinel",
+			client: redis.NewUniversalClient(&redis.UniversalOptions{
+				Addrs:       []string{":26379"},
+				MasterName:  "master",
+				ReadTimeout: time.Second,
+			}),
+			want: 2 * time.Second,
+		},
+		{
+			name:   "cluster",
+			client: redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{":7000", ":7001"}}),
+			want:   6 * time.Second,
+		},
+		{
+			name:   "ring",
+			client: redis.NewRing(&redis.RingOptions{Addrs: map[string]string{"shard1": ":6379"}}),
+			want:   6 * time.Second,
+		},
+		{
+			name: "ring without read timeout",
+			client: redis.NewRing(&redis.RingOptions{
+				Addrs:       map[string]string{"shard1": ":6379"},
+				ReadTimeout: -1,
+			}),
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer tt.client.Close()
+			c, err := NewAutoPipeline(tt.client)
+			assert.Nil(t, err)
+			defer c.Stop()
+			assert.Equal(t, tt.want, c.(*Autopipeline).cache.execTimeout)
+		})
+	}
+}
+
 func TestHDel(t *testing.T) {
 	var ctx = context.TODO()
 	db, mock := redismock.NewClientMock()
@@ -316,3 +1232,933 @@ func TestMGet(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, []interface{}{"john", "jill"}, result)
 }
+
+func TestEvalRO(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectEvalRO("return ARGV[1]", []string{"key"}, "10").SetVal("10")
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	result, err := c.EvalRO(ctx, "return ARGV[1]", []string{"key"}, 10).Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "10", result)
+}
+
+func TestFCallRO(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectFCallRo("myfunc", []string{"key"}, "arg").SetVal("john")
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	result, err := c.FCallRO(ctx, "myfunc", []string{"key"}, "arg").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "john", result)
+}
+
+func TestOperationReadOnly(t *testing.T) {
+	assert.True(t, EvalRO.readOnly())
+	assert.True(t, FCallRO.readOnly())
+	assert.True(t, Get.readOnly())
+	assert.False(t, Del.readOnly())
+	assert.False(t, Expire.readOnly())
+	assert.False(t, SafeDel.readOnly())
+}
+
+func TestExists(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectExists("key", "key1").SetVal(1)
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	result, err := c.Exists(ctx, "key", "key1").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), result)
+}
+
+func TestExistsMany(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectExists("key").SetVal(1)
+	mock.ExpectExists("key1").SetVal(0)
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	result, err := c.ExistsMany(ctx, []string{"key", "key1", "key"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"key": true, "key1": false}, result)
+}
+
+func TestExistsManyError(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectExists("key").SetVal(1)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	c.Stop()
+	_, err = c.ExistsMany(ctx, []string{"key"})
+	assert.ErrorIs(t, err, ErrChannelClosed)
+}
+
+func TestTime(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	now := time.Unix(1700000000, 0)
+	mock.ExpectTime().SetVal(now)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	result, err := c.Time(ctx).Result()
+	assert.Nil(t, err)
+	assert.True(t, now.Equal(result))
+}
+
+func TestEcho(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectEcho("ping").SetVal("ping")
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	result, err := c.Echo(ctx, "ping").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", result)
+}
+
+func TestDo(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectDo("setrange", "key", "6", "redis").SetVal(int64(11))
+	mock.ExpectDo("strlen", "key").SetVal(int64(11))
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	c.Pause()
+	resCh1 := c.DoAsync(ctx, "setrange", "key", 6, "redis")
+	resCh2 := c.DoAsync(ctx, "strlen", "key")
+	c.Resume()
+	result, err := (<-resCh1).(*redis.Cmd).Int64()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), result)
+	result, err = (<-resCh2).(*redis.Cmd).Int64()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), result)
+
+	c.Stop()
+	assert.ErrorIs(t, c.Do(ctx, "strlen", "key").Err(), ErrChannelClosed)
+}
+
+func TestEnqueueCmd(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectDo("strlen", "key").SetVal(int64(5))
+	mock.ExpectDo("strlen", "key").SetVal(int64(5))
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	// identical pre-built commands are not coalesced, each of them is completed in place
+	cmd1 := redis.NewIntCmd(ctx, "strlen", "key")
+	cmd2 := redis.NewIntCmd(ctx, "strlen", "key")
+	c.Pause()
+	resCh1 := c.EnqueueCmd(ctx, cmd1)
+	resCh2 := c.EnqueueCmd(ctx, cmd2)
+	assert.Equal(t, 2, c.Len())
+	c.Resume()
+	assert.Equal(t, cmd1, <-resCh1)
+	assert.Equal(t, cmd2, <-resCh2)
+	assert.Nil(t, cmd1.Err())
+	assert.Equal(t, int64(5), cmd1.Val())
+	assert.Equal(t, int64(5), cmd2.Val())
+
+	c.Stop()
+	_, ok := <-c.EnqueueCmd(ctx, redis.NewIntCmd(ctx, "strlen", "key"))
+	assert.False(t, ok)
+}
+
+func TestHealth(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key").SetVal("john")
+	mock.ExpectGet("key1").SetErr(errors.New("connection refused"))
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithOperationTTL(20*time.Millisecond),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	assert.True(t, c.IsRunning())
+	assert.True(t, c.LastFlushTime().IsZero())
+	assert.Nil(t, c.LastError())
+
+	start := time.Now()
+	_, err = c.Get(ctx, "key").Result()
+	assert.Nil(t, err)
+	assert.False(t, c.LastFlushTime().Before(start.Truncate(time.Microsecond)))
+	assert.Nil(t, c.LastError())
+
+	_, err = c.Get(ctx, "key1").Result()
+	assert.EqualError(t, err, "connection refused")
+	assert.EqualError(t, c.LastError(), "connection refused")
+
+	c.Stop()
+	assert.False(t, c.IsRunning())
+}
+
+func TestLatencyInjection(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key").SetVal("john")
+	mock.ExpectDo("debug", "sleep", "0.01").SetVal("OK")
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithInjectedLatency(5*time.Millisecond),
+		WithDebugSleep(10*time.Millisecond))
+	assert.Nil(t, err)
+
+	r, err := c.Get(ctx, "key").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "john", r)
+	// injected latency is a part of round-trip time
+	assert.GreaterOrEqual(t, c.Stats().LastRTT, 5*time.Millisecond)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key1").SetVal("john")
+	mock.ExpectGet("key2").SetVal("jane")
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithDeadlinePropagation(true))
+	assert.Nil(t, err)
+	assert.True(t, c.Config().DeadlinePropagation)
+
+	tight, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	loose, looseCancel := context.WithTimeout(context.TODO(), time.Hour)
+	defer looseCancel()
+	c.Pause()
+	resCh1 := c.GetAsync(loose, "key1")
+	resCh2 := c.GetAsync(tight, "key2")
+	// pipeline is executed with the earliest deadline
+	cache := c.(*Autopipeline).cache
+	sh := cache.shards[0]
+	sh.mx.RLock()
+	deadline := cache.deadline(sh, time.Time{})
+	sh.mx.RUnlock()
+	want, _ := tight.Deadline()
+	assert.Equal(t, want, deadline)
+	c.Resume()
+
+	assert.Equal(t, "john", (<-resCh1).(*redis.StringCmd).Val())
+	assert.Equal(t, "jane", (<-resCh2).(*redis.StringCmd).Val())
+}
+
+func TestAdaptiveTTL(t *testing.T) {
+	tests := []struct {
+		name   string
+		factor float64
+		rtt    time.Duration
+		want   time.Duration
+	}{
+		{name: "static ttl", rtt: 100 * time.Microsecond, want: time.Millisecond},
+		{name: "nothing measured", factor: 2, want: time.Millisecond},
+		{name: "adapted to rtt", factor: 2, rtt: 100 * time.Microsecond, want: 200 * time.Microsecond},
+		{name: "limited by ttl", factor: 2, rtt: time.Millisecond, want: time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := redismock.NewClientMock()
+			c, err := NewAutoPipeline(db,
+				WithCacheTTL(time.Millisecond),
+				WithAdaptiveTTL(tt.factor))
+			assert.Nil(t, err)
+			defer c.Stop()
+			assert.Equal(t, tt.factor, c.Config().AdaptiveTTL)
+
+			cache := c.(*Autopipeline).cache
+			if tt.rtt > 0 {
+				cache.stats.rtt(tt.rtt)
+			}
+			assert.Equal(t, tt.want, cache.window())
+		})
+	}
+}
+
+func TestMaxDelay(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key").SetVal("john")
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Second),
+		WithMaxSize(200),
+		WithMaxDelay(time.Millisecond))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, Duration(time.Millisecond), c.Config().MaxDelay)
+
+	start := time.Now()
+	assert.Equal(t, "john", c.Get(ctx, "key").Val())
+	// command doesn't wait for the ttl
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, uint64(1), c.Stats().MaxDelayFlushes)
+	assert.Equal(t, uint64(0), c.Stats().TTLFlushes)
+}
+
+func TestSoloBypass(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key1").SetVal("john")
+	mock.ExpectGet("key2").SetVal("jane")
+	mock.ExpectGet("key3").SetVal("jack")
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(50*time.Millisecond),
+		WithMaxSize(200),
+		WithSoloBypass(100*time.Microsecond))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, Duration(100*time.Microsecond), c.Config().SoloBypass)
+
+	start := time.Now()
+	assert.Equal(t, "john", c.Get(ctx, "key1").Val())
+	// solitary command doesn't wait for the ttl
+	assert.Less(t, time.Since(start), 25*time.Millisecond)
+	assert.Equal(t, uint64(1), c.Stats().SoloFlushes)
+
+	// concurrent commands are batched
+	c.Pause()
+	resCh2 := c.GetAsync(ctx, "key2")
+	resCh3 := c.GetAsync(ctx, "key3")
+	c.Resume()
+	assert.Equal(t, "jane", (<-resCh2).(*redis.StringCmd).Val())
+	assert.Equal(t, "jack", (<-resCh3).(*redis.StringCmd).Val())
+	assert.Equal(t, uint64(1), c.Stats().SoloFlushes)
+	assert.Equal(t, uint64(1), c.Stats().TTLFlushes)
+}
+
+func TestMaxCommandsPerPipeline(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.MatchExpectationsInOrder(false)
+	keys := []string{"key1", "key2", "key3", "key4", "key5"}
+	for _, key := range keys {
+		mock.ExpectGet(key).SetVal(key)
+	}
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200),
+		WithMaxCommandsPerPipeline(2))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, uint(2), c.Config().MaxCommandsPerPipeline)
+
+	c.Pause()
+	var resChs []<-chan interface{}
+	for _, key := range keys {
+		resChs = append(resChs, c.GetAsync(ctx, key))
+	}
+	c.Resume()
+	for i, resCh := range resChs {
+		assert.Equal(t, keys[i], (<-resCh).(*redis.StringCmd).Val())
+	}
+	stats := c.Stats()
+	assert.Equal(t, uint64(3), stats.Pipelines)
+	assert.Equal(t, uint64(5), stats.Commands)
+	assert.Equal(t, uint64(2), stats.MaxCommands)
+}
+
+func TestMaxCommandsPerPipelinePartialFailure(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectGet("key1").SetErr(errors.New("connection refused"))
+	mock.ExpectGet("key2").SetVal("jane")
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200),
+		WithMaxCommandsPerPipeline(1),
+		WithLogger(&errorRecorder{}))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	c.Pause()
+	resCh1 := c.GetAsync(ctx, "key1")
+	resCh2 := c.GetAsync(ctx, "key2")
+	c.Resume()
+	// result of succeeded pipeline is delivered, failed command gets its own error
+	assert.Equal(t, "jane", (<-resCh2).(*redis.StringCmd).Val())
+	assert.EqualError(t, (<-resCh1).(*redis.StringCmd).Err(), "connection refused")
+	assert.Equal(t, 0, c.Pending())
+}
+
+// wrongTypeHook completes commands as redis does, commands with keys starting with "wrong" fail with WRONGTYPE,
+// pipeline fails with the first error, as go-redis does
+type wrongTypeHook struct {
+	mgetHook
+}
+
+func (h *wrongTypeHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	process := h.mgetHook.ProcessPipelineHook(next)
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		_ = process(ctx, cmds)
+		var err error
+		for _, cmd := range cmds {
+			if strings.HasPrefix(fmt.Sprint(cmd.Args()[1]), "wrong") {
+				cmd.SetErr(errors.New("WRONGTYPE Operation against a key holding the wrong kind of value"))
+			}
+			if err == nil {
+				err = cmd.Err()
+			}
+		}
+		return err
+	}
+}
+
+func TestPipelineCommandErrors(t *testing.T) {
+	var ctx = context.TODO()
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(&wrongTypeHook{})
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithLogger(&errorRecorder{}))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	c.Pause()
+	resCh1 := c.GetAsync(ctx, "wrong1")
+	resCh2 := c.GetAsync(ctx, "key2")
+	resCh3 := c.DelAsync(ctx, "key3")
+	c.Resume()
+	// every command of failed pipeline is completed with its own result
+	assert.ErrorContains(t, (<-resCh1).(*redis.StringCmd).Err(), "WRONGTYPE")
+	assert.Equal(t, "key2", (<-resCh2).(*redis.StringCmd).Val())
+	assert.Equal(t, int64(1), (<-resCh3).(*redis.IntCmd).Val())
+	assert.Equal(t, 0, c.Pending())
+	assert.Equal(t, uint64(1), c.Stats().Errors)
+
+	// commands of pipeline failed by a hook, which doesn't complete them, get the error of pipeline
+	db = redis.NewClient(&redis.Options{})
+	db.AddHook(failingHook{})
+	c, err = NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithLogger(&errorRecorder{}))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.EqualError(t, c.Get(ctx, "key1").Err(), "connection refused")
+}
+
+// flakyHook fails the first pipelines with connection error set to every command, as go-redis does,
+// next ones are completed by mgetHook
+type flakyHook struct {
+	mgetHook
+	fails atomic.Int32
+}
+
+func (h *flakyHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	process := h.mgetHook.ProcessPipelineHook(next)
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if h.fails.Add(-1) < 0 {
+			return process(ctx, cmds)
+		}
+		err := errors.New("read: connection reset by peer")
+		for _, cmd := range cmds {
+			cmd.SetErr(err)
+		}
+		return err
+	}
+}
+
+func TestReadRetries(t *testing.T) {
+	var ctx = context.TODO()
+	hook := &flakyHook{}
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(hook)
+	letters := &deadLetters{}
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithReadRetries(2),
+		WithDeadLetterHook(letters.hook),
+		WithLogger(&errorRecorder{}))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, uint(2), c.Config().ReadRetries)
+
+	// read-only command is requeued, mutating one fails right away
+	hook.fails.Store(2)
+	c.Pause()
+	getCh := c.GetAsync(ctx, "key1")
+	delCh := c.DelAsync(ctx, "key2")
+	c.Resume()
+	assert.EqualError(t, (<-delCh).(*redis.IntCmd).Err(), "read: connection reset by peer")
+	assert.Equal(t, "key1", (<-getCh).(*redis.StringCmd).Val())
+	assert.Equal(t, uint64(2), c.Stats().Requeues)
+	assert.Empty(t, letters.get())
+
+	// command, which exhausted requeues, fails and is passed to dead letter hook
+	hook.fails.Store(3)
+	assert.EqualError(t, c.HGet(ctx, "key3", "name").Err(), "read: connection reset by peer")
+	assert.Equal(t, uint64(4), c.Stats().Requeues)
+	got := letters.get()
+	assert.Len(t, got, 1)
+	assert.Equal(t, "HGet", got[0].Kind)
+	assert.Equal(t, []string{"key3", "name"}, got[0].Args)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestMaxBatchBytes(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.MatchExpectationsInOrder(false)
+	keys := make([]string, 100)
+	vals := make([]interface{}, 100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	mock.ExpectMGet(keys...).SetVal(vals)
+	mock.ExpectGet("key").SetVal("john")
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Second),
+		WithMaxSize(200),
+		WithMaxBatchBytes(512))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, uint(512), c.Config().MaxBatchBytes)
+
+	resCh := c.GetAsync(ctx, "key")
+	// the only command exceeds batch bytes, so both commands are executed without waiting for the ttl
+	start := time.Now()
+	assert.Nil(t, c.MGet(ctx, keys...).Err())
+	assert.Equal(t, "john", (<-resCh).(*redis.StringCmd).Val())
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, uint64(1), c.Stats().BytesFlushes)
+	assert.Equal(t, uint64(2), c.Stats().Commands)
+}
+
+func TestEstimateSize(t *testing.T) {
+	assert.Equal(t, int64(len("*2\r\n$3\r\nGet\r\n$3\r\nkey\r\n")), estimateSize(Get, []string{"key"}))
+	assert.Equal(t, int64(len("*3\r\n$4\r\nHGet\r\n$3\r\nkey\r\n$10\r\nfield12345\r\n")),
+		estimateSize(HGet, []string{"key", "field12345"}))
+}
+
+func TestFlushJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		fraction float64
+		sample   float64
+		want     time.Duration
+	}{
+		{name: "no jitter", sample: 0.5, want: time.Millisecond},
+		{name: "min jitter", fraction: 0.2, want: time.Millisecond},
+		{name: "half of jitter", fraction: 0.2, sample: 0.5, want: 900 * time.Microsecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := redismock.NewClientMock()
+			c, err := NewAutoPipeline(db,
+				WithCacheTTL(time.Millisecond),
+				WithFlushJitter(tt.fraction))
+			assert.Nil(t, err)
+			defer c.Stop()
+			assert.Equal(t, tt.fraction, c.Config().FlushJitter)
+
+			cache := c.(*Autopipeline).cache
+			cache.jitterSample.Store(math.Float64bits(tt.sample))
+			assert.Equal(t, tt.want, cache.jittered(cache.window()))
+		})
+	}
+}
+
+func TestStorageShards(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.MatchExpectationsInOrder(false)
+	keys := make([]string, 50)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		mock.ExpectGet(keys[i]).SetVal(keys[i])
+	}
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200),
+		WithStorageShards(8))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, uint(8), c.Config().StorageShards)
+
+	c.Pause()
+	resChs := make([]<-chan interface{}, len(keys))
+	for i, key := range keys {
+		resChs[i] = c.GetAsync(ctx, key)
+	}
+	// duplicate is coalesced, abandoned listener is removed from its shard
+	abandoned := c.GetAsync(ctx, keys[0])
+	cache := c.(*Autopipeline).cache
+	cache.abandon(ctx, abandoned)
+	_, ok := <-abandoned
+	assert.False(t, ok)
+	assert.Equal(t, len(keys), c.Pending())
+	assert.Equal(t, len(keys), c.Len())
+	assert.Len(t, c.DumpPending(), len(keys))
+	c.Resume()
+
+	for i, resCh := range resChs {
+		assert.Equal(t, keys[i], (<-resCh).(*redis.StringCmd).Val())
+	}
+	// commands of all shards are executed in the same pipeline
+	assert.Equal(t, uint64(1), c.Stats().Pipelines)
+	assert.Equal(t, 0, c.Pending())
+	// locations of delivered listeners are forgotten
+	waiters := 0
+	cache.waiters.Range(func(_, _ interface{}) bool {
+		waiters++
+		return true
+	})
+	assert.Zero(t, waiters)
+}
+
+func TestShrinkStorage(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.MatchExpectationsInOrder(false)
+	keys := make([]string, shrinkMinPeak)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		mock.ExpectGet(keys[i]).SetVal(keys[i])
+	}
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(uint(2*len(keys))))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	c.Pause()
+	resChs := make([]<-chan interface{}, len(keys))
+	for i, key := range keys {
+		resChs[i] = c.GetAsync(ctx, key)
+	}
+	sh := c.(*Autopipeline).cache.shards[0]
+	sh.mx.RLock()
+	assert.Equal(t, len(keys), sh.peak)
+	sh.mx.RUnlock()
+	c.Resume()
+
+	for i, resCh := range resChs {
+		assert.Equal(t, keys[i], (<-resCh).(*redis.StringCmd).Val())
+	}
+	// storage drained by the flush is recreated
+	sh.mx.RLock()
+	assert.Equal(t, 0, sh.peak)
+	assert.Len(t, sh.storage, 0)
+	sh.mx.RUnlock()
+}
+
+func TestShardShrink(t *testing.T) {
+	tests := []struct {
+		name   string
+		peak   int
+		remain int
+		want   bool
+	}{
+		{name: "small peak", peak: shrinkMinPeak - 1, remain: 0, want: false},
+		{name: "drained", peak: shrinkMinPeak, remain: 0, want: true},
+		{name: "partially drained", peak: shrinkMinPeak, remain: shrinkMinPeak / shrinkRatio, want: true},
+		{name: "still large", peak: shrinkMinPeak, remain: shrinkMinPeak/shrinkRatio + 1, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sh := &shard{mx: &sync.RWMutex{}, storage: make(map[string]*redisOperation), peak: tt.peak}
+			for i := 0; i < tt.remain; i++ {
+				sh.storage[fmt.Sprint(i)] = &redisOperation{}
+			}
+			assert.Equal(t, tt.want, sh.shrink())
+			assert.Len(t, sh.storage, tt.remain)
+			if tt.want {
+				assert.Equal(t, tt.remain, sh.peak)
+			} else {
+				assert.Equal(t, tt.peak, sh.peak)
+			}
+		})
+	}
+}
+
+// barrierHook replies to Get with the key, every pipeline waits for the others up to the timeout,
+// so the max number of pipelines in flight is known
+type barrierHook struct {
+	mx       sync.Mutex
+	inFlight int
+	maxSeen  int
+	ready    chan struct{}
+	parties  int
+}
+
+func (h *barrierHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *barrierHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (h *barrierHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.mx.Lock()
+		h.inFlight++
+		h.maxSeen = max(h.maxSeen, h.inFlight)
+		if h.inFlight == h.parties {
+			close(h.ready)
+		}
+		h.mx.Unlock()
+		select {
+		case <-h.ready:
+		case <-time.After(time.Second):
+		}
+		for _, cmd := range cmds {
+			cmd.(*redis.StringCmd).SetVal(fmt.Sprint(cmd.Args()[1]))
+		}
+		h.mx.Lock()
+		h.inFlight--
+		h.mx.Unlock()
+		return nil
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	var ctx = context.TODO()
+	hook := &barrierHook{ready: make(chan struct{}), parties: 4}
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(hook)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200),
+		WithWorkers(4))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, uint(4), c.Config().Workers)
+
+	c.Pause()
+	keys := make([]string, 10)
+	resChs := make([]<-chan interface{}, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		resChs[i] = c.GetAsync(ctx, keys[i])
+	}
+	c.Resume()
+
+	for i, resCh := range resChs {
+		assert.Equal(t, keys[i], (<-resCh).(*redis.StringCmd).Val())
+	}
+	// batch is split evenly between workers, pipelines are executed concurrently
+	stats := c.Stats()
+	assert.Equal(t, uint64(4), stats.Pipelines)
+	hook.mx.Lock()
+	assert.Equal(t, 4, hook.maxSeen)
+	hook.mx.Unlock()
+}
+
+// stallHook never replies to pipelines, they fail once ctx is done
+type stallHook struct{}
+
+func (stallHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (stallHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (stallHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+}
+
+// gateHook replies to Get with the key, once pipeline, which has started, is released
+type gateHook struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *gateHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *gateHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (h *gateHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.started <- struct{}{}
+		<-h.release
+		for _, cmd := range cmds {
+			cmd.(*redis.StringCmd).SetVal(fmt.Sprint(cmd.Args()[1]))
+		}
+		return nil
+	}
+}
+
+// failingHook fails pipelines without completing their commands
+type failingHook struct{}
+
+func (failingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (failingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (failingHook) ProcessPipelineHook(redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(context.Context, []redis.Cmder) error {
+		return errors.New("connection refused")
+	}
+}
+
+func TestPipelineExecTimeout(t *testing.T) {
+	var ctx = context.TODO()
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(stallHook{})
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithPipelineExecTimeout(10*time.Millisecond))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, Duration(10*time.Millisecond), c.Config().PipelineExecTimeout)
+
+	// commands of timed out pipeline are not retried, the next pipeline is executed as usual
+	for _, key := range []string{"key1", "key2"} {
+		start := time.Now()
+		err := c.Get(ctx, key).Err()
+		assert.ErrorIs(t, err, ErrPipelineTimeout)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, 0, c.Len())
+	}
+	stats := c.Stats()
+	assert.Equal(t, uint64(2), stats.Pipelines)
+	assert.Equal(t, uint64(2), stats.Errors)
+}
+
+// slowAdmitter blocks release of quota of tenant "slow" until release channel is closed
+type slowAdmitter struct {
+	release chan struct{}
+}
+
+func (a slowAdmitter) Admit(context.Context, string) error {
+	return nil
+}
+
+func (a slowAdmitter) Done(ctx context.Context, _ string) {
+	if tenantFromContext(ctx) == "slow" {
+		<-a.release
+	}
+}
+
+func TestDeliveryWorkers(t *testing.T) {
+	ctx := context.TODO()
+	slowCtx := context.WithValue(ctx, tenantKey{}, "slow")
+	db, mock := redismock.NewClientMock()
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectGet("key1").SetVal("john")
+	mock.ExpectGet("key2").SetVal("mike")
+
+	admitter := slowAdmitter{release: make(chan struct{})}
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithAdmission(admitter),
+		WithDeliveryWorkers(2))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, uint(2), c.Config().DeliveryWorkers)
+
+	c.Pause()
+	slowCh := c.GetAsync(slowCtx, "key1")
+	resCh := c.GetAsync(ctx, "key2")
+	c.Resume()
+
+	// slow receiver doesn't delay others in the batch
+	select {
+	case res := <-resCh:
+		assert.Equal(t, "mike", res.(*redis.StringCmd).Val())
+	case <-time.After(time.Second):
+		t.Fatal("result is delayed by slow receiver")
+	}
+	select {
+	case <-slowCh:
+		t.Fatal("result is delivered before quota is released")
+	default:
+	}
+	close(admitter.release)
+	assert.Equal(t, "john", (<-slowCh).(*redis.StringCmd).Val())
+}
+
+func TestOnCreate(t *testing.T) {
+	db := redis.NewClient(&redis.Options{})
+	var calls []string
+	hook := func(name string, err error) func(a *Autopipeline) error {
+		return func(a *Autopipeline) error {
+			assert.NotNil(t, a.cache)
+			calls = append(calls, name)
+			return err
+		}
+	}
+	c, err := NewAutoPipeline(db, WithOnCreate(hook("first", nil)), WithOnCreate(hook("second", nil)))
+	assert.Nil(t, err)
+	c.Stop()
+	assert.Equal(t, []string{"first", "second"}, calls)
+
+	calls = nil
+	hookErr := errors.New("registry is closed")
+	_, err = NewAutoPipeline(db, WithOnCreate(hook("first", hookErr)), WithOnCreate(hook("second", nil)))
+	assert.ErrorIs(t, err, hookErr)
+	assert.Equal(t, []string{"first"}, calls)
+}
@@ -0,0 +1,227 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock, which time is moved forward by Advance only
+type fakeClock struct {
+	mx     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1), deadline: c.now.Add(d), active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	panic("not implemented")
+}
+
+// Advance moves time forward and fires expired timers
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.now = c.now.Add(d)
+	active := c.timers[:0]
+	for _, t := range c.timers {
+		if !t.active {
+			continue
+		}
+		if t.deadline.After(c.now) {
+			active = append(active, t)
+			continue
+		}
+		t.active = false
+		t.c <- c.now
+	}
+	c.timers = active
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mx.Lock()
+	defer t.clock.mx.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mx.Lock()
+	defer t.clock.mx.Unlock()
+	wasActive := t.active
+	t.deadline = t.clock.now.Add(d)
+	if !wasActive {
+		t.active = true
+		t.clock.timers = append(t.clock.timers, t)
+	}
+	return wasActive
+}
+
+func TestClockTTLTriggered(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key").SetVal("john")
+	clock := newFakeClock()
+
+	c, err := NewAutoPipeline(db,
+		WithClock(clock),
+		WithCacheTTL(time.Second),
+		WithOperationTTL(0),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	resCh := c.GetAsync(ctx, "key")
+	// time doesn't go, so there is no pipeline
+	time.Sleep(time.Millisecond)
+	assert.Len(t, resCh, 0)
+	assert.Equal(t, uint64(0), c.Stats().Pipelines)
+
+	// background goroutine may be not waiting for the timer yet, so advance time until result is ready
+	for {
+		clock.Advance(time.Second)
+		select {
+		case res := <-resCh:
+			assert.Equal(t, "john", res.(*redis.StringCmd).Val())
+			assert.Equal(t, uint64(1), c.Stats().TTLFlushes)
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// countingClock is a real clock, which counts created timers
+type countingClock struct {
+	realClock
+	timers atomic.Int32
+}
+
+func (c *countingClock) NewTimer(d time.Duration) Timer {
+	c.timers.Add(1)
+	return c.realClock.NewTimer(d)
+}
+
+func TestClockTimerReused(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key1").SetVal("john")
+	mock.ExpectGet("key2").SetVal("jane")
+	mock.ExpectGet("key3").SetVal("jack")
+	clock := &countingClock{}
+
+	c, err := NewAutoPipeline(db,
+		WithClock(clock),
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	// every command is flushed by ttl timer
+	assert.Equal(t, "john", c.Get(ctx, "key1").Val())
+	assert.Equal(t, "jane", c.Get(ctx, "key2").Val())
+	assert.Equal(t, "jack", c.Get(ctx, "key3").Val())
+	assert.Equal(t, uint64(3), c.Stats().TTLFlushes)
+	c.Stop()
+	assert.Equal(t, int32(1), clock.timers.Load())
+}
+
+func TestClockTTLOfOldestCommand(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key1").SetVal("john")
+	mock.ExpectGet("key2").SetVal("jane")
+	clock := newFakeClock()
+
+	c, err := NewAutoPipeline(db,
+		WithClock(clock),
+		WithCacheTTL(time.Second),
+		WithOperationTTL(0),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	advanceUntil := func(resCh <-chan interface{}) interface{} {
+		for {
+			clock.Advance(time.Second)
+			select {
+			case res := <-resCh:
+				return res
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+	assert.Equal(t, "john", advanceUntil(c.GetAsync(ctx, "key1")).(*redis.StringCmd).Val())
+
+	// ttl passed since the last pipeline, but command is queued just now, so it waits for the ttl
+	clock.Advance(900 * time.Millisecond)
+	resCh := c.GetAsync(ctx, "key2")
+	clock.Advance(200 * time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	assert.Len(t, resCh, 0)
+	assert.Equal(t, "jane", advanceUntil(resCh).(*redis.StringCmd).Val())
+	assert.Equal(t, uint64(2), c.Stats().TTLFlushes)
+}
+
+func TestClockDeadlinePropagation(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key").SetVal("john")
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	clock := &fakeClock{now: want.Add(-time.Hour)}
+
+	c, err := NewAutoPipeline(db,
+		WithClock(clock),
+		WithCacheTTL(time.Second),
+		WithMaxSize(200),
+		WithDeadlinePropagation(true))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	// paused autopipeline keeps the command, while time goes
+	c.Pause()
+	c.GetAsync(ctx, "key")
+	cache := c.(*Autopipeline).cache
+	deadline := func() time.Time {
+		sh := cache.shards[0]
+		sh.mx.RLock()
+		defer sh.mx.RUnlock()
+		return cache.deadline(sh, time.Time{})
+	}
+	assert.Equal(t, want, deadline())
+	// deadline is expired by the clock of autopipeline, not by the wall clock
+	clock.Advance(2 * time.Hour)
+	assert.True(t, deadline().IsZero())
+}
@@ -0,0 +1,102 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// ErrUnsupportedCommand is returned by commands, which aren't batched, if fallback to direct execution is disabled
+var ErrUnsupportedCommand = errors.New("command isn't supported by autopipeline")
+
+// Cmdable is a subset of redis.Cmdable with the same method signatures, so code programming against it
+// may use either *redis.Client, *redis.ClusterClient or autopipeline, f.e. to enable batching without code changes
+// commands, which aren't batched (Set, HSet, Incr and TTL), are executed directly by redis client
+// if fallback is enabled with WithFallback, otherwise they fail with ErrUnsupportedCommand; Set is batched
+// if Set merging is enabled with WithSetMerging; keys of commands executed directly are prefixed same as keys
+// of queued ones, see WithKeyPrefix
+type Cmdable interface {
+	HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd
+	Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd
+	HGet(ctx context.Context, key, field string) *redis.StringCmd
+	HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd
+	Get(ctx context.Context, key string) *redis.StringCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	SMembers(ctx context.Context, key string) *redis.StringSliceCmd
+	MGet(ctx context.Context, keys ...string) *redis.SliceCmd
+	EvalRO(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd
+	FCallRO(ctx context.Context, function string, keys []string, args ...interface{}) *redis.Cmd
+	Exists(ctx context.Context, keys ...string) *redis.IntCmd
+	Time(ctx context.Context) *redis.TimeCmd
+	Echo(ctx context.Context, message interface{}) *redis.StringCmd
+	Do(ctx context.Context, args ...interface{}) *redis.Cmd
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+	HSet(ctx context.Context, key string, values ...interface{}) *redis.IntCmd
+	Incr(ctx context.Context, key string) *redis.IntCmd
+	TTL(ctx context.Context, key string) *redis.DurationCmd
+}
+
+var (
+	_ Cmdable = (*redis.Client)(nil)
+	_ Cmdable = (*redis.ClusterClient)(nil)
+	_ Cmdable = (*redis.Ring)(nil)
+	_ Cmdable = (redis.UniversalClient)(nil)
+	_ Cmdable = Autopipeline{}
+)
+
+// Set is queued if Set merging is enabled, see WithSetMerging, otherwise it's executed directly by redis client
+// if fallback is enabled, see Cmdable
+func (a Autopipeline) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	if a.cnf.mergeSets {
+		res, err := a.await(ctx, a.SetAsync(ctx, key, value, expiration))
+		if err != nil {
+			resp := redis.StatusCmd{}
+			resp.SetErr(err)
+			return &resp
+		}
+		return res.(*redis.StatusCmd)
+	}
+	if !a.cnf.fallback {
+		cmd := &redis.StatusCmd{}
+		cmd.SetErr(unsupported("Set"))
+		return cmd
+	}
+	return a.cache.client().Set(ctx, a.cnf.keyPrefix+key, value, expiration)
+}
+
+// HSet is executed directly by redis client if fallback is enabled, see Cmdable
+func (a Autopipeline) HSet(ctx context.Context, key string, values ...interface{}) *redis.IntCmd {
+	if !a.cnf.fallback {
+		cmd := &redis.IntCmd{}
+		cmd.SetErr(unsupported("HSet"))
+		return cmd
+	}
+	return a.cache.client().HSet(ctx, a.cnf.keyPrefix+key, values...)
+}
+
+// Incr is executed directly by redis client if fallback is enabled, see Cmdable
+func (a Autopipeline) Incr(ctx context.Context, key string) *redis.IntCmd {
+	if !a.cnf.fallback {
+		cmd := &redis.IntCmd{}
+		cmd.SetErr(unsupported("Incr"))
+		return cmd
+	}
+	return a.cache.client().Incr(ctx, a.cnf.keyPrefix+key)
+}
+
+// TTL is executed directly by redis client if fallback is enabled, see Cmdable
+func (a Autopipeline) TTL(ctx context.Context, key string) *redis.DurationCmd {
+	if !a.cnf.fallback {
+		cmd := &redis.DurationCmd{}
+		cmd.SetErr(unsupported("TTL"))
+		return cmd
+	}
+	return a.cache.client().TTL(ctx, a.cnf.keyPrefix+key)
+}
+
+// unsupported returns ErrUnsupportedCommand for the command
+func unsupported(command string) error {
+	return fmt.Errorf("%w: %s", ErrUnsupportedCommand, command)
+}
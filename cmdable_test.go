@@ -0,0 +1,65 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// getter is a code programming against Cmdable
+func getter(ctx context.Context, c Cmdable, key string) (string, error) {
+	return c.Get(ctx, key).Result()
+}
+
+func TestCmdable(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key1").SetVal("john")
+	mock.ExpectGet("key1").SetVal("john")
+
+	// redis client and autopipeline are interchangeable
+	val, err := getter(ctx, db, "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "john", val)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	val, err = getter(ctx, c, "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "john", val)
+}
+
+func TestFallback(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectSet("key1", "john", time.Minute).SetVal("OK")
+	mock.ExpectHSet("key2", "name", "john").SetVal(1)
+	mock.ExpectIncr("key3").SetVal(1)
+	mock.ExpectTTL("key1").SetVal(time.Minute)
+
+	tests := []struct {
+		name     string
+		fallback bool
+		wantErr  error
+	}{
+		{name: "fallback disabled", fallback: false, wantErr: ErrUnsupportedCommand},
+		{name: "fallback enabled", fallback: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewAutoPipeline(db, WithFallback(tt.fallback))
+			assert.Nil(t, err)
+			assert.Equal(t, tt.fallback, c.Config().Fallback)
+			assert.ErrorIs(t, c.Set(ctx, "key1", "john", time.Minute).Err(), tt.wantErr)
+			assert.ErrorIs(t, c.HSet(ctx, "key2", "name", "john").Err(), tt.wantErr)
+			assert.ErrorIs(t, c.Incr(ctx, "key3").Err(), tt.wantErr)
+			assert.ErrorIs(t, c.TTL(ctx, "key1").Err(), tt.wantErr)
+		})
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
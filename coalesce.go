@@ -0,0 +1,254 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+)
+
+// getBatch is a set of Get commands of single pipeline, which are executed as a single MGet command,
+// or one MGet per hash slot if cross slot splitting is enabled
+type getBatch struct {
+	hashes []string          // hashes of Get operations
+	keys   []string          // keys of Get operations, in the same order
+	groups [][]int           // indexes of keys, one group for every MGet command
+	mgets  []*redis.SliceCmd // MGet commands, one for each group
+}
+
+func (b *getBatch) add(hash, key string) {
+	b.hashes = append(b.hashes, hash)
+	b.keys = append(b.keys, key)
+}
+
+// build adds commands of the batch to the pipeline and returns their number, nil groups means all keys
+// are fetched with the single MGet; a key, which is alone in its group, is fetched with Get as usual
+func (b *getBatch) build(ctx context.Context, pipe redis.Pipeliner, groups [][]int, stringCmds map[string]*redis.StringCmd) int {
+	if groups == nil {
+		all := make([]int, len(b.keys))
+		for i := range all {
+			all[i] = i
+		}
+		groups = [][]int{all}
+	}
+	for _, g := range groups {
+		if len(g) == 1 {
+			stringCmds[b.hashes[g[0]]] = pipe.Get(ctx, b.keys[g[0]])
+			continue
+		}
+		b.groups = append(b.groups, g)
+		b.mgets = append(b.mgets, pipe.MGet(ctx, pickKeys(b.keys, g)...))
+	}
+	return len(groups)
+}
+
+// results sends results of MGet commands to listeners as individual Get commands,
+// missing keys fail with redis.Nil, same as Get does
+func (b *getBatch) results(ctx context.Context, send func(hash string, cmd redis.Cmder)) {
+	for i, g := range b.groups {
+		mget := b.mgets[i]
+		for j, idx := range g {
+			cmd := redis.NewStringCmd(ctx, "get", b.keys[idx])
+			if err := mget.Err(); err != nil {
+				cmd.SetErr(err)
+				send(b.hashes[idx], cmd)
+				continue
+			}
+			switch v := mget.Val()[j].(type) {
+			case nil:
+				cmd.SetErr(redis.Nil)
+			case string:
+				cmd.SetVal(v)
+			default:
+				cmd.SetVal(fmt.Sprint(v))
+			}
+			send(b.hashes[idx], cmd)
+		}
+	}
+}
+
+// delBatch is a set of Del commands of single pipeline, which are executed as a single Del command
+// with the union of their keys, or one Del per hash slot if cross slot splitting is enabled
+type delBatch struct {
+	hashes []string      // hashes of Del operations
+	keys   [][]string    // keys of Del operations, in the same order
+	cmd    *redis.IntCmd // merged command, nil if it's split by hash slot
+	split  *crossSlotDel // merged command split by hash slot
+}
+
+func (b *delBatch) add(hash string, keys []string) {
+	b.hashes = append(b.hashes, hash)
+	b.keys = append(b.keys, keys)
+}
+
+// build adds merged command of the batch to the pipeline and returns the number of commands added,
+// a key of several Del commands is deleted once
+func (b *delBatch) build(ctx context.Context, pipe redis.Pipeliner, slotGroups func(keys []string) [][]int) int {
+	var union []string
+	seen := make(map[string]struct{})
+	for _, keys := range b.keys {
+		for _, key := range keys {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				union = append(union, key)
+			}
+		}
+	}
+	if groups := slotGroups(union); len(groups) > 1 {
+		b.split = newCrossSlotDel(ctx, pipe, union, groups)
+		return len(groups)
+	}
+	b.cmd = pipe.Del(ctx, union...)
+	return 1
+}
+
+// results sends result of merged command to listeners of every Del command, number of deleted keys
+// is the total one of the merged command, as redis doesn't report which keys were deleted
+func (b *delBatch) results(ctx context.Context, send func(hash string, cmd redis.Cmder)) {
+	merged := b.cmd
+	if b.split != nil {
+		merged = b.split.merge(ctx)
+	}
+	for i, hash := range b.hashes {
+		args := make([]interface{}, len(b.keys[i])+1)
+		args[0] = "del"
+		for j, key := range b.keys[i] {
+			args[j+1] = key
+		}
+		cmd := redis.NewIntCmd(ctx, args...)
+		if err := merged.Err(); err != nil {
+			cmd.SetErr(err)
+		} else {
+			cmd.SetVal(merged.Val())
+		}
+		send(hash, cmd)
+	}
+}
+
+// setBatch is a set of Set commands without expiration of single pipeline, which are executed as a single MSet
+// command, or one MSet per hash slot if cross slot splitting is enabled
+type setBatch struct {
+	hashes []string           // hashes of Set operations
+	keys   []string           // keys of Set operations, in the same order
+	values []string           // values of Set operations, in the same order
+	seqs   []uint64           // numbers of the latest calls of Set operations, in the same order
+	groups [][]int            // indexes of keys, one group for every command
+	cmds   []*redis.StatusCmd // MSet or Set commands, one for each group
+}
+
+func (b *setBatch) add(hash, key, value string, seq uint64) {
+	b.hashes = append(b.hashes, hash)
+	b.keys = append(b.keys, key)
+	b.values = append(b.values, value)
+	b.seqs = append(b.seqs, seq)
+}
+
+// build adds commands of the batch to the pipeline and returns their number, nil groups means all keys
+// are written with the single MSet; a key set by several operations gets the value of the latest call,
+// a group of a single key is written with Set
+func (b *setBatch) build(ctx context.Context, pipe redis.Pipeliner, groups [][]int) int {
+	if groups == nil {
+		all := make([]int, len(b.keys))
+		for i := range all {
+			all[i] = i
+		}
+		groups = [][]int{all}
+	}
+	for _, g := range groups {
+		latest := make(map[string]int, len(g)) // index of the latest operation by key
+		var order []string                     // keys of the group, in the order they are met
+		for _, idx := range g {
+			prev, ok := latest[b.keys[idx]]
+			if !ok {
+				order = append(order, b.keys[idx])
+			}
+			if !ok || b.seqs[idx] > b.seqs[prev] {
+				latest[b.keys[idx]] = idx
+			}
+		}
+		b.groups = append(b.groups, g)
+		if len(order) == 1 {
+			idx := latest[order[0]]
+			b.cmds = append(b.cmds, pipe.Set(ctx, b.keys[idx], b.values[idx], 0))
+			continue
+		}
+		pairs := make([]interface{}, 0, 2*len(order))
+		for _, key := range order {
+			pairs = append(pairs, key, b.values[latest[key]])
+		}
+		b.cmds = append(b.cmds, pipe.MSet(ctx, pairs...))
+	}
+	return len(groups)
+}
+
+// results sends results of MSet commands to listeners as individual Set commands
+func (b *setBatch) results(ctx context.Context, send func(hash string, cmd redis.Cmder)) {
+	for i, g := range b.groups {
+		merged := b.cmds[i]
+		for _, idx := range g {
+			cmd := redis.NewStatusCmd(ctx, "set", b.keys[idx], b.values[idx])
+			if err := merged.Err(); err != nil {
+				cmd.SetErr(err)
+			} else {
+				cmd.SetVal(merged.Val())
+			}
+			send(b.hashes[idx], cmd)
+		}
+	}
+}
+
+// hashReads is a set of HGet commands of single flush, which are served by HGetAll commands of the same key
+// queued for the flush, so the field isn't fetched twice
+type hashReads struct {
+	all    map[string]string // hashes of HGetAll operations by key
+	gets   []hashRead        // HGet operations
+	merged []hashRead        // HGet operations served by HGetAll
+}
+
+// hashRead is a HGet operation of the flush
+type hashRead struct {
+	hash  string
+	p     hgetPayload
+	chunk int // index of pipeline, which the operation was gathered to
+}
+
+func (r *hashReads) addGet(hash string, p hgetPayload, chunk int) {
+	r.gets = append(r.gets, hashRead{hash: hash, p: p, chunk: chunk})
+}
+
+func (r *hashReads) addAll(hash, key string) {
+	if r.all == nil {
+		r.all = make(map[string]string)
+	}
+	r.all[key] = hash
+}
+
+// build adds HGet commands, which are not served by HGetAll, to their pipelines, served ones are moved
+// to the pipeline of HGetAll, so they fail together, sizes of pipelines are updated
+func (r *hashReads) build(ctx context.Context, pipes []redis.Pipeliner, sizes []int, chunks map[string]int, stringCmds map[string]*redis.StringCmd) {
+	for _, g := range r.gets {
+		if allHash, ok := r.all[g.p.key]; ok {
+			sizes[g.chunk]--
+			chunks[g.hash] = chunks[allHash]
+			r.merged = append(r.merged, g)
+			continue
+		}
+		stringCmds[g.hash] = pipes[g.chunk].HGet(ctx, g.p.key, g.p.field)
+	}
+}
+
+// results sends fields of HGetAll results to listeners of served HGet commands,
+// missing fields fail with redis.Nil, same as HGet does
+func (r *hashReads) results(ctx context.Context, all map[string]*redis.MapStringStringCmd, send func(hash string, cmd redis.Cmder)) {
+	for _, g := range r.merged {
+		src := all[r.all[g.p.key]]
+		cmd := redis.NewStringCmd(ctx, "hget", g.p.key, g.p.field)
+		if err := src.Err(); err != nil {
+			cmd.SetErr(err)
+		} else if v, ok := src.Val()[g.p.field]; ok {
+			cmd.SetVal(v)
+		} else {
+			cmd.SetErr(redis.Nil)
+		}
+		send(g.hash, cmd)
+	}
+}
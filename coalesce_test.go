@@ -0,0 +1,534 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mgetHook serves Get and MGet commands with keys as values and Del commands with number of keys,
+// keys with "missing" prefix don't exist, names of executed commands and values written by Set and MSet
+// are recorded
+type mgetHook struct {
+	mx     sync.Mutex
+	names  []string
+	values map[string]string
+}
+
+func (h *mgetHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *mgetHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (h *mgetHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.mx.Lock()
+		defer h.mx.Unlock()
+		for _, cmd := range cmds {
+			h.names = append(h.names, cmd.Name())
+			switch cmd := cmd.(type) {
+			case *redis.StringCmd:
+				key := fmt.Sprint(cmd.Args()[1])
+				if strings.HasPrefix(key, "missing") {
+					cmd.SetErr(redis.Nil)
+				} else {
+					cmd.SetVal(key)
+				}
+			case *redis.SliceCmd:
+				vals := make([]interface{}, len(cmd.Args())-1)
+				for i, arg := range cmd.Args()[1:] {
+					if key := fmt.Sprint(arg); !strings.HasPrefix(key, "missing") {
+						vals[i] = key
+					}
+				}
+				cmd.SetVal(vals)
+			case *redis.IntCmd:
+				var deleted int64
+				for _, arg := range cmd.Args()[1:] {
+					if !strings.HasPrefix(fmt.Sprint(arg), "missing") {
+						deleted++
+					}
+				}
+				cmd.SetVal(deleted)
+			case *redis.StatusCmd:
+				if h.values == nil {
+					h.values = make(map[string]string)
+				}
+				// MSet has key and value pairs, Set has a key and a value followed by expiration
+				args := cmd.Args()[1:]
+				if cmd.Name() == "set" {
+					args = args[:2]
+				}
+				for i := 0; i < len(args); i += 2 {
+					h.values[fmt.Sprint(args[i])] = fmt.Sprint(args[i+1])
+				}
+				cmd.SetVal("OK")
+			}
+		}
+		return nil
+	}
+}
+
+func (h *mgetHook) commands() []string {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	return h.names
+}
+
+func TestGetCoalescing(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   []func(a *Autopipeline)
+		keys      []string
+		wantNames []string
+	}{
+		{
+			name:      "disabled",
+			keys:      []string{"key1", "key2"},
+			wantNames: []string{"get", "get"},
+		},
+		{
+			name:      "single get",
+			options:   []func(a *Autopipeline){WithGetCoalescing(true)},
+			keys:      []string{"key1"},
+			wantNames: []string{"get"},
+		},
+		{
+			name:      "distinct gets",
+			options:   []func(a *Autopipeline){WithGetCoalescing(true)},
+			keys:      []string{"key1", "missing1", "key2", "key1"},
+			wantNames: []string{"mget"},
+		},
+		{
+			name:      "gets in different hash slots",
+			options:   []func(a *Autopipeline){WithGetCoalescing(true), WithCrossSlotSplitting(true)},
+			keys:      []string{"{a}key1", "{b}key1", "{a}key2"},
+			wantNames: []string{"get", "mget"},
+		},
+		{
+			name:      "prefixed keys",
+			options:   []func(a *Autopipeline){WithGetCoalescing(true), WithKeyPrefix("tenant1:")},
+			keys:      []string{"key1", "key2"},
+			wantNames: []string{"mget"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctx = context.TODO()
+			hook := &mgetHook{}
+			db := redis.NewClient(&redis.Options{})
+			db.AddHook(hook)
+			c, err := NewAutoPipeline(db, append([]func(a *Autopipeline){WithCacheTTL(time.Microsecond * 100)}, tt.options...)...)
+			assert.Nil(t, err)
+			defer c.Stop()
+
+			c.Pause()
+			chs := make([]<-chan interface{}, len(tt.keys))
+			for i, key := range tt.keys {
+				chs[i] = c.GetAsync(ctx, key)
+			}
+			c.Resume()
+
+			prefix := c.Config().KeyPrefix
+			for i, key := range tt.keys {
+				cmd := (<-chs[i]).(*redis.StringCmd)
+				assert.Equal(t, []interface{}{"get", prefix + key}, cmd.Args())
+				if strings.HasPrefix(key, "missing") {
+					assert.ErrorIs(t, cmd.Err(), redis.Nil)
+					continue
+				}
+				assert.Nil(t, cmd.Err())
+				assert.Equal(t, prefix+key, cmd.Val())
+			}
+			assert.ElementsMatch(t, tt.wantNames, hook.commands())
+			assert.Equal(t, uint64(len(tt.wantNames)), c.Stats().Commands)
+		})
+	}
+}
+
+func TestGetBatchResultsError(t *testing.T) {
+	ctx := context.TODO()
+	b := &getBatch{}
+	b.add("hash1", "key1")
+	b.add("hash2", "key2")
+	b.groups = [][]int{{0, 1}}
+	mget := redis.NewSliceCmd(ctx, "mget", "key1", "key2")
+	mget.SetErr(redis.ErrClosed)
+	b.mgets = []*redis.SliceCmd{mget}
+
+	results := map[string]redis.Cmder{}
+	b.results(ctx, func(hash string, cmd redis.Cmder) {
+		results[hash] = cmd
+	})
+	assert.Len(t, results, 2)
+	for _, cmd := range results {
+		assert.ErrorIs(t, cmd.Err(), redis.ErrClosed)
+	}
+}
+
+func TestDelMerging(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     []func(a *Autopipeline)
+		keys        [][]string
+		wantNames   []string
+		wantDeleted []int64
+	}{
+		{
+			name:        "disabled",
+			keys:        [][]string{{"key1"}, {"key2", "key3"}},
+			wantNames:   []string{"del", "del"},
+			wantDeleted: []int64{1, 2},
+		},
+		{
+			name:        "single del",
+			options:     []func(a *Autopipeline){WithDelMerging(true)},
+			keys:        [][]string{{"key1", "missing1"}},
+			wantNames:   []string{"del"},
+			wantDeleted: []int64{1},
+		},
+		{
+			name:        "overlapping keys",
+			options:     []func(a *Autopipeline){WithDelMerging(true)},
+			keys:        [][]string{{"key1"}, {"key2", "key1"}, {"missing1"}},
+			wantNames:   []string{"del"},
+			wantDeleted: []int64{2, 2, 2},
+		},
+		{
+			name:        "keys in different hash slots",
+			options:     []func(a *Autopipeline){WithDelMerging(true), WithCrossSlotSplitting(true)},
+			keys:        [][]string{{"{a}key1"}, {"{b}key1", "{a}key2"}},
+			wantNames:   []string{"del", "del"},
+			wantDeleted: []int64{3, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctx = context.TODO()
+			hook := &mgetHook{}
+			db := redis.NewClient(&redis.Options{})
+			db.AddHook(hook)
+			c, err := NewAutoPipeline(db, append([]func(a *Autopipeline){WithCacheTTL(time.Microsecond * 100)}, tt.options...)...)
+			assert.Nil(t, err)
+			defer c.Stop()
+
+			c.Pause()
+			chs := make([]<-chan interface{}, len(tt.keys))
+			for i, keys := range tt.keys {
+				chs[i] = c.DelAsync(ctx, keys...)
+			}
+			c.Resume()
+
+			for i, keys := range tt.keys {
+				cmd := (<-chs[i]).(*redis.IntCmd)
+				assert.Nil(t, cmd.Err())
+				assert.Equal(t, len(keys)+1, len(cmd.Args()))
+				assert.Equal(t, tt.wantDeleted[i], cmd.Val())
+			}
+			assert.ElementsMatch(t, tt.wantNames, hook.commands())
+			assert.Equal(t, uint64(len(tt.wantNames)), c.Stats().Commands)
+		})
+	}
+}
+
+func TestSetMerging(t *testing.T) {
+	type set struct {
+		key        string
+		value      string
+		expiration time.Duration
+	}
+	tests := []struct {
+		name       string
+		options    []func(a *Autopipeline)
+		sets       []set
+		wantNames  []string
+		wantValues map[string]string
+	}{
+		{
+			name:       "disabled",
+			sets:       []set{{key: "key1", value: "john"}, {key: "key2", value: "jane"}},
+			wantNames:  []string{"set", "set"},
+			wantValues: map[string]string{"key1": "john", "key2": "jane"},
+		},
+		{
+			name:       "single set",
+			options:    []func(a *Autopipeline){WithSetMerging(true)},
+			sets:       []set{{key: "key1", value: "john"}},
+			wantNames:  []string{"set"},
+			wantValues: map[string]string{"key1": "john"},
+		},
+		{
+			name:       "plain sets",
+			options:    []func(a *Autopipeline){WithSetMerging(true)},
+			sets:       []set{{key: "key1", value: "john"}, {key: "key2", value: "jane"}, {key: "key3", value: "john"}},
+			wantNames:  []string{"mset"},
+			wantValues: map[string]string{"key1": "john", "key2": "jane", "key3": "john"},
+		},
+		{
+			name:    "sets with expiration",
+			options: []func(a *Autopipeline){WithSetMerging(true)},
+			sets: []set{
+				{key: "key1", value: "john"},
+				{key: "key2", value: "jane"},
+				{key: "key3", value: "john", expiration: time.Minute},
+				{key: "key4", value: "jane", expiration: redis.KeepTTL},
+			},
+			wantNames:  []string{"mset", "set", "set"},
+			wantValues: map[string]string{"key1": "john", "key2": "jane", "key3": "john", "key4": "jane"},
+		},
+		{
+			name:       "keys in different hash slots",
+			options:    []func(a *Autopipeline){WithSetMerging(true), WithCrossSlotSplitting(true)},
+			sets:       []set{{key: "{a}key1", value: "john"}, {key: "{b}key1", value: "jane"}, {key: "{a}key2", value: "john"}},
+			wantNames:  []string{"mset", "set"},
+			wantValues: map[string]string{"{a}key1": "john", "{b}key1": "jane", "{a}key2": "john"},
+		},
+		{
+			name:       "latest set of the key wins",
+			options:    []func(a *Autopipeline){WithSetMerging(true)},
+			sets:       []set{{key: "key1", value: "john"}, {key: "key2", value: "john"}, {key: "key1", value: "jane"}},
+			wantNames:  []string{"mset"},
+			wantValues: map[string]string{"key1": "jane", "key2": "john"},
+		},
+		{
+			name:    "identical set joined later wins",
+			options: []func(a *Autopipeline){WithSetMerging(true)},
+			sets: []set{
+				{key: "key1", value: "john"},
+				{key: "key1", value: "jane"},
+				{key: "key2", value: "jane"},
+				{key: "key1", value: "john"},
+			},
+			wantNames:  []string{"mset"},
+			wantValues: map[string]string{"key1": "john", "key2": "jane"},
+		},
+		{
+			name:       "sets of a single key",
+			options:    []func(a *Autopipeline){WithSetMerging(true)},
+			sets:       []set{{key: "key1", value: "john"}, {key: "key1", value: "jane"}},
+			wantNames:  []string{"set"},
+			wantValues: map[string]string{"key1": "jane"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctx = context.TODO()
+			hook := &mgetHook{}
+			db := redis.NewClient(&redis.Options{})
+			db.AddHook(hook)
+			c, err := NewAutoPipeline(db, append([]func(a *Autopipeline){WithCacheTTL(time.Microsecond * 100)}, tt.options...)...)
+			assert.Nil(t, err)
+			defer c.Stop()
+
+			c.Pause()
+			chs := make([]<-chan interface{}, len(tt.sets))
+			for i, s := range tt.sets {
+				chs[i] = c.SetAsync(ctx, s.key, s.value, s.expiration)
+			}
+			c.Resume()
+
+			for i, s := range tt.sets {
+				cmd := (<-chs[i]).(*redis.StatusCmd)
+				assert.Nil(t, cmd.Err())
+				assert.Equal(t, "OK", cmd.Val())
+				assert.Equal(t, []interface{}{"set", s.key, s.value}, cmd.Args()[:3])
+			}
+			assert.ElementsMatch(t, tt.wantNames, hook.commands())
+			assert.Equal(t, tt.wantValues, hook.values)
+			assert.Equal(t, uint64(len(tt.wantNames)), c.Stats().Commands)
+		})
+	}
+}
+
+func TestSetMergingCmdable(t *testing.T) {
+	var ctx = context.TODO()
+	hook := &mgetHook{}
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(hook)
+	c, err := NewAutoPipeline(db, WithCacheTTL(time.Microsecond*100), WithSetMerging(true))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	// Set of Cmdable is queued without fallback
+	assert.Equal(t, "OK", c.Set(ctx, "key1", "john", 0).Val())
+	assert.Equal(t, []string{"set"}, hook.commands())
+}
+
+// gatedSetHook holds the first pipeline until it's released
+type gatedSetHook struct {
+	mgetHook
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *gatedSetHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	process := h.mgetHook.ProcessPipelineHook(next)
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.once.Do(func() {
+			h.started <- struct{}{}
+			<-h.release
+		})
+		return process(ctx, cmds)
+	}
+}
+
+func TestSetMergingInFlight(t *testing.T) {
+	var ctx = context.TODO()
+	hook := &gatedSetHook{started: make(chan struct{}, 1), release: make(chan struct{})}
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(hook)
+	c, err := NewAutoPipeline(db, WithCacheTTL(time.Microsecond*100), WithSetMerging(true))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	cache := c.(*Autopipeline).cache
+	chs := []chan interface{}{cache.enqueue(ctx, Set, transformSet("key1", "john", 0))}
+	<-hook.started
+	// identical Set doesn't join the one in flight, which would be overwritten by Set queued before it
+	chs = append(chs,
+		cache.enqueue(ctx, Set, transformSet("key1", "jane", 0)),
+		cache.enqueue(ctx, Set, transformSet("key1", "john", 0)),
+	)
+	close(hook.release)
+
+	for _, ch := range chs {
+		assert.Equal(t, "OK", (<-ch).(*redis.StatusCmd).Val())
+	}
+	assert.Equal(t, []string{"set", "set"}, hook.commands())
+	assert.Equal(t, map[string]string{"key1": "john"}, hook.values)
+}
+
+func TestSetQueued(t *testing.T) {
+	var ctx = context.TODO()
+	hook := &mgetHook{}
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(hook)
+	c, err := NewAutoPipeline(db, WithCacheTTL(time.Microsecond*100))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	// Set is queued even if merging is disabled
+	res := <-c.SetAsync(ctx, "key1", "john", 0)
+	assert.Equal(t, "OK", res.(*redis.StatusCmd).Val())
+	cmd, err := c.SetFuture(ctx, "key2", "jane", 0).Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", cmd.(*redis.StatusCmd).Val())
+	v, err := c.AwaitOnly().Set(ctx, "key3", "john", time.Minute).Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", v.(*redis.StatusCmd).Val())
+	ch, err := c.Checked().SetAsync(ctx, "key4", "jane", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", (<-ch).(*redis.StatusCmd).Val())
+	assert.Equal(t, "OK", (<-c.Typed().SetAsync(ctx, "key5", "john", 0)).Val())
+	cmd, err = c.NewBatch().Set("key6", "jane", 0).Submit(ctx)[0].Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", cmd.(*redis.StatusCmd).Val())
+	assert.Equal(t, []string{"set", "set", "set", "set", "set", "set"}, hook.commands())
+	assert.Equal(t, map[string]string{"key1": "john", "key2": "jane", "key3": "john", "key4": "jane", "key5": "john", "key6": "jane"}, hook.values)
+}
+
+func TestSetBatchResultsError(t *testing.T) {
+	ctx := context.TODO()
+	b := &setBatch{}
+	b.add("hash1", "key1", "john", 1)
+	b.add("hash2", "key2", "jane", 2)
+	b.groups = [][]int{{0, 1}}
+	mset := redis.NewStatusCmd(ctx, "mset", "key1", "john", "key2", "jane")
+	mset.SetErr(redis.ErrClosed)
+	b.cmds = []*redis.StatusCmd{mset}
+
+	results := map[string]redis.Cmder{}
+	b.results(ctx, func(hash string, cmd redis.Cmder) {
+		results[hash] = cmd
+	})
+	assert.Len(t, results, 2)
+	for _, cmd := range results {
+		assert.ErrorIs(t, cmd.Err(), redis.ErrClosed)
+	}
+}
+
+func TestHashReadMerging(t *testing.T) {
+	tests := []struct {
+		name         string
+		enabled      bool
+		wantCommands uint64
+	}{
+		{
+			name:         "disabled",
+			wantCommands: 4,
+		},
+		{
+			name:         "enabled",
+			enabled:      true,
+			wantCommands: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctx = context.TODO()
+			db, mock := redismock.NewClientMock()
+			mock.MatchExpectationsInOrder(false)
+			mock.ExpectHGetAll("user1").SetVal(map[string]string{"name": "john", "age": "42"})
+			mock.ExpectHGet("user2", "name").SetVal("mike")
+			if !tt.enabled {
+				mock.ExpectHGet("user1", "name").SetVal("john")
+				mock.ExpectHGet("user1", "age").SetVal("42")
+			}
+			c, err := NewAutoPipeline(db, WithCacheTTL(time.Microsecond*100), WithHashReadMerging(tt.enabled))
+			assert.Nil(t, err)
+			defer c.Stop()
+			assert.Equal(t, tt.enabled, c.Config().HashReadMerging)
+
+			c.Pause()
+			allCh := c.HGetAllAsync(ctx, "user1")
+			nameCh := c.HGetAsync(ctx, "user1", "name")
+			ageCh := c.HGetAsync(ctx, "user1", "age")
+			otherCh := c.HGetAsync(ctx, "user2", "name")
+			c.Resume()
+
+			assert.Equal(t, map[string]string{"name": "john", "age": "42"}, (<-allCh).(*redis.MapStringStringCmd).Val())
+			name := (<-nameCh).(*redis.StringCmd)
+			assert.Nil(t, name.Err())
+			assert.Equal(t, "john", name.Val())
+			assert.Equal(t, []interface{}{"hget", "user1", "name"}, name.Args())
+			assert.Equal(t, "42", (<-ageCh).(*redis.StringCmd).Val())
+			assert.Equal(t, "mike", (<-otherCh).(*redis.StringCmd).Val())
+			assert.Equal(t, tt.wantCommands, c.Stats().Commands)
+			assert.Nil(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestHashReadsResults(t *testing.T) {
+	ctx := context.TODO()
+	r := &hashReads{}
+	r.addAll("all1", "user1")
+	r.addAll("all2", "user2")
+	r.merged = []hashRead{
+		{hash: "name", p: hgetPayload{key: "user1", field: "name"}},
+		{hash: "email", p: hgetPayload{key: "user1", field: "email"}},
+		{hash: "failed", p: hgetPayload{key: "user2", field: "name"}},
+	}
+	all1 := redis.NewMapStringStringCmd(ctx, "hgetall", "user1")
+	all1.SetVal(map[string]string{"name": "john"})
+	all2 := redis.NewMapStringStringCmd(ctx, "hgetall", "user2")
+	all2.SetErr(redis.ErrClosed)
+
+	results := map[string]redis.Cmder{}
+	r.results(ctx, map[string]*redis.MapStringStringCmd{"all1": all1, "all2": all2}, func(hash string, cmd redis.Cmder) {
+		results[hash] = cmd
+	})
+	assert.Equal(t, "john", results["name"].(*redis.StringCmd).Val())
+	assert.ErrorIs(t, results["email"].Err(), redis.Nil)
+	assert.ErrorIs(t, results["failed"].Err(), redis.ErrClosed)
+}
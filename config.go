@@ -0,0 +1,392 @@
+package redis_autopipeline
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is a plain configuration of autopipeline, which may be unmarshalled from json or yaml,
+// use DefaultConfig to get a config with default values and override the needed ones
+type Config struct {
+	TTL                    Duration        `json:"ttl" yaml:"ttl"`                                             // see WithCacheTTL
+	MaxSize                uint            `json:"max_size" yaml:"max_size"`                                   // see WithMaxSize
+	RunInterval            Duration        `json:"run_interval" yaml:"run_interval"`                           // see WithRunInterval
+	OperationTTL           Duration        `json:"operation_ttl" yaml:"operation_ttl"`                         // see WithOperationTTL
+	CommandTimeout         Duration        `json:"command_timeout" yaml:"command_timeout"`                     // see WithCommandTimeout
+	PauseLimit             uint            `json:"pause_limit" yaml:"pause_limit"`                             // see WithPauseLimit
+	CrossSlotSplitting     bool            `json:"cross_slot_splitting" yaml:"cross_slot_splitting"`           // see WithCrossSlotSplitting
+	DuplicatePolicy        DuplicatePolicy `json:"duplicate_policy" yaml:"duplicate_policy"`                   // see WithDuplicatePolicy
+	OrderedDelivery        bool            `json:"ordered_delivery" yaml:"ordered_delivery"`                   // see WithOrderedDelivery
+	DeadlinePropagation    bool            `json:"deadline_propagation" yaml:"deadline_propagation"`           // see WithDeadlinePropagation
+	Fallback               bool            `json:"fallback" yaml:"fallback"`                                   // see WithFallback
+	KeyPrefix              string          `json:"key_prefix" yaml:"key_prefix"`                               // see WithKeyPrefix
+	AdaptiveTTL            float64         `json:"adaptive_ttl" yaml:"adaptive_ttl"`                           // see WithAdaptiveTTL
+	MaxDelay               Duration        `json:"max_delay" yaml:"max_delay"`                                 // see WithMaxDelay
+	SoloBypass             Duration        `json:"solo_bypass" yaml:"solo_bypass"`                             // see WithSoloBypass
+	MaxCommandsPerPipeline uint            `json:"max_commands_per_pipeline" yaml:"max_commands_per_pipeline"` // see WithMaxCommandsPerPipeline
+	MaxBatchBytes          uint            `json:"max_batch_bytes" yaml:"max_batch_bytes"`                     // see WithMaxBatchBytes
+	FlushJitter            float64         `json:"flush_jitter" yaml:"flush_jitter"`                           // see WithFlushJitter
+	StorageShards          uint            `json:"storage_shards" yaml:"storage_shards"`                       // see WithStorageShards
+	LockFreeEnqueue        bool            `json:"lock_free_enqueue" yaml:"lock_free_enqueue"`                 // see WithLockFreeEnqueue
+	Workers                uint            `json:"workers" yaml:"workers"`                                     // see WithWorkers
+	PipelineExecTimeout    Duration        `json:"pipeline_exec_timeout" yaml:"pipeline_exec_timeout"`         // see WithPipelineExecTimeout
+	DeliveryWorkers        uint            `json:"delivery_workers" yaml:"delivery_workers"`                   // see WithDeliveryWorkers
+	DeliveryTimeout        Duration        `json:"delivery_timeout" yaml:"delivery_timeout"`                   // see WithDeliveryTimeout
+	DropPolicy             DropPolicy      `json:"drop_policy" yaml:"drop_policy"`                             // see WithDropPolicy
+	GetCoalescing          bool            `json:"get_coalescing" yaml:"get_coalescing"`                       // see WithGetCoalescing
+	DelMerging             bool            `json:"del_merging" yaml:"del_merging"`                             // see WithDelMerging
+	SetMerging             bool            `json:"set_merging" yaml:"set_merging"`                             // see WithSetMerging
+	HashReadMerging        bool            `json:"hash_read_merging" yaml:"hash_read_merging"`                 // see WithHashReadMerging
+	NoDedup                []string        `json:"no_dedup" yaml:"no_dedup"`                                   // see WithoutDedup
+	DedupWindow            Duration        `json:"dedup_window" yaml:"dedup_window"`                           // see WithDedupWindow
+	WriteLaneTTL           Duration        `json:"write_lane_ttl" yaml:"write_lane_ttl"`                       // see WithWriteLane
+	WriteLaneMaxSize       uint            `json:"write_lane_max_size" yaml:"write_lane_max_size"`             // see WithWriteLane
+	MaxPending             uint            `json:"max_pending" yaml:"max_pending"`                             // see WithMaxPending
+	OverflowPolicy         OverflowPolicy  `json:"overflow_policy" yaml:"overflow_policy"`                     // see WithOverflowPolicy
+	ReadRetries            uint            `json:"read_retries" yaml:"read_retries"`                           // see WithReadRetries
+	AutoTuneLatency        Duration        `json:"auto_tune_latency" yaml:"auto_tune_latency"`                 // see AutoTuning.TargetLatency
+	AutoTuneRate           float64         `json:"auto_tune_rate" yaml:"auto_tune_rate"`                       // see AutoTuning.TargetRate
+	AutoTuneMinTTL         Duration        `json:"auto_tune_min_ttl" yaml:"auto_tune_min_ttl"`                 // see AutoTuning.MinTTL
+	AutoTuneMaxTTL         Duration        `json:"auto_tune_max_ttl" yaml:"auto_tune_max_ttl"`                 // see AutoTuning.MaxTTL
+	AutoTuneMinSize        uint            `json:"auto_tune_min_size" yaml:"auto_tune_min_size"`               // see AutoTuning.MinSize
+	AutoTuneMaxSize        uint            `json:"auto_tune_max_size" yaml:"auto_tune_max_size"`               // see AutoTuning.MaxSize
+	AutoTuneInterval       Duration        `json:"auto_tune_interval" yaml:"auto_tune_interval"`               // see AutoTuning.Interval
+	FailoverPause          Duration        `json:"failover_pause" yaml:"failover_pause"`                       // see WithFailoverPause
+	NodeBatching           bool            `json:"node_batching" yaml:"node_batching"`                         // see WithNodeBatching
+	ReplicaReads           bool            `json:"replica_reads" yaml:"replica_reads"`                         // see WithReplicaReads
+	Transactional          bool            `json:"transactional" yaml:"transactional"`                         // see WithTransactional
+	Expvar                 string          `json:"expvar" yaml:"expvar"`                                       // see WithExpvar
+	LogLevel               LogLevel        `json:"log_level" yaml:"log_level"`                                 // see WithLogLevel
+}
+
+// DefaultConfig returns a config with default values
+func DefaultConfig() Config {
+	return Config{
+		TTL:              Duration(defaultCacheTTL),
+		MaxSize:          defaultCacheSize,
+		WriteLaneMaxSize: defaultCacheSize,
+		RunInterval:      Duration(defaultRunInterval),
+		PauseLimit:       defaultPauseLimit,
+		DuplicatePolicy:  DuplicateCoalesce,
+		DropPolicy:       DropCount,
+		OverflowPolicy:   OverflowBlock,
+		StorageShards:    defaultStorageShards,
+		Workers:          defaultWorkers,
+	}
+}
+
+// ConfigFromEnv returns default config overridden by environment variables with the given prefix, f.e.
+// for prefix "AUTOPIPELINE" variables are AUTOPIPELINE_TTL, AUTOPIPELINE_MAX_SIZE, AUTOPIPELINE_RUN_INTERVAL,
+// AUTOPIPELINE_OPERATION_TTL, AUTOPIPELINE_COMMAND_TIMEOUT, AUTOPIPELINE_PAUSE_LIMIT, AUTOPIPELINE_CROSS_SLOT_SPLITTING,
+// AUTOPIPELINE_DUPLICATE_POLICY, AUTOPIPELINE_ORDERED_DELIVERY, AUTOPIPELINE_DEADLINE_PROPAGATION,
+// AUTOPIPELINE_FALLBACK, AUTOPIPELINE_KEY_PREFIX, AUTOPIPELINE_ADAPTIVE_TTL, AUTOPIPELINE_MAX_DELAY,
+// AUTOPIPELINE_SOLO_BYPASS, AUTOPIPELINE_MAX_COMMANDS_PER_PIPELINE, AUTOPIPELINE_MAX_BATCH_BYTES
+// AUTOPIPELINE_FLUSH_JITTER, AUTOPIPELINE_STORAGE_SHARDS, AUTOPIPELINE_LOCK_FREE_ENQUEUE, AUTOPIPELINE_WORKERS,
+// AUTOPIPELINE_PIPELINE_EXEC_TIMEOUT, AUTOPIPELINE_DELIVERY_WORKERS, AUTOPIPELINE_DELIVERY_TIMEOUT,
+// AUTOPIPELINE_DROP_POLICY, AUTOPIPELINE_GET_COALESCING, AUTOPIPELINE_DEL_MERGING, AUTOPIPELINE_SET_MERGING
+// AUTOPIPELINE_HASH_READ_MERGING, AUTOPIPELINE_NO_DEDUP, AUTOPIPELINE_DEDUP_WINDOW, AUTOPIPELINE_WRITE_LANE_TTL,
+// AUTOPIPELINE_WRITE_LANE_MAX_SIZE, AUTOPIPELINE_MAX_PENDING, AUTOPIPELINE_OVERFLOW_POLICY,
+// AUTOPIPELINE_READ_RETRIES, AUTOPIPELINE_AUTO_TUNE_LATENCY, AUTOPIPELINE_AUTO_TUNE_RATE,
+// AUTOPIPELINE_AUTO_TUNE_MIN_TTL, AUTOPIPELINE_AUTO_TUNE_MAX_TTL, AUTOPIPELINE_AUTO_TUNE_MIN_SIZE,
+// AUTOPIPELINE_AUTO_TUNE_MAX_SIZE, AUTOPIPELINE_AUTO_TUNE_INTERVAL, AUTOPIPELINE_FAILOVER_PAUSE,
+// AUTOPIPELINE_NODE_BATCHING, AUTOPIPELINE_REPLICA_READS, AUTOPIPELINE_TRANSACTIONAL, AUTOPIPELINE_EXPVAR
+// and AUTOPIPELINE_LOG_LEVEL,
+// values are written same as in json config, lists are comma separated
+func ConfigFromEnv(prefix string) (Config, error) {
+	cfg := DefaultConfig()
+	vars := []struct {
+		name string
+		dst  interface{}
+	}{
+		{name: "TTL", dst: &cfg.TTL},
+		{name: "MAX_SIZE", dst: &cfg.MaxSize},
+		{name: "RUN_INTERVAL", dst: &cfg.RunInterval},
+		{name: "OPERATION_TTL", dst: &cfg.OperationTTL},
+		{name: "COMMAND_TIMEOUT", dst: &cfg.CommandTimeout},
+		{name: "PAUSE_LIMIT", dst: &cfg.PauseLimit},
+		{name: "CROSS_SLOT_SPLITTING", dst: &cfg.CrossSlotSplitting},
+		{name: "DUPLICATE_POLICY", dst: &cfg.DuplicatePolicy},
+		{name: "ORDERED_DELIVERY", dst: &cfg.OrderedDelivery},
+		{name: "DEADLINE_PROPAGATION", dst: &cfg.DeadlinePropagation},
+		{name: "FALLBACK", dst: &cfg.Fallback},
+		{name: "KEY_PREFIX", dst: &cfg.KeyPrefix},
+		{name: "ADAPTIVE_TTL", dst: &cfg.AdaptiveTTL},
+		{name: "MAX_DELAY", dst: &cfg.MaxDelay},
+		{name: "SOLO_BYPASS", dst: &cfg.SoloBypass},
+		{name: "MAX_COMMANDS_PER_PIPELINE", dst: &cfg.MaxCommandsPerPipeline},
+		{name: "MAX_BATCH_BYTES", dst: &cfg.MaxBatchBytes},
+		{name: "FLUSH_JITTER", dst: &cfg.FlushJitter},
+		{name: "STORAGE_SHARDS", dst: &cfg.StorageShards},
+		{name: "LOCK_FREE_ENQUEUE", dst: &cfg.LockFreeEnqueue},
+		{name: "WORKERS", dst: &cfg.Workers},
+		{name: "PIPELINE_EXEC_TIMEOUT", dst: &cfg.PipelineExecTimeout},
+		{name: "DELIVERY_WORKERS", dst: &cfg.DeliveryWorkers},
+		{name: "DELIVERY_TIMEOUT", dst: &cfg.DeliveryTimeout},
+		{name: "DROP_POLICY", dst: &cfg.DropPolicy},
+		{name: "GET_COALESCING", dst: &cfg.GetCoalescing},
+		{name: "DEL_MERGING", dst: &cfg.DelMerging},
+		{name: "SET_MERGING", dst: &cfg.SetMerging},
+		{name: "HASH_READ_MERGING", dst: &cfg.HashReadMerging},
+		{name: "NO_DEDUP", dst: &cfg.NoDedup},
+		{name: "DEDUP_WINDOW", dst: &cfg.DedupWindow},
+		{name: "WRITE_LANE_TTL", dst: &cfg.WriteLaneTTL},
+		{name: "WRITE_LANE_MAX_SIZE", dst: &cfg.WriteLaneMaxSize},
+		{name: "MAX_PENDING", dst: &cfg.MaxPending},
+		{name: "OVERFLOW_POLICY", dst: &cfg.OverflowPolicy},
+		{name: "READ_RETRIES", dst: &cfg.ReadRetries},
+		{name: "AUTO_TUNE_LATENCY", dst: &cfg.AutoTuneLatency},
+		{name: "AUTO_TUNE_RATE", dst: &cfg.AutoTuneRate},
+		{name: "AUTO_TUNE_MIN_TTL", dst: &cfg.AutoTuneMinTTL},
+		{name: "AUTO_TUNE_MAX_TTL", dst: &cfg.AutoTuneMaxTTL},
+		{name: "AUTO_TUNE_MIN_SIZE", dst: &cfg.AutoTuneMinSize},
+		{name: "AUTO_TUNE_MAX_SIZE", dst: &cfg.AutoTuneMaxSize},
+		{name: "AUTO_TUNE_INTERVAL", dst: &cfg.AutoTuneInterval},
+		{name: "FAILOVER_PAUSE", dst: &cfg.FailoverPause},
+		{name: "NODE_BATCHING", dst: &cfg.NodeBatching},
+		{name: "REPLICA_READS", dst: &cfg.ReplicaReads},
+		{name: "TRANSACTIONAL", dst: &cfg.Transactional},
+		{name: "EXPVAR", dst: &cfg.Expvar},
+		{name: "LOG_LEVEL", dst: &cfg.LogLevel},
+	}
+	for _, v := range vars {
+		name := prefix + "_" + v.name
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := parseEnv(value, v.dst); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return cfg, nil
+}
+
+// parseEnv parses value of environment variable to dst
+func parseEnv(value string, dst interface{}) error {
+	switch d := dst.(type) {
+	case *string:
+		*d = value
+	case *[]string:
+		*d = nil
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				*d = append(*d, v)
+			}
+		}
+	case *uint:
+		v, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			return err
+		}
+		*d = uint(v)
+	case *float64:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*d = v
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*d = v
+	case encoding.TextUnmarshaler:
+		return d.UnmarshalText([]byte(value))
+	default:
+		return fmt.Errorf("unsupported type %T", dst)
+	}
+	return nil
+}
+
+// options returns functional options equivalent to the config
+func (c Config) options() []func(a *Autopipeline) {
+	return []func(a *Autopipeline){
+		WithCacheTTL(time.Duration(c.TTL)),
+		WithMaxSize(c.MaxSize),
+		WithRunInterval(time.Duration(c.RunInterval)),
+		WithOperationTTL(time.Duration(c.OperationTTL)),
+		WithCommandTimeout(time.Duration(c.CommandTimeout)),
+		WithPauseLimit(c.PauseLimit),
+		WithCrossSlotSplitting(c.CrossSlotSplitting),
+		WithDuplicatePolicy(c.DuplicatePolicy),
+		WithOrderedDelivery(c.OrderedDelivery),
+		WithDeadlinePropagation(c.DeadlinePropagation),
+		WithFallback(c.Fallback),
+		WithKeyPrefix(c.KeyPrefix),
+		WithAdaptiveTTL(c.AdaptiveTTL),
+		WithMaxDelay(time.Duration(c.MaxDelay)),
+		WithSoloBypass(time.Duration(c.SoloBypass)),
+		WithMaxCommandsPerPipeline(c.MaxCommandsPerPipeline),
+		WithMaxBatchBytes(c.MaxBatchBytes),
+		WithFlushJitter(c.FlushJitter),
+		WithStorageShards(c.StorageShards),
+		WithLockFreeEnqueue(c.LockFreeEnqueue),
+		WithWorkers(c.Workers),
+		WithPipelineExecTimeout(time.Duration(c.PipelineExecTimeout)),
+		WithDeliveryWorkers(c.DeliveryWorkers),
+		WithDeliveryTimeout(time.Duration(c.DeliveryTimeout)),
+		WithDropPolicy(c.DropPolicy),
+		WithGetCoalescing(c.GetCoalescing),
+		WithDelMerging(c.DelMerging),
+		WithSetMerging(c.SetMerging),
+		WithHashReadMerging(c.HashReadMerging),
+		WithoutDedup(c.NoDedup...),
+		WithDedupWindow(time.Duration(c.DedupWindow)),
+		WithWriteLane(time.Duration(c.WriteLaneTTL), c.WriteLaneMaxSize),
+		WithMaxPending(c.MaxPending),
+		WithOverflowPolicy(c.OverflowPolicy),
+		WithReadRetries(c.ReadRetries),
+		WithAutoTuning(AutoTuning{
+			TargetLatency: time.Duration(c.AutoTuneLatency),
+			TargetRate:    c.AutoTuneRate,
+			MinTTL:        time.Duration(c.AutoTuneMinTTL),
+			MaxTTL:        time.Duration(c.AutoTuneMaxTTL),
+			MinSize:       c.AutoTuneMinSize,
+			MaxSize:       c.AutoTuneMaxSize,
+			Interval:      time.Duration(c.AutoTuneInterval),
+		}),
+		WithFailoverPause(time.Duration(c.FailoverPause)),
+		WithNodeBatching(c.NodeBatching),
+		WithReplicaReads(c.ReplicaReads),
+		WithTransactional(c.Transactional),
+		WithExpvar(c.Expvar),
+		WithLogLevel(c.LogLevel),
+	}
+}
+
+// ConfigChange is an event of runtime-tunable setting change
+type ConfigChange struct {
+	Time    time.Time   `json:"time"`    // time of change
+	Setting string      `json:"setting"` // name of setting, same as in Config json
+	Old     interface{} `json:"old"`     // previous value
+	New     interface{} `json:"new"`     // new value
+}
+
+func (c ConfigChange) String() string {
+	return fmt.Sprintf("autopipeline config changed: %s %v -> %v", c.Setting, c.Old, c.New)
+}
+
+// Duration is a time.Duration, which is marshalled to text as "1ms", "500µs" and so on
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// duplicatePolicyNames contains text representation of duplicate policies
+var duplicatePolicyNames = map[DuplicatePolicy]string{
+	DuplicateCoalesce: "coalesce",
+	DuplicateWarn:     "warn",
+	DuplicateReject:   "reject",
+}
+
+func (p DuplicatePolicy) MarshalText() ([]byte, error) {
+	name, ok := duplicatePolicyNames[p]
+	if !ok {
+		return nil, fmt.Errorf("unknown duplicate policy: %d", p)
+	}
+	return []byte(name), nil
+}
+
+func (p *DuplicatePolicy) UnmarshalText(text []byte) error {
+	for policy, name := range duplicatePolicyNames {
+		if name == string(text) {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown duplicate policy: %s", text)
+}
+
+// dropPolicyNames contains text representation of drop policies
+var dropPolicyNames = map[DropPolicy]string{
+	DropCount: "count",
+	DropLog:   "log",
+}
+
+func (p DropPolicy) MarshalText() ([]byte, error) {
+	name, ok := dropPolicyNames[p]
+	if !ok {
+		return nil, fmt.Errorf("unknown drop policy: %d", p)
+	}
+	return []byte(name), nil
+}
+
+func (p *DropPolicy) UnmarshalText(text []byte) error {
+	for policy, name := range dropPolicyNames {
+		if name == string(text) {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown drop policy: %s", text)
+}
+
+// overflowPolicyNames contains text representation of overflow policies
+var overflowPolicyNames = map[OverflowPolicy]string{
+	OverflowBlock:  "block",
+	OverflowReject: "reject",
+	OverflowDirect: "direct",
+}
+
+func (p OverflowPolicy) MarshalText() ([]byte, error) {
+	name, ok := overflowPolicyNames[p]
+	if !ok {
+		return nil, fmt.Errorf("unknown overflow policy: %d", p)
+	}
+	return []byte(name), nil
+}
+
+func (p *OverflowPolicy) UnmarshalText(text []byte) error {
+	for policy, name := range overflowPolicyNames {
+		if name == string(text) {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown overflow policy: %s", text)
+}
+
+// logLevelNames contains text representation of log levels
+var logLevelNames = map[LogLevel]string{
+	LogDebug: "debug",
+	LogInfo:  "info",
+	LogWarn:  "warn",
+	LogError: "error",
+}
+
+func (l LogLevel) MarshalText() ([]byte, error) {
+	name, ok := logLevelNames[l]
+	if !ok {
+		return nil, fmt.Errorf("unknown log level: %d", l)
+	}
+	return []byte(name), nil
+}
+
+func (l *LogLevel) UnmarshalText(text []byte) error {
+	for level, name := range logLevelNames {
+		if name == string(text) {
+			*l = level
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log level: %s", text)
+}
@@ -0,0 +1,257 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestConfigUnmarshalJSON(t *testing.T) {
+	cfg := DefaultConfig()
+	err := json.Unmarshal([]byte(`{"ttl":"2ms","max_size":50,"duplicate_policy":"reject"}`), &cfg)
+	assert.Nil(t, err)
+
+	want := DefaultConfig()
+	want.TTL = Duration(2 * time.Millisecond)
+	want.MaxSize = 50
+	want.DuplicatePolicy = DuplicateReject
+	assert.Equal(t, want, cfg)
+}
+
+func TestConfigMarshalJSON(t *testing.T) {
+	b, err := json.Marshal(DefaultConfig())
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"ttl": "1ms",
+		"max_size": 100,
+		"run_interval": "50µs",
+		"operation_ttl": "0s",
+		"command_timeout": "0s",
+		"pause_limit": 10000,
+		"cross_slot_splitting": false,
+		"duplicate_policy": "coalesce",
+		"ordered_delivery": false,
+		"deadline_propagation": false,
+		"fallback": false,
+		"key_prefix": "",
+		"adaptive_ttl": 0,
+		"max_delay": "0s",
+		"solo_bypass": "0s",
+		"max_commands_per_pipeline": 0,
+		"max_batch_bytes": 0,
+		"flush_jitter": 0,
+		"storage_shards": 1,
+		"lock_free_enqueue": false,
+		"workers": 1,
+		"pipeline_exec_timeout": "0s",
+		"delivery_workers": 0,
+		"delivery_timeout": "0s",
+		"drop_policy": "count",
+		"get_coalescing": false,
+		"del_merging": false,
+		"set_merging": false,
+		"hash_read_merging": false,
+		"no_dedup": null,
+		"dedup_window": "0s",
+		"write_lane_ttl": "0s",
+		"write_lane_max_size": 100,
+		"max_pending": 0,
+		"overflow_policy": "block",
+		"read_retries": 0,
+		"auto_tune_latency": "0s",
+		"auto_tune_rate": 0,
+		"auto_tune_min_ttl": "0s",
+		"auto_tune_max_ttl": "0s",
+		"auto_tune_min_size": 0,
+		"auto_tune_max_size": 0,
+		"auto_tune_interval": "0s",
+		"failover_pause": "0s",
+		"node_batching": false,
+		"replica_reads": false,
+		"transactional": false,
+		"expvar": "",
+		"log_level": "info"
+	}`, string(b))
+}
+
+func TestConfigUnmarshalInvalid(t *testing.T) {
+	cfg := DefaultConfig()
+	assert.NotNil(t, json.Unmarshal([]byte(`{"ttl":"2 parsecs"}`), &cfg))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"duplicate_policy":"ignore"}`), &cfg))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"drop_policy":"panic"}`), &cfg))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"log_level":"trace"}`), &cfg))
+}
+
+func TestNewAutoPipelineFromConfig(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key").SetVal("john")
+
+	cfg := DefaultConfig()
+	cfg.TTL = Duration(time.Microsecond * 5)
+	c, err := NewAutoPipelineFromConfig(db, cfg, WithLogger(&defaultLogger{}))
+	assert.Nil(t, err)
+	result, err := c.Get(ctx, "key").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "john", result)
+
+	cfg.MaxSize = 0
+	_, err = NewAutoPipelineFromConfig(db, cfg)
+	assert.ErrorIs(t, err, ErrInvalidMaxSize)
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("AUTOPIPELINE_TTL", "2ms")
+	t.Setenv("AUTOPIPELINE_MAX_SIZE", "50")
+	t.Setenv("AUTOPIPELINE_RUN_INTERVAL", "100us")
+	t.Setenv("AUTOPIPELINE_CROSS_SLOT_SPLITTING", "true")
+	t.Setenv("AUTOPIPELINE_DUPLICATE_POLICY", "warn")
+	t.Setenv("AUTOPIPELINE_ORDERED_DELIVERY", "1")
+	t.Setenv("AUTOPIPELINE_FALLBACK", "true")
+	t.Setenv("AUTOPIPELINE_KEY_PREFIX", "tenant1:")
+	t.Setenv("AUTOPIPELINE_ADAPTIVE_TTL", "2.5")
+	t.Setenv("AUTOPIPELINE_MAX_DELAY", "3ms")
+	t.Setenv("AUTOPIPELINE_SOLO_BYPASS", "20µs")
+	t.Setenv("AUTOPIPELINE_MAX_COMMANDS_PER_PIPELINE", "500")
+	t.Setenv("AUTOPIPELINE_MAX_BATCH_BYTES", "65536")
+	t.Setenv("AUTOPIPELINE_FLUSH_JITTER", "0.1")
+	t.Setenv("AUTOPIPELINE_STORAGE_SHARDS", "8")
+	t.Setenv("AUTOPIPELINE_LOCK_FREE_ENQUEUE", "true")
+	t.Setenv("AUTOPIPELINE_WORKERS", "4")
+	t.Setenv("AUTOPIPELINE_PIPELINE_EXEC_TIMEOUT", "2s")
+	t.Setenv("AUTOPIPELINE_DELIVERY_WORKERS", "4")
+	t.Setenv("AUTOPIPELINE_DELIVERY_TIMEOUT", "5ms")
+	t.Setenv("AUTOPIPELINE_DROP_POLICY", "log")
+	t.Setenv("AUTOPIPELINE_GET_COALESCING", "true")
+	t.Setenv("AUTOPIPELINE_DEL_MERGING", "true")
+	t.Setenv("AUTOPIPELINE_SET_MERGING", "true")
+	t.Setenv("AUTOPIPELINE_HASH_READ_MERGING", "true")
+	t.Setenv("AUTOPIPELINE_NO_DEDUP", "Do, EvalRO")
+	t.Setenv("AUTOPIPELINE_DEDUP_WINDOW", "200µs")
+	t.Setenv("AUTOPIPELINE_WRITE_LANE_TTL", "5ms")
+	t.Setenv("AUTOPIPELINE_WRITE_LANE_MAX_SIZE", "1000")
+	t.Setenv("AUTOPIPELINE_MAX_PENDING", "5000")
+	t.Setenv("AUTOPIPELINE_OVERFLOW_POLICY", "direct")
+	t.Setenv("AUTOPIPELINE_READ_RETRIES", "3")
+	t.Setenv("AUTOPIPELINE_AUTO_TUNE_LATENCY", "10ms")
+	t.Setenv("AUTOPIPELINE_AUTO_TUNE_RATE", "2000")
+	t.Setenv("AUTOPIPELINE_AUTO_TUNE_MIN_TTL", "100µs")
+	t.Setenv("AUTOPIPELINE_AUTO_TUNE_MAX_TTL", "5ms")
+	t.Setenv("AUTOPIPELINE_AUTO_TUNE_MIN_SIZE", "10")
+	t.Setenv("AUTOPIPELINE_AUTO_TUNE_MAX_SIZE", "1000")
+	t.Setenv("AUTOPIPELINE_AUTO_TUNE_INTERVAL", "10s")
+	t.Setenv("AUTOPIPELINE_FAILOVER_PAUSE", "1s")
+	t.Setenv("AUTOPIPELINE_NODE_BATCHING", "true")
+	t.Setenv("AUTOPIPELINE_REPLICA_READS", "true")
+	t.Setenv("AUTOPIPELINE_TRANSACTIONAL", "true")
+	t.Setenv("AUTOPIPELINE_EXPVAR", "autopipeline")
+	t.Setenv("AUTOPIPELINE_LOG_LEVEL", "debug")
+	// other prefix is ignored
+	t.Setenv("OTHER_PAUSE_LIMIT", "1")
+
+	cfg, err := ConfigFromEnv("AUTOPIPELINE")
+	assert.Nil(t, err)
+
+	want := DefaultConfig()
+	want.TTL = Duration(2 * time.Millisecond)
+	want.MaxSize = 50
+	want.RunInterval = Duration(100 * time.Microsecond)
+	want.CrossSlotSplitting = true
+	want.DuplicatePolicy = DuplicateWarn
+	want.OrderedDelivery = true
+	want.Fallback = true
+	want.KeyPrefix = "tenant1:"
+	want.AdaptiveTTL = 2.5
+	want.MaxDelay = Duration(3 * time.Millisecond)
+	want.SoloBypass = Duration(20 * time.Microsecond)
+	want.MaxCommandsPerPipeline = 500
+	want.MaxBatchBytes = 65536
+	want.FlushJitter = 0.1
+	want.StorageShards = 8
+	want.LockFreeEnqueue = true
+	want.Workers = 4
+	want.PipelineExecTimeout = Duration(2 * time.Second)
+	want.DeliveryWorkers = 4
+	want.DeliveryTimeout = Duration(5 * time.Millisecond)
+	want.DropPolicy = DropLog
+	want.GetCoalescing = true
+	want.DelMerging = true
+	want.SetMerging = true
+	want.HashReadMerging = true
+	want.NoDedup = []string{"Do", "EvalRO"}
+	want.DedupWindow = Duration(200 * time.Microsecond)
+	want.WriteLaneTTL = Duration(5 * time.Millisecond)
+	want.WriteLaneMaxSize = 1000
+	want.MaxPending = 5000
+	want.OverflowPolicy = OverflowDirect
+	want.ReadRetries = 3
+	want.AutoTuneLatency = Duration(10 * time.Millisecond)
+	want.AutoTuneRate = 2000
+	want.AutoTuneMinTTL = Duration(100 * time.Microsecond)
+	want.AutoTuneMaxTTL = Duration(5 * time.Millisecond)
+	want.AutoTuneMinSize = 10
+	want.AutoTuneMaxSize = 1000
+	want.AutoTuneInterval = Duration(10 * time.Second)
+	want.FailoverPause = Duration(time.Second)
+	want.NodeBatching = true
+	want.ReplicaReads = true
+	want.Transactional = true
+	want.Expvar = "autopipeline"
+	want.LogLevel = LogDebug
+	assert.Equal(t, want, cfg)
+}
+
+func TestConfigFromEnvInvalid(t *testing.T) {
+	t.Setenv("AUTOPIPELINE_MAX_SIZE", "-1")
+
+	_, err := ConfigFromEnv("AUTOPIPELINE")
+	assert.ErrorContains(t, err, "AUTOPIPELINE_MAX_SIZE")
+}
+
+type infoRecorder struct {
+	defaultLogger
+	messages []string
+}
+
+func (l *infoRecorder) Info(args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprint(args...))
+}
+
+func TestConfigChange(t *testing.T) {
+	db, _ := redismock.NewClientMock()
+	var changes []ConfigChange
+	logger := &infoRecorder{}
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Millisecond),
+		WithLogger(logger),
+		WithConfigChangeHook(func(change ConfigChange) {
+			changes = append(changes, change)
+		}))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	c.SetCacheTTL(2 * time.Millisecond)
+	c.SetMaxSize(10)
+	c.SetRunInterval(defaultRunInterval) // not changed
+	assert.Len(t, changes, 2)
+	assert.Equal(t, "ttl", changes[0].Setting)
+	assert.Equal(t, Duration(time.Millisecond), changes[0].Old)
+	assert.Equal(t, Duration(2*time.Millisecond), changes[0].New)
+	assert.Equal(t, "max_size", changes[1].Setting)
+	assert.Equal(t, defaultCacheSize, changes[1].Old)
+	assert.Equal(t, uint(10), changes[1].New)
+	assert.Equal(t, []string{
+		"autopipeline config changed: ttl 1ms -> 2ms",
+		"autopipeline config changed: max_size 100 -> 10",
+	}, logger.messages)
+
+	want := DefaultConfig()
+	want.TTL = Duration(2 * time.Millisecond)
+	want.MaxSize = 10
+	assert.Equal(t, want, c.Config())
+}
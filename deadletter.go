@@ -0,0 +1,29 @@
+package redis_autopipeline
+
+import (
+	"time"
+)
+
+// DeadLetter is a command, which was dropped without execution, see WithDeadLetterHook
+type DeadLetter struct {
+	Time time.Time // time when command was dropped
+	Kind string    // redis command, e.g. HDel, Get and so on
+	Args []string  // arguments of redis command, keys include key prefix
+	Err  error     // reason of drop, f.e. ErrMaxPendingExceeded, ErrCacheStopped or ErrOperationExpired
+}
+
+// dropped passes the command, which is not executed, to dead letter hook
+func (c *cache) dropped(kind operationPrefix, p payload, err error) {
+	if c.deadLetterHook == nil {
+		return
+	}
+	c.deadLetterHook(DeadLetter{Time: c.clock.Now(), Kind: kind.String(), Args: payloadArgs(p), Err: err})
+}
+
+// rejected passes the command, which wasn't queued, to dead letter hook
+func (c *cache) rejected(kind operationPrefix, p payload, err error) {
+	if c.deadLetterHook == nil {
+		return
+	}
+	c.dropped(kind, prefixPayload(c.keyPrefix, p), err)
+}
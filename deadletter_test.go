@@ -0,0 +1,91 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deadLetters collects dead letters passed to the hook
+type deadLetters struct {
+	mx      sync.Mutex
+	letters []DeadLetter
+}
+
+func (d *deadLetters) hook(l DeadLetter) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.letters = append(d.letters, l)
+}
+
+func (d *deadLetters) get() []DeadLetter {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return append([]DeadLetter(nil), d.letters...)
+}
+
+func TestDeadLetterHook(t *testing.T) {
+	ctx := context.TODO()
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(&mgetHook{})
+
+	// rejected by full and stopped cache
+	letters := &deadLetters{}
+	c, err := NewAutoPipeline(db,
+		WithKeyPrefix("app:"),
+		WithMaxPending(1),
+		WithOverflowPolicy(OverflowReject),
+		WithDeadLetterHook(letters.hook))
+	assert.Nil(t, err)
+	c.Pause()
+	pending := c.GetAsync(ctx, "key1")
+	assert.ErrorIs(t, c.Del(ctx, "key2", "key3").Err(), ErrMaxPendingExceeded)
+	c.Stop()
+	assert.Equal(t, "app:key1", (<-pending).(*redis.StringCmd).Val())
+	_, err = c.AwaitOnly().HGet(ctx, "key4", "name").Await(ctx)
+	assert.ErrorIs(t, err, ErrCacheStopped)
+	got := letters.get()
+	assert.Len(t, got, 2)
+	assert.Equal(t, "Del", got[0].Kind)
+	assert.Equal(t, []string{"app:key2", "app:key3"}, got[0].Args)
+	assert.ErrorIs(t, got[0].Err, ErrMaxPendingExceeded)
+	assert.False(t, got[0].Time.IsZero())
+	assert.Equal(t, "HGet", got[1].Kind)
+	assert.Equal(t, []string{"app:key4", "name"}, got[1].Args)
+	assert.ErrorIs(t, got[1].Err, ErrCacheStopped)
+
+	// expired
+	letters = &deadLetters{}
+	c, err = NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithOperationTTL(5*time.Millisecond),
+		WithDeadLetterHook(letters.hook))
+	assert.Nil(t, err)
+	c.Pause()
+	assert.ErrorIs(t, c.Get(ctx, "key1").Err(), ErrOperationExpired)
+	c.Stop()
+	got = letters.get()
+	assert.Len(t, got, 1)
+	assert.Equal(t, "Get", got[0].Kind)
+	assert.ErrorIs(t, got[0].Err, ErrOperationExpired)
+
+	// pending on stop with done context
+	letters = &deadLetters{}
+	cancelCtx, cancel := context.WithCancel(ctx)
+	c, err = NewAutoPipeline(db,
+		WithContext(cancelCtx),
+		WithDeadLetterHook(letters.hook))
+	assert.Nil(t, err)
+	c.Pause()
+	pending = c.DoAsync(ctx, "ping")
+	cancel()
+	assert.ErrorIs(t, (<-pending).(*redis.Cmd).Err(), context.Canceled)
+	got = letters.get()
+	assert.Len(t, got, 1)
+	assert.Equal(t, "Do", got[0].Kind)
+	assert.Equal(t, []string{"ping"}, got[0].Args)
+	assert.ErrorIs(t, got[0].Err, context.Canceled)
+}
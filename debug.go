@@ -0,0 +1,71 @@
+package redis_autopipeline
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+var (
+	// sizeBounds are upper bounds of buckets of batch size histogram of the debug handler
+	sizeBounds = []int64{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 2048, 4096}
+	// execTimeBounds are upper bounds of buckets of execution time histogram of the debug handler
+	execTimeBounds = []int64{
+		int64(100 * time.Microsecond), int64(250 * time.Microsecond), int64(500 * time.Microsecond),
+		int64(time.Millisecond), int64(2500 * time.Microsecond), int64(5 * time.Millisecond),
+		int64(10 * time.Millisecond), int64(25 * time.Millisecond), int64(50 * time.Millisecond),
+		int64(100 * time.Millisecond), int64(250 * time.Millisecond), int64(500 * time.Millisecond),
+		int64(time.Second),
+	}
+)
+
+// histogramBucket is a number of samples not greater than LE and greater than LE of the previous bucket,
+// the last bucket counts samples above all bounds
+type histogramBucket struct {
+	LE    int64 `json:"le,omitempty"`
+	Inf   bool  `json:"inf,omitempty"`
+	Count int   `json:"count"`
+}
+
+// debugState is live state of autopipeline rendered by the debug handler, durations are in nanoseconds
+type debugState struct {
+	Running      bool              `json:"running"`
+	Pending      int               `json:"pending"`
+	LastFlush    time.Time         `json:"last_flush"`
+	LastError    string            `json:"last_error,omitempty"`
+	RecentErrors []recentError     `json:"recent_errors"`
+	BatchSizes   []histogramBucket `json:"batch_sizes"`
+	ExecTimes    []histogramBucket `json:"exec_times"`
+	Stats        Stats             `json:"stats"`
+	Config       Config            `json:"config"`
+}
+
+// debugState returns live state of autopipeline, histograms are computed over the latest 1024 pipelines
+func (a Autopipeline) debugState() debugState {
+	s := debugState{
+		Running:      a.IsRunning(),
+		Pending:      a.Len(),
+		LastFlush:    a.LastFlushTime(),
+		RecentErrors: a.cache.stats.recentErrors(),
+		Stats:        a.Stats(),
+		Config:       a.Config(),
+	}
+	if err := a.LastError(); err != nil {
+		s.LastError = err.Error()
+	}
+	s.BatchSizes, s.ExecTimes = a.cache.stats.histograms()
+	return s
+}
+
+// DebugHandler returns http.Handler, which renders live state of autopipeline as json, f.e. on the admin port
+// of the service next to expvar and pprof: whether it's running, queue depth, last flush, last and recent errors
+// of pipelines, histograms of batch size and execution time (in nanoseconds) of the latest 1024 pipelines,
+// stats and config
+func (a Autopipeline) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(a.debugState())
+	})
+}
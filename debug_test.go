@@ -0,0 +1,102 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDebugHandler(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key").SetVal("john")
+	mock.ExpectGet("key1").SetErr(errors.New("connection refused"))
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithOperationTTL(20*time.Millisecond),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	read := func() debugState {
+		rec := httptest.NewRecorder()
+		c.DebugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/autopipeline", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+		var s debugState
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &s))
+		return s
+	}
+
+	s := read()
+	assert.True(t, s.Running)
+	assert.True(t, s.LastFlush.IsZero())
+	assert.Empty(t, s.LastError)
+	assert.Empty(t, s.RecentErrors)
+	assert.Equal(t, uint(200), s.Config.MaxSize)
+	assert.Len(t, s.BatchSizes, len(sizeBounds)+1)
+	assert.Len(t, s.ExecTimes, len(execTimeBounds)+1)
+
+	assert.Equal(t, "john", c.Get(ctx, "key").Val())
+	_, err = c.Get(ctx, "key1").Result()
+	assert.EqualError(t, err, "connection refused")
+
+	s = read()
+	assert.False(t, s.LastFlush.IsZero())
+	assert.Equal(t, "connection refused", s.LastError)
+	if assert.Len(t, s.RecentErrors, 1) {
+		assert.Equal(t, "connection refused", s.RecentErrors[0].Error)
+		assert.False(t, s.RecentErrors[0].Time.IsZero())
+	}
+	assert.Equal(t, histogramBucket{LE: 1, Count: 2}, s.BatchSizes[0])
+	assert.Equal(t, uint64(2), s.Stats.Pipelines)
+	assert.Equal(t, 0, s.Pending)
+
+	c.Stop()
+	assert.False(t, read().Running)
+}
+
+func TestRecentErrors(t *testing.T) {
+	st := newStats()
+	for i := 0; i < recentErrorsSize+2; i++ {
+		st.failed(time.Unix(int64(i), 0), errors.New("connection refused"))
+	}
+	errs := st.recentErrors()
+	assert.Len(t, errs, recentErrorsSize)
+	assert.Equal(t, time.Unix(2, 0), errs[0].Time)
+	assert.Equal(t, time.Unix(recentErrorsSize+1, 0), errs[recentErrorsSize-1].Time)
+}
+
+func TestHistogram(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []int64
+		want    []histogramBucket
+	}{
+		{
+			name: "empty",
+			want: []histogramBucket{{LE: 1}, {LE: 4}, {Inf: true}},
+		},
+		{
+			name:    "bounds are inclusive",
+			samples: []int64{1, 2, 4, 5, 100},
+			want:    []histogramBucket{{LE: 1, Count: 1}, {LE: 4, Count: 2}, {Inf: true, Count: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w window
+			for _, sample := range tt.samples {
+				w.add(sample)
+			}
+			assert.Equal(t, tt.want, w.histogram([]int64{1, 4}))
+		})
+	}
+}
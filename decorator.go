@@ -0,0 +1,321 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// Decorator wraps a Client to layer cross-cutting behavior, f.e. retries, metrics or logging, on top of it
+type Decorator func(c Client) Client
+
+// Decorate wraps the client with decorators, first decorator is the outermost one
+func Decorate(c Client, decorators ...Decorator) Client {
+	for i := len(decorators) - 1; i >= 0; i-- {
+		c = decorators[i](c)
+	}
+	return c
+}
+
+// interceptor runs the synchronous command with do, which returns the error of command,
+// it may run do several times, f.e. for retries
+type interceptor func(ctx context.Context, command string, do func() error) error
+
+// decoratedClient intercepts synchronous commands of the wrapped Client,
+// all other methods are passed through as is
+type decoratedClient struct {
+	Client
+	intercept interceptor
+}
+
+// idempotentCommands are commands, which have the same effect if they're executed twice, as command failed
+// with transient error, f.e. network error, may be executed by redis already
+var idempotentCommands = map[string]struct{}{
+	"HDel": {}, "Expire": {}, "HGet": {}, "HGetAll": {}, "Get": {}, "Del": {}, "SMembers": {}, "MGet": {},
+	"EvalRO": {}, "FCallRO": {}, "Exists": {}, "Time": {}, "Echo": {}, "SafeDel": {}, "Set": {}, "HSet": {},
+	"TTL": {}, "ExistsMany": {}, "Sample": {},
+}
+
+// retriesKey is a context key, which allows retries of commands, which aren't idempotent
+type retriesKey struct{}
+
+// ContextWithRetries returns a context, which allows WithRetryDecorator to retry commands called with it,
+// which aren't idempotent, f.e. Incr or writes sent with Do and Operation, when the caller accepts
+// that such command may be applied twice
+func ContextWithRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retriesKey{}, true)
+}
+
+// WithRetryDecorator retries synchronous commands failed with transient errors, f.e. network errors
+// or ErrOperationExpired, up to attempts times in total, waiting backoff between attempts
+// redis.Nil, errors replied by redis, context errors and errors caused by the caller are not retried,
+// commands, which aren't idempotent, f.e. Incr, Do and Operation, are retried only with ContextWithRetries
+func WithRetryDecorator(attempts int, backoff time.Duration) Decorator {
+	return func(c Client) Client {
+		return decoratedClient{Client: c, intercept: func(ctx context.Context, command string, do func() error) error {
+			err := do()
+			if _, ok := idempotentCommands[command]; !ok && ctx.Value(retriesKey{}) == nil {
+				return err
+			}
+			for i := 1; i < attempts && retryable(err); i++ {
+				t := time.NewTimer(backoff)
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					return err
+				case <-t.C:
+				}
+				err = do()
+			}
+			return err
+		}}
+	}
+}
+
+// WithMetricsDecorator reports name, duration and error of every synchronous command to observe
+func WithMetricsDecorator(observe func(command string, duration time.Duration, err error)) Decorator {
+	return func(c Client) Client {
+		return decoratedClient{Client: c, intercept: func(ctx context.Context, command string, do func() error) error {
+			start := time.Now()
+			err := do()
+			observe(command, time.Since(start), err)
+			return err
+		}}
+	}
+}
+
+// WithLoggingDecorator logs errors of synchronous commands, redis.Nil is not logged
+func WithLoggingDecorator(l Logger) Decorator {
+	return func(c Client) Client {
+		return decoratedClient{Client: c, intercept: func(ctx context.Context, command string, do func() error) error {
+			err := do()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				l.Error(fmt.Errorf("%s: %w", command, err))
+			}
+			return err
+		}}
+	}
+}
+
+// retryable reports whether command failed with transient error and may be retried
+func retryable(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// error replied by redis will be replied again
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		return false
+	}
+	for _, e := range []error{ErrCacheStopped, ErrChannelClosed, ErrDuplicateCommand, ErrQuotaExceeded, ErrTypeMismatch, ErrUnsupportedCommand} {
+		if errors.Is(err, e) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d decoratedClient) HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd {
+	var cmd *redis.IntCmd
+	_ = d.intercept(ctx, "HDel", func() error {
+		cmd = d.Client.HDel(ctx, key, fields...)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
+	var cmd *redis.BoolCmd
+	_ = d.intercept(ctx, "Expire", func() error {
+		cmd = d.Client.Expire(ctx, key, expiration)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) HGet(ctx context.Context, key, field string) *redis.StringCmd {
+	var cmd *redis.StringCmd
+	_ = d.intercept(ctx, "HGet", func() error {
+		cmd = d.Client.HGet(ctx, key, field)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd {
+	var cmd *redis.MapStringStringCmd
+	_ = d.intercept(ctx, "HGetAll", func() error {
+		cmd = d.Client.HGetAll(ctx, key)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) Get(ctx context.Context, key string) *redis.StringCmd {
+	var cmd *redis.StringCmd
+	_ = d.intercept(ctx, "Get", func() error {
+		cmd = d.Client.Get(ctx, key)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	var cmd *redis.IntCmd
+	_ = d.intercept(ctx, "Del", func() error {
+		cmd = d.Client.Del(ctx, keys...)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) SMembers(ctx context.Context, key string) *redis.StringSliceCmd {
+	var cmd *redis.StringSliceCmd
+	_ = d.intercept(ctx, "SMembers", func() error {
+		cmd = d.Client.SMembers(ctx, key)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
+	var cmd *redis.SliceCmd
+	_ = d.intercept(ctx, "MGet", func() error {
+		cmd = d.Client.MGet(ctx, keys...)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) EvalRO(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	var cmd *redis.Cmd
+	_ = d.intercept(ctx, "EvalRO", func() error {
+		cmd = d.Client.EvalRO(ctx, script, keys, args...)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) FCallRO(ctx context.Context, function string, keys []string, args ...interface{}) *redis.Cmd {
+	var cmd *redis.Cmd
+	_ = d.intercept(ctx, "FCallRO", func() error {
+		cmd = d.Client.FCallRO(ctx, function, keys, args...)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) Exists(ctx context.Context, keys ...string) *redis.IntCmd {
+	var cmd *redis.IntCmd
+	_ = d.intercept(ctx, "Exists", func() error {
+		cmd = d.Client.Exists(ctx, keys...)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) Time(ctx context.Context) *redis.TimeCmd {
+	var cmd *redis.TimeCmd
+	_ = d.intercept(ctx, "Time", func() error {
+		cmd = d.Client.Time(ctx)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) Echo(ctx context.Context, message interface{}) *redis.StringCmd {
+	var cmd *redis.StringCmd
+	_ = d.intercept(ctx, "Echo", func() error {
+		cmd = d.Client.Echo(ctx, message)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) SafeDel(ctx context.Context, key, expectedType string) *redis.IntCmd {
+	var cmd *redis.IntCmd
+	_ = d.intercept(ctx, "SafeDel", func() error {
+		cmd = d.Client.SafeDel(ctx, key, expectedType)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) Do(ctx context.Context, args ...interface{}) *redis.Cmd {
+	var cmd *redis.Cmd
+	_ = d.intercept(ctx, "Do", func() error {
+		cmd = d.Client.Do(ctx, args...)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) Operation(ctx context.Context, name string, args ...interface{}) redis.Cmder {
+	var cmd redis.Cmder
+	_ = d.intercept(ctx, name, func() error {
+		cmd = d.Client.Operation(ctx, name, args...)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	var cmd *redis.StatusCmd
+	_ = d.intercept(ctx, "Set", func() error {
+		cmd = d.Client.Set(ctx, key, value, expiration)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) HSet(ctx context.Context, key string, values ...interface{}) *redis.IntCmd {
+	var cmd *redis.IntCmd
+	_ = d.intercept(ctx, "HSet", func() error {
+		cmd = d.Client.HSet(ctx, key, values...)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) Incr(ctx context.Context, key string) *redis.IntCmd {
+	var cmd *redis.IntCmd
+	_ = d.intercept(ctx, "Incr", func() error {
+		cmd = d.Client.Incr(ctx, key)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) TTL(ctx context.Context, key string) *redis.DurationCmd {
+	var cmd *redis.DurationCmd
+	_ = d.intercept(ctx, "TTL", func() error {
+		cmd = d.Client.TTL(ctx, key)
+		return cmd.Err()
+	})
+	return cmd
+}
+
+func (d decoratedClient) ExistsMany(ctx context.Context, keys []string) (map[string]bool, error) {
+	var present map[string]bool
+	err := d.intercept(ctx, "ExistsMany", func() error {
+		var err error
+		present, err = d.Client.ExistsMany(ctx, keys)
+		return err
+	})
+	return present, err
+}
+
+func (d decoratedClient) Sample(ctx context.Context, n int) ([]string, error) {
+	var keys []string
+	err := d.intercept(ctx, "Sample", func() error {
+		var err error
+		keys, err = d.Client.Sample(ctx, n)
+		return err
+	})
+	return keys, err
+}
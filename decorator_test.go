@@ -0,0 +1,164 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// fakeClient is a Client, which replies to Get and Incr with the preset errors, one per call
+type fakeClient struct {
+	Client
+	errs  []error
+	calls int
+}
+
+func (f *fakeClient) Get(ctx context.Context, key string) *redis.StringCmd {
+	cmd := redis.NewStringCmd(ctx, "get", key)
+	if f.calls < len(f.errs) && f.errs[f.calls] != nil {
+		cmd.SetErr(f.errs[f.calls])
+	} else {
+		cmd.SetVal("john")
+	}
+	f.calls++
+	return cmd
+}
+
+func (f *fakeClient) Incr(ctx context.Context, key string) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx, "incr", key)
+	if f.calls < len(f.errs) && f.errs[f.calls] != nil {
+		cmd.SetErr(f.errs[f.calls])
+	} else {
+		cmd.SetVal(1)
+	}
+	f.calls++
+	return cmd
+}
+
+type errorRecorder struct {
+	messages []string
+}
+
+func (l *errorRecorder) Error(args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprint(args...))
+}
+
+func TestRetryDecorator(t *testing.T) {
+	var ctx = context.TODO()
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{
+			name:      "success",
+			wantCalls: 1,
+		},
+		{
+			name:      "transient errors",
+			errs:      []error{ErrOperationExpired, errors.New("connection reset")},
+			wantCalls: 3,
+		},
+		{
+			name:      "attempts exhausted",
+			errs:      []error{ErrOperationExpired, ErrOperationExpired, ErrOperationExpired},
+			wantCalls: 3,
+			wantErr:   ErrOperationExpired,
+		},
+		{
+			name:      "nil is not retried",
+			errs:      []error{redis.Nil},
+			wantCalls: 1,
+			wantErr:   redis.Nil,
+		},
+		{
+			name:      "caller error is not retried",
+			errs:      []error{ErrDuplicateCommand},
+			wantCalls: 1,
+			wantErr:   ErrDuplicateCommand,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeClient{errs: tt.errs}
+			c := Decorate(f, WithRetryDecorator(3, time.Microsecond))
+			_, err := c.Get(ctx, "key").Result()
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantCalls, f.calls)
+		})
+	}
+}
+
+func TestRetryDecoratorIdempotence(t *testing.T) {
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		call      func(ctx context.Context, c Client) error
+		wantCalls int
+	}{
+		{
+			name:      "idempotent command is retried",
+			ctx:       context.TODO(),
+			call:      func(ctx context.Context, c Client) error { return c.Get(ctx, "key").Err() },
+			wantCalls: 2,
+		},
+		{
+			name:      "command may be applied twice",
+			ctx:       context.TODO(),
+			call:      func(ctx context.Context, c Client) error { return c.Incr(ctx, "key").Err() },
+			wantCalls: 1,
+		},
+		{
+			name:      "caller accepts command applied twice",
+			ctx:       ContextWithRetries(context.TODO()),
+			call:      func(ctx context.Context, c Client) error { return c.Incr(ctx, "key").Err() },
+			wantCalls: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// command may be executed by redis, before the connection was reset
+			reset := errors.New("connection reset")
+			f := &fakeClient{errs: []error{reset}}
+			c := Decorate(f, WithRetryDecorator(3, time.Microsecond))
+			err := tt.call(tt.ctx, c)
+			if tt.wantCalls == 1 {
+				assert.ErrorIs(t, err, reset)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, tt.wantCalls, f.calls)
+		})
+	}
+}
+
+func TestMetricsAndLoggingDecorators(t *testing.T) {
+	var ctx = context.TODO()
+	var commands []string
+	var errs []error
+	logger := &errorRecorder{}
+	f := &fakeClient{errs: []error{nil, redis.Nil, ErrOperationExpired}}
+
+	c := Decorate(f,
+		WithMetricsDecorator(func(command string, _ time.Duration, err error) {
+			commands = append(commands, command)
+			errs = append(errs, err)
+		}),
+		WithLoggingDecorator(logger))
+
+	assert.Equal(t, "john", c.Get(ctx, "key").Val())
+	assert.ErrorIs(t, c.Get(ctx, "key").Err(), redis.Nil)
+	assert.ErrorIs(t, c.Get(ctx, "key").Err(), ErrOperationExpired)
+
+	assert.Equal(t, []string{"Get", "Get", "Get"}, commands)
+	assert.Equal(t, []error{nil, redis.Nil, ErrOperationExpired}, errs)
+	// nil is not logged
+	assert.Equal(t, []string{"Get: operation expired in cache"}, logger.messages)
+}
@@ -0,0 +1,29 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+)
+
+// Executor builds and executes pipelines of the cache, see WithExecutor,
+// it may wrap PipelineExecutor, f.e. for instrumentation or custom retries, or replace it entirely,
+// f.e. to route pipelines to a proxy like Twemproxy; it's called concurrently by lanes and workers
+type Executor interface {
+	// Pipeline returns a new pipeline, which commands of a flush are queued to, client is the one in use,
+	// see WithStandby, its Pipeline method returns a transactional pipeline, see WithTransactional
+	Pipeline(client redis.UniversalClient) redis.Pipeliner
+	// Exec executes commands queued to the pipeline, errors of commands should be set to commands themselves,
+	// as go-redis does, commands of a pipeline failed without them get the returned error
+	Exec(ctx context.Context, pipe redis.Pipeliner) ([]redis.Cmder, error)
+}
+
+// PipelineExecutor is a default Executor, which executes pipelines of go-redis client as is
+type PipelineExecutor struct{}
+
+func (PipelineExecutor) Pipeline(client redis.UniversalClient) redis.Pipeliner {
+	return client.Pipeline()
+}
+
+func (PipelineExecutor) Exec(ctx context.Context, pipe redis.Pipeliner) ([]redis.Cmder, error) {
+	return pipe.Exec(ctx)
+}
@@ -0,0 +1,80 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingExecutor wraps PipelineExecutor and counts built and executed pipelines
+type countingExecutor struct {
+	PipelineExecutor
+	built    atomic.Int32
+	executed atomic.Int32
+}
+
+func (e *countingExecutor) Pipeline(client redis.UniversalClient) redis.Pipeliner {
+	e.built.Add(1)
+	return e.PipelineExecutor.Pipeline(client)
+}
+
+func (e *countingExecutor) Exec(ctx context.Context, pipe redis.Pipeliner) ([]redis.Cmder, error) {
+	e.executed.Add(1)
+	return e.PipelineExecutor.Exec(ctx, pipe)
+}
+
+// proxyExecutor routes pipelines to the proxy client instead of the client in use
+type proxyExecutor struct {
+	PipelineExecutor
+	proxy redis.UniversalClient
+}
+
+func (e proxyExecutor) Pipeline(redis.UniversalClient) redis.Pipeliner {
+	return e.proxy.Pipeline()
+}
+
+func TestExecutor(t *testing.T) {
+	ctx := context.TODO()
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(&mgetHook{})
+
+	// wrapped default executor
+	executor := &countingExecutor{}
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithExecutor(executor))
+	assert.Nil(t, err)
+	assert.Equal(t, "key1", c.Get(ctx, "key1").Val())
+	assert.Equal(t, int64(2), c.Del(ctx, "key2", "key3").Val())
+	c.Stop()
+	assert.Equal(t, int32(2), executor.built.Load())
+	assert.Equal(t, int32(2), executor.executed.Load())
+
+	// commands executed directly on overflow are pipelined by executor too
+	executor = &countingExecutor{}
+	c, err = NewAutoPipeline(db,
+		WithMaxPending(1),
+		WithOverflowPolicy(OverflowDirect),
+		WithExecutor(executor))
+	assert.Nil(t, err)
+	c.Pause()
+	pending := c.GetAsync(ctx, "key1")
+	assert.Equal(t, "key2", c.Get(ctx, "key2").Val())
+	assert.Equal(t, int32(1), executor.executed.Load())
+	c.Resume()
+	assert.Equal(t, "key1", (<-pending).(*redis.StringCmd).Val())
+	c.Stop()
+
+	// replaced execution strategy
+	refused := redis.NewClient(&redis.Options{})
+	refused.AddHook(failingHook{})
+	c, err = NewAutoPipeline(refused,
+		WithCacheTTL(time.Microsecond*100),
+		WithExecutor(proxyExecutor{proxy: db}))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, "key1", c.Get(ctx, "key1").Val())
+}
@@ -0,0 +1,115 @@
+package redis_autopipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"io"
+	"time"
+)
+
+// BatchRecordVersion is a version of BatchRecord format, it's increased on every incompatible change
+const BatchRecordVersion = 1
+
+// ErrUnsupportedVersion is returned by ReadBatchRecord for records of unknown format version
+var ErrUnsupportedVersion = errors.New("unsupported batch record version")
+
+// Statuses of commands in BatchRecord
+const (
+	CommandOK    = "ok"    // command succeeded
+	CommandNil   = "nil"   // command succeeded with redis nil reply
+	CommandError = "error" // command failed
+)
+
+// BatchRecord is a stable, versioned representation of the executed pipeline for offline analysis tools,
+// f.e. hot keys detection or batch composition reports
+type BatchRecord struct {
+	Version  int             `json:"version"`         // format version, see BatchRecordVersion
+	Time     time.Time       `json:"time"`            // time when pipeline execution started
+	Trigger  string          `json:"trigger"`         // reason of pipeline execution: size, bytes, ttl, max_delay, solo or shutdown
+	RTT      Duration        `json:"rtt"`             // round-trip time of pipeline execution
+	Error    string          `json:"error,omitempty"` // error of pipeline execution, commands have no status then
+	Commands []CommandRecord `json:"commands"`        // commands of pipeline
+}
+
+// CommandRecord is a single command of BatchRecord
+type CommandRecord struct {
+	Kind      string   `json:"kind"`             // redis command, e.g. HDel, Get and so on
+	Args      []string `json:"args"`             // arguments of redis command
+	Listeners int      `json:"listeners"`        // number of listeners and awaiters served by the command
+	Status    string   `json:"status,omitempty"` // result of command: ok, nil or error
+	Error     string   `json:"error,omitempty"`  // error of command
+}
+
+// WriteBatchRecord writes the record to w as a single line of json
+func WriteBatchRecord(w io.Writer, r BatchRecord) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// ReadBatchRecord reads a single record written by WriteBatchRecord from decoder,
+// records of newer format versions are rejected with ErrUnsupportedVersion
+func ReadBatchRecord(d *json.Decoder) (BatchRecord, error) {
+	var r BatchRecord
+	if err := d.Decode(&r); err != nil {
+		return BatchRecord{}, err
+	}
+	if r.Version < 1 || r.Version > BatchRecordVersion {
+		return BatchRecord{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, r.Version)
+	}
+	return r, nil
+}
+
+// batchRecorder collects BatchRecord of a single pipeline execution
+type batchRecorder struct {
+	record  BatchRecord
+	indexes map[string]int // indexes of commands by hash of operation
+}
+
+func newBatchRecorder(trigger flushTrigger) *batchRecorder {
+	return &batchRecorder{
+		record: BatchRecord{
+			Version: BatchRecordVersion,
+			Trigger: trigger.String(),
+		},
+		indexes: make(map[string]int),
+	}
+}
+
+// add records queued operation
+func (r *batchRecorder) add(hash string, op *redisOperation) {
+	args := payloadArgs(op.payload)
+	r.indexes[hash] = len(r.record.Commands)
+	r.record.Commands = append(r.record.Commands, CommandRecord{
+		Kind:      op.kind.String(),
+		Args:      args,
+		Listeners: len(op.listeners) + op.awaiters,
+	})
+}
+
+// executed records the result of pipeline execution
+func (r *batchRecorder) executed(start time.Time, rtt time.Duration, err error) {
+	r.record.Time = start
+	r.record.RTT = Duration(rtt)
+	if err != nil {
+		r.record.Error = err.Error()
+	}
+}
+
+// result records the result of command
+func (r *batchRecorder) result(hash string, cmd redis.Cmder) {
+	i, ok := r.indexes[hash]
+	if !ok {
+		return
+	}
+	err := cmd.Err()
+	switch {
+	case err == nil:
+		r.record.Commands[i].Status = CommandOK
+	case errors.Is(err, redis.Nil):
+		r.record.Commands[i].Status = CommandNil
+	default:
+		r.record.Commands[i].Status = CommandError
+		r.record.Commands[i].Error = err.Error()
+	}
+}
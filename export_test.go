@@ -0,0 +1,78 @@
+package redis_autopipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchExport(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key1").SetVal("john")
+	mock.ExpectGet("key2").RedisNil()
+	mock.MatchExpectationsInOrder(false)
+	records := make(chan BatchRecord, 2)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200),
+		WithBatchExport(func(r BatchRecord) {
+			records <- r
+		}))
+	assert.Nil(t, err)
+
+	c.Pause()
+	resCh := c.GetAsync(ctx, "key1")
+	h := c.AwaitOnly().Get(ctx, "key1")
+	c.Resume()
+	<-resCh
+	_, err = h.Await(ctx)
+	assert.Nil(t, err)
+	r := <-records
+	assert.Equal(t, BatchRecordVersion, r.Version)
+	assert.Equal(t, "ttl", r.Trigger)
+	assert.False(t, r.Time.IsZero())
+	assert.Empty(t, r.Error)
+	assert.Equal(t, []CommandRecord{
+		{Kind: "Get", Args: []string{"key1"}, Listeners: 2, Status: CommandOK},
+	}, r.Commands)
+
+	_ = c.Get(ctx, "key2")
+	r = <-records
+	assert.Equal(t, []CommandRecord{
+		{Kind: "Get", Args: []string{"key2"}, Listeners: 1, Status: CommandNil},
+	}, r.Commands)
+}
+
+func TestBatchRecordWriteRead(t *testing.T) {
+	r := BatchRecord{
+		Version: BatchRecordVersion,
+		Time:    time.Unix(1700000000, 0).UTC(),
+		Trigger: "size",
+		RTT:     Duration(time.Millisecond),
+		Commands: []CommandRecord{
+			{Kind: "HGet", Args: []string{"key", "field"}, Listeners: 3, Status: CommandError, Error: "WRONGTYPE"},
+		},
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, WriteBatchRecord(&buf, r))
+	assert.Nil(t, WriteBatchRecord(&buf, r))
+	assert.Contains(t, buf.String(), `"rtt":"1ms"`)
+
+	d := json.NewDecoder(&buf)
+	for i := 0; i < 2; i++ {
+		got, err := ReadBatchRecord(d)
+		assert.Nil(t, err)
+		assert.Equal(t, r, got)
+	}
+
+	_, err := ReadBatchRecord(json.NewDecoder(strings.NewReader(`{"version":2}`)))
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+}
@@ -0,0 +1,29 @@
+package redis_autopipeline
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// expvarMx serializes lookups and publishing of expvar names, as expvar.Publish panics on duplicate ones
+var expvarMx sync.Mutex
+
+// expvarStats is a value of autopipeline published to expvar, see WithExpvar
+type expvarStats struct {
+	Stats
+	Pending int // number of queued commands, identical commands are counted once
+}
+
+// publishExpvar publishes stats of the autopipeline under the name, which should be unused
+func publishExpvar(name string, a Autopipeline) error {
+	expvarMx.Lock()
+	defer expvarMx.Unlock()
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("%w: %q is published already", ErrInvalidExpvar, name)
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return expvarStats{Stats: a.Stats(), Pending: a.Len()}
+	}))
+	return nil
+}
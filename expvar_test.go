@@ -0,0 +1,48 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestExpvar(t *testing.T) {
+	ctx := context.TODO()
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(&mgetHook{})
+
+	// names are kept by expvar, so every run of the test publishes a name of its own
+	name := fmt.Sprintf("autopipeline_%d", time.Now().UnixNano())
+	c, err := NewAutoPipeline(db, WithCacheTTL(time.Microsecond*100), WithExpvar(name))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, name, c.Config().Expvar)
+
+	read := func() expvarStats {
+		var s expvarStats
+		assert.Nil(t, json.Unmarshal([]byte(expvar.Get(name).String()), &s))
+		return s
+	}
+	c.Pause()
+	first := c.GetAsync(ctx, "key1")
+	second := c.GetAsync(ctx, "key1")
+	assert.Equal(t, 1, read().Pending)
+	c.Resume()
+	<-first
+	<-second
+	assert.Equal(t, "key2", c.Get(ctx, "key2").Val())
+
+	s := read()
+	assert.Equal(t, 0, s.Pending)
+	assert.Equal(t, c.Stats().Pipelines, s.Pipelines)
+	assert.Equal(t, uint64(2), s.Commands)
+	assert.Equal(t, uint64(1), s.DedupHits)
+
+	_, err = NewAutoPipeline(db, WithExpvar(name))
+	assert.ErrorIs(t, err, ErrInvalidExpvar)
+}
@@ -0,0 +1,35 @@
+package redis_autopipeline
+
+import (
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// failoverErrorPrefixes are prefixes of errors, which redis replies without executing the command
+// while master is switched over, f.e. by Sentinel
+var failoverErrorPrefixes = []string{"READONLY", "LOADING", "MASTERDOWN"}
+
+// failoverError reports whether err is replied by redis during failover, so the command may be executed again
+func failoverError(err error) bool {
+	for _, prefix := range failoverErrorPrefixes {
+		if redis.HasErrorPrefix(err, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// failingOver reports whether flushing is paused after failover errors, see WithFailoverPause,
+// failover state is used by background goroutine only
+func (c *cache) failingOver(now time.Time) bool {
+	return now.Before(c.failoverUntil)
+}
+
+// failover pauses flushing for failoverPause since now, every failover error of a flush extends the pause
+func (c *cache) failover() {
+	now := c.clock.Now()
+	if !c.failingOver(now) {
+		c.stats.failover()
+	}
+	c.failoverUntil = now.Add(c.failoverPause)
+}
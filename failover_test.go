@@ -0,0 +1,103 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// replyError is an error replied by redis
+type replyError string
+
+func (e replyError) Error() string { return string(e) }
+
+func (replyError) RedisError() {}
+
+// failoverHook fails commands of the first pipelines with READONLY error, as a demoted master does,
+// next ones are completed by mgetHook
+type failoverHook struct {
+	mgetHook
+	fails atomic.Int32
+}
+
+func (h *failoverHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	process := h.mgetHook.ProcessPipelineHook(next)
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if h.fails.Add(-1) < 0 {
+			return process(ctx, cmds)
+		}
+		err := replyError("READONLY You can't write against a read only replica.")
+		for _, cmd := range cmds {
+			cmd.SetErr(err)
+		}
+		return err
+	}
+}
+
+func TestFailoverError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "readonly", err: replyError("READONLY You can't write against a read only replica."), want: true},
+		{name: "loading", err: replyError("LOADING Redis is loading the dataset in memory"), want: true},
+		{name: "master down", err: replyError("MASTERDOWN Link with MASTER is down"), want: true},
+		{name: "wrong type", err: replyError("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{name: "not replied by redis", err: errors.New("READONLY")},
+		{name: "nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, failoverError(tt.err))
+		})
+	}
+}
+
+func TestFailoverPause(t *testing.T) {
+	ctx := context.TODO()
+	hook := &failoverHook{}
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(hook)
+
+	// without pause commands fail right away
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithLogger(&errorRecorder{}))
+	assert.Nil(t, err)
+	hook.fails.Store(1)
+	assert.ErrorContains(t, c.Del(ctx, "key1").Err(), "READONLY")
+	c.Stop()
+
+	letters := &deadLetters{}
+	c, err = NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithOperationTTL(200*time.Millisecond),
+		WithFailoverPause(20*time.Millisecond),
+		WithDeadLetterHook(letters.hook),
+		WithLogger(&errorRecorder{}))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, Duration(20*time.Millisecond), c.Config().FailoverPause)
+
+	// mutating command is executed against the new master after the pause
+	hook.fails.Store(1)
+	start := time.Now()
+	assert.Equal(t, int64(1), c.Del(ctx, "key1").Val())
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.Failovers)
+	assert.Equal(t, uint64(1), stats.Requeues)
+
+	// commands are kept until they're expired
+	hook.fails.Store(1000)
+	assert.ErrorIs(t, c.Del(ctx, "key2").Err(), ErrOperationExpired)
+	got := letters.get()
+	assert.Len(t, got, 1)
+	assert.Equal(t, "Del", got[0].Kind)
+	assert.Equal(t, 0, c.Len())
+}
@@ -0,0 +1,121 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// closedCh is a channel, which is returned by Done of futures which were never queued
+var closedCh = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// Future is a result of queued redis command, which is ready once Done channel is closed,
+// it's an alternative to raw channels of *Async methods without manual handling of closed channels
+type Future struct {
+	h *Handle
+}
+
+func newFuture(h *Handle) *Future {
+	return &Future{h: h}
+}
+
+// Await blocks until redis command is executed or ctx is done, and returns the command with its error,
+// the command may be cast to the proper type, f.e. *redis.IntCmd for HDelFuture
+func (f *Future) Await(ctx context.Context) (redis.Cmder, error) {
+	res, err := f.h.Await(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cmd := res.(redis.Cmder)
+	return cmd, cmd.Err()
+}
+
+// Done returns a channel, which is closed once the result is ready
+func (f *Future) Done() <-chan struct{} {
+	if f.h.err != nil {
+		return closedCh
+	}
+	return f.h.op.done
+}
+
+// Err returns the error of redis command once the result is ready, or the reason why it wasn't queued,
+// it returns nil while the result is not ready
+func (f *Future) Err() error {
+	select {
+	case <-f.Done():
+	default:
+		return nil
+	}
+	if f.h.err != nil {
+		return f.h.err
+	}
+	return f.h.op.result.(redis.Cmder).Err()
+}
+
+func (a Autopipeline) HDelFuture(ctx context.Context, key string, fields ...string) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, HDel, transformHDel(key, fields...)))
+}
+
+func (a Autopipeline) ExpireFuture(ctx context.Context, key string, expiration time.Duration) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, Expire, transformExpire(key, expiration)))
+}
+
+func (a Autopipeline) HGetFuture(ctx context.Context, key, field string) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, HGet, transformHGet(key, field)))
+}
+
+func (a Autopipeline) HGetAllFuture(ctx context.Context, key string) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, HGetAll, transformHGetAll(key)))
+}
+
+func (a Autopipeline) GetFuture(ctx context.Context, key string) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, Get, transformGet(key)))
+}
+
+func (a Autopipeline) DelFuture(ctx context.Context, keys ...string) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, Del, transformDel(keys...)))
+}
+
+func (a Autopipeline) SMembersFuture(ctx context.Context, key string) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, SMembers, transformSMembers(key)))
+}
+
+func (a Autopipeline) MGetFuture(ctx context.Context, keys ...string) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, MGet, transformMGet(keys...)))
+}
+
+func (a Autopipeline) EvalROFuture(ctx context.Context, script string, keys []string, args ...interface{}) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, EvalRO, transformScript(script, keys, args...)))
+}
+
+func (a Autopipeline) FCallROFuture(ctx context.Context, function string, keys []string, args ...interface{}) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, FCallRO, transformScript(function, keys, args...)))
+}
+
+func (a Autopipeline) ExistsFuture(ctx context.Context, keys ...string) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, Exists, transformExists(keys...)))
+}
+
+func (a Autopipeline) TimeFuture(ctx context.Context) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, Time, transformTime()))
+}
+
+func (a Autopipeline) EchoFuture(ctx context.Context, message interface{}) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, Echo, transformEcho(message)))
+}
+
+func (a Autopipeline) SafeDelFuture(ctx context.Context, key, expectedType string) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, SafeDel, transformSafeDel(key, expectedType)))
+}
+
+func (a Autopipeline) SetFuture(ctx context.Context, key string, value interface{}, expiration time.Duration) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, Set, transformSet(key, value, expiration)))
+}
+
+func (a Autopipeline) DoFuture(ctx context.Context, args ...interface{}) *Future {
+	return newFuture(a.cache.enqueueHandle(ctx, Do, transformDo(args...)))
+}
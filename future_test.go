@@ -0,0 +1,74 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFutureAwait(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHDel("key", "f1").SetVal(1)
+	mock.ExpectGet("missing").RedisNil()
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	f1 := c.HDelFuture(ctx, "key", "f1")
+	cmd, err := f1.Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), cmd.(*redis.IntCmd).Val())
+	assert.Nil(t, f1.Err())
+
+	// error of redis command is returned by Await and Err
+	f2 := c.GetFuture(ctx, "missing")
+	<-f2.Done()
+	assert.ErrorIs(t, f2.Err(), redis.Nil)
+	cmd, err = f2.Await(ctx)
+	assert.ErrorIs(t, err, redis.Nil)
+	assert.IsType(t, &redis.StringCmd{}, cmd)
+}
+
+func TestFutureNotReady(t *testing.T) {
+	db, _ := redismock.NewClientMock()
+
+	c, err := NewAutoPipeline(db)
+	assert.Nil(t, err)
+	c.Pause()
+
+	f := c.GetFuture(context.TODO(), "key")
+	assert.Nil(t, f.Err())
+	select {
+	case <-f.Done():
+		t.Fatal("future is done while autopipeline is paused")
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond)
+	defer cancel()
+	_, err = f.Await(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestFutureStopped(t *testing.T) {
+	var ctx = context.TODO()
+	db, _ := redismock.NewClientMock()
+
+	c, err := NewAutoPipeline(db)
+	assert.Nil(t, err)
+	c.Stop()
+
+	f := c.TimeFuture(ctx)
+	<-f.Done()
+	assert.ErrorIs(t, f.Err(), ErrCacheStopped)
+	_, err = f.Await(ctx)
+	assert.ErrorIs(t, err, ErrCacheStopped)
+}
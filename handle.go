@@ -0,0 +1,116 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"time"
+)
+
+// AwaitClient is an await-only mode of the Client
+// its methods return a Handle instead of a channel, results are delivered only when Handle.Await is called,
+// so abandoned handles are simply garbage collected
+type AwaitClient interface {
+	HDel(ctx context.Context, key string, fields ...string) *Handle
+	Expire(ctx context.Context, key string, expiration time.Duration) *Handle
+	HGet(ctx context.Context, key, field string) *Handle
+	HGetAll(ctx context.Context, key string) *Handle
+	Get(ctx context.Context, key string) *Handle
+	Del(ctx context.Context, keys ...string) *Handle
+	SMembers(ctx context.Context, key string) *Handle
+	MGet(ctx context.Context, keys ...string) *Handle
+	EvalRO(ctx context.Context, script string, keys []string, args ...interface{}) *Handle
+	FCallRO(ctx context.Context, function string, keys []string, args ...interface{}) *Handle
+	Exists(ctx context.Context, keys ...string) *Handle
+	Time(ctx context.Context) *Handle
+	Echo(ctx context.Context, message interface{}) *Handle
+	SafeDel(ctx context.Context, key, expectedType string) *Handle
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *Handle
+	Do(ctx context.Context, args ...interface{}) *Handle
+}
+
+// Handle is a lightweight reference to the queued redis command
+type Handle struct {
+	op  *redisOperation // queued operation, nil if command wasn't queued
+	err error           // reason why command wasn't queued
+}
+
+// Await blocks until result of redis command is ready or ctx is done,
+// result should be cast to proper redis command type, same as for *Async methods of Client
+func (h *Handle) Await(ctx context.Context) (interface{}, error) {
+	if h.err != nil {
+		return nil, h.err
+	}
+	select {
+	case <-h.op.done:
+		return h.op.result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type awaitClient struct {
+	cache *cache
+}
+
+func (a awaitClient) HDel(ctx context.Context, key string, fields ...string) *Handle {
+	return a.cache.enqueueHandle(ctx, HDel, transformHDel(key, fields...))
+}
+
+func (a awaitClient) Expire(ctx context.Context, key string, expiration time.Duration) *Handle {
+	return a.cache.enqueueHandle(ctx, Expire, transformExpire(key, expiration))
+}
+
+func (a awaitClient) HGet(ctx context.Context, key, field string) *Handle {
+	return a.cache.enqueueHandle(ctx, HGet, transformHGet(key, field))
+}
+
+func (a awaitClient) HGetAll(ctx context.Context, key string) *Handle {
+	return a.cache.enqueueHandle(ctx, HGetAll, transformHGetAll(key))
+}
+
+func (a awaitClient) Get(ctx context.Context, key string) *Handle {
+	return a.cache.enqueueHandle(ctx, Get, transformGet(key))
+}
+
+func (a awaitClient) Del(ctx context.Context, keys ...string) *Handle {
+	return a.cache.enqueueHandle(ctx, Del, transformDel(keys...))
+}
+
+func (a awaitClient) SMembers(ctx context.Context, key string) *Handle {
+	return a.cache.enqueueHandle(ctx, SMembers, transformSMembers(key))
+}
+
+func (a awaitClient) MGet(ctx context.Context, keys ...string) *Handle {
+	return a.cache.enqueueHandle(ctx, MGet, transformMGet(keys...))
+}
+
+func (a awaitClient) EvalRO(ctx context.Context, script string, keys []string, args ...interface{}) *Handle {
+	return a.cache.enqueueHandle(ctx, EvalRO, transformScript(script, keys, args...))
+}
+
+func (a awaitClient) FCallRO(ctx context.Context, function string, keys []string, args ...interface{}) *Handle {
+	return a.cache.enqueueHandle(ctx, FCallRO, transformScript(function, keys, args...))
+}
+
+func (a awaitClient) Exists(ctx context.Context, keys ...string) *Handle {
+	return a.cache.enqueueHandle(ctx, Exists, transformExists(keys...))
+}
+
+func (a awaitClient) Time(ctx context.Context) *Handle {
+	return a.cache.enqueueHandle(ctx, Time, transformTime())
+}
+
+func (a awaitClient) Echo(ctx context.Context, message interface{}) *Handle {
+	return a.cache.enqueueHandle(ctx, Echo, transformEcho(message))
+}
+
+func (a awaitClient) SafeDel(ctx context.Context, key, expectedType string) *Handle {
+	return a.cache.enqueueHandle(ctx, SafeDel, transformSafeDel(key, expectedType))
+}
+
+func (a awaitClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *Handle {
+	return a.cache.enqueueHandle(ctx, Set, transformSet(key, value, expiration))
+}
+
+func (a awaitClient) Do(ctx context.Context, args ...interface{}) *Handle {
+	return a.cache.enqueueHandle(ctx, Do, transformDo(args...))
+}
@@ -0,0 +1,82 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHandleAwait(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key").SetVal("john")
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	h1 := c.AwaitOnly().Get(ctx, "key")
+	h2 := c.AwaitOnly().Get(ctx, "key")
+	assert.Equal(t, 2, c.Pending())
+
+	res, err := h1.Await(ctx)
+	assert.Nil(t, err)
+	r, err := res.(*redis.StringCmd).Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "john", r)
+
+	// second handle is served by the same command
+	res, err = h2.Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "john", res.(*redis.StringCmd).Val())
+	assert.Equal(t, 0, c.Pending())
+}
+
+func TestHandleAbandoned(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectDel("key").SetVal(1)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	// nobody awaits the result, but it doesn't block the cache
+	c.AwaitOnly().Del(ctx, "key")
+	drainCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	assert.Nil(t, c.Drain(drainCtx))
+}
+
+func TestHandleAwaitContextDone(t *testing.T) {
+	var ctx = context.TODO()
+	db, _ := redismock.NewClientMock()
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Second),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	awaitCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	_, err = c.AwaitOnly().Get(ctx, "key").Await(awaitCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestHandleCacheStopped(t *testing.T) {
+	var ctx = context.TODO()
+	db, _ := redismock.NewClientMock()
+
+	c, err := NewAutoPipeline(db)
+	assert.Nil(t, err)
+	c.Stop()
+
+	_, err = c.AwaitOnly().Get(ctx, "key").Await(ctx)
+	assert.ErrorIs(t, err, ErrCacheStopped)
+}
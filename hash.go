@@ -1,21 +1,37 @@
 package redis_autopipeline
 
 import (
-	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
+	"github.com/redis/go-redis/v9"
 )
 
-const hashDelimiter = ","
+// hashPayload returns unique hash for the redisOperation with the operation payload
+func hashPayload(operation operationPrefix, p payload) string {
+	return hashArgs(operation, payloadArgs(p))
+}
 
-// hashStringSlice returns unique hash for the redisOperation with the operation arguments
-func hashStringSlice(operation operationPrefix, args []string) string {
-	var buffer bytes.Buffer
-	buffer.WriteByte(byte(operation))
+// hashArgs returns unique hash for the redisOperation with the operation arguments,
+// every argument is prefixed by its length, so arguments containing any bytes can't be mixed up,
+// f.e. ["a,b"] and ["a", "b"] or ["ab", ""] and ["a", "b"]
+func hashArgs(operation operationPrefix, args []string) string {
+	size := 1 + len(args)*binary.MaxVarintLen64
+	for _, s := range args {
+		size += len(s)
+	}
+	buffer := make([]byte, 0, size)
+	buffer = append(buffer, byte(operation))
 	for _, s := range args {
-		buffer.WriteString(s)
-		buffer.WriteString(hashDelimiter)
+		buffer = binary.AppendUvarint(buffer, uint64(len(s)))
+		buffer = append(buffer, s...)
 	}
-	hash := sha256.Sum256([]byte(buffer.String()))
+	hash := sha256.Sum256(buffer)
 	return fmt.Sprintf("%x", hash)
 }
+
+// hashCmd returns unique hash for the pre-built redis command, identical commands are distinct,
+// as each of them is completed in place
+func hashCmd(cmd redis.Cmder) string {
+	return hashArgs(Cmd, []string{fmt.Sprintf("%p", cmd)})
+}
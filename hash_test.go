@@ -1,11 +1,13 @@
 package redis_autopipeline
 
 import (
+	"context"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
-func TestHashStringSlice(t *testing.T) {
+func TestHashArgs(t *testing.T) {
 	tests := []struct {
 		name     string
 		in       []string
@@ -14,22 +16,50 @@ func TestHashStringSlice(t *testing.T) {
 		{
 			"OK: a,b,c",
 			[]string{"a", "b", "c"},
-			"6b23e5291e4989b8a7684eafdee18b55b0a2cab016d4a42a3feb7d70157c8a4f",
+			"a5354ee19e9c6d8e5958950c9fbe9f75eebd53cc2fc96a3a5e97f1767900d951",
 		},
 		{
 			"OK: abc",
 			[]string{"abc"},
-			"5b14979300b6bec9418599a4f0b5770cb99c4f20ae4914a178f0e5025a3d925e",
+			"a18bb1cc0780ac9b8a305ebfe12654668d0280f25f39b89e6362e89bf76e3204",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := hashStringSlice(HDel, tt.in)
+			got := hashArgs(HDel, tt.in)
 			assert.Equal(t, tt.expected, got)
-			got2 := hashStringSlice(Del, tt.in)
+			got2 := hashArgs(Del, tt.in)
 			assert.NotEqual(t, got, got2)
 		})
 
 	}
 }
+
+func TestHashArgsCollisions(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []string
+		b    []string
+	}{
+		{"delimiter in argument", []string{"a,b"}, []string{"a", "b"}},
+		{"trailing delimiter", []string{"a,"}, []string{"a"}},
+		{"empty argument", []string{"ab", ""}, []string{"a", "b"}},
+		{"empty arguments", []string{""}, []string{}},
+		{"shifted boundary", []string{"a", "bc"}, []string{"ab", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NotEqual(t, hashArgs(HDel, tt.a), hashArgs(HDel, tt.b))
+		})
+	}
+}
+
+func TestHashCmd(t *testing.T) {
+	ctx := context.TODO()
+	cmd1 := redis.NewIntCmd(ctx, "strlen", "key")
+	cmd2 := redis.NewIntCmd(ctx, "strlen", "key")
+	assert.Equal(t, hashCmd(cmd1), hashCmd(cmd1))
+	assert.NotEqual(t, hashCmd(cmd1), hashCmd(cmd2))
+}
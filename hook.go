@@ -0,0 +1,136 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"strings"
+	"time"
+)
+
+// processHooks chains ProcessHook of hooks around next, the first hook is the outermost one, as go-redis does
+func processHooks(hooks []redis.Hook, next redis.ProcessHook) redis.ProcessHook {
+	for i := len(hooks) - 1; i >= 0; i-- {
+		next = hooks[i].ProcessHook(next)
+	}
+	return next
+}
+
+// hookCmd returns a command passed to hooks for the logical command, it has the name and arguments of it,
+// pre-built command is passed as is
+func hookCmd(ctx context.Context, kind operationPrefix, p payload) redis.Cmder {
+	switch p := p.(type) {
+	case cmdPayload:
+		return p.cmd
+	case opaquePayload:
+		if kind == Do {
+			return redis.NewCmd(ctx, interfaceArgs(p)...)
+		}
+	case expirePayload:
+		// expiration is sent in seconds, as go-redis does
+		return redis.NewCmd(ctx, "expire", p.key, int64(p.expiration/time.Second))
+	}
+	return redis.NewCmd(ctx, interfaceArgs(append([]string{strings.ToLower(kind.String())}, payloadArgs(p)...))...)
+}
+
+// resultErr returns the error of the command delivered as a result
+func resultErr(result interface{}) error {
+	if cmd, ok := result.(redis.Cmder); ok {
+		return cmd.Err()
+	}
+	return nil
+}
+
+// runHooks runs command hooks around the logical command with ctx of the caller, wait returns its result,
+// once it's delivered, result is nil if hooks failed the command without calling next
+func (c *cache) runHooks(ctx context.Context, kind operationPrefix, p payload, wait func() (interface{}, error)) (interface{}, error) {
+	cmd := hookCmd(ctx, kind, p)
+	var result interface{}
+	err := processHooks(c.hooks, func(context.Context, redis.Cmder) error {
+		var err error
+		if result, err = wait(); err != nil {
+			return err
+		}
+		err = resultErr(result)
+		if _, ok := p.(cmdPayload); !ok {
+			cmd.SetErr(err)
+		}
+		return err
+	})(ctx, cmd)
+	return result, err
+}
+
+// hooked runs command hooks around the logical command queued with the listener channel in a goroutine,
+// see WithCommandHooks, the result is forwarded to the returned channel once hooks return,
+// listener channel is returned as is without hooks
+func (c *cache) hooked(ctx context.Context, kind operationPrefix, p payload, listener chan interface{}) chan interface{} {
+	if len(c.hooks) == 0 {
+		return listener
+	}
+	resultCh := make(chan interface{}, resultChannelBufferSize)
+	// listener is removed from the operation, if caller abandons the result channel, see abandon
+	c.forwards.Store((<-chan interface{})(resultCh), (<-chan interface{})(listener))
+	go func() {
+		defer close(resultCh)
+		defer c.forwards.Delete((<-chan interface{})(resultCh))
+		defer c.dumpOnPanic()
+		result, err := c.runHooks(ctx, kind, p, func() (interface{}, error) {
+			result, ok := <-listener
+			if !ok {
+				return nil, ErrChannelClosed
+			}
+			return result, nil
+		})
+		switch {
+		case result != nil:
+			resultCh <- result
+		case errors.Is(err, ErrChannelClosed):
+		case kind == Cmd:
+			// pre-built command is completed in place, so it's awaited before it's failed by hooks
+			<-listener
+			cmd := p.(cmdPayload).cmd
+			cmd.SetErr(err)
+			resultCh <- cmd
+		default:
+			// command failed by hooks isn't executed, unless its pipeline is gathered already
+			c.abandon(ctx, listener)
+			resultCh <- c.failedCmd(kind, p, err)
+		}
+	}()
+	return resultCh
+}
+
+// hookedHandle runs command hooks around the logical command queued with the handle in a goroutine,
+// result of handle is delivered to the caller regardless of hooks
+func (c *cache) hookedHandle(ctx context.Context, kind operationPrefix, p payload, h *Handle) *Handle {
+	if len(c.hooks) == 0 {
+		return h
+	}
+	go func() {
+		defer c.dumpOnPanic()
+		_, _ = c.runHooks(ctx, kind, p, func() (interface{}, error) {
+			if h.err != nil {
+				return nil, h.err
+			}
+			<-h.op.done
+			return h.op.result, nil
+		})
+	}()
+	return h
+}
+
+// hookedBatch runs command hooks around every command of the batch, see hookedHandle
+func (c *cache) hookedBatch(ctx context.Context, cmds []batchCommand, handles []*Handle) []*Handle {
+	for i, cmd := range cmds {
+		handles[i] = c.hookedHandle(ctx, cmd.kind, cmd.payload, handles[i])
+	}
+	return handles
+}
+
+// forwarded returns the listener channel, which result is forwarded to the channel of caller, see hooked
+func (c *cache) forwarded(ch <-chan interface{}) <-chan interface{} {
+	if listener, ok := c.forwards.Load(ch); ok {
+		return listener.(<-chan interface{})
+	}
+	return ch
+}
@@ -0,0 +1,124 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type hookCtxKey struct{}
+
+// commandsHook records commands seen by ProcessHook with value of ctx, commands are failed with err
+// without calling next, if it's set
+type commandsHook struct {
+	name string
+	err  error
+	mx   sync.Mutex
+	seen []string
+}
+
+func (h *commandsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *commandsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		var err error
+		if h.err != nil {
+			err = h.err
+		} else {
+			err = next(ctx, cmd)
+		}
+		h.mx.Lock()
+		defer h.mx.Unlock()
+		h.seen = append(h.seen, fmt.Sprintf("%s %v %v %v", h.name, cmd.Args(), ctx.Value(hookCtxKey{}), err))
+		return err
+	}
+}
+
+func (h *commandsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func (h *commandsHook) get() []string {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	return append([]string(nil), h.seen...)
+}
+
+func TestCommandHooks(t *testing.T) {
+	ctx := context.WithValue(context.TODO(), hookCtxKey{}, "caller")
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(&mgetHook{})
+
+	outer, inner := &commandsHook{name: "outer"}, &commandsHook{name: "inner"}
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithCommandHooks(outer, inner))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	// hooks return before the result is delivered
+	assert.Equal(t, "key1", c.Get(ctx, "key1").Val())
+	assert.Equal(t, []string{"inner [get key1] caller <nil>", "outer [get key1] caller <nil>"},
+		append(inner.get(), outer.get()...))
+	assert.ErrorIs(t, c.HGet(ctx, "missing", "name").Err(), redis.Nil)
+	assert.Equal(t, "inner [hget missing name] caller redis: nil", inner.get()[1])
+	assert.Nil(t, c.Do(ctx, "del", "key1", "key2").Err())
+	assert.Equal(t, "inner [del key1 key2] caller <nil>", inner.get()[2])
+	cmd := redis.NewStringCmd(ctx, "get", "key3")
+	<-c.EnqueueCmd(ctx, cmd)
+	assert.Equal(t, "key3", cmd.Val())
+	assert.Equal(t, "inner [get key3] caller <nil>", inner.get()[3])
+
+	// hooks run around commands of futures regardless of their results
+	results := c.Group(ctx, func(b *Batch) {
+		b.Get("key4")
+		b.Expire("key4", time.Minute)
+	})
+	res, err := results[0].Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "key4", res.(*redis.StringCmd).Val())
+	assert.Eventually(t, func() bool {
+		return len(inner.get()) == 6
+	}, time.Second, time.Millisecond)
+	assert.ElementsMatch(t, []string{"inner [get key4] caller <nil>", "inner [expire key4 60] caller <nil>"}, inner.get()[4:])
+}
+
+func TestCommandHooksFailure(t *testing.T) {
+	ctx := context.TODO()
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(&mgetHook{})
+
+	// command is failed by hook
+	hook := &commandsHook{err: errors.New("circuit is open")}
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithCommandHooks(hook))
+	assert.Nil(t, err)
+	assert.EqualError(t, c.Get(ctx, "key1").Err(), "circuit is open")
+	cmd := redis.NewStringCmd(ctx, "get", "key2")
+	<-c.EnqueueCmd(ctx, cmd)
+	assert.EqualError(t, cmd.Err(), "circuit is open")
+	c.Stop()
+
+	// abandoned command is removed from the cache
+	hook = &commandsHook{}
+	c, err = NewAutoPipeline(db,
+		WithCommandTimeout(time.Millisecond),
+		WithCommandHooks(hook))
+	assert.Nil(t, err)
+	defer c.Stop()
+	c.Pause()
+	assert.ErrorIs(t, c.Get(ctx, "key1").Err(), ErrCommandTimeout)
+	assert.Equal(t, 0, c.Len())
+	assert.Eventually(t, func() bool {
+		got := hook.get()
+		return len(got) == 1 && got[0] == " [get key1] <nil> "+ErrChannelClosed.Error()
+	}, time.Second, time.Millisecond)
+}
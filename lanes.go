@@ -0,0 +1,29 @@
+package redis_autopipeline
+
+// lane returns the cache, which commands of the kind are queued to: mutating commands go to the write lane
+// if it's enabled, see WithWriteLane, pre-built commands and custom operations are considered mutating
+func (c *cache) lane(kind operationPrefix) *cache {
+	if c.writes != nil && !kind.readOnly() {
+		return c.writes
+	}
+	return c
+}
+
+// batchLane returns the cache, which the batch is queued to, batch isn't split between lanes,
+// so a batch with any mutating command goes to the write lane
+func (c *cache) batchLane(cmds []batchCommand) *cache {
+	for _, cmd := range cmds {
+		if lane := c.lane(cmd.kind); lane != c {
+			return lane
+		}
+	}
+	return c
+}
+
+// lanes returns caches of all lanes, the read lane, which is the only one if lanes are disabled, goes first
+func (c *cache) lanes() []*cache {
+	if c.writes == nil {
+		return []*cache{c}
+	}
+	return []*cache{c, c.writes}
+}
@@ -0,0 +1,84 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLane(t *testing.T) {
+	db := redis.NewClient(&redis.Options{})
+	c, err := NewAutoPipeline(db, WithWriteLane(time.Millisecond, 100))
+	assert.Nil(t, err)
+	defer c.Stop()
+	reads := c.(*Autopipeline).cache
+	writes := reads.writes
+	assert.NotNil(t, writes)
+	assert.Equal(t, []*cache{reads, writes}, reads.lanes())
+	assert.Same(t, reads.stats, writes.stats)
+
+	tests := []struct {
+		name string
+		kind operationPrefix
+		want *cache
+	}{
+		{name: "get", kind: Get, want: reads},
+		{name: "hgetall", kind: HGetAll, want: reads},
+		{name: "del", kind: Del, want: writes},
+		{name: "expire", kind: Expire, want: writes},
+		{name: "do", kind: Do, want: writes},
+		{name: "cmd", kind: Cmd, want: writes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Same(t, tt.want, reads.lane(tt.kind))
+		})
+	}
+	assert.Same(t, reads, reads.batchLane([]batchCommand{{kind: Get}, {kind: MGet}}))
+	assert.Same(t, writes, reads.batchLane([]batchCommand{{kind: Get}, {kind: Del}}))
+
+	// lanes are disabled by default
+	c, err = NewAutoPipeline(db)
+	assert.Nil(t, err)
+	defer c.Stop()
+	single := c.(*Autopipeline).cache
+	assert.Same(t, single, single.lane(Del))
+	assert.Equal(t, []*cache{single}, single.lanes())
+}
+
+func TestWriteLane(t *testing.T) {
+	var ctx = context.TODO()
+	hook := &mgetHook{}
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(hook)
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithWriteLane(time.Hour, 1))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, Duration(time.Hour), c.Config().WriteLaneTTL)
+	assert.Equal(t, uint(1), c.Config().WriteLaneMaxSize)
+
+	// reads aren't delayed by pending writes
+	delCh := c.DelAsync(ctx, "key1")
+	assert.Equal(t, "key2", c.Get(ctx, "key2").Val())
+	assert.Equal(t, 1, c.Len())
+	assert.Equal(t, 1, c.Pending())
+	assert.Len(t, c.DumpPending(), 1)
+	assert.Len(t, delCh, 0)
+
+	// writes are flushed by thresholds of write lane
+	otherCh := c.DelAsync(ctx, "key3")
+	assert.Equal(t, int64(1), (<-delCh).(*redis.IntCmd).Val())
+	assert.Equal(t, int64(1), (<-otherCh).(*redis.IntCmd).Val())
+	assert.Equal(t, 0, c.Len())
+	assert.Equal(t, uint64(2), c.Stats().Pipelines)
+
+	// pending writes are executed on stop
+	delCh = c.DelAsync(ctx, "key1")
+	c.Stop()
+	assert.False(t, c.IsRunning())
+	assert.Equal(t, int64(1), (<-delCh).(*redis.IntCmd).Val())
+}
@@ -0,0 +1,135 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// slowPipelineFactor is a ratio of execution time to the rolling RTT estimate, which pipeline is logged as slow at
+	slowPipelineFactor = 4
+	// slowPipelineMin is a min execution time of pipeline logged as slow, so jitter of fast pipelines isn't logged
+	slowPipelineMin = time.Millisecond
+)
+
+// debugLogger, infoLogger and warnLogger are optional extensions of Logger, see LeveledLogger
+type debugLogger interface {
+	Debug(args ...interface{})
+}
+
+type infoLogger interface {
+	Info(args ...interface{})
+}
+
+type warnLogger interface {
+	Warn(args ...interface{})
+}
+
+// supports reports whether l logs messages of the level, plain Logger logs errors only
+func supports(l Logger, level LogLevel) bool {
+	switch level {
+	case LogDebug:
+		_, ok := l.(debugLogger)
+		return ok
+	case LogInfo:
+		_, ok := l.(infoLogger)
+		return ok
+	case LogWarn:
+		_, ok := l.(warnLogger)
+		return ok
+	}
+	return true
+}
+
+// logAt logs args by l at the level, if the level isn't lower than min and l supports it
+func logAt(l Logger, min, level LogLevel, args ...interface{}) {
+	if level < min {
+		return
+	}
+	switch level {
+	case LogDebug:
+		if d, ok := l.(debugLogger); ok {
+			d.Debug(args...)
+		}
+	case LogInfo:
+		if i, ok := l.(infoLogger); ok {
+			i.Info(args...)
+		}
+	case LogWarn:
+		if w, ok := l.(warnLogger); ok {
+			w.Warn(args...)
+		}
+	default:
+		l.Error(args...)
+	}
+}
+
+// logEnabled reports whether messages of the level are logged, so they aren't composed in vain
+func (c *cache) logEnabled(level LogLevel) bool {
+	if level < c.logLevel {
+		return false
+	}
+	if l, ok := c.log.(slogLogger); ok {
+		return l.l.Enabled(context.Background(), slogLevels[level])
+	}
+	return supports(c.log, level)
+}
+
+// slowPipeline reports whether pipeline executed for exec is slow compared to the rolling RTT estimate
+func slowPipeline(exec, smoothedRTT time.Duration) bool {
+	return smoothedRTT > 0 && exec >= slowPipelineMin && exec > slowPipelineFactor*smoothedRTT
+}
+
+// composition returns numbers of commands by operation, f.e. "Del: 2, Get: 10"
+func composition(kinds map[operationPrefix]int) string {
+	parts := make([]string, 0, len(kinds))
+	for kind, n := range kinds {
+		parts = append(parts, fmt.Sprintf("%s: %d", kind, n))
+	}
+	slices.Sort(parts)
+	return strings.Join(parts, ", ")
+}
+
+// logFlush logs the flush decision and composition of the batch at debug level
+func (c *cache) logFlush(trigger flushTrigger, kinds map[operationPrefix]int, pipelines int) {
+	commands := 0
+	for _, n := range kinds {
+		commands += n
+	}
+	if l, ok := c.log.(slogLogger); ok {
+		l.flush(trigger, commands, pipelines, composition(kinds))
+		return
+	}
+	logAt(c.log, c.logLevel, LogDebug, fmt.Sprintf("flush by %s: %d commands in %d pipelines (%s)",
+		trigger, commands, pipelines, composition(kinds)))
+}
+
+// logPipeline logs executed pipeline at debug level, slow one at warn level and failed one at error level
+func (c *cache) logPipeline(trigger flushTrigger, commands int, exec, smoothedRTT time.Duration, err error) {
+	level := LogDebug
+	switch {
+	case err != nil:
+		level = LogError
+	case slowPipeline(exec, smoothedRTT):
+		level = LogWarn
+	}
+	if level < c.logLevel {
+		return
+	}
+	if l, ok := c.log.(slogLogger); ok {
+		l.pipeline(level, trigger, commands, exec, smoothedRTT, err)
+		return
+	}
+	switch level {
+	case LogError:
+		c.log.Error(err)
+	case LogWarn:
+		logAt(c.log, c.logLevel, level, fmt.Sprintf("slow pipeline of %d commands by %s executed in %s, smoothed rtt is %s",
+			commands, trigger, exec, smoothedRTT))
+	default:
+		logAt(c.log, c.logLevel, level, fmt.Sprintf("pipeline of %d commands by %s executed in %s", commands, trigger, exec))
+	}
+}
@@ -0,0 +1,151 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// leveledRecorder records messages of LeveledLogger prefixed by their levels, f.e. "warn slow pipeline ..."
+type leveledRecorder struct {
+	mx       sync.Mutex
+	messages []string
+}
+
+func (l *leveledRecorder) add(level string, args ...interface{}) {
+	l.mx.Lock()
+	l.messages = append(l.messages, level+" "+fmt.Sprint(args...))
+	l.mx.Unlock()
+}
+
+func (l *leveledRecorder) get() []string {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return append([]string(nil), l.messages...)
+}
+
+func (l *leveledRecorder) Debug(args ...interface{}) { l.add("debug", args...) }
+func (l *leveledRecorder) Info(args ...interface{})  { l.add("info", args...) }
+func (l *leveledRecorder) Warn(args ...interface{})  { l.add("warn", args...) }
+func (l *leveledRecorder) Error(args ...interface{}) { l.add("error", args...) }
+
+// slowHook completes commands by mgetHook, pipelines after the first one are executed for delay
+type slowHook struct {
+	mgetHook
+	delay     time.Duration
+	pipelines int
+}
+
+func (h *slowHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	process := h.mgetHook.ProcessPipelineHook(next)
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if h.pipelines++; h.pipelines > 1 {
+			time.Sleep(h.delay)
+		}
+		return process(ctx, cmds)
+	}
+}
+
+func TestSlowPipeline(t *testing.T) {
+	tests := []struct {
+		name        string
+		exec        time.Duration
+		smoothedRTT time.Duration
+		want        bool
+	}{
+		{name: "slow", exec: 5 * time.Millisecond, smoothedRTT: time.Millisecond, want: true},
+		{name: "close to estimate", exec: 3 * time.Millisecond, smoothedRTT: time.Millisecond},
+		{name: "fast", exec: 500 * time.Microsecond, smoothedRTT: 100 * time.Microsecond},
+		{name: "no estimate", exec: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, slowPipeline(tt.exec, tt.smoothedRTT))
+		})
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	ctx := context.TODO()
+
+	tests := []struct {
+		name         string
+		level        LogLevel
+		wantMessages []string
+	}{
+		{
+			name:  "debug",
+			level: LogDebug,
+			wantMessages: []string{
+				"debug flush by ttl: 2 commands in 1 pipelines (Del: 1, Get: 1)",
+				"debug pipeline of 2 commands by ttl executed in",
+				"info autopipeline config changed: ttl 100µs -> 200µs",
+				"debug flush by ttl: 1 commands in 1 pipelines (Get: 1)",
+				"warn slow pipeline of 1 commands by ttl executed in",
+			},
+		},
+		{
+			name:  "info",
+			level: LogInfo,
+			wantMessages: []string{
+				"info autopipeline config changed: ttl 100µs -> 200µs",
+				"warn slow pipeline of 1 commands by ttl executed in",
+			},
+		},
+		{
+			name:  "warn",
+			level: LogWarn,
+			wantMessages: []string{
+				"warn slow pipeline of 1 commands by ttl executed in",
+			},
+		},
+		{
+			name:  "error",
+			level: LogError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := redis.NewClient(&redis.Options{})
+			db.AddHook(&slowHook{delay: 10 * time.Millisecond})
+			logger := &leveledRecorder{}
+			c, err := NewAutoPipeline(db,
+				WithCacheTTL(100*time.Microsecond),
+				WithLogger(logger),
+				WithLogLevel(tt.level))
+			assert.Nil(t, err)
+			defer c.Stop()
+			assert.Equal(t, tt.level, c.Config().LogLevel)
+
+			c.Pause()
+			first, second := c.GetAsync(ctx, "key1"), c.DelAsync(ctx, "key2")
+			c.Resume()
+			<-first
+			<-second
+			c.SetCacheTTL(200 * time.Microsecond)
+			assert.Equal(t, "key3", c.Get(ctx, "key3").Val())
+
+			messages := logger.get()
+			assert.Len(t, messages, len(tt.wantMessages))
+			for i, want := range tt.wantMessages {
+				if i < len(messages) {
+					assert.True(t, strings.HasPrefix(messages[i], want), messages[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPlainLoggerLevels(t *testing.T) {
+	logger := &errorRecorder{}
+	for _, level := range []LogLevel{LogDebug, LogInfo, LogWarn, LogError} {
+		logAt(logger, LogDebug, level, "message")
+	}
+	// plain logger gets errors only
+	assert.Equal(t, []string{"message"}, logger.messages)
+}
@@ -0,0 +1,38 @@
+// Package logruslog adapts logrus logger to the Logger interface of redis_autopipeline,
+// so logrus isn't a dependency of autopipeline itself
+package logruslog
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// Logger is a Logger of autopipeline, which writes to logrus logger, it supports all levels of LeveledLogger, see WithLogLevel
+type Logger struct {
+	l logrus.FieldLogger
+}
+
+// New returns Logger writing to l, *logrus.Logger or *logrus.Entry with fields,
+// f.e. redis_autopipeline.WithLogger(logruslog.New(logrus.WithField("component", "autopipeline")))
+func New(l logrus.FieldLogger) *Logger {
+	return &Logger{l: l}
+}
+
+// Error logs args at error level, spaces are always added between them, as fmt.Println does
+func (l *Logger) Error(args ...interface{}) {
+	l.l.Errorln(args...)
+}
+
+// Debug logs args at debug level, spaces are always added between them, as fmt.Println does
+func (l *Logger) Debug(args ...interface{}) {
+	l.l.Debugln(args...)
+}
+
+// Info logs args at info level, spaces are always added between them, as fmt.Println does
+func (l *Logger) Info(args ...interface{}) {
+	l.l.Infoln(args...)
+}
+
+// Warn logs args at warn level, spaces are always added between them, as fmt.Println does
+func (l *Logger) Warn(args ...interface{}) {
+	l.l.Warnln(args...)
+}
@@ -0,0 +1,32 @@
+package logruslog
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"redis-autopipeline"
+	"testing"
+)
+
+// Logger is passed to WithLogger as leveled one
+var _ redis_autopipeline.LeveledLogger = &Logger{}
+
+func TestLogger(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	l := New(logger.WithField("component", "autopipeline"))
+	l.Error("recovered:", 42)
+	// debug level is disabled by the logger
+	l.Debug("flush by ttl")
+	l.Info("ttl changed")
+	l.Warn("slow pipeline")
+
+	entries := hook.AllEntries()
+	assert.Len(t, entries, 3)
+	assert.Equal(t, logrus.ErrorLevel, entries[0].Level)
+	assert.Equal(t, "recovered: 42", entries[0].Message)
+	assert.Equal(t, "autopipeline", entries[0].Data["component"])
+	assert.Equal(t, logrus.InfoLevel, entries[1].Level)
+	assert.Equal(t, "ttl changed", entries[1].Message)
+	assert.Equal(t, logrus.WarnLevel, entries[2].Level)
+	assert.Equal(t, "slow pipeline", entries[2].Message)
+}
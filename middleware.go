@@ -0,0 +1,126 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"slices"
+)
+
+// ErrKeysRewritten is returned for commands, number of keys of which was changed by a middleware, see Command
+var ErrKeysRewritten = errors.New("number of keys changed by middleware")
+
+// Command is a logical command passed to Handler.Enqueue of middlewares, see WithMiddleware,
+// keys rewritten by a middleware are seen by next middlewares in Keys only, the key prefix is added after all of them
+type Command struct {
+	Name string   // name of operation, f.e. "Get", "Del", "Do" or name of custom operation
+	Args []string // arguments of command as they're passed by the caller, including keys, read-only
+	Keys []string // keys of command, middleware may rewrite them in place, f.e. to namespace keys of a tenant
+}
+
+// Handler handles commands on their way to redis: Enqueue is called by the caller for every logical command
+// before it's queued or executed directly, error fails the command, so it's neither queued nor executed;
+// Exec executes pipelines same as Executor.Exec does, see WithExecutor
+type Handler struct {
+	Enqueue func(ctx context.Context, cmd *Command) error
+	Exec    func(ctx context.Context, pipe redis.Pipeliner) ([]redis.Cmder, error)
+}
+
+// Middleware wraps the next Handler, f.e. for auditing, per-tenant quotas, rewriting of keys or blocking
+// of dangerous commands, nil handlers of the returned Handler are taken from the next one
+type Middleware func(next Handler) Handler
+
+// middlewareChain is an Executor, which executes pipelines by middlewares around executor of the cache,
+// it's shared by lanes, so state of middlewares is shared too
+type middlewareChain struct {
+	Executor
+	handler Handler
+}
+
+func newMiddlewareChain(middlewares []Middleware, e Executor) *middlewareChain {
+	h := Handler{
+		Enqueue: func(context.Context, *Command) error { return nil },
+		Exec:    e.Exec,
+	}
+	// the first middleware is the outermost one
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		next := h
+		h = middlewares[i](next)
+		if h.Enqueue == nil {
+			h.Enqueue = next.Enqueue
+		}
+		if h.Exec == nil {
+			h.Exec = next.Exec
+		}
+	}
+	return &middlewareChain{Executor: e, handler: h}
+}
+
+func (m *middlewareChain) Exec(ctx context.Context, pipe redis.Pipeliner) ([]redis.Cmder, error) {
+	return m.handler.Exec(ctx, pipe)
+}
+
+// enqueue passes the command through middlewares and returns its payload with keys rewritten by them
+func (m *middlewareChain) enqueue(ctx context.Context, kind operationPrefix, p payload) (payload, error) {
+	keys := payloadKeys(p)
+	cmd := &Command{Name: kind.String(), Args: payloadArgs(p), Keys: slices.Clone(keys)}
+	if err := m.handler.Enqueue(ctx, cmd); err != nil {
+		return p, err
+	}
+	if len(cmd.Keys) != len(keys) {
+		return p, fmt.Errorf("%w: %s has %d keys, got %d", ErrKeysRewritten, cmd.Name, len(keys), len(cmd.Keys))
+	}
+	if slices.Equal(cmd.Keys, keys) {
+		return p, nil
+	}
+	return withKeys(p, cmd.Keys), nil
+}
+
+// payloadKeys returns keys of redis command with the payload, arguments of Do, EnqueueCmd and custom operations
+// are not known to be keys, so they have no keys, same as for payloadKey
+func payloadKeys(p payload) []string {
+	switch p := p.(type) {
+	case keyPayload:
+		return []string{p.key}
+	case keysPayload:
+		return p
+	case hdelPayload:
+		return []string{p.key}
+	case hgetPayload:
+		return []string{p.key}
+	case expirePayload:
+		return []string{p.key}
+	case setPayload:
+		return []string{p.key}
+	case scriptPayload:
+		return p.keys
+	case safeDelPayload:
+		return []string{p.key}
+	}
+	return nil
+}
+
+// withKeys returns the payload with its keys replaced by keys of the same number, see payloadKeys,
+// payload itself is never modified
+func withKeys(p payload, keys []string) payload {
+	switch p := p.(type) {
+	case keyPayload:
+		return keyPayload{key: keys[0]}
+	case keysPayload:
+		return keysPayload(keys)
+	case hdelPayload:
+		return hdelPayload{key: keys[0], fields: p.fields}
+	case hgetPayload:
+		return hgetPayload{key: keys[0], field: p.field}
+	case expirePayload:
+		return expirePayload{key: keys[0], expiration: p.expiration}
+	case setPayload:
+		return setPayload{key: keys[0], value: p.value, expiration: p.expiration}
+	case scriptPayload:
+		return scriptPayload{script: p.script, keys: keys, args: p.args}
+	case safeDelPayload:
+		return safeDelPayload{key: keys[0], expectedType: p.expectedType}
+	}
+	return p
+}
@@ -0,0 +1,155 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// auditMiddleware records enqueued commands and executed pipelines prefixed by name of the middleware
+type auditMiddleware struct {
+	mx      sync.Mutex
+	records []string
+}
+
+func (a *auditMiddleware) add(record string) {
+	a.mx.Lock()
+	a.records = append(a.records, record)
+	a.mx.Unlock()
+}
+
+func (a *auditMiddleware) get() []string {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	return append([]string(nil), a.records...)
+}
+
+func (a *auditMiddleware) middleware(name string) Middleware {
+	return func(next Handler) Handler {
+		return Handler{
+			Enqueue: func(ctx context.Context, cmd *Command) error {
+				a.add(name + " " + cmd.Name + " " + strings.Join(cmd.Args, " ") + " " + strings.Join(cmd.Keys, " "))
+				return next.Enqueue(ctx, cmd)
+			},
+			Exec: func(ctx context.Context, pipe redis.Pipeliner) ([]redis.Cmder, error) {
+				a.add(name + " exec")
+				return next.Exec(ctx, pipe)
+			},
+		}
+	}
+}
+
+var errDangerous = errors.New("dangerous command")
+
+// blockDo fails Do commands, it doesn't wrap execution of pipelines
+func blockDo(next Handler) Handler {
+	return Handler{Enqueue: func(ctx context.Context, cmd *Command) error {
+		if cmd.Name == "Do" {
+			return errDangerous
+		}
+		return next.Enqueue(ctx, cmd)
+	}}
+}
+
+// tenantKeys prefixes keys of commands with the tenant namespace
+func tenantKeys(next Handler) Handler {
+	return Handler{Enqueue: func(ctx context.Context, cmd *Command) error {
+		for i, key := range cmd.Keys {
+			cmd.Keys[i] = "tenant1:" + key
+		}
+		return next.Enqueue(ctx, cmd)
+	}}
+}
+
+func TestPayloadKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  payload
+		wantKeys []string
+		want     payload
+	}{
+		{name: "key", payload: keyPayload{key: "k"}, wantKeys: []string{"k"}, want: keyPayload{key: "x"}},
+		{name: "keys", payload: keysPayload{"k1", "k2"}, wantKeys: []string{"k1", "k2"}, want: keysPayload{"x", "y"}},
+		{name: "hdel", payload: hdelPayload{key: "k", fields: []string{"f"}}, wantKeys: []string{"k"}, want: hdelPayload{key: "x", fields: []string{"f"}}},
+		{name: "hget", payload: hgetPayload{key: "k", field: "f"}, wantKeys: []string{"k"}, want: hgetPayload{key: "x", field: "f"}},
+		{name: "expire", payload: expirePayload{key: "k", expiration: time.Second}, wantKeys: []string{"k"}, want: expirePayload{key: "x", expiration: time.Second}},
+		{name: "set", payload: setPayload{key: "k", value: "v", expiration: time.Second}, wantKeys: []string{"k"}, want: setPayload{key: "x", value: "v", expiration: time.Second}},
+		{
+			name:     "script",
+			payload:  scriptPayload{script: "s", keys: []string{"k1", "k2"}, args: []string{"a"}},
+			wantKeys: []string{"k1", "k2"},
+			want:     scriptPayload{script: "s", keys: []string{"x", "y"}, args: []string{"a"}},
+		},
+		{name: "safe del", payload: safeDelPayload{key: "k", expectedType: "hash"}, wantKeys: []string{"k"}, want: safeDelPayload{key: "x", expectedType: "hash"}},
+		{name: "value", payload: valuePayload{value: "v"}, want: valuePayload{value: "v"}},
+		{name: "opaque", payload: opaquePayload{"get", "k"}, want: opaquePayload{"get", "k"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := payloadKeys(tt.payload)
+			assert.Equal(t, tt.wantKeys, keys)
+			assert.Equal(t, tt.want, withKeys(tt.payload, []string{"x", "y"}[:len(keys)]))
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	ctx := context.TODO()
+	audit := &auditMiddleware{}
+	hook := &pipelinesHook{}
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(hook)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Millisecond),
+		WithMiddleware(audit.middleware("outer"), blockDo, tenantKeys, audit.middleware("inner")))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	c.Pause()
+	get := c.GetAsync(ctx, "key1")
+	h := c.AwaitOnly().Get(ctx, "key1")
+	do := c.DoAsync(ctx, "flushall")
+	c.Resume()
+	assert.Equal(t, "tenant1:key1", (<-get).(*redis.StringCmd).Val())
+	res, err := h.Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant1:key1", res.(*redis.StringCmd).Val())
+	assert.ErrorIs(t, (<-do).(*redis.Cmd).Err(), errDangerous)
+
+	// keys are rewritten by the inner middleware, so it audits them, Do is blocked before it
+	assert.Equal(t, []string{
+		"outer Get key1 key1",
+		"inner Get key1 tenant1:key1",
+		"outer Get key1 key1",
+		"inner Get key1 tenant1:key1",
+		"outer Do flushall ",
+		"outer exec",
+		"inner exec",
+	}, audit.get())
+	assert.Equal(t, [][]string{{"tenant1:key1"}}, hook.get())
+}
+
+func TestMiddlewareKeysRewritten(t *testing.T) {
+	ctx := context.TODO()
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(&mgetHook{})
+	dropKeys := func(next Handler) Handler {
+		return Handler{Enqueue: func(ctx context.Context, cmd *Command) error {
+			cmd.Keys = cmd.Keys[:1]
+			return next.Enqueue(ctx, cmd)
+		}}
+	}
+
+	c, err := NewAutoPipeline(db, WithCacheTTL(time.Millisecond), WithMiddleware(dropKeys))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	assert.Equal(t, "key1", c.Get(ctx, "key1").Val())
+	assert.ErrorIs(t, c.Del(ctx, "key1", "key2").Err(), ErrKeysRewritten)
+}
@@ -0,0 +1,91 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// orderedResult is a result of async command, which waits for results of commands enqueued earlier with the same ctx
+type orderedResult struct {
+	ctx    context.Context  // context of caller, results are ordered per context
+	ch     chan interface{} // listener channel
+	result interface{}      // result of redis command, set once it's delivered
+	ready  bool             // marks result as delivered, but not released yet
+}
+
+// sequencer releases results of async commands to listener channels in the order they were enqueued,
+// separately for every caller context
+type sequencer struct {
+	mx      *sync.Mutex
+	send    func(ch chan interface{}, result interface{}) // puts the released result to the listener channel
+	queues  map[context.Context][]*orderedResult          // pending results in enqueue order, per caller context
+	results map[chan interface{}]*orderedResult           // pending results by listener channel
+}
+
+func newSequencer(send func(ch chan interface{}, result interface{})) *sequencer {
+	return &sequencer{
+		mx:      &sync.Mutex{},
+		send:    send,
+		queues:  make(map[context.Context][]*orderedResult),
+		results: make(map[chan interface{}]*orderedResult),
+	}
+}
+
+// register reserves a place for the result of listener channel in the queue of ctx
+func (s *sequencer) register(ctx context.Context, ch chan interface{}) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	r := &orderedResult{ctx: ctx, ch: ch}
+	s.queues[ctx] = append(s.queues[ctx], r)
+	s.results[ch] = r
+}
+
+// release sends the result to the listener channel as soon as all results enqueued earlier with the same ctx
+// are released, channels which were not registered receive the result immediately
+func (s *sequencer) release(ch chan interface{}, result interface{}) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	r, ok := s.results[ch]
+	if !ok {
+		s.send(ch, result)
+		return
+	}
+	r.result = result
+	r.ready = true
+	s.flush(r.ctx)
+}
+
+// remove forgets the result of listener channel, so it doesn't hold results enqueued later with the same ctx
+func (s *sequencer) remove(ch chan interface{}) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	r, ok := s.results[ch]
+	if !ok {
+		return
+	}
+	delete(s.results, ch)
+	q := s.queues[r.ctx]
+	for i := range q {
+		if q[i] == r {
+			s.queues[r.ctx] = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	s.flush(r.ctx)
+}
+
+// flush sends ready results from the head of ctx queue, mutex should be locked
+func (s *sequencer) flush(ctx context.Context) {
+	q := s.queues[ctx]
+	for len(q) > 0 && q[0].ready {
+		s.send(q[0].ch, q[0].result)
+		delete(s.results, q[0].ch)
+		q[0] = nil
+		q = q[1:]
+	}
+	if len(q) == 0 {
+		delete(s.queues, ctx)
+		return
+	}
+	s.queues[ctx] = q
+}
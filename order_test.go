@@ -0,0 +1,99 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSequencer(t *testing.T) {
+	ctx1, cancel1 := context.WithCancel(context.TODO())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.TODO())
+	defer cancel2()
+
+	s := newSequencer((&sender{stats: newStats()}).send)
+	ch1 := make(chan interface{}, resultChannelBufferSize)
+	ch2 := make(chan interface{}, resultChannelBufferSize)
+	ch3 := make(chan interface{}, resultChannelBufferSize)
+	other := make(chan interface{}, resultChannelBufferSize)
+	s.register(ctx1, ch1)
+	s.register(ctx1, ch2)
+	s.register(ctx2, other)
+	s.register(ctx1, ch3)
+
+	// later results wait for earlier ones
+	s.release(ch3, 3)
+	s.release(ch2, 2)
+	assert.Len(t, ch2, 0)
+	assert.Len(t, ch3, 0)
+
+	// results of other context are not affected
+	s.release(other, 0)
+	assert.Equal(t, 0, <-other)
+
+	s.release(ch1, 1)
+	assert.Equal(t, 1, <-ch1)
+	assert.Equal(t, 2, <-ch2)
+	assert.Equal(t, 3, <-ch3)
+	_, ok := <-ch3
+	assert.False(t, ok)
+	assert.Empty(t, s.queues)
+	assert.Empty(t, s.results)
+
+	// not registered channel receives result immediately
+	unknown := make(chan interface{}, resultChannelBufferSize)
+	s.release(unknown, 4)
+	assert.Equal(t, 4, <-unknown)
+}
+
+func TestSequencerRemove(t *testing.T) {
+	ctx := context.TODO()
+	s := newSequencer((&sender{stats: newStats()}).send)
+	ch1 := make(chan interface{}, resultChannelBufferSize)
+	ch2 := make(chan interface{}, resultChannelBufferSize)
+	s.register(ctx, ch1)
+	s.register(ctx, ch2)
+
+	s.release(ch2, 2)
+	assert.Len(t, ch2, 0)
+	// abandoned result doesn't hold later ones
+	s.remove(ch1)
+	assert.Equal(t, 2, <-ch2)
+	assert.Empty(t, s.queues)
+	assert.Empty(t, s.results)
+}
+
+func TestOrderedDelivery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	db, mock := redismock.NewClientMock()
+	mock.MatchExpectationsInOrder(false)
+	const n = 20
+	for i := 0; i < n; i++ {
+		mock.ExpectGet(fmt.Sprintf("key%d", i)).SetVal(fmt.Sprintf("value%d", i))
+	}
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Millisecond*5),
+		WithMaxSize(200),
+		WithOrderedDelivery(true))
+	assert.Nil(t, err)
+	assert.True(t, c.Config().OrderedDelivery)
+
+	channels := make([]<-chan interface{}, n)
+	for i := 0; i < n; i++ {
+		channels[i] = c.GetAsync(ctx, fmt.Sprintf("key%d", i))
+	}
+	// once the last result is available, all earlier ones are available too
+	last := <-channels[n-1]
+	assert.Equal(t, fmt.Sprintf("value%d", n-1), last.(*redis.StringCmd).Val())
+	for i := 0; i < n-1; i++ {
+		assert.Len(t, channels[i], 1)
+		assert.Equal(t, fmt.Sprintf("value%d", i), (<-channels[i]).(*redis.StringCmd).Val())
+	}
+}
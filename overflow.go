@@ -0,0 +1,123 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"sync/atomic"
+)
+
+// backpressure bounds the number of pending commands of the cache, see WithMaxPending
+type backpressure struct {
+	mx      *sync.Mutex
+	room    chan struct{} // closed once a pending command is completed, to wake up blocked callers
+	blocked atomic.Int32  // number of blocked callers, so completed commands don't lock mx if there are none
+}
+
+// overflow applies overflow policy if the cache is full, it reports whether the command should be executed
+// directly by redis client, blocked caller waits until pending command is completed, ctx is done or cache is stopped
+func (c *cache) overflow(ctx context.Context) (bool, error) {
+	if c.maxPending == 0 || c.activeListeners.Load() < c.maxPending {
+		return false, nil
+	}
+	c.stats.overflow()
+	switch c.overflowPolicy {
+	case OverflowReject:
+		return false, ErrMaxPendingExceeded
+	case OverflowDirect:
+		return true, nil
+	}
+	bp := c.backpressure
+	bp.mx.Lock()
+	bp.blocked.Add(1)
+	defer bp.blocked.Add(-1)
+	for c.activeListeners.Load() >= c.maxPending {
+		room := bp.room
+		bp.mx.Unlock()
+		var err error
+		select {
+		case <-room:
+			if c.done.Load() {
+				err = ErrCacheStopped
+			}
+		case <-ctx.Done():
+			err = context.Cause(ctx)
+		}
+		if err != nil {
+			return false, err
+		}
+		bp.mx.Lock()
+	}
+	bp.mx.Unlock()
+	return false, nil
+}
+
+// completed wakes up blocked callers, it's called once pending commands are completed or the cache is stopped
+func (c *cache) completed() {
+	bp := c.backpressure
+	if bp == nil || bp.blocked.Load() == 0 {
+		return
+	}
+	bp.mx.Lock()
+	close(bp.room)
+	bp.room = make(chan struct{})
+	bp.mx.Unlock()
+}
+
+// direct executes the command by redis client without queueing, the result is of the same type as queued command has
+func (c *cache) direct(ctx context.Context, kind operationPrefix, p payload) interface{} {
+	p, err := c.admit(ctx, kind, p)
+	if err != nil {
+		return c.failedCmd(kind, p, err)
+	}
+	defer c.unadmit(ctx, kind)
+	pipe := c.executor.Pipeline(c.client())
+	result := c.pipeDirect(ctx, pipe, kind, prefixPayload(c.keyPrefix, p))
+	// errors of commands are set to commands themselves
+	_, _ = c.executor.Exec(ctx, pipe)
+	return result()
+}
+
+// directBatch executes commands of the batch by redis client in a single pipeline without queueing,
+// pipeline is a transaction, if tx is set
+func (c *cache) directBatch(ctx context.Context, cmds []batchCommand, tx bool) []*Handle {
+	handles := make([]*Handle, len(cmds))
+	results := make([]func() interface{}, len(cmds))
+	_, client := c.pipelineClient(tx)
+	pipe := c.executor.Pipeline(client)
+	for i, cmd := range cmds {
+		p, err := c.admit(ctx, cmd.kind, cmd.payload)
+		if err != nil {
+			handles[i] = &Handle{err: err}
+			continue
+		}
+		defer c.unadmit(ctx, cmd.kind)
+		results[i] = c.pipeDirect(ctx, pipe, cmd.kind, prefixPayload(c.keyPrefix, p))
+	}
+	_, _ = c.executor.Exec(ctx, pipe)
+	for i, result := range results {
+		if result != nil {
+			handles[i] = completedHandle(result())
+		}
+	}
+	return handles
+}
+
+// pipeDirect adds the command to the pipeline and returns a function, which returns its result once pipeline
+// is executed, see pipeCmd
+func (c *cache) pipeDirect(ctx context.Context, pipe redis.Pipeliner, kind operationPrefix, p payload) func() interface{} {
+	piped := c.pipeCmd(ctx, pipe, kind, p)
+	return func() interface{} {
+		if piped.empty() {
+			return nil
+		}
+		return piped.result(ctx)
+	}
+}
+
+// completedHandle returns a Handle, which result is ready
+func completedHandle(result interface{}) *Handle {
+	op := &redisOperation{done: make(chan struct{}), result: result}
+	close(op.done)
+	return &Handle{op: op}
+}
@@ -0,0 +1,103 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestOverflowPolicy(t *testing.T) {
+	ctx := context.TODO()
+	hook := &mgetHook{}
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(hook)
+
+	// reject
+	c, err := NewAutoPipeline(db,
+		WithMaxPending(1),
+		WithOverflowPolicy(OverflowReject))
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), c.Config().MaxPending)
+	assert.Equal(t, OverflowReject, c.Config().OverflowPolicy)
+	// pending command is kept in the cache until stop
+	c.Pause()
+	pending := c.GetAsync(ctx, "key1")
+	res := <-c.GetAsync(ctx, "key2")
+	assert.ErrorIs(t, res.(*redis.StringCmd).Err(), ErrMaxPendingExceeded)
+	_, err = c.AwaitOnly().Get(ctx, "key2").Await(ctx)
+	assert.ErrorIs(t, err, ErrMaxPendingExceeded)
+	// identical command is rejected too, as it's counted as another listener
+	res = <-c.GetAsync(ctx, "key1")
+	assert.ErrorIs(t, res.(*redis.StringCmd).Err(), ErrMaxPendingExceeded)
+	assert.Equal(t, uint64(3), c.Stats().Overflows)
+	c.Stop()
+	assert.Equal(t, "key1", (<-pending).(*redis.StringCmd).Val())
+
+	// direct
+	c, err = NewAutoPipeline(db,
+		WithKeyPrefix("app:"),
+		WithMaxPending(1),
+		WithOverflowPolicy(OverflowDirect))
+	assert.Nil(t, err)
+	c.Pause()
+	pending = c.GetAsync(ctx, "key1")
+	assert.Equal(t, "app:key2", c.Get(ctx, "key2").Val())
+	assert.Equal(t, int64(2), c.Del(ctx, "key3", "key4").Val())
+	h := c.AwaitOnly().Get(ctx, "key5")
+	res, err = h.Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "app:key5", res.(*redis.StringCmd).Val())
+	futures := c.(*Autopipeline).NewBatch().Get("key6").Get("key7").Submit(ctx)
+	cmd, err := futures[0].Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "app:key6", cmd.(*redis.StringCmd).Val())
+	cmd, err = futures[1].Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "app:key7", cmd.(*redis.StringCmd).Val())
+	assert.Equal(t, 1, c.Len())
+	assert.Equal(t, uint64(4), c.Stats().Overflows)
+	c.Stop()
+	assert.Equal(t, "app:key1", (<-pending).(*redis.StringCmd).Val())
+}
+
+func TestOverflowBlock(t *testing.T) {
+	ctx := context.TODO()
+	hook := &mgetHook{}
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(hook)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Millisecond*20),
+		WithMaxPending(1))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, OverflowBlock, c.Config().OverflowPolicy)
+
+	// caller waits for the pending command to be flushed
+	pending := c.GetAsync(ctx, "key1")
+	assert.Equal(t, "key2", c.Get(ctx, "key2").Val())
+	assert.Equal(t, "key1", (<-pending).(*redis.StringCmd).Val())
+	assert.Equal(t, uint64(1), c.Stats().Overflows)
+
+	// caller gives up once ctx is done
+	c.Pause()
+	pending = c.GetAsync(ctx, "key1")
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*10)
+	defer cancel()
+	_, err = c.AwaitOnly().Get(timeoutCtx, "key2").Await(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// blocked caller is released on stop
+	blocked := make(chan interface{})
+	go func() {
+		blocked <- <-c.GetAsync(ctx, "key2")
+	}()
+	assert.Eventually(t, func() bool {
+		return c.(*Autopipeline).cache.backpressure.blocked.Load() == 1
+	}, time.Second, time.Millisecond)
+	c.Stop()
+	assert.Equal(t, "key1", (<-pending).(*redis.StringCmd).Val())
+	assert.ErrorIs(t, (<-blocked).(*redis.StringCmd).Err(), ErrCacheStopped)
+}
@@ -0,0 +1,224 @@
+package redis_autopipeline
+
+import (
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// payload is typed arguments of redis command, it's built once by transform function,
+// so the command is added to the pipeline without parsing its arguments back
+type payload interface {
+	// appendArgs appends arguments of redis command as they are written to the wire,
+	// they identify the operation and are used by snapshots, exports and size estimation
+	appendArgs(dst []string) []string
+	// withPrefix returns the payload with the key prefix added to every key, payload itself is never modified
+	withPrefix(prefix string) payload
+}
+
+// keyPayload is a payload of command with a single key, f.e. Get, HGetAll and SMembers
+type keyPayload struct {
+	key string
+}
+
+func (p keyPayload) appendArgs(dst []string) []string {
+	return append(dst, p.key)
+}
+
+func (p keyPayload) withPrefix(prefix string) payload {
+	return keyPayload{key: prefix + p.key}
+}
+
+// keysPayload is a payload of command with keys only, f.e. Del, MGet and Exists
+type keysPayload []string
+
+func (p keysPayload) appendArgs(dst []string) []string {
+	return append(dst, p...)
+}
+
+func (p keysPayload) withPrefix(prefix string) payload {
+	return keysPayload(prefixKeys(prefix, p))
+}
+
+// hdelPayload is a payload of HDel
+type hdelPayload struct {
+	key    string
+	fields []string
+}
+
+func (p hdelPayload) appendArgs(dst []string) []string {
+	return append(append(dst, p.key), p.fields...)
+}
+
+func (p hdelPayload) withPrefix(prefix string) payload {
+	return hdelPayload{key: prefix + p.key, fields: p.fields}
+}
+
+// hgetPayload is a payload of HGet
+type hgetPayload struct {
+	key   string
+	field string
+}
+
+func (p hgetPayload) appendArgs(dst []string) []string {
+	return append(dst, p.key, p.field)
+}
+
+func (p hgetPayload) withPrefix(prefix string) payload {
+	return hgetPayload{key: prefix + p.key, field: p.field}
+}
+
+// expirePayload is a payload of Expire, expiration is kept as is, so it's passed to go-redis without precision loss
+type expirePayload struct {
+	key        string
+	expiration time.Duration
+}
+
+func (p expirePayload) appendArgs(dst []string) []string {
+	return append(dst, p.key, strconv.FormatInt(p.expiration.Nanoseconds(), 10))
+}
+
+func (p expirePayload) withPrefix(prefix string) payload {
+	return expirePayload{key: prefix + p.key, expiration: p.expiration}
+}
+
+// scriptPayload is a payload of EvalRO and FCallRO, script is a script body or function name
+type scriptPayload struct {
+	script string
+	keys   []string
+	args   []string
+}
+
+func (p scriptPayload) appendArgs(dst []string) []string {
+	dst = append(dst, p.script, strconv.Itoa(len(p.keys)))
+	dst = append(dst, p.keys...)
+	return append(dst, p.args...)
+}
+
+func (p scriptPayload) withPrefix(prefix string) payload {
+	return scriptPayload{script: p.script, keys: prefixKeys(prefix, p.keys), args: p.args}
+}
+
+// safeDelPayload is a payload of SafeDel
+type safeDelPayload struct {
+	key          string
+	expectedType string
+}
+
+func (p safeDelPayload) appendArgs(dst []string) []string {
+	return append(dst, p.key, p.expectedType)
+}
+
+func (p safeDelPayload) withPrefix(prefix string) payload {
+	return safeDelPayload{key: prefix + p.key, expectedType: p.expectedType}
+}
+
+// setPayload is a payload of Set, value is formatted the same way go-redis writes it to the wire,
+// expiration is kept as is, so zero and redis.KeepTTL are passed to go-redis unchanged
+type setPayload struct {
+	key        string
+	value      string
+	expiration time.Duration
+}
+
+func (p setPayload) appendArgs(dst []string) []string {
+	return append(dst, p.key, p.value, strconv.FormatInt(p.expiration.Nanoseconds(), 10))
+}
+
+func (p setPayload) withPrefix(prefix string) payload {
+	return setPayload{key: prefix + p.key, value: p.value, expiration: p.expiration}
+}
+
+// valuePayload is a payload of command with a single value, which is not a key, f.e. Echo
+type valuePayload struct {
+	value string
+}
+
+func (p valuePayload) appendArgs(dst []string) []string {
+	return append(dst, p.value)
+}
+
+func (p valuePayload) withPrefix(string) payload {
+	return p
+}
+
+// emptyPayload is a payload of command without arguments, f.e. Time
+type emptyPayload struct{}
+
+func (p emptyPayload) appendArgs(dst []string) []string {
+	return dst
+}
+
+func (p emptyPayload) withPrefix(string) payload {
+	return p
+}
+
+// opaquePayload is a payload of Do and custom operations, arguments are not known to be keys, so they are not prefixed
+type opaquePayload []string
+
+func (p opaquePayload) appendArgs(dst []string) []string {
+	return append(dst, p...)
+}
+
+func (p opaquePayload) withPrefix(string) payload {
+	return p
+}
+
+// cmdPayload is a payload of pre-built redis command, which is pipelined verbatim, see EnqueueCmd,
+// arguments are formatted for snapshots and exports only
+type cmdPayload struct {
+	cmd redis.Cmder
+}
+
+func (p cmdPayload) appendArgs(dst []string) []string {
+	for _, arg := range p.cmd.Args() {
+		dst = append(dst, argToString(arg))
+	}
+	return dst
+}
+
+func (p cmdPayload) withPrefix(string) payload {
+	return p
+}
+
+// payloadKey returns the first key of redis command with the payload, which routes it in redis cluster,
+// false is returned for commands without keys and for ones, arguments of which are not known to be keys
+func payloadKey(p payload) (string, bool) {
+	switch p := p.(type) {
+	case keyPayload:
+		return p.key, true
+	case keysPayload:
+		if len(p) > 0 {
+			return p[0], true
+		}
+	case hdelPayload:
+		return p.key, true
+	case hgetPayload:
+		return p.key, true
+	case expirePayload:
+		return p.key, true
+	case setPayload:
+		return p.key, true
+	case scriptPayload:
+		if len(p.keys) > 0 {
+			return p.keys[0], true
+		}
+	case safeDelPayload:
+		return p.key, true
+	}
+	return "", false
+}
+
+// payloadArgs returns arguments of redis command with the payload
+func payloadArgs(p payload) []string {
+	return p.appendArgs(nil)
+}
+
+// interfaceArgs returns strings as interface values expected by go-redis
+func interfaceArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
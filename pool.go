@@ -0,0 +1,83 @@
+package redis_autopipeline
+
+import (
+	"github.com/redis/go-redis/v9"
+	"sync"
+)
+
+// operationPool reuses operations delivered to listeners, so they don't become garbage after every flush
+var operationPool = sync.Pool{
+	New: func() interface{} {
+		return &redisOperation{}
+	},
+}
+
+// newOperation returns an operation from the pool
+func newOperation(kind operationPrefix, p payload) *redisOperation {
+	op := operationPool.Get().(*redisOperation)
+	op.kind = kind
+	op.payload = p
+	return op
+}
+
+// releaseOperation resets the operation and puts it back to the pool,
+// operation awaited by handles is referenced by them, so it's left for garbage collector
+// payload is not reused, as it may be shared with other operations, f.e. commands of Batch submitted again
+func releaseOperation(op *redisOperation) {
+	if op.done != nil {
+		return
+	}
+	op.reset()
+	operationPool.Put(op)
+}
+
+// reset zeroes the operation, slices keep their capacity, but not the references to listeners and contexts,
+// including removed ones
+func (op *redisOperation) reset() {
+	clear(op.listeners[:cap(op.listeners)])
+	clear(op.callers[:cap(op.callers)])
+	clear(op.admitted[:cap(op.admitted)])
+	*op = redisOperation{
+		listeners: op.listeners[:0],
+		callers:   op.callers[:0],
+		admitted:  op.admitted[:0],
+		batches:   op.batches[:0],
+	}
+}
+
+// pipelineCmds contains redis commands of single runPipeline execution by hash of operation,
+// maps are reused between executions, as they are cleared once results are delivered
+type pipelineCmds struct {
+	piped               map[string]pipedCmd                  // commands added as is, see pipeCmd
+	stringCmds          map[string]*redis.StringCmd          // Get and HGet commands left of coalesced ones
+	stringStringMapCmds map[string]*redis.MapStringStringCmd // HGetAll commands, which serve HGet commands
+	cmders              map[string]redis.Cmder               // pre-built commands, see EnqueueCmd
+	chunks              map[string]int                       // indexes of pipelines
+}
+
+// pipelineCmdsPool reuses maps of runPipeline executions
+var pipelineCmdsPool = sync.Pool{
+	New: func() interface{} {
+		return &pipelineCmds{
+			piped:               map[string]pipedCmd{},
+			stringCmds:          map[string]*redis.StringCmd{},
+			stringStringMapCmds: map[string]*redis.MapStringStringCmd{},
+			cmders:              map[string]redis.Cmder{},
+			chunks:              map[string]int{},
+		}
+	},
+}
+
+// releasePipelineCmds clears the maps and puts them back to the pool,
+// maps of a large batch are left for garbage collector, as cleared map keeps its buckets
+func releasePipelineCmds(p *pipelineCmds) {
+	if len(p.chunks) >= shrinkMinPeak {
+		return
+	}
+	clear(p.piped)
+	clear(p.stringCmds)
+	clear(p.stringStringMapCmds)
+	clear(p.cmders)
+	clear(p.chunks)
+	pipelineCmdsPool.Put(p)
+}
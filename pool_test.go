@@ -0,0 +1,35 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestResetOperation(t *testing.T) {
+	ch := make(chan interface{}, 1)
+	op := &redisOperation{kind: Get, payload: transformGet("key")}
+	op.created = time.Now()
+	op.listeners = append(op.listeners, ch, ch)
+	op.callers = append(op.callers, context.TODO())
+	op.cmd = redis.NewStringCmd(context.TODO(), "get", "key")
+	op.listeners = op.listeners[:1]
+
+	op.reset()
+	assert.Nil(t, op.payload)
+	assert.Nil(t, op.cmd)
+	assert.True(t, op.created.IsZero())
+	assert.Len(t, op.listeners, 0)
+	assert.Nil(t, op.listeners[:2][1])
+	assert.Len(t, op.callers, 0)
+
+	// operation awaited by handle isn't reset
+	op = &redisOperation{kind: Get, payload: transformGet("key")}
+	op.done = make(chan struct{})
+	op.awaiters = 1
+	releaseOperation(op)
+	assert.Equal(t, transformGet("key"), op.payload)
+	assert.Equal(t, 1, op.awaiters)
+}
@@ -0,0 +1,32 @@
+package redis_autopipeline
+
+import (
+	"strings"
+)
+
+// prefixPayload returns the payload of operation with the key prefix added to every key,
+// payload is copied, as it may be shared with other operations, f.e. commands of Batch submitted again
+// arguments of Do, EnqueueCmd and custom operations are opaque, so they are not prefixed
+func prefixPayload(prefix string, p payload) payload {
+	if prefix == "" {
+		return p
+	}
+	return p.withPrefix(prefix)
+}
+
+// prefixKeys returns a copy of keys with the key prefix added to every key
+func prefixKeys(prefix string, keys []string) []string {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = prefix + key
+	}
+	return prefixed
+}
+
+// stripKeyPrefix returns the key without prefix and reports whether the key has it
+func stripKeyPrefix(prefix, key string) (string, bool) {
+	if !strings.HasPrefix(key, prefix) {
+		return "", false
+	}
+	return key[len(prefix):], true
+}
@@ -0,0 +1,114 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPrefixPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		payload payload
+		want    []string
+	}{
+		{
+			name:    "no prefix",
+			payload: transformGet("key"),
+			want:    []string{"key"},
+		},
+		{
+			name:    "single key",
+			prefix:  "t:",
+			payload: transformHGet("key", "field"),
+			want:    []string{"t:key", "field"},
+		},
+		{
+			name:    "keys",
+			prefix:  "t:",
+			payload: transformMGet("key1", "key2"),
+			want:    []string{"t:key1", "t:key2"},
+		},
+		{
+			name:    "script",
+			prefix:  "t:",
+			payload: transformScript("return 1", []string{"key"}, "arg"),
+			want:    []string{"return 1", "1", "t:key", "arg"},
+		},
+		{
+			name:    "no keys",
+			prefix:  "t:",
+			payload: transformEcho("ping"),
+			want:    []string{"ping"},
+		},
+		{
+			name:    "opaque arguments",
+			prefix:  "t:",
+			payload: transformDo("strlen", "key"),
+			want:    []string{"strlen", "key"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := payloadArgs(tt.payload)
+			assert.Equal(t, tt.want, payloadArgs(prefixPayload(tt.prefix, tt.payload)))
+			// payload of caller is kept
+			assert.Equal(t, args, payloadArgs(tt.payload))
+		})
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("tenant1:key1").SetVal("john")
+	mock.ExpectDel("tenant1:key2", "tenant1:key3").SetVal(2)
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200),
+		WithKeyPrefix("tenant1:"))
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant1:", c.Config().KeyPrefix)
+
+	c.Pause()
+	resCh := c.GetAsync(ctx, "key1")
+	h := c.AwaitOnly().Del(ctx, "key2", "key3")
+	c.Resume()
+
+	assert.Equal(t, "john", (<-resCh).(*redis.StringCmd).Val())
+	res, err := h.Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), res.(*redis.IntCmd).Val())
+}
+
+func TestKeyPrefixFallback(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHSet("tenant1:key1", "name", "john").SetVal(1)
+	mock.ExpectHGet("tenant1:key1", "name").SetVal("john")
+	mock.ExpectSet("tenant1:key2", "jane", time.Minute).SetVal("OK")
+	mock.ExpectIncr("tenant1:key3").SetVal(1)
+	mock.ExpectTTL("tenant1:key2").SetVal(time.Minute)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithFallback(true),
+		WithKeyPrefix("tenant1:"))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	// key written directly by redis client is read by queued command
+	assert.Nil(t, c.HSet(ctx, "key1", "name", "john").Err())
+	assert.Equal(t, "john", c.HGet(ctx, "key1", "name").Val())
+	assert.Nil(t, c.Set(ctx, "key2", "jane", time.Minute).Err())
+	assert.Equal(t, int64(1), c.Incr(ctx, "key3").Val())
+	assert.Equal(t, time.Minute, c.TTL(ctx, "key2").Val())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,145 @@
+// Package promstats exports stats of redis_autopipeline to prometheus,
+// so prometheus isn't a dependency of autopipeline itself
+package promstats
+
+import (
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"redis-autopipeline"
+	"time"
+)
+
+// namespace is a prefix of names of exported metrics
+const namespace = "autopipeline"
+
+// ErrNoSource is returned by Register for nil source
+var ErrNoSource = errors.New("autopipeline to collect metrics of isn't set")
+
+var (
+	pendingDesc = prometheus.NewDesc(namespace+"_pending_commands",
+		"Number of queued commands, identical commands are counted once.", nil, nil)
+	flushesDesc = prometheus.NewDesc(namespace+"_flushes_total",
+		"Number of executed pipelines by reason of flush.", []string{"reason"}, nil)
+	dedupHitsDesc = prometheus.NewDesc(namespace+"_dedup_hits_total",
+		"Number of commands joined to already queued identical command.", nil, nil)
+	droppedResultsDesc = prometheus.NewDesc(namespace+"_dropped_results_total",
+		"Number of results dropped as listeners didn't receive them.", nil, nil)
+	errorsDesc = prometheus.NewDesc(namespace+"_pipeline_errors_total",
+		"Number of failed pipelines.", nil, nil)
+)
+
+// Source is an autopipeline, which metrics are collected from, f.e. *redis_autopipeline.Autopipeline
+type Source interface {
+	Stats() redis_autopipeline.Stats
+	Len() int
+}
+
+// Collector is a prometheus collector of autopipeline, counters are read from Stats of autopipeline on scrape,
+// distributions are observed by every executed pipeline, as Collector is a MetricsSink of autopipeline
+type Collector struct {
+	src       Source // set by Register, before the collector is registered
+	batchSize prometheus.Histogram
+	execTime  prometheus.Histogram
+}
+
+var _ redis_autopipeline.MetricsSink = &Collector{}
+
+// New returns Collector, which is passed to autopipeline with WithMetricsSink and registered once
+// autopipeline is created, see Register
+func New() *Collector {
+	return &Collector{
+		batchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "batch_size",
+			Help:      "Number of commands in executed pipelines.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 13),
+		}),
+		execTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "exec_duration_seconds",
+			Help:      "Execution time of pipelines, including failed ones.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}),
+	}
+}
+
+// Register registers the collector of src with reg: pending commands, distributions of batch size and execution time
+// of pipelines, flushes by reason, dedup hits, dropped results and failed pipelines; metrics of lanes are joined;
+// collector isn't unregistered on Stop, to register several autopipelines with the same registry wrap it
+// with distinct constant labels for every one of them, f.e. by prometheus.WrapRegistererWith
+func (c *Collector) Register(reg prometheus.Registerer, src Source) error {
+	if src == nil {
+		return ErrNoSource
+	}
+	c.src = src
+	return reg.Register(c)
+}
+
+// WithMetrics returns an option of autopipeline, which exports its metrics with a collector of its own,
+// see New, and registers the collector with reg once autopipeline is created, see Register; NewAutoPipeline fails
+// if the collector isn't registered, f.e. as another one has the same constant labels; the collector is added
+// to other metrics sinks of autopipeline, see WithMetricsSink; nil reg disables it
+func WithMetrics(reg prometheus.Registerer) func(a *redis_autopipeline.Autopipeline) {
+	return func(a *redis_autopipeline.Autopipeline) {
+		if reg == nil {
+			return
+		}
+		c := New()
+		redis_autopipeline.WithMetricsSink(c)(a)
+		redis_autopipeline.WithOnCreate(func(a *redis_autopipeline.Autopipeline) error {
+			if err := c.Register(reg, a); err != nil {
+				return fmt.Errorf("register metrics: %w", err)
+			}
+			return nil
+		})(a)
+	}
+}
+
+// ObserveEnqueue does nothing, dedup hits are read from stats on scrape
+func (c *Collector) ObserveEnqueue(string, bool) {}
+
+// ObserveBatch observes executed pipeline with the number of commands in it and its execution time
+func (c *Collector) ObserveBatch(_ string, commands int, exec time.Duration) {
+	c.batchSize.Observe(float64(commands))
+	c.execTime.Observe(exec.Seconds())
+}
+
+// ObserveError does nothing, failed pipelines are read from stats on scrape
+func (c *Collector) ObserveError(error) {}
+
+// ObserveDrop does nothing, dropped results are read from stats on scrape
+func (c *Collector) ObserveDrop() {}
+
+// ObserveOverflow does nothing, overflows aren't exported
+func (c *Collector) ObserveOverflow() {}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pendingDesc
+	ch <- flushesDesc
+	ch <- dedupHitsDesc
+	ch <- droppedResultsDesc
+	ch <- errorsDesc
+	c.batchSize.Describe(ch)
+	c.execTime.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.src.Stats()
+	ch <- prometheus.MustNewConstMetric(pendingDesc, prometheus.GaugeValue, float64(c.src.Len()))
+	for reason, flushes := range map[string]uint64{
+		"size":      s.SizeFlushes,
+		"ttl":       s.TTLFlushes,
+		"shutdown":  s.ShutdownFlushes,
+		"max_delay": s.MaxDelayFlushes,
+		"solo":      s.SoloFlushes,
+		"bytes":     s.BytesFlushes,
+	} {
+		ch <- prometheus.MustNewConstMetric(flushesDesc, prometheus.CounterValue, float64(flushes), reason)
+	}
+	ch <- prometheus.MustNewConstMetric(dedupHitsDesc, prometheus.CounterValue, float64(s.DedupHits))
+	ch <- prometheus.MustNewConstMetric(droppedResultsDesc, prometheus.CounterValue, float64(s.DroppedResults))
+	ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.CounterValue, float64(s.Errors))
+	c.batchSize.Collect(ch)
+	c.execTime.Collect(ch)
+}
@@ -0,0 +1,125 @@
+package promstats
+
+import (
+	"context"
+	"github.com/go-redis/redismock/v9"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"redis-autopipeline"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gather returns metrics of the registry by their names and labels, f.e. autopipeline_flushes_total{reason=ttl}
+func gather(t *testing.T, reg *prometheus.Registry) map[string]*dto.Metric {
+	families, err := reg.Gather()
+	assert.Nil(t, err)
+	metrics := make(map[string]*dto.Metric)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			name := f.GetName()
+			for _, l := range m.GetLabel() {
+				name += "{" + l.GetName() + "=" + l.GetValue() + "}"
+			}
+			metrics[name] = m
+		}
+	}
+	return metrics
+}
+
+func TestCollector(t *testing.T) {
+	ctx := context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectGet("key1").SetVal("john")
+	mock.ExpectGet("key2").SetVal("jane")
+
+	reg := prometheus.NewRegistry()
+	collector := New()
+	c, err := redis_autopipeline.NewAutoPipeline(db,
+		redis_autopipeline.WithCacheTTL(time.Microsecond*100),
+		redis_autopipeline.WithMetricsSink(collector))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Nil(t, collector.Register(prometheus.WrapRegistererWith(prometheus.Labels{"name": "main"}, reg), c))
+
+	c.Pause()
+	first := c.GetAsync(ctx, "key1")
+	second := c.GetAsync(ctx, "key1")
+	assert.Equal(t, float64(1), gather(t, reg)["autopipeline_pending_commands{name=main}"].GetGauge().GetValue())
+	c.Resume()
+	<-first
+	<-second
+	assert.Equal(t, "jane", c.Get(ctx, "key2").Val())
+
+	stats := c.Stats()
+	metrics := gather(t, reg)
+	assert.Equal(t, float64(0), metrics["autopipeline_pending_commands{name=main}"].GetGauge().GetValue())
+	assert.Equal(t, float64(stats.TTLFlushes), metrics["autopipeline_flushes_total{name=main}{reason=ttl}"].GetCounter().GetValue())
+	assert.Equal(t, float64(0), metrics["autopipeline_flushes_total{name=main}{reason=size}"].GetCounter().GetValue())
+	assert.Equal(t, float64(1), metrics["autopipeline_dedup_hits_total{name=main}"].GetCounter().GetValue())
+	assert.Equal(t, float64(0), metrics["autopipeline_dropped_results_total{name=main}"].GetCounter().GetValue())
+	assert.Equal(t, float64(0), metrics["autopipeline_pipeline_errors_total{name=main}"].GetCounter().GetValue())
+	assert.Equal(t, stats.Pipelines, metrics["autopipeline_batch_size{name=main}"].GetHistogram().GetSampleCount())
+	assert.Equal(t, float64(stats.Commands), metrics["autopipeline_batch_size{name=main}"].GetHistogram().GetSampleSum())
+	assert.Equal(t, stats.Pipelines, metrics["autopipeline_exec_duration_seconds{name=main}"].GetHistogram().GetSampleCount())
+
+	// collector of another autopipeline is registered with its own labels only
+	assert.NotNil(t, New().Register(prometheus.WrapRegistererWith(prometheus.Labels{"name": "main"}, reg), c))
+	other, err := redis_autopipeline.NewAutoPipeline(db)
+	assert.Nil(t, err)
+	defer other.Stop()
+	assert.Nil(t, New().Register(prometheus.WrapRegistererWith(prometheus.Labels{"name": "other"}, reg), other))
+	assert.Contains(t, gather(t, reg), "autopipeline_pending_commands{name=other}")
+
+	assert.ErrorIs(t, New().Register(reg, nil), ErrNoSource)
+}
+
+// countingSink counts executed pipelines, so fan-out of metrics sinks is checked
+type countingSink struct {
+	batches atomic.Int32
+}
+
+func (s *countingSink) ObserveEnqueue(string, bool) {}
+
+func (s *countingSink) ObserveBatch(string, int, time.Duration) {
+	s.batches.Add(1)
+}
+
+func (s *countingSink) ObserveError(error) {}
+
+func (s *countingSink) ObserveDrop() {}
+
+func (s *countingSink) ObserveOverflow() {}
+
+func TestWithMetrics(t *testing.T) {
+	ctx := context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key1").SetVal("john")
+
+	reg := prometheus.NewRegistry()
+	sink := &countingSink{}
+	c, err := redis_autopipeline.NewAutoPipeline(db,
+		redis_autopipeline.WithCacheTTL(time.Microsecond*100),
+		redis_autopipeline.WithMetricsSink(sink),
+		WithMetrics(prometheus.WrapRegistererWith(prometheus.Labels{"name": "main"}, reg)))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	assert.Equal(t, "john", c.Get(ctx, "key1").Val())
+	metrics := gather(t, reg)
+	assert.Equal(t, uint64(1), metrics["autopipeline_batch_size{name=main}"].GetHistogram().GetSampleCount())
+	// collector is added to other sinks
+	assert.Equal(t, int32(1), sink.batches.Load())
+
+	// collector with the same labels isn't registered
+	_, err = redis_autopipeline.NewAutoPipeline(db, WithMetrics(prometheus.WrapRegistererWith(prometheus.Labels{"name": "main"}, reg)))
+	var already prometheus.AlreadyRegisteredError
+	assert.ErrorAs(t, err, &already)
+
+	other, err := redis_autopipeline.NewAutoPipeline(db, WithMetrics(nil))
+	assert.Nil(t, err)
+	other.Stop()
+}
@@ -0,0 +1,107 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// queuedCommand is a command put to the lock-free queue by producer, it's moved to the storage by background goroutine
+type queuedCommand struct {
+	ctx      context.Context  // context of caller
+	kind     operationPrefix  // redis command
+	payload  payload          // arguments of redis command, keys are prefixed already
+	hash     string           // hash of operation, calculated by producer
+	resultCh chan interface{} // listener of the result
+	created  time.Time        // time when command was enqueued
+	next     *queuedCommand   // next command in the queue
+}
+
+// commandQueue is a lock-free multi-producer queue, producers push commands by CAS of the head,
+// consumer takes all of them at once
+type commandQueue struct {
+	head atomic.Pointer[queuedCommand]
+}
+
+// push puts the command to the queue, it never blocks
+func (q *commandQueue) push(cmd *queuedCommand) {
+	for {
+		head := q.head.Load()
+		cmd.next = head
+		if q.head.CompareAndSwap(head, cmd) {
+			return
+		}
+	}
+}
+
+// takeAll removes all commands from the queue and returns the first one, commands are linked in the order of push
+func (q *commandQueue) takeAll() *queuedCommand {
+	var first *queuedCommand
+	cmd := q.head.Swap(nil)
+	for cmd != nil {
+		next := cmd.next
+		cmd.next = first
+		first = cmd
+		cmd = next
+	}
+	return first
+}
+
+// push admits the request and puts it to the lock-free queue without locking any mutex,
+// listener is counted right away, so triggers and pause limit take it into account before it's drained
+func (c *cache) push(ctx context.Context, kind operationPrefix, p payload, resultCh chan interface{}) error {
+	p, err := c.admit(ctx, kind, p)
+	if err != nil {
+		return err
+	}
+	p = prefixPayload(c.keyPrefix, p)
+	if c.sequencer != nil {
+		c.sequencer.register(ctx, resultCh)
+	}
+	c.queue.push(&queuedCommand{
+		ctx:      ctx,
+		kind:     kind,
+		payload:  p,
+		hash:     c.hash(kind, p),
+		resultCh: resultCh,
+		created:  c.clock.Now(),
+	})
+	// background goroutine is woken up to drain the queue, same as for commands put to the storage
+	if n := c.activeListeners.Add(1); n == 1 || n > c.storageThresholdSize.Load() {
+		c.signal()
+	}
+	return nil
+}
+
+// drainQueue moves commands of lock-free queue to the storage, identical commands are coalesced meanwhile,
+// it's called by background goroutine only
+func (c *cache) drainQueue() {
+	if c.queue == nil {
+		return
+	}
+	for cmd := c.queue.takeAll(); cmd != nil; cmd = cmd.next {
+		c.store(cmd)
+	}
+}
+
+// store puts queued command to the storage
+func (c *cache) store(cmd *queuedCommand) {
+	sh := c.shard(cmd.hash)
+	sh.mx.Lock()
+	op, err := c.operation(cmd.ctx, sh, cmd.hash, cmd.kind, cmd.payload, 0)
+	if err == nil {
+		// operation waits since the command was enqueued, not since it was drained
+		if cmd.created.Before(op.created) {
+			op.created = cmd.created
+			c.trackPending(cmd.created)
+		}
+		c.addListener(op, cmd.resultCh)
+	}
+	sh.mx.Unlock()
+	// listener is counted by push already
+	c.activeListeners.Add(-1)
+	if err != nil {
+		c.unadmit(cmd.ctx, cmd.kind)
+		c.release(cmd.resultCh, c.failedCmd(cmd.kind, cmd.payload, err))
+	}
+}
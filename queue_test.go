@@ -0,0 +1,77 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCommandQueue(t *testing.T) {
+	q := &commandQueue{}
+	assert.Nil(t, q.takeAll())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.push(&queuedCommand{kind: Get})
+		}()
+	}
+	wg.Wait()
+	n := 0
+	for cmd := q.takeAll(); cmd != nil; cmd = cmd.next {
+		n++
+	}
+	assert.Equal(t, 100, n)
+
+	// commands are taken in the order of push
+	q.push(&queuedCommand{hash: "1"})
+	q.push(&queuedCommand{hash: "2"})
+	q.push(&queuedCommand{hash: "3"})
+	var hashes []string
+	for cmd := q.takeAll(); cmd != nil; cmd = cmd.next {
+		hashes = append(hashes, cmd.hash)
+	}
+	assert.Equal(t, []string{"1", "2", "3"}, hashes)
+	assert.Nil(t, q.takeAll())
+}
+
+func TestLockFreeEnqueue(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key1").SetVal("john")
+	mock.ExpectGet("key2").SetVal("jane")
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200),
+		WithLockFreeEnqueue(true),
+		WithDuplicatePolicy(DuplicateReject))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.True(t, c.Config().LockFreeEnqueue)
+
+	c.Pause()
+	resCh1 := c.GetAsync(ctx, "key1")
+	resCh2 := c.GetAsync(ctx, "key2")
+	// identical commands are coalesced while queue is drained
+	resCh3 := c.GetAsync(context.WithValue(ctx, tenantKey{}, "b"), "key1")
+	// duplicate of the same caller is rejected while queue is drained
+	duplicateCh := c.GetAsync(ctx, "key1")
+	assert.Equal(t, 4, c.Pending())
+	c.Resume()
+
+	assert.ErrorIs(t, (<-duplicateCh).(*redis.StringCmd).Err(), ErrDuplicateCommand)
+	assert.Equal(t, "john", (<-resCh1).(*redis.StringCmd).Val())
+	assert.Equal(t, "jane", (<-resCh2).(*redis.StringCmd).Val())
+	assert.Equal(t, "john", (<-resCh3).(*redis.StringCmd).Val())
+	assert.Equal(t, uint64(1), c.Stats().DedupHits)
+	assert.Equal(t, uint64(2), c.Stats().Commands)
+	assert.Equal(t, 0, c.Pending())
+}
@@ -0,0 +1,125 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is wrapped by QuotaError, which is returned for commands rejected by TenantQuota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Admitter is an admission control of commands, see WithAdmission
+type Admitter interface {
+	// Admit is called before command is queued, an error rejects the command and is returned to the caller
+	Admit(ctx context.Context, command string) error
+	// Done is called once result of admitted command is delivered, or if admitted command wasn't queued
+	Done(ctx context.Context, command string)
+}
+
+// RateLimiter caps the rate of pipelines sent to redis, see WithRateLimiter,
+// f.e. *rate.Limiter of golang.org/x/time/rate implements it
+type RateLimiter interface {
+	// Wait blocks until execution of one pipeline is permitted, an error fails commands of the flush with it
+	Wait(ctx context.Context) error
+}
+
+// QuotaError is returned for commands of a tenant, which exceeded its quota
+type QuotaError struct {
+	Tenant string // tenant identified from context
+	Limit  string // exceeded limit, "rate" or "pending"
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %s limit of tenant %q", ErrQuotaExceeded, e.Limit, e.Tenant)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// tenantState is a usage of quota by a single tenant
+type tenantState struct {
+	tokens  float64   // available commands, refilled with the rate
+	updated time.Time // time of last refill
+	pending int       // number of commands awaiting results
+}
+
+// TenantQuota is an Admitter, which limits commands per second and pending commands of every tenant,
+// so a single noisy tenant can't exhaust a shared autopipeline
+type TenantQuota struct {
+	mx         *sync.Mutex
+	tenant     func(ctx context.Context) string // identifies tenant from context
+	rate       float64                          // commands per second
+	maxPending int                              // max number of commands awaiting results
+	now        func() time.Time                 // source of time
+	tenants    map[string]*tenantState          // usage of quota by tenants
+}
+
+// NewTenantQuota returns an Admitter, which identifies tenants with the tenant function
+// and limits each of them with rate commands per second and maxPending commands awaiting results,
+// zero value disables the respective limit
+func NewTenantQuota(tenant func(ctx context.Context) string, rate float64, maxPending int) *TenantQuota {
+	return &TenantQuota{
+		mx:         &sync.Mutex{},
+		tenant:     tenant,
+		rate:       rate,
+		maxPending: maxPending,
+		now:        time.Now,
+		tenants:    make(map[string]*tenantState),
+	}
+}
+
+func (q *TenantQuota) Admit(ctx context.Context, _ string) error {
+	tenant := q.tenant(ctx)
+	now := q.now()
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	s, ok := q.tenants[tenant]
+	if !ok {
+		s = &tenantState{tokens: q.burst(), updated: now}
+		q.tenants[tenant] = s
+	}
+	if q.maxPending > 0 && s.pending >= q.maxPending {
+		return &QuotaError{Tenant: tenant, Limit: "pending"}
+	}
+	if q.rate > 0 {
+		// refill tokens, but not above the burst
+		s.tokens += now.Sub(s.updated).Seconds() * q.rate
+		if s.tokens > q.burst() {
+			s.tokens = q.burst()
+		}
+		s.updated = now
+		if s.tokens < 1 {
+			return &QuotaError{Tenant: tenant, Limit: "rate"}
+		}
+		s.tokens--
+	}
+	s.pending++
+	return nil
+}
+
+func (q *TenantQuota) Done(ctx context.Context, _ string) {
+	tenant := q.tenant(ctx)
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	s, ok := q.tenants[tenant]
+	if !ok {
+		return
+	}
+	s.pending--
+	// forget idle tenants with full bucket, so the map doesn't grow with tenants seen once
+	if s.pending <= 0 && (q.rate == 0 || s.tokens+q.now().Sub(s.updated).Seconds()*q.rate >= q.burst()) {
+		delete(q.tenants, tenant)
+	}
+}
+
+// burst returns max number of commands, which may be admitted at once, it's one second of commands, but at least one
+func (q *TenantQuota) burst() float64 {
+	if q.rate < 1 {
+		return 1
+	}
+	return q.rate
+}
@@ -0,0 +1,140 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type tenantKey struct{}
+
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+func TestTenantQuotaRate(t *testing.T) {
+	ctx := context.WithValue(context.TODO(), tenantKey{}, "a")
+	otherCtx := context.WithValue(context.TODO(), tenantKey{}, "b")
+	now := time.Now()
+	q := NewTenantQuota(tenantFromContext, 2, 0)
+	q.now = func() time.Time { return now }
+
+	assert.Nil(t, q.Admit(ctx, "Get"))
+	assert.Nil(t, q.Admit(ctx, "Get"))
+	err := q.Admit(ctx, "Get")
+	var quotaErr *QuotaError
+	assert.True(t, errors.As(err, &quotaErr))
+	assert.Equal(t, &QuotaError{Tenant: "a", Limit: "rate"}, quotaErr)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	// other tenant has its own quota
+	assert.Nil(t, q.Admit(otherCtx, "Get"))
+
+	// tokens are refilled with the rate
+	now = now.Add(500 * time.Millisecond)
+	assert.Nil(t, q.Admit(ctx, "Get"))
+	assert.NotNil(t, q.Admit(ctx, "Get"))
+}
+
+func TestTenantQuotaPending(t *testing.T) {
+	ctx := context.WithValue(context.TODO(), tenantKey{}, "a")
+	q := NewTenantQuota(tenantFromContext, 0, 1)
+
+	assert.Nil(t, q.Admit(ctx, "Get"))
+	assert.Equal(t, &QuotaError{Tenant: "a", Limit: "pending"}, q.Admit(ctx, "Get"))
+	q.Done(ctx, "Get")
+	assert.Empty(t, q.tenants)
+	assert.Nil(t, q.Admit(ctx, "Get"))
+}
+
+func TestAdmission(t *testing.T) {
+	ctx := context.WithValue(context.TODO(), tenantKey{}, "a")
+	otherCtx := context.WithValue(context.TODO(), tenantKey{}, "b")
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key1").SetVal("john")
+	mock.ExpectGet("key2").SetVal("jane")
+	mock.MatchExpectationsInOrder(false)
+	q := NewTenantQuota(tenantFromContext, 0, 1)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200),
+		WithAdmission(q))
+	assert.Nil(t, err)
+
+	c.Pause()
+	resCh1 := c.GetAsync(ctx, "key1")
+	// noisy tenant is rejected, others are not affected
+	_, err = c.Get(ctx, "key2").Result()
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	_, err = c.AwaitOnly().Get(ctx, "key2").Await(ctx)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	resCh2 := c.GetAsync(otherCtx, "key2")
+	c.Resume()
+
+	assert.Equal(t, "john", (<-resCh1).(*redis.StringCmd).Val())
+	assert.Equal(t, "jane", (<-resCh2).(*redis.StringCmd).Val())
+	// quota is released on delivery
+	assert.Nil(t, q.Admit(ctx, "Get"))
+}
+
+// countingLimiter counts waits and fails the first `fails` ones
+type countingLimiter struct {
+	mx    sync.Mutex
+	waits int
+	fails int
+}
+
+func (l *countingLimiter) Wait(context.Context) error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.waits++
+	if l.waits <= l.fails {
+		return errors.New("rate limited")
+	}
+	return nil
+}
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectGet("key1").SetVal("john")
+	mock.ExpectGet("key2").SetVal("jane")
+	mock.ExpectGet("key3").SetVal("jack")
+
+	limiter := &countingLimiter{fails: 1}
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxCommandsPerPipeline(2),
+		WithRateLimiter(limiter))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	// commands of the flush, which wasn't permitted, fail with the error of limiter
+	c.Pause()
+	chs := []<-chan interface{}{c.GetAsync(ctx, "key1"), c.GetAsync(ctx, "key2"), c.GetAsync(ctx, "key3")}
+	c.Resume()
+	for _, ch := range chs {
+		assert.EqualError(t, (<-ch).(*redis.StringCmd).Err(), "rate limited")
+	}
+
+	c.Pause()
+	chs = []<-chan interface{}{c.GetAsync(ctx, "key1"), c.GetAsync(ctx, "key2"), c.GetAsync(ctx, "key3")}
+	c.Resume()
+	for i, want := range []string{"john", "jane", "jack"} {
+		assert.Equal(t, want, (<-chs[i]).(*redis.StringCmd).Val())
+	}
+	// every pipeline is waited for
+	limiter.mx.Lock()
+	assert.Equal(t, 3, limiter.waits)
+	limiter.mx.Unlock()
+	assert.Equal(t, uint64(2), c.Stats().Errors)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
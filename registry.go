@@ -0,0 +1,135 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"sync"
+)
+
+// firstCustomOperation is the kind of the first registered custom operation, kinds below are reserved for built-in commands
+const firstCustomOperation operationPrefix = 128
+
+var (
+	// ErrInvalidOperation is returned by RegisterOperation for an empty name or missing functions
+	ErrInvalidOperation = errors.New("invalid custom operation")
+	// ErrOperationRegistered is returned by RegisterOperation if the name is already taken
+	ErrOperationRegistered = errors.New("operation is already registered")
+	// ErrTooManyOperations is returned by RegisterOperation if all kinds of custom operations are taken
+	ErrTooManyOperations = errors.New("too many custom operations")
+	// ErrUnknownOperation is returned for commands of operation, which wasn't registered
+	ErrUnknownOperation = errors.New("unknown operation")
+)
+
+// EncodeFunc transforms arguments of custom operation to the payload,
+// commands with identical payloads queued before the flush are executed once
+type EncodeFunc func(args ...interface{}) []string
+
+// BuildFunc adds redis command of custom operation with the payload to the pipeline and returns it,
+// f.e. pipe.HStrLen(ctx, payload[0], payload[1]), the command is sent to listeners as is
+type BuildFunc func(ctx context.Context, pipe redis.Pipeliner, payload []string) redis.Cmder
+
+// customOperation is an operation registered with RegisterOperation
+type customOperation struct {
+	kind   operationPrefix
+	name   string
+	encode EncodeFunc
+	build  BuildFunc
+}
+
+// registry contains custom operations by name and by kind
+var registry = struct {
+	mx     sync.RWMutex
+	byName map[string]*customOperation
+	byKind map[operationPrefix]*customOperation
+}{
+	byName: make(map[string]*customOperation),
+	byKind: make(map[operationPrefix]*customOperation),
+}
+
+// RegisterOperation registers custom operation, so redis commands not wrapped by the package
+// may be batched with Operation and OperationAsync of any Client, it's usually called from init,
+// name should differ from names of built-in commands, f.e. "HGet"
+func RegisterOperation(name string, encode EncodeFunc, build BuildFunc) error {
+	if name == "" || encode == nil || build == nil {
+		return ErrInvalidOperation
+	}
+	for _, builtIn := range operationNames {
+		if builtIn == name {
+			return fmt.Errorf("%w: %s", ErrOperationRegistered, name)
+		}
+	}
+	registry.mx.Lock()
+	defer registry.mx.Unlock()
+	if _, ok := registry.byName[name]; ok {
+		return fmt.Errorf("%w: %s", ErrOperationRegistered, name)
+	}
+	if len(registry.byKind) > int(^operationPrefix(0)-firstCustomOperation) {
+		return ErrTooManyOperations
+	}
+	o := &customOperation{
+		kind:   firstCustomOperation + operationPrefix(len(registry.byKind)),
+		name:   name,
+		encode: encode,
+		build:  build,
+	}
+	registry.byName[name] = o
+	registry.byKind[o.kind] = o
+	return nil
+}
+
+// lookupOperation returns custom operation by name
+func lookupOperation(name string) (*customOperation, bool) {
+	registry.mx.RLock()
+	defer registry.mx.RUnlock()
+	o, ok := registry.byName[name]
+	return o, ok
+}
+
+// lookupOperationKind returns custom operation by kind
+func lookupOperationKind(kind operationPrefix) (*customOperation, bool) {
+	if kind < firstCustomOperation {
+		return nil, false
+	}
+	registry.mx.RLock()
+	defer registry.mx.RUnlock()
+	o, ok := registry.byKind[kind]
+	return o, ok
+}
+
+// Operation executes custom operation registered with RegisterOperation in the same pipeline as other commands,
+// result should be cast to the type of command returned by BuildFunc
+// *redis.Cmd with ErrUnknownOperation is returned if operation isn't registered
+func (a Autopipeline) Operation(ctx context.Context, name string, args ...interface{}) redis.Cmder {
+	o, ok := lookupOperation(name)
+	if !ok {
+		return unknownOperationCmd(name)
+	}
+	payload := opaquePayload(o.encode(args...))
+	resCh := a.cache.enqueue(ctx, o.kind, payload)
+	res, err := a.await(ctx, resCh)
+	if err != nil {
+		return a.cache.failedCmd(o.kind, payload, err).(redis.Cmder)
+	}
+	return res.(redis.Cmder)
+}
+
+// OperationAsync is an async variant of Operation
+func (a Autopipeline) OperationAsync(ctx context.Context, name string, args ...interface{}) <-chan interface{} {
+	o, ok := lookupOperation(name)
+	if !ok {
+		resCh := make(chan interface{}, resultChannelBufferSize)
+		resCh <- unknownOperationCmd(name)
+		close(resCh)
+		return resCh
+	}
+	return a.cache.enqueue(ctx, o.kind, opaquePayload(o.encode(args...)))
+}
+
+// unknownOperationCmd returns a redis command with ErrUnknownOperation set
+func unknownOperationCmd(name string) *redis.Cmd {
+	cmd := &redis.Cmd{}
+	cmd.SetErr(fmt.Errorf("%w: %s", ErrUnknownOperation, name))
+	return cmd
+}
@@ -0,0 +1,77 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+var registerStrLen sync.Once
+
+// strLen registers StrLen custom operation once, as registry is shared by all tests
+func strLen(t *testing.T) {
+	registerStrLen.Do(func() {
+		err := RegisterOperation("StrLen", func(args ...interface{}) []string {
+			return []string{fmt.Sprint(args[0])}
+		}, func(ctx context.Context, pipe redis.Pipeliner, payload []string) redis.Cmder {
+			return pipe.StrLen(ctx, payload[0])
+		})
+		assert.Nil(t, err)
+	})
+}
+
+func TestRegisterOperation(t *testing.T) {
+	strLen(t)
+	encode := func(args ...interface{}) []string { return nil }
+	build := func(ctx context.Context, pipe redis.Pipeliner, payload []string) redis.Cmder { return nil }
+
+	assert.ErrorIs(t, RegisterOperation("", encode, build), ErrInvalidOperation)
+	assert.ErrorIs(t, RegisterOperation("Custom", nil, build), ErrInvalidOperation)
+	assert.ErrorIs(t, RegisterOperation("Custom", encode, nil), ErrInvalidOperation)
+	assert.ErrorIs(t, RegisterOperation("HGet", encode, build), ErrOperationRegistered)
+	assert.ErrorIs(t, RegisterOperation("StrLen", encode, build), ErrOperationRegistered)
+
+	o, ok := lookupOperation("StrLen")
+	assert.True(t, ok)
+	assert.Equal(t, "StrLen", o.kind.String())
+}
+
+func TestOperation(t *testing.T) {
+	strLen(t)
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectStrLen("key1").SetVal(4)
+	mock.ExpectStrLen("key2").SetVal(5)
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	c.Pause()
+	resCh := c.OperationAsync(ctx, "StrLen", "key1")
+	// identical commands are coalesced
+	_ = c.OperationAsync(ctx, "StrLen", "key1")
+	assert.Equal(t, 1, c.Len())
+	c.Resume()
+	assert.Equal(t, int64(4), (<-resCh).(*redis.IntCmd).Val())
+
+	cmd := c.Operation(ctx, "StrLen", "key2")
+	assert.Nil(t, cmd.Err())
+	assert.Equal(t, int64(5), cmd.(*redis.IntCmd).Val())
+
+	cmd = c.Operation(ctx, "HLen", "key1")
+	assert.ErrorIs(t, cmd.Err(), ErrUnknownOperation)
+	assert.ErrorIs(t, (<-c.OperationAsync(ctx, "HLen", "key1")).(redis.Cmder).Err(), ErrUnknownOperation)
+
+	// failed command has the type of operation command
+	cmd = c.Operation(ContextWithCommandTimeout(ctx, time.Nanosecond), "StrLen", "key3")
+	assert.ErrorIs(t, cmd.Err(), ErrCommandTimeout)
+	assert.IsType(t, &redis.IntCmd{}, cmd)
+}
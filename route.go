@@ -0,0 +1,84 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+)
+
+// masterForKeyFunc returns address of cluster node, which serves the key
+type masterForKeyFunc func(ctx context.Context, key string) (string, error)
+
+// clusterMasterForKey returns address of master node of the key known to cluster client
+func clusterMasterForKey(client *redis.ClusterClient) masterForKeyFunc {
+	return func(ctx context.Context, key string) (string, error) {
+		node, err := client.MasterForKey(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		return node.Options().Addr, nil
+	}
+}
+
+// nodeRouter groups commands of a flush by cluster node of their keys, see WithNodeBatching,
+// it's used by background goroutine only
+type nodeRouter struct {
+	masterForKey masterForKeyFunc
+	slots        map[int]string // nodes of hash slots resolved during the flush
+}
+
+func newNodeRouter(masterForKey masterForKeyFunc) *nodeRouter {
+	return &nodeRouter{masterForKey: masterForKey, slots: make(map[int]string)}
+}
+
+// reset forgets nodes of the previous flush, as slots may be migrated meanwhile,
+// and makes cluster client load its state, if it's not loaded yet, so storage isn't locked while it's loaded
+func (r *nodeRouter) reset(ctx context.Context) {
+	clear(r.slots)
+	_, _ = r.masterForKey(ctx, "")
+}
+
+// pipeGroup is a target of commands of a flush, which have their own pipelines: cluster node of their keys
+// with node batching, and replicas or primaries with replica reads
+type pipeGroup struct {
+	node     string
+	readOnly bool
+}
+
+// join returns the target of commands of both groups, which are executed in the same pipeline, see chunk,
+// commands of different nodes are routed by cluster client, mutating commands are executed by primaries
+func (g pipeGroup) join(other pipeGroup) pipeGroup {
+	if g.node != other.node {
+		g.node = ""
+	}
+	g.readOnly = g.readOnly && other.readOnly
+	return g
+}
+
+// group returns the target of the operation, see WithNodeBatching and WithReplicaReads
+func (c *cache) group(ctx context.Context, op *redisOperation) pipeGroup {
+	var g pipeGroup
+	if c.router != nil {
+		g.node = c.router.node(ctx, op.payload)
+	}
+	g.readOnly = c.replicaReads && op.kind.readOnly()
+	return g
+}
+
+// node returns address of cluster node of the operation, empty string is returned for operations without keys
+// and for ones, node of which is unknown, pipeline of them is routed by cluster client itself
+func (r *nodeRouter) node(ctx context.Context, p payload) string {
+	key, ok := payloadKey(p)
+	if !ok {
+		return ""
+	}
+	slot := KeyHashSlot(key)
+	if node, ok := r.slots[slot]; ok {
+		return node
+	}
+	node, err := r.masterForKey(ctx, key)
+	if err != nil {
+		node = ""
+	}
+	r.slots[slot] = node
+	return node
+}
@@ -0,0 +1,226 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipelinesHook records names and first arguments of commands of every pipeline, commands are completed by mgetHook
+type pipelinesHook struct {
+	mgetHook
+	mx        sync.Mutex
+	pipelines [][]string
+	commands  [][]string
+}
+
+func (h *pipelinesHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	process := h.mgetHook.ProcessPipelineHook(next)
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		args := make([]string, len(cmds))
+		names := make([]string, len(cmds))
+		for i, cmd := range cmds {
+			args[i] = fmt.Sprint(cmd.Args()[1])
+			names[i] = cmd.Name()
+		}
+		h.mx.Lock()
+		h.pipelines = append(h.pipelines, args)
+		h.commands = append(h.commands, names)
+		h.mx.Unlock()
+		return process(ctx, cmds)
+	}
+}
+
+func (h *pipelinesHook) get() [][]string {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	return append([][]string(nil), h.pipelines...)
+}
+
+func (h *pipelinesHook) names() [][]string {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	return append([][]string(nil), h.commands...)
+}
+
+// twoNodes places the lower half of hash slots to node "a" and the upper one to node "b"
+func twoNodes(_ context.Context, key string) (string, error) {
+	if key == "" {
+		return "", errors.New("no key")
+	}
+	if KeyHashSlot(key) < hashSlotsCount/2 {
+		return "a", nil
+	}
+	return "b", nil
+}
+
+func TestPayloadKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload payload
+		want    string
+		wantOk  bool
+	}{
+		{name: "key", payload: keyPayload{key: "k"}, want: "k", wantOk: true},
+		{name: "keys", payload: keysPayload{"k1", "k2"}, want: "k1", wantOk: true},
+		{name: "no keys", payload: keysPayload{}},
+		{name: "hdel", payload: hdelPayload{key: "k", fields: []string{"f"}}, want: "k", wantOk: true},
+		{name: "hget", payload: hgetPayload{key: "k", field: "f"}, want: "k", wantOk: true},
+		{name: "expire", payload: expirePayload{key: "k", expiration: time.Second}, want: "k", wantOk: true},
+		{name: "set", payload: setPayload{key: "k", value: "v"}, want: "k", wantOk: true},
+		{name: "script", payload: scriptPayload{script: "s", keys: []string{"k"}}, want: "k", wantOk: true},
+		{name: "script without keys", payload: scriptPayload{script: "s"}},
+		{name: "safe del", payload: safeDelPayload{key: "k", expectedType: "hash"}, want: "k", wantOk: true},
+		{name: "value", payload: valuePayload{value: "v"}},
+		{name: "opaque", payload: opaquePayload{"get", "k"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := payloadKey(tt.payload)
+			assert.Equal(t, tt.want, key)
+			assert.Equal(t, tt.wantOk, ok)
+		})
+	}
+}
+
+func TestNodeBatching(t *testing.T) {
+	ctx := context.TODO()
+	keys := []string{"key1", "key2", "key3", "key4", "key5", "key6", "key7", "key8"}
+
+	tests := []struct {
+		name         string
+		masterForKey masterForKeyFunc
+		maxCommands  uint
+		wantNodes    bool
+	}{
+		{name: "pipeline per node", masterForKey: twoNodes, wantNodes: true},
+		{name: "full pipelines of nodes are split", masterForKey: twoNodes, maxCommands: 2, wantNodes: true},
+		{name: "not a cluster client"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &pipelinesHook{}
+			db := redis.NewClient(&redis.Options{})
+			db.AddHook(hook)
+			c, err := NewAutoPipeline(db,
+				WithCacheTTL(time.Microsecond*100),
+				WithNodeBatching(true),
+				WithMaxCommandsPerPipeline(tt.maxCommands),
+				func(a *Autopipeline) {
+					a.cnf.masterForKey = tt.masterForKey
+				})
+			assert.Nil(t, err)
+			defer c.Stop()
+			assert.True(t, c.Config().NodeBatching)
+
+			c.Pause()
+			results := make([]<-chan interface{}, len(keys))
+			for i, key := range keys {
+				results[i] = c.GetAsync(ctx, key)
+			}
+			echo := c.EchoAsync(ctx, "hello")
+			c.Resume()
+			for i, key := range keys {
+				assert.Equal(t, key, (<-results[i]).(*redis.StringCmd).Val())
+			}
+			assert.Equal(t, "hello", (<-echo).(*redis.StringCmd).Val())
+
+			pipelines := hook.get()
+			if !tt.wantNodes {
+				assert.Len(t, pipelines, 1)
+				return
+			}
+			commands := 0
+			nodes := make(map[string]int)
+			for _, args := range pipelines {
+				commands += len(args)
+				if tt.maxCommands > 0 {
+					assert.LessOrEqual(t, len(args), int(tt.maxCommands))
+				}
+				// commands of every pipeline are served by the same node
+				node, _ := twoNodes(ctx, args[0])
+				if args[0] == "hello" {
+					node = ""
+				}
+				for _, arg := range args {
+					if arg == "hello" {
+						assert.Equal(t, "", node)
+						continue
+					}
+					n, _ := twoNodes(ctx, arg)
+					assert.Equal(t, node, n)
+				}
+				nodes[node]++
+			}
+			assert.Equal(t, len(keys)+1, commands)
+			assert.Len(t, nodes, 3)
+			if tt.maxCommands == 0 {
+				assert.Len(t, pipelines, 3)
+			}
+		})
+	}
+}
+
+func TestReplicaReads(t *testing.T) {
+	ctx := context.TODO()
+	readOnly := map[string]bool{"get": true, "hgetall": true, "smembers": true, "mget": true, "del": false, "expire": false}
+
+	tests := []struct {
+		name          string
+		options       []func(a *Autopipeline)
+		wantPipelines int
+	}{
+		{name: "reads apart from writes", wantPipelines: 2},
+		{
+			name: "reads apart from writes of every node",
+			options: []func(a *Autopipeline){WithNodeBatching(true), func(a *Autopipeline) {
+				a.cnf.masterForKey = twoNodes
+			}},
+			wantPipelines: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &pipelinesHook{}
+			db := redis.NewClient(&redis.Options{})
+			db.AddHook(hook)
+			c, err := NewAutoPipeline(db, append([]func(a *Autopipeline){
+				WithCacheTTL(time.Microsecond * 100),
+				WithReplicaReads(true),
+			}, tt.options...)...)
+			assert.Nil(t, err)
+			defer c.Stop()
+			assert.True(t, c.Config().ReplicaReads)
+
+			c.Pause()
+			var results []<-chan interface{}
+			for _, key := range []string{"key1", "key2", "key3", "key4"} {
+				results = append(results,
+					c.GetAsync(ctx, key),
+					c.HGetAllAsync(ctx, key),
+					c.SMembersAsync(ctx, key),
+					c.MGetAsync(ctx, key),
+					c.DelAsync(ctx, key),
+					c.ExpireAsync(ctx, key, time.Minute))
+			}
+			c.Resume()
+			for _, res := range results {
+				<-res
+			}
+
+			pipelines := hook.names()
+			assert.Len(t, pipelines, tt.wantPipelines)
+			for _, names := range pipelines {
+				// commands of every pipeline are either read-only or mutating
+				for _, name := range names {
+					assert.Equal(t, readOnly[names[0]], readOnly[name], names)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+)
+
+// safeDelScript deletes the key only if it has the expected type,
+// it returns the number of deleted keys, or actual type of the key if it doesn't match
+const safeDelScript = `local t = redis.call('TYPE', KEYS[1])['ok']
+if t == 'none' then
+	return 0
+end
+if t ~= ARGV[1] then
+	return t
+end
+return redis.call('DEL', KEYS[1])`
+
+// safeDel is a SafeDel command, which is executed as a lua script with TYPE check and conditional DEL
+type safeDel struct {
+	key          string     // key to delete
+	expectedType string     // expected type of key, as reported by TYPE command
+	cmd          *redis.Cmd // script command
+}
+
+func newSafeDel(ctx context.Context, pipe redis.Pipeliner, key, expectedType string) *safeDel {
+	return &safeDel{
+		key:          key,
+		expectedType: expectedType,
+		cmd:          pipe.Eval(ctx, safeDelScript, []string{key}, expectedType),
+	}
+}
+
+// merge returns the result of script as a Del command,
+// ErrTypeMismatch is set if the key has another type than expected
+func (d *safeDel) merge(ctx context.Context) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx, "del", d.key)
+	if err := d.cmd.Err(); err != nil {
+		cmd.SetErr(err)
+		return cmd
+	}
+	switch v := d.cmd.Val().(type) {
+	case int64:
+		cmd.SetVal(v)
+	case string:
+		cmd.SetErr(fmt.Errorf("%w: key %s has type %s, expected %s", ErrTypeMismatch, d.key, v, d.expectedType))
+	default:
+		cmd.SetErr(fmt.Errorf("unexpected result of safe delete script: %v", v))
+	}
+	return cmd
+}
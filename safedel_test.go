@@ -0,0 +1,56 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSafeDel(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  interface{}
+		want    int64
+		wantErr error
+	}{
+		{
+			name:   "deleted",
+			result: int64(1),
+			want:   1,
+		},
+		{
+			name:   "missing key",
+			result: int64(0),
+			want:   0,
+		},
+		{
+			name:    "type mismatch",
+			result:  "string",
+			wantErr: ErrTypeMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctx = context.TODO()
+			db, mock := redismock.NewClientMock()
+			mock.ExpectEval(safeDelScript, []string{"key"}, "hash").SetVal(tt.result)
+
+			c, err := NewAutoPipeline(db,
+				WithCacheTTL(time.Microsecond*5),
+				WithMaxSize(200))
+			assert.Nil(t, err)
+
+			deleted, err := c.SafeDel(ctx, "key", "hash").Result()
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.ErrorContains(t, err, "key key has type string, expected hash")
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, deleted)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// Sample returns up to n random keys, f.e. for cache audit tooling,
+// keys are picked with n RANDOMKEY commands executed in a single pipeline, so duplicates are possible
+// and they are skipped, result is empty for an empty database
+// with key prefix only keys having it are returned, stripped of it
+// RANDOMKEY commands are not coalesced, so they are executed directly, bypassing the cache
+func (a Autopipeline) Sample(ctx context.Context, n int) ([]string, error) {
+	if n <= 0 {
+		return []string{}, nil
+	}
+	pipe := a.cache.client().Pipeline()
+	cmds := make([]*redis.StringCmd, n)
+	for i := range cmds {
+		cmds[i] = pipe.RandomKey(ctx)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, n)
+	keys := make([]string, 0, n)
+	for _, cmd := range cmds {
+		key, err := cmd.Result()
+		if errors.Is(err, redis.Nil) {
+			// database is empty
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// keys of other namespaces are skipped
+		key, ok := stripKeyPrefix(a.cnf.keyPrefix, key)
+		if !ok {
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,55 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSample(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectRandomKey().SetVal("a")
+	mock.ExpectRandomKey().SetVal("b")
+	mock.ExpectRandomKey().SetVal("a")
+
+	c, err := NewAutoPipeline(db)
+	assert.Nil(t, err)
+
+	keys, err := c.Sample(ctx, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, keys)
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	keys, err = c.Sample(ctx, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestSampleEmptyDatabase(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectRandomKey().RedisNil()
+
+	c, err := NewAutoPipeline(db)
+	assert.Nil(t, err)
+
+	keys, err := c.Sample(ctx, 1)
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestSampleKeyPrefix(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectRandomKey().SetVal("tenant1:a")
+	mock.ExpectRandomKey().SetVal("tenant2:b")
+
+	c, err := NewAutoPipeline(db, WithKeyPrefix("tenant1:"))
+	assert.Nil(t, err)
+
+	keys, err := c.Sample(ctx, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a"}, keys)
+}
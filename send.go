@@ -0,0 +1,59 @@
+package redis_autopipeline
+
+import (
+	"fmt"
+	"time"
+)
+
+// sender puts results to listener channels, a listener which doesn't receive the result doesn't wedge
+// the flush path: the result waits for it up to timeout and is dropped then according to the policy
+type sender struct {
+	timeout time.Duration // max time to wait for the listener, zero means the result is dropped right away
+	policy  DropPolicy    // behavior for dropped results
+	clock   Clock
+	log     Logger
+	stats   *stats
+}
+
+// send puts the result to the listener channel and closes it, so listener may use range or `res, ok := <-ch` semantics,
+// channel is closed even if the result is dropped, so listener doesn't wait for it forever
+func (s *sender) send(ch chan interface{}, result interface{}) {
+	// For case of write to a channel closed by the listener, it's closed already then
+	defer func() {
+		if r := recover(); r != nil {
+			s.drop(fmt.Errorf("%w: %v", ErrResultDropped, r))
+		}
+	}()
+	if !s.put(ch, result) {
+		s.drop(ErrResultDropped)
+	}
+	close(ch)
+}
+
+// put puts the result to the channel, it waits for the listener up to timeout if the channel is full
+func (s *sender) put(ch chan interface{}, result interface{}) bool {
+	select {
+	case ch <- result:
+		return true
+	default:
+	}
+	if s.timeout <= 0 {
+		return false
+	}
+	t := s.clock.NewTimer(s.timeout)
+	defer t.Stop()
+	select {
+	case ch <- result:
+		return true
+	case <-t.C():
+		return false
+	}
+}
+
+// drop registers the result, which listener didn't receive
+func (s *sender) drop(err error) {
+	s.stats.droppedResult()
+	if s.policy == DropLog {
+		s.log.Error(err)
+	}
+}
@@ -0,0 +1,123 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSender(t *testing.T) {
+	tests := []struct {
+		name        string
+		timeout     time.Duration
+		policy      DropPolicy
+		ch          func() chan interface{}
+		receive     bool
+		wantDropped uint64
+		wantLogged  int
+	}{
+		{
+			name: "buffered channel",
+			ch:   func() chan interface{} { return make(chan interface{}, resultChannelBufferSize) },
+		},
+		{
+			name:        "full channel",
+			ch:          func() chan interface{} { return make(chan interface{}) },
+			wantDropped: 1,
+		},
+		{
+			name:        "full channel with timeout",
+			timeout:     time.Millisecond,
+			policy:      DropLog,
+			ch:          func() chan interface{} { return make(chan interface{}) },
+			wantDropped: 1,
+			wantLogged:  1,
+		},
+		{
+			name:    "listener receives within timeout",
+			timeout: time.Second,
+			policy:  DropLog,
+			ch:      func() chan interface{} { return make(chan interface{}) },
+			receive: true,
+		},
+		{
+			name:   "closed channel",
+			policy: DropLog,
+			ch: func() chan interface{} {
+				ch := make(chan interface{}, resultChannelBufferSize)
+				close(ch)
+				return ch
+			},
+			wantDropped: 1,
+			wantLogged:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := &errorRecorder{}
+			s := &sender{timeout: tt.timeout, policy: tt.policy, clock: realClock{}, log: log, stats: newStats()}
+			ch := tt.ch()
+			received := make(chan interface{}, 1)
+			if tt.receive {
+				go func() {
+					received <- <-ch
+				}()
+			}
+			s.send(ch, "result")
+			if tt.receive {
+				assert.Equal(t, "result", <-received)
+			} else if tt.wantDropped == 0 {
+				assert.Equal(t, "result", <-ch)
+			}
+			// channel is closed anyway
+			_, ok := <-ch
+			assert.False(t, ok)
+			assert.Equal(t, tt.wantDropped, s.stats.snapshot().DroppedResults)
+			assert.Len(t, log.messages, tt.wantLogged)
+			for _, m := range log.messages {
+				assert.Contains(t, m, ErrResultDropped.Error())
+			}
+		})
+	}
+}
+
+func TestDropPolicy(t *testing.T) {
+	ctx := context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("key1").SetVal("john")
+
+	log := &errorRecorder{}
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithLogger(log),
+		WithDeliveryTimeout(time.Millisecond),
+		WithDropPolicy(DropLog))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.Equal(t, Duration(time.Millisecond), c.Config().DeliveryTimeout)
+	assert.Equal(t, DropLog, c.Config().DropPolicy)
+	cache := c.(*Autopipeline).cache
+
+	c.Pause()
+	// listener, which never receives, shares the command with a well-behaved one
+	stuck := make(chan interface{})
+	assert.Nil(t, cache.listen(ctx, Get, keyPayload{key: "key1"}, stuck))
+	resCh := c.GetAsync(ctx, "key1")
+	c.Resume()
+
+	select {
+	case res := <-resCh:
+		assert.Equal(t, "john", res.(*redis.StringCmd).Val())
+	case <-time.After(time.Second):
+		t.Fatal("result is wedged by listener, which never receives")
+	}
+	_, ok := <-stuck
+	assert.False(t, ok)
+	assert.Equal(t, uint64(1), c.Stats().DroppedResults)
+	// stuck listener is served before the other one, so the drop is logged already
+	assert.Len(t, log.messages, 1)
+	assert.Eventually(t, func() bool { return cache.activeListeners.Load() == 0 }, time.Second, time.Millisecond)
+}
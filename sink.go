@@ -0,0 +1,110 @@
+package redis_autopipeline
+
+import (
+	"strconv"
+	"time"
+)
+
+// MetricsSink receives events of autopipeline as they happen, see WithMetricsSink, so any metrics system,
+// f.e. StatsD, Datadog or OpenCensus, may be wired with a thin adapter, see NewStatsdSink;
+// it's called synchronously by callers, lanes and workers, concurrently, so it should be fast and non-blocking
+type MetricsSink interface {
+	// ObserveEnqueue is called for every queued command, deduped if it's joined to already queued identical one
+	ObserveEnqueue(operation string, deduped bool)
+	// ObserveBatch is called for every executed pipeline with the reason of flush, f.e. "ttl" or "size",
+	// the number of commands in it and its execution time, including failed ones
+	ObserveBatch(reason string, commands int, exec time.Duration)
+	// ObserveError is called for every failed pipeline
+	ObserveError(err error)
+	// ObserveDrop is called for every result, which listener didn't receive, see WithDropPolicy
+	ObserveDrop()
+	// ObserveOverflow is called for every command, which found the cache full, see WithMaxPending
+	ObserveOverflow()
+}
+
+// multiSink is a MetricsSink, which passes every event to all of its sinks in order, see WithMetricsSink
+type multiSink []MetricsSink
+
+// newSink returns MetricsSink, which passes events to sinks, nil if there are no sinks
+func newSink(sinks []MetricsSink) MetricsSink {
+	switch len(sinks) {
+	case 0:
+		return nil
+	case 1:
+		return sinks[0]
+	}
+	return multiSink(sinks)
+}
+
+func (s multiSink) ObserveEnqueue(operation string, deduped bool) {
+	for _, sink := range s {
+		sink.ObserveEnqueue(operation, deduped)
+	}
+}
+
+func (s multiSink) ObserveBatch(reason string, commands int, exec time.Duration) {
+	for _, sink := range s {
+		sink.ObserveBatch(reason, commands, exec)
+	}
+}
+
+func (s multiSink) ObserveError(err error) {
+	for _, sink := range s {
+		sink.ObserveError(err)
+	}
+}
+
+func (s multiSink) ObserveDrop() {
+	for _, sink := range s {
+		sink.ObserveDrop()
+	}
+}
+
+func (s multiSink) ObserveOverflow() {
+	for _, sink := range s {
+		sink.ObserveOverflow()
+	}
+}
+
+// StatsdClient is a subset of methods of datadog-go statsd client, so *statsd.Client is passed as is,
+// other statsd clients are wrapped easily
+type StatsdClient interface {
+	Incr(name string, tags []string, rate float64) error
+	Histogram(name string, value float64, tags []string, rate float64) error
+	Timing(name string, value time.Duration, tags []string, rate float64) error
+}
+
+// statsdSink is a MetricsSink, which sends events to statsd with names prefixed by prefix
+type statsdSink struct {
+	client StatsdClient
+	prefix string
+}
+
+// NewStatsdSink returns MetricsSink, which sends events to statsd client as metrics prefixed by prefix,
+// f.e. "autopipeline.": counters enqueued (tagged by operation and deduped), pipeline_errors, dropped_results
+// and overflows, histogram batch_size and timing exec_duration (tagged by reason); errors of client are ignored
+func NewStatsdSink(client StatsdClient, prefix string) MetricsSink {
+	return statsdSink{client: client, prefix: prefix}
+}
+
+func (s statsdSink) ObserveEnqueue(operation string, deduped bool) {
+	_ = s.client.Incr(s.prefix+"enqueued", []string{"operation:" + operation, "deduped:" + strconv.FormatBool(deduped)}, 1)
+}
+
+func (s statsdSink) ObserveBatch(reason string, commands int, exec time.Duration) {
+	tags := []string{"reason:" + reason}
+	_ = s.client.Histogram(s.prefix+"batch_size", float64(commands), tags, 1)
+	_ = s.client.Timing(s.prefix+"exec_duration", exec, tags, 1)
+}
+
+func (s statsdSink) ObserveError(error) {
+	_ = s.client.Incr(s.prefix+"pipeline_errors", nil, 1)
+}
+
+func (s statsdSink) ObserveDrop() {
+	_ = s.client.Incr(s.prefix+"dropped_results", nil, 1)
+}
+
+func (s statsdSink) ObserveOverflow() {
+	_ = s.client.Incr(s.prefix+"overflows", nil, 1)
+}
@@ -0,0 +1,167 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventsSink records events of MetricsSink as strings, f.e. "enqueue Get false"
+type eventsSink struct {
+	mx     sync.Mutex
+	events []string
+}
+
+func (s *eventsSink) add(format string, args ...interface{}) {
+	s.mx.Lock()
+	s.events = append(s.events, fmt.Sprintf(format, args...))
+	s.mx.Unlock()
+}
+
+func (s *eventsSink) get() []string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return append([]string(nil), s.events...)
+}
+
+func (s *eventsSink) ObserveEnqueue(operation string, deduped bool) {
+	s.add("enqueue %s %t", operation, deduped)
+}
+
+func (s *eventsSink) ObserveBatch(reason string, commands int, _ time.Duration) {
+	s.add("batch %s %d", reason, commands)
+}
+
+func (s *eventsSink) ObserveError(err error) {
+	s.add("error %v", err)
+}
+
+func (s *eventsSink) ObserveDrop() {
+	s.add("drop")
+}
+
+func (s *eventsSink) ObserveOverflow() {
+	s.add("overflow")
+}
+
+// statsdRecorder is a StatsdClient, which records sent metrics as strings, f.e. "incr a.enqueued [operation:Get]"
+type statsdRecorder struct {
+	eventsSink
+}
+
+func (r *statsdRecorder) Incr(name string, tags []string, _ float64) error {
+	r.add("incr %s %v", name, tags)
+	return nil
+}
+
+func (r *statsdRecorder) Histogram(name string, value float64, tags []string, _ float64) error {
+	r.add("histogram %s %v %v", name, value, tags)
+	return nil
+}
+
+func (r *statsdRecorder) Timing(name string, _ time.Duration, tags []string, _ float64) error {
+	r.add("timing %s %v", name, tags)
+	return errors.New("statsd is down")
+}
+
+func TestMetricsSink(t *testing.T) {
+	ctx := context.TODO()
+
+	tests := []struct {
+		name       string
+		hook       redis.Hook
+		options    []func(a *Autopipeline)
+		wantEvents []string
+	}{
+		{
+			name: "commands",
+			hook: &mgetHook{},
+			wantEvents: []string{
+				"enqueue Get false",
+				"enqueue Get true",
+				"enqueue Del false",
+				"batch shutdown 2",
+			},
+		},
+		{
+			name: "failed pipeline",
+			hook: &failingHook{},
+			wantEvents: []string{
+				"enqueue Get false",
+				"enqueue Get true",
+				"enqueue Del false",
+				"batch shutdown 2",
+				"error connection refused",
+			},
+		},
+		{
+			name:    "overflow",
+			hook:    &mgetHook{},
+			options: []func(a *Autopipeline){WithMaxPending(2), WithOverflowPolicy(OverflowReject)},
+			wantEvents: []string{
+				"enqueue Get false",
+				"enqueue Get true",
+				"overflow",
+				"batch shutdown 1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := redis.NewClient(&redis.Options{})
+			db.AddHook(tt.hook)
+			sink := &eventsSink{}
+			c, err := NewAutoPipeline(db, append([]func(a *Autopipeline){
+				WithCacheTTL(time.Second),
+				WithMetricsSink(sink),
+			}, tt.options...)...)
+			assert.Nil(t, err)
+
+			results := []<-chan interface{}{c.GetAsync(ctx, "key1"), c.GetAsync(ctx, "key1"), c.DelAsync(ctx, "key2")}
+			c.Stop()
+			for _, res := range results {
+				<-res
+			}
+			assert.Equal(t, tt.wantEvents, sink.get())
+		})
+	}
+}
+
+func TestMetricsSinks(t *testing.T) {
+	ctx := context.TODO()
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(&mgetHook{})
+	first, second := &eventsSink{}, &eventsSink{}
+	c, err := NewAutoPipeline(db, WithCacheTTL(time.Second), WithMetricsSink(first, nil), WithMetricsSink(second))
+	assert.Nil(t, err)
+
+	res := c.GetAsync(ctx, "key1")
+	c.Stop()
+	<-res
+	want := []string{"enqueue Get false", "batch shutdown 1"}
+	assert.Equal(t, want, first.get())
+	assert.Equal(t, want, second.get())
+}
+
+func TestStatsdSink(t *testing.T) {
+	r := &statsdRecorder{}
+	sink := NewStatsdSink(r, "autopipeline.")
+	sink.ObserveEnqueue("Get", true)
+	sink.ObserveBatch("ttl", 3, time.Millisecond)
+	sink.ObserveError(errors.New("connection refused"))
+	sink.ObserveDrop()
+	sink.ObserveOverflow()
+	assert.Equal(t, []string{
+		"incr autopipeline.enqueued [operation:Get deduped:true]",
+		"histogram autopipeline.batch_size 3 [reason:ttl]",
+		"timing autopipeline.exec_duration [reason:ttl]",
+		"incr autopipeline.pipeline_errors []",
+		"incr autopipeline.dropped_results []",
+		"incr autopipeline.overflows []",
+	}, r.get())
+}
@@ -0,0 +1,81 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// slogLevels are levels of slog by log levels of autopipeline
+var slogLevels = map[LogLevel]slog.Level{
+	LogDebug: slog.LevelDebug,
+	LogInfo:  slog.LevelInfo,
+	LogWarn:  slog.LevelWarn,
+	LogError: slog.LevelError,
+}
+
+// slogLogger is a LeveledLogger writing structured records to slog.Logger, see WithSlog
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// sprint formats args same as defaultLogger does
+func sprint(args ...interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
+
+func (s slogLogger) Debug(args ...interface{}) {
+	s.l.Debug(sprint(args...))
+}
+
+func (s slogLogger) Info(args ...interface{}) {
+	s.l.Info(sprint(args...))
+}
+
+func (s slogLogger) Warn(args ...interface{}) {
+	s.l.Warn(sprint(args...))
+}
+
+func (s slogLogger) Error(args ...interface{}) {
+	s.l.Error(sprint(args...))
+}
+
+// flush logs the flush decision and composition of the batch at debug level
+func (s slogLogger) flush(trigger flushTrigger, commands, pipelines int, composition string) {
+	s.l.Debug("flush",
+		slog.String("flush_reason", trigger.String()),
+		slog.Int("batch_size", commands),
+		slog.Int("pipelines", pipelines),
+		slog.String("composition", composition))
+}
+
+// pipeline logs executed pipeline at the level, "pipeline executed" at debug level,
+// "slow pipeline" at warn level with smoothed rtt and "pipeline failed" at error level with the error
+func (s slogLogger) pipeline(level LogLevel, trigger flushTrigger, commands int, exec, smoothedRTT time.Duration, err error) {
+	ctx := context.Background()
+	if !s.l.Enabled(ctx, slogLevels[level]) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.Int("batch_size", commands),
+		slog.String("flush_reason", trigger.String()),
+		slog.Duration("duration", exec),
+	}
+	msg := "pipeline executed"
+	switch level {
+	case LogError:
+		msg = "pipeline failed"
+		attrs = append(attrs, slog.Any("error", err))
+	case LogWarn:
+		msg = "slow pipeline"
+		attrs = append(attrs, slog.Duration("smoothed_rtt", smoothedRTT))
+	}
+	s.l.LogAttrs(ctx, slogLevels[level], msg, attrs...)
+}
+
+// configChange logs change of runtime-tunable setting at info level
+func (s slogLogger) configChange(change ConfigChange) {
+	s.l.Info("config changed", "setting", change.Setting, "old", change.Old, "new", change.New)
+}
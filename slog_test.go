@@ -0,0 +1,94 @@
+package redis_autopipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// records decodes records of slog json handler, durations are in nanoseconds
+func records(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var res []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var r map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &r))
+		delete(r, "time")
+		delete(r, "duration")
+		res = append(res, r)
+	}
+	return res
+}
+
+func TestSlog(t *testing.T) {
+	ctx := context.TODO()
+
+	tests := []struct {
+		name        string
+		hook        redis.Hook
+		level       slog.Level
+		wantRecords []map[string]interface{}
+	}{
+		{
+			name:  "executed pipelines",
+			hook:  &mgetHook{},
+			level: slog.LevelDebug,
+			wantRecords: []map[string]interface{}{
+				{"level": "INFO", "msg": "config changed", "setting": "ttl", "old": "1s", "new": "2s"},
+				{"level": "DEBUG", "msg": "flush", "flush_reason": "shutdown", "batch_size": float64(2), "pipelines": float64(1), "composition": "Del: 1, Get: 1"},
+				{"level": "DEBUG", "msg": "pipeline executed", "batch_size": float64(2), "flush_reason": "shutdown"},
+			},
+		},
+		{
+			name:  "executed pipelines below the level",
+			hook:  &mgetHook{},
+			level: slog.LevelInfo,
+			wantRecords: []map[string]interface{}{
+				{"level": "INFO", "msg": "config changed", "setting": "ttl", "old": "1s", "new": "2s"},
+			},
+		},
+		{
+			name:  "failed pipeline",
+			hook:  &failingHook{},
+			level: slog.LevelInfo,
+			wantRecords: []map[string]interface{}{
+				{"level": "INFO", "msg": "config changed", "setting": "ttl", "old": "1s", "new": "2s"},
+				{"level": "ERROR", "msg": "pipeline failed", "batch_size": float64(2), "flush_reason": "shutdown", "error": "connection refused"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := redis.NewClient(&redis.Options{})
+			db.AddHook(tt.hook)
+			buf := &bytes.Buffer{}
+			c, err := NewAutoPipeline(db,
+				WithCacheTTL(time.Second),
+				WithOperationTTL(0),
+				// records are filtered by the handler
+				WithLogLevel(LogDebug),
+				WithSlog(slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: tt.level}))))
+			assert.Nil(t, err)
+
+			c.SetCacheTTL(2 * time.Second)
+			results := []<-chan interface{}{c.GetAsync(ctx, "key1"), c.DelAsync(ctx, "key2")}
+			c.Stop()
+			for _, res := range results {
+				<-res
+			}
+			assert.Equal(t, tt.wantRecords, records(t, buf))
+		})
+	}
+}
+
+func TestSlogLoggerError(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := slogLogger{l: slog.New(slog.NewJSONHandler(buf, nil))}
+	l.Error("recovered:", 42)
+	assert.Equal(t, []map[string]interface{}{{"level": "ERROR", "msg": "recovered: 42"}}, records(t, buf))
+}
@@ -0,0 +1,163 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"strings"
+)
+
+// hashSlotsCount is a number of hash slots in redis cluster
+const hashSlotsCount = 16384
+
+// crc16 returns CRC16 (XMODEM) checksum of the key, as it's used by redis cluster
+func crc16(key string) uint16 {
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// HashTag returns hash tag of the key, which is a content between first "{" and first "}" after it,
+// and reports whether key has a non-empty hash tag.
+// Only hash tag is hashed by redis cluster, so keys with same hash tag are placed in the same slot
+func HashTag(key string) (string, bool) {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return "", false
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	// empty "{}" is not a hash tag, whole key is hashed then
+	if end <= 0 {
+		return "", false
+	}
+	return key[start+1 : start+1+end], true
+}
+
+// WithHashTagKey returns key prefixed with the hash tag, f.e. "{user1000}following"
+// so it's placed in the same slot with other keys with the same tag
+func WithHashTagKey(tag, key string) string {
+	return "{" + tag + "}" + key
+}
+
+// KeyHashSlot returns redis cluster hash slot of the key, hash tag of the key is respected
+func KeyHashSlot(key string) int {
+	if tag, ok := HashTag(key); ok {
+		key = tag
+	}
+	return int(crc16(key) % hashSlotsCount)
+}
+
+// groupBySlot returns indexes of keys grouped by hash slot,
+// groups are ordered by first occurrence of the slot, indexes within group are ordered as keys
+func groupBySlot(keys []string) [][]int {
+	var groups [][]int
+	slots := make(map[int]int) // hash slot to index of group
+	for i, key := range keys {
+		slot := KeyHashSlot(key)
+		g, ok := slots[slot]
+		if !ok {
+			g = len(groups)
+			slots[slot] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+// pickKeys returns keys with the given indexes
+func pickKeys(keys []string, indexes []int) []string {
+	picked := make([]string, len(indexes))
+	for i, idx := range indexes {
+		picked[i] = keys[idx]
+	}
+	return picked
+}
+
+// crossSlotMGet is a MGet command with keys in different hash slots,
+// split into per-slot MGet commands
+type crossSlotMGet struct {
+	keys   []string          // original keys of MGet command
+	groups [][]int           // indexes of keys, grouped by hash slot
+	cmds   []*redis.SliceCmd // per-slot commands, one for each group
+}
+
+func newCrossSlotMGet(ctx context.Context, pipe redis.Pipeliner, keys []string, groups [][]int) *crossSlotMGet {
+	m := &crossSlotMGet{
+		keys:   keys,
+		groups: groups,
+		cmds:   make([]*redis.SliceCmd, len(groups)),
+	}
+	for i, g := range groups {
+		m.cmds[i] = pipe.MGet(ctx, pickKeys(keys, g)...)
+	}
+	return m
+}
+
+// merge returns results of per-slot commands as a single MGet command, in order of original keys
+func (m *crossSlotMGet) merge(ctx context.Context) *redis.SliceCmd {
+	args := make([]interface{}, len(m.keys)+1)
+	args[0] = "mget"
+	for i, key := range m.keys {
+		args[i+1] = key
+	}
+	cmd := redis.NewSliceCmd(ctx, args...)
+	vals := make([]interface{}, len(m.keys))
+	for i, g := range m.groups {
+		if err := m.cmds[i].Err(); err != nil {
+			cmd.SetErr(err)
+			return cmd
+		}
+		for j, idx := range g {
+			vals[idx] = m.cmds[i].Val()[j]
+		}
+	}
+	cmd.SetVal(vals)
+	return cmd
+}
+
+// crossSlotDel is a Del command with keys in different hash slots,
+// split into per-slot Del commands
+type crossSlotDel struct {
+	keys []string        // original keys of Del command
+	cmds []*redis.IntCmd // per-slot commands
+}
+
+func newCrossSlotDel(ctx context.Context, pipe redis.Pipeliner, keys []string, groups [][]int) *crossSlotDel {
+	d := &crossSlotDel{
+		keys: keys,
+		cmds: make([]*redis.IntCmd, len(groups)),
+	}
+	for i, g := range groups {
+		d.cmds[i] = pipe.Del(ctx, pickKeys(keys, g)...)
+	}
+	return d
+}
+
+// merge returns total number of deleted keys as a single Del command
+func (d *crossSlotDel) merge(ctx context.Context) *redis.IntCmd {
+	args := make([]interface{}, len(d.keys)+1)
+	args[0] = "del"
+	for i, key := range d.keys {
+		args[i+1] = key
+	}
+	cmd := redis.NewIntCmd(ctx, args...)
+	var deleted int64
+	for _, c := range d.cmds {
+		if err := c.Err(); err != nil {
+			cmd.SetErr(err)
+			return cmd
+		}
+		deleted += c.Val()
+	}
+	cmd.SetVal(deleted)
+	return cmd
+}
@@ -0,0 +1,212 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestKeyHashSlot(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{
+			name: "empty key",
+			key:  "",
+			want: 0,
+		},
+		{
+			name: "crc16 check value",
+			key:  "123456789",
+			want: 0x31C3,
+		},
+		{
+			name: "foo",
+			key:  "foo",
+			want: 12182,
+		},
+		{
+			name: "bar",
+			key:  "bar",
+			want: 5061,
+		},
+		{
+			name: "hash tag",
+			key:  "{foo}bar",
+			want: 12182,
+		},
+		{
+			name: "empty hash tag",
+			key:  "{}foo",
+			want: int(crc16("{}foo") % hashSlotsCount),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, KeyHashSlot(tt.key))
+		})
+	}
+}
+
+func TestHashTag(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		want   string
+		wantOk bool
+	}{
+		{
+			name: "no hash tag",
+			key:  "user1000",
+		},
+		{
+			name:   "hash tag",
+			key:    "{user1000}.following",
+			want:   "user1000",
+			wantOk: true,
+		},
+		{
+			name:   "first hash tag only",
+			key:    "foo{bar}{zap}",
+			want:   "bar",
+			wantOk: true,
+		},
+		{
+			name:   "first closing brace",
+			key:    "foo{{bar}}zap",
+			want:   "{bar",
+			wantOk: true,
+		},
+		{
+			name: "empty hash tag",
+			key:  "foo{}{bar}",
+		},
+		{
+			name: "not closed",
+			key:  "foo{bar",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := HashTag(tt.key)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantOk, ok)
+		})
+	}
+}
+
+func TestWithHashTagKey(t *testing.T) {
+	key := WithHashTagKey("user1000", "following")
+	assert.Equal(t, "{user1000}following", key)
+	assert.Equal(t, KeyHashSlot("{user1000}followers"), KeyHashSlot(key))
+}
+
+func TestGroupBySlot(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		want [][]int
+	}{
+		{
+			name: "same slot",
+			keys: []string{"foo", "foo"},
+			want: [][]int{{0, 1}},
+		},
+		{
+			name: "different slots",
+			keys: []string{"foo", "bar", "foo"},
+			want: [][]int{{0, 2}, {1}},
+		},
+		{
+			name: "same hash tag",
+			keys: []string{"{foo}1", "bar", "{foo}2"},
+			want: [][]int{{0, 2}, {1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, groupBySlot(tt.keys))
+		})
+	}
+}
+
+func TestCrossSlotMGet(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectMGet("foo", "foo").SetVal([]interface{}{"a", "a"})
+	mock.ExpectMGet("bar").SetVal([]interface{}{nil})
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithCrossSlotSplitting(true),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	result, err := c.MGet(ctx, "foo", "bar", "foo").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"a", nil, "a"}, result)
+}
+
+func TestCrossSlotDel(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectDel("foo").SetVal(1)
+	mock.ExpectDel("bar").SetVal(1)
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithCrossSlotSplitting(true),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	result, err := c.Del(ctx, "foo", "bar").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), result)
+}
+
+func TestClusterClient(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClusterMock()
+	mock.ExpectGet("key").SetVal("john")
+	mock.ExpectHGetAll("hash").SetVal(map[string]string{"name": "john"})
+	mock.ExpectMGet("foo").SetVal([]interface{}{"a"})
+	mock.ExpectMGet("bar").SetVal([]interface{}{nil})
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*5),
+		WithCrossSlotSplitting(true),
+		// nodes of mocked cluster are unknown, so commands are routed by cluster client
+		WithNodeBatching(true),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+	defer c.Stop()
+	getCh := c.GetAsync(ctx, "key")
+	hgetallCh := c.HGetAllAsync(ctx, "hash")
+	mgetCh := c.MGetAsync(ctx, "foo", "bar")
+	assert.Equal(t, "john", (<-getCh).(*redis.StringCmd).Val())
+	assert.Equal(t, map[string]string{"name": "john"}, (<-hgetallCh).(*redis.MapStringStringCmd).Val())
+	assert.Equal(t, []interface{}{"a", nil}, (<-mgetCh).(*redis.SliceCmd).Val())
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	// timeouts of cluster client bound pipeline execution too
+	timeout := execTimeoutFromClient(redis.NewClusterClient(&redis.ClusterOptions{
+		ReadTimeout:  time.Second,
+		WriteTimeout: 2 * time.Second,
+	}))
+	assert.Equal(t, 3*time.Second, timeout)
+
+	var nilCluster *redis.ClusterClient
+	_, err = NewAutoPipeline(nilCluster)
+	assert.ErrorIs(t, err, ErrRedisIsNil)
+	_, err = NewAutoPipeline(nil)
+	assert.ErrorIs(t, err, ErrRedisIsNil)
+}
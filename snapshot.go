@@ -0,0 +1,35 @@
+package redis_autopipeline
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// OperationSnapshot is a state of a single queued operation
+// arguments of redis command are omitted in redacted snapshots, as they may contain sensitive data
+type OperationSnapshot struct {
+	Kind      string        `json:"kind"`           // redis command, e.g. HDel, Get and so on
+	Args      []string      `json:"args,omitempty"` // arguments of redis command, nil if redacted
+	Listeners int           `json:"listeners"`      // number of listeners awaiting the result
+	Age       time.Duration `json:"age"`            // time since operation was put to the cache
+}
+
+// QueueSnapshot is a state of the cache at certain moment of time
+type QueueSnapshot struct {
+	Time            time.Time           `json:"time"`             // time when snapshot was taken
+	ActiveListeners int32               `json:"active_listeners"` // number of active listeners in the cache
+	Operations      []OperationSnapshot `json:"operations"`       // queued operations
+}
+
+// SnapshotEncoder serializes the snapshot of pending queue to a writer
+type SnapshotEncoder interface {
+	Encode(w io.Writer, s *QueueSnapshot) error
+}
+
+// JSONSnapshotEncoder is a default SnapshotEncoder, which writes snapshot as a single line of json
+type JSONSnapshotEncoder struct{}
+
+func (e JSONSnapshotEncoder) Encode(w io.Writer, s *QueueSnapshot) error {
+	return json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,103 @@
+package redis_autopipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCacheSnapshot(t *testing.T) {
+	var ctx = context.TODO()
+	db, _ := redismock.NewClientMock()
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Second),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	c.HDelAsync(ctx, "key1")
+	c.HDelAsync(ctx, "key1")
+	c.GetAsync(ctx, "key2")
+
+	s := c.(*Autopipeline).cache.snapshot(false)
+	assert.Equal(t, int32(3), s.ActiveListeners)
+	assert.Len(t, s.Operations, 2)
+	listeners := map[string]int{}
+	for _, op := range s.Operations {
+		listeners[op.Kind] = op.Listeners
+		assert.Nil(t, op.Args)
+	}
+	assert.Equal(t, map[string]int{"HDel": 2, "Get": 1}, listeners)
+}
+
+func TestDumpPending(t *testing.T) {
+	var ctx = context.TODO()
+	db, _ := redismock.NewClientMock()
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Second),
+		WithMaxSize(200))
+	assert.Nil(t, err)
+
+	c.HDelAsync(ctx, "key1", "f1")
+	c.HDelAsync(ctx, "key1", "f1")
+
+	ops := c.DumpPending()
+	assert.Len(t, ops, 1)
+	assert.Equal(t, "HDel", ops[0].Kind)
+	assert.Equal(t, []string{"key1", "f1"}, ops[0].Args)
+	assert.Equal(t, 2, ops[0].Listeners)
+	assert.Greater(t, ops[0].Age, time.Duration(0))
+}
+
+func TestDumpOnPanic(t *testing.T) {
+	var ctx = context.TODO()
+	db, _ := redismock.NewClientMock()
+	var buf bytes.Buffer
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Second),
+		WithMaxSize(200),
+		WithCrashDump(&buf))
+	assert.Nil(t, err)
+	c.HDelAsync(ctx, "key1", "f1")
+
+	// panic of a worker goroutine is dumped and passed on
+	cache := c.(*Autopipeline).cache
+	assert.PanicsWithValue(t, "boom", func() {
+		defer cache.dumpOnPanic()
+		panic("boom")
+	})
+	var s QueueSnapshot
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &s))
+	assert.Len(t, s.Operations, 1)
+	assert.Equal(t, "HDel", s.Operations[0].Kind)
+	assert.Nil(t, s.Operations[0].Args)
+
+	// nothing is dumped without panic
+	buf.Reset()
+	func() {
+		defer cache.dumpOnPanic()
+	}()
+	assert.Zero(t, buf.Len())
+}
+
+func TestJSONSnapshotEncoder(t *testing.T) {
+	s := &QueueSnapshot{
+		Time:            time.Unix(0, 0).UTC(),
+		ActiveListeners: 1,
+		Operations: []OperationSnapshot{
+			{Kind: "Get", Listeners: 1, Age: time.Millisecond},
+		},
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, JSONSnapshotEncoder{}.Encode(&buf, s))
+
+	var got QueueSnapshot
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Equal(t, *s, got)
+}
@@ -0,0 +1,67 @@
+package redis_autopipeline
+
+import (
+	"github.com/redis/go-redis/v9"
+	"sync/atomic"
+)
+
+// endpointNames are names of clients reported on switchover, see WithStandby
+var endpointNames = [2]string{"primary", "standby"}
+
+// endpoints are redis clients commands are executed by, they are shared by lanes, so they switch together
+type endpoints struct {
+	clients   [2]redis.UniversalClient                   // primary and standby clients, standby is nil if disabled
+	active    atomic.Int32                               // index of the client in use
+	failures  atomic.Int32                               // number of consecutive failed flushes of the client in use
+	threshold int32                                      // number of failed flushes to switch the client, 0 means disabled
+	report    func(setting string, old, new interface{}) // reports switchovers
+}
+
+func newEndpoints(primary, standby redis.UniversalClient, threshold uint, report func(setting string, old, new interface{})) *endpoints {
+	e := &endpoints{clients: [2]redis.UniversalClient{primary, standby}, report: report}
+	if standby != nil {
+		e.threshold = int32(threshold)
+	}
+	return e
+}
+
+// current returns the client in use and its index
+func (e *endpoints) current() (int32, redis.UniversalClient) {
+	i := e.active.Load()
+	return i, e.clients[i]
+}
+
+// client returns the client in use
+func (e *endpoints) client() redis.UniversalClient {
+	_, client := e.current()
+	return client
+}
+
+// flushed registers the outcome of a flush executed by the client of index i, and switches to the other client,
+// if the flush is the threshold failed one in a row, outcomes of the client, which is already switched, are ignored
+func (e *endpoints) flushed(i int32, failed bool) bool {
+	if e.threshold == 0 || e.active.Load() != i {
+		return false
+	}
+	if !failed {
+		e.failures.Store(0)
+		return false
+	}
+	if e.failures.Add(1) < e.threshold || !e.active.CompareAndSwap(i, 1-i) {
+		return false
+	}
+	e.failures.Store(0)
+	e.report("client", endpointNames[i], endpointNames[1-i])
+	return true
+}
+
+// unreachable reports whether every pipeline of the flush failed with transient error, f.e. connection refused,
+// errors replied by redis and timeouts don't mean redis is down
+func unreachable(succeeded int, err error) bool {
+	return succeeded == 0 && retryable(err)
+}
+
+// client returns redis client commands are executed by
+func (c *cache) client() redis.UniversalClient {
+	return c.endpoints.client()
+}
@@ -0,0 +1,100 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestEndpointsFlushed(t *testing.T) {
+	primary, standby := redis.NewClient(&redis.Options{}), redis.NewClient(&redis.Options{})
+	changes := &changesRecorder{}
+	report := func(setting string, old, new interface{}) {
+		changes.hook(ConfigChange{Setting: setting, Old: old, New: new})
+	}
+
+	e := newEndpoints(primary, standby, 2, report)
+	assert.False(t, e.flushed(0, true))
+	// successful flush resets failures
+	assert.False(t, e.flushed(0, false))
+	assert.False(t, e.flushed(0, true))
+	assert.True(t, e.flushed(0, true))
+	i, client := e.current()
+	assert.Equal(t, int32(1), i)
+	assert.Equal(t, standby, client)
+	// outcomes of flushes executed by the primary before the switch are ignored
+	assert.False(t, e.flushed(0, true))
+	assert.False(t, e.flushed(0, true))
+	assert.False(t, e.flushed(1, true))
+	assert.True(t, e.flushed(1, true))
+	assert.Equal(t, primary, e.client())
+	got := changes.get()
+	assert.Len(t, got, 2)
+	assert.Equal(t, ConfigChange{Setting: "client", Old: "primary", New: "standby"}, got[0])
+	assert.Equal(t, ConfigChange{Setting: "client", Old: "standby", New: "primary"}, got[1])
+
+	// without standby the primary is used forever
+	e = newEndpoints(primary, nil, 0, report)
+	for i := 0; i < 10; i++ {
+		assert.False(t, e.flushed(0, true))
+	}
+	assert.Equal(t, primary, e.client())
+}
+
+func TestUnreachable(t *testing.T) {
+	tests := []struct {
+		name      string
+		succeeded int
+		err       error
+		want      bool
+	}{
+		{name: "connection error", err: errors.New("connection refused"), want: true},
+		{name: "some pipelines succeeded", succeeded: 1, err: errors.New("connection refused")},
+		{name: "replied by redis", err: replyError("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{name: "timeout", err: context.DeadlineExceeded},
+		{name: "nil", err: redis.Nil},
+		{name: "no error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, unreachable(tt.succeeded, tt.err))
+		})
+	}
+}
+
+func TestStandby(t *testing.T) {
+	ctx := context.TODO()
+	primary := redis.NewClient(&redis.Options{})
+	primary.AddHook(failingHook{})
+	standby := redis.NewClient(&redis.Options{})
+	standby.AddHook(&mgetHook{})
+
+	changes := &changesRecorder{}
+	c, err := NewAutoPipeline(primary,
+		WithCacheTTL(time.Microsecond*100),
+		WithStandby(standby, 2),
+		WithWriteLane(time.Microsecond*100, 100),
+		WithConfigChangeHook(changes.hook),
+		WithLogger(&errorRecorder{}))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	assert.EqualError(t, c.Get(ctx, "key1").Err(), "connection refused")
+	// commands of the flush, which made the switch, are executed by the standby
+	assert.Equal(t, "key2", c.Get(ctx, "key2").Val())
+	assert.Equal(t, "key3", c.Get(ctx, "key3").Val())
+	// write lane is switched too
+	assert.Equal(t, int64(1), c.Del(ctx, "key4").Val())
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.Switchovers)
+	assert.Equal(t, uint64(1), stats.Requeues)
+	got := changes.get()
+	assert.Len(t, got, 1)
+	assert.Equal(t, "client", got[0].Setting)
+	assert.Equal(t, "primary", got[0].Old)
+	assert.Equal(t, "standby", got[0].New)
+}
@@ -0,0 +1,305 @@
+package redis_autopipeline
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// rttSmoothingFactor is a weight of a new sample in the rolling RTT estimate, same as TCP uses
+	rttSmoothingFactor = 0.125
+	// statsWindow is a number of the latest pipelines, which percentiles of execution time and size are computed over
+	statsWindow = 1024
+	// recentErrorsSize is a number of the latest errors of pipelines kept for the debug handler
+	recentErrorsSize = 16
+)
+
+// flushTrigger is a reason of pipeline execution
+type flushTrigger byte
+
+const (
+	triggerSize     flushTrigger = iota + 1 // number of active listeners exceeded maxSize
+	triggerTTL                              // ttl passed since last pipeline
+	triggerShutdown                         // cache is stopping
+	triggerMaxDelay                         // the oldest command waited for maxDelay
+	triggerSolo                             // the only pending command waited for solo bypass grace period
+	triggerBytes                            // estimated size of queued commands exceeded maxBatchBytes
+)
+
+// flushTriggerNames contains names of flush triggers
+var flushTriggerNames = map[flushTrigger]string{
+	triggerSize:     "size",
+	triggerTTL:      "ttl",
+	triggerShutdown: "shutdown",
+	triggerMaxDelay: "max_delay",
+	triggerSolo:     "solo",
+	triggerBytes:    "bytes",
+}
+
+func (t flushTrigger) String() string {
+	if name, ok := flushTriggerNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Stats contains batching metrics of autopipeline since its creation
+type Stats struct {
+	Pipelines       uint64        // number of executed pipelines
+	Commands        uint64        // number of commands sent to redis in all pipelines
+	MinCommands     uint64        // min number of commands in single pipeline
+	MaxCommands     uint64        // max number of commands in single pipeline
+	AvgCommands     float64       // average number of commands in single pipeline
+	DedupHits       uint64        // number of commands joined to already queued identical command
+	SizeFlushes     uint64        // number of pipelines triggered by maxSize
+	TTLFlushes      uint64        // number of pipelines triggered by ttl
+	ShutdownFlushes uint64        // number of pipelines executed on stop of autopipeline
+	MaxDelayFlushes uint64        // number of pipelines triggered by max delay of command
+	SoloFlushes     uint64        // number of solitary commands executed without waiting for ttl
+	BytesFlushes    uint64        // number of pipelines triggered by max batch bytes
+	Errors          uint64        // number of failed pipelines
+	DroppedResults  uint64        // number of results dropped as listeners didn't receive them, see WithDropPolicy
+	Overflows       uint64        // number of commands, which found the cache full, see WithMaxPending
+	Requeues        uint64        // number of commands requeued after transient or failover errors, see WithReadRetries
+	Failovers       uint64        // number of pauses of flushing after failover errors, see WithFailoverPause
+	Switchovers     uint64        // number of switches between primary and standby clients, see WithStandby
+	LastRTT         time.Duration // round-trip time of the last successful pipeline
+	SmoothedRTT     time.Duration // rolling estimate of pipeline round-trip time
+	ExecP50         time.Duration // median execution time of the latest pipelines, including failed ones
+	ExecP95         time.Duration // 95th percentile of execution time of the latest pipelines
+	ExecP99         time.Duration // 99th percentile of execution time of the latest pipelines
+	BatchP50        uint64        // median number of commands in the latest pipelines
+	BatchP95        uint64        // 95th percentile of number of commands in the latest pipelines
+	BatchP99        uint64        // 99th percentile of number of commands in the latest pipelines
+}
+
+// FlushStats describes a single flush of the cache, which may be executed by several pipelines, see WithOnFlush
+type FlushStats struct {
+	Time      time.Time     // time when execution of pipelines started
+	Trigger   string        // reason of flush: size, bytes, ttl, max_delay, solo or shutdown
+	Commands  int           // number of commands sent to redis in pipelines of the flush
+	Pipelines int           // number of executed pipelines
+	Deduped   int           // number of calls joined to identical commands of the flush, which they share results of
+	Exec      time.Duration // execution time of pipelines of the flush
+	Err       error         // the first error of pipelines, nil if all of them succeeded
+}
+
+// stats collects batching metrics of the cache
+type stats struct {
+	mx        *sync.Mutex
+	s         Stats
+	execTimes window        // execution times of the latest pipelines
+	sizes     window        // number of commands in the latest pipelines
+	sink      MetricsSink   // receives events, nil if disabled
+	errors    []recentError // the latest errors of pipelines, the oldest one first
+}
+
+// recentError is an error of pipeline kept for the debug handler, see DebugHandler
+type recentError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+func newStats() *stats {
+	return &stats{mx: &sync.Mutex{}}
+}
+
+// pipeline registers executed pipeline with the number of commands in it and its execution time
+func (st *stats) pipeline(trigger flushTrigger, commands int, exec time.Duration, err error) {
+	if st.sink != nil {
+		st.sink.ObserveBatch(trigger.String(), commands, exec)
+		if err != nil {
+			st.sink.ObserveError(err)
+		}
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.execTimes.add(int64(exec))
+	st.sizes.add(int64(commands))
+	n := uint64(commands)
+	if st.s.Pipelines == 0 || n < st.s.MinCommands {
+		st.s.MinCommands = n
+	}
+	if n > st.s.MaxCommands {
+		st.s.MaxCommands = n
+	}
+	st.s.Pipelines++
+	st.s.Commands += n
+	switch trigger {
+	case triggerSize:
+		st.s.SizeFlushes++
+	case triggerTTL:
+		st.s.TTLFlushes++
+	case triggerShutdown:
+		st.s.ShutdownFlushes++
+	case triggerMaxDelay:
+		st.s.MaxDelayFlushes++
+	case triggerSolo:
+		st.s.SoloFlushes++
+	case triggerBytes:
+		st.s.BytesFlushes++
+	}
+	if err != nil {
+		st.s.Errors++
+	}
+}
+
+// rtt registers round-trip time of successful pipeline and updates the rolling estimate
+func (st *stats) rtt(d time.Duration) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.s.LastRTT = d
+	if st.s.SmoothedRTT == 0 {
+		st.s.SmoothedRTT = d
+		return
+	}
+	st.s.SmoothedRTT += time.Duration(rttSmoothingFactor * float64(d-st.s.SmoothedRTT))
+}
+
+// smoothedRTT returns the rolling estimate of pipeline round-trip time, 0 if there were no pipelines yet
+func (st *stats) smoothedRTT() time.Duration {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	return st.s.SmoothedRTT
+}
+
+// enqueued registers queued command, deduped if it's joined to already queued one, see dedupHit
+func (st *stats) enqueued(kind operationPrefix, deduped bool) {
+	if st.sink != nil {
+		st.sink.ObserveEnqueue(kind.String(), deduped)
+	}
+}
+
+// dedupHit registers command joined to already queued one
+func (st *stats) dedupHit() {
+	st.mx.Lock()
+	st.s.DedupHits++
+	st.mx.Unlock()
+}
+
+// droppedResult registers result, which listener didn't receive
+func (st *stats) droppedResult() {
+	if st.sink != nil {
+		st.sink.ObserveDrop()
+	}
+	st.mx.Lock()
+	st.s.DroppedResults++
+	st.mx.Unlock()
+}
+
+// overflow registers command, which found the cache full
+func (st *stats) overflow() {
+	if st.sink != nil {
+		st.sink.ObserveOverflow()
+	}
+	st.mx.Lock()
+	st.s.Overflows++
+	st.mx.Unlock()
+}
+
+// requeue registers command requeued after transient or failover error
+func (st *stats) requeue() {
+	st.mx.Lock()
+	st.s.Requeues++
+	st.mx.Unlock()
+}
+
+// failover registers pause of flushing after failover errors
+func (st *stats) failover() {
+	st.mx.Lock()
+	st.s.Failovers++
+	st.mx.Unlock()
+}
+
+// switchover registers switch between primary and standby clients
+func (st *stats) switchover() {
+	st.mx.Lock()
+	st.s.Switchovers++
+	st.mx.Unlock()
+}
+
+// failed registers error of pipeline failed at t, only the latest recentErrorsSize errors are kept
+func (st *stats) failed(t time.Time, err error) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if len(st.errors) == recentErrorsSize {
+		st.errors = append(st.errors[:0], st.errors[1:]...)
+	}
+	st.errors = append(st.errors, recentError{Time: t, Error: err.Error()})
+}
+
+// recentErrors returns a copy of the latest errors of pipelines, the oldest one first
+func (st *stats) recentErrors() []recentError {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	return slices.Clone(st.errors)
+}
+
+// histograms returns distributions of number of commands and execution time over the latest pipelines
+func (st *stats) histograms() (sizes, execTimes []histogramBucket) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	return st.sizes.histogram(sizeBounds), st.execTimes.histogram(execTimeBounds)
+}
+
+// snapshot returns a copy of collected metrics
+func (st *stats) snapshot() Stats {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	s := st.s
+	if s.Pipelines > 0 {
+		s.AvgCommands = float64(s.Commands) / float64(s.Pipelines)
+	}
+	execTimes := st.execTimes.percentiles(0.5, 0.95, 0.99)
+	s.ExecP50, s.ExecP95, s.ExecP99 = time.Duration(execTimes[0]), time.Duration(execTimes[1]), time.Duration(execTimes[2])
+	sizes := st.sizes.percentiles(0.5, 0.95, 0.99)
+	s.BatchP50, s.BatchP95, s.BatchP99 = uint64(sizes[0]), uint64(sizes[1]), uint64(sizes[2])
+	return s
+}
+
+// window is a ring buffer of the latest statsWindow samples
+type window struct {
+	samples []int64
+	next    int // index of the oldest sample, once the buffer is full
+}
+
+func (w *window) add(v int64) {
+	if len(w.samples) < statsWindow {
+		w.samples = append(w.samples, v)
+		return
+	}
+	w.samples[w.next] = v
+	w.next = (w.next + 1) % statsWindow
+}
+
+// histogram returns numbers of samples by buckets with the given ascending upper bounds, samples above the last
+// bound are counted in the extra bucket without bound
+func (w *window) histogram(bounds []int64) []histogramBucket {
+	buckets := make([]histogramBucket, len(bounds)+1)
+	for i, bound := range bounds {
+		buckets[i].LE = bound
+	}
+	buckets[len(bounds)].Inf = true
+	for _, v := range w.samples {
+		i, _ := slices.BinarySearch(bounds, v)
+		buckets[i].Count++
+	}
+	return buckets
+}
+
+// percentiles returns nearest-rank percentiles of samples, f.e. 0.99 for p99, zeros if there are no samples
+func (w *window) percentiles(ps ...float64) []int64 {
+	res := make([]int64, len(ps))
+	if len(w.samples) == 0 {
+		return res
+	}
+	sorted := slices.Clone(w.samples)
+	slices.Sort(sorted)
+	for i, p := range ps {
+		rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+		res[i] = sorted[max(rank, 0)]
+	}
+	return res
+}
@@ -0,0 +1,182 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestStatsSnapshot(t *testing.T) {
+	st := newStats()
+	assert.Equal(t, Stats{}, st.snapshot())
+
+	st.pipeline(triggerTTL, 4, 2*time.Millisecond, nil)
+	st.pipeline(triggerSize, 1, time.Millisecond, nil)
+	st.pipeline(triggerShutdown, 7, 5*time.Millisecond, errors.New("connection refused"))
+	st.dedupHit()
+
+	assert.Equal(t, Stats{
+		Pipelines:       3,
+		Commands:        12,
+		MinCommands:     1,
+		MaxCommands:     7,
+		AvgCommands:     4,
+		DedupHits:       1,
+		SizeFlushes:     1,
+		TTLFlushes:      1,
+		ShutdownFlushes: 1,
+		Errors:          1,
+		ExecP50:         2 * time.Millisecond,
+		ExecP95:         5 * time.Millisecond,
+		ExecP99:         5 * time.Millisecond,
+		BatchP50:        4,
+		BatchP95:        7,
+		BatchP99:        7,
+	}, st.snapshot())
+}
+
+func TestStatsPercentiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		pipelines int
+		wantP50   time.Duration
+		wantP95   time.Duration
+		wantP99   time.Duration
+	}{
+		{
+			name:      "no pipelines",
+			pipelines: 0,
+		},
+		{
+			name:      "single pipeline",
+			pipelines: 1,
+			wantP50:   time.Millisecond,
+			wantP95:   time.Millisecond,
+			wantP99:   time.Millisecond,
+		},
+		{
+			name:      "partial window",
+			pipelines: 100,
+			wantP50:   50 * time.Millisecond,
+			wantP95:   95 * time.Millisecond,
+			wantP99:   99 * time.Millisecond,
+		},
+		{
+			name:      "oldest pipelines are forgotten",
+			pipelines: statsWindow + 1000,
+			wantP50:   (1000 + statsWindow/2) * time.Millisecond,
+			wantP95:   (1000 + 973) * time.Millisecond,
+			wantP99:   (1000 + 1014) * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newStats()
+			// execution time in milliseconds equals the number of commands
+			for i := 1; i <= tt.pipelines; i++ {
+				st.pipeline(triggerTTL, i, time.Duration(i)*time.Millisecond, nil)
+			}
+			s := st.snapshot()
+			assert.Equal(t, tt.wantP50, s.ExecP50)
+			assert.Equal(t, tt.wantP95, s.ExecP95)
+			assert.Equal(t, tt.wantP99, s.ExecP99)
+			assert.Equal(t, uint64(tt.wantP50/time.Millisecond), s.BatchP50)
+			assert.Equal(t, uint64(tt.wantP95/time.Millisecond), s.BatchP95)
+			assert.Equal(t, uint64(tt.wantP99/time.Millisecond), s.BatchP99)
+		})
+	}
+}
+
+func TestClientStats(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHDel("key1").SetVal(1)
+	mock.ExpectGet("key2").SetVal("john")
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Second),
+		WithMaxSize(2))
+	assert.Nil(t, err)
+
+	resCh1 := c.HDelAsync(ctx, "key1")
+	resCh2 := c.HDelAsync(ctx, "key1")
+	resCh3 := c.GetAsync(ctx, "key2")
+	<-resCh1
+	<-resCh2
+	<-resCh3
+
+	s := c.Stats()
+	assert.Equal(t, uint64(1), s.Pipelines)
+	assert.Equal(t, uint64(2), s.Commands)
+	assert.Equal(t, uint64(1), s.DedupHits)
+	assert.Equal(t, uint64(1), s.SizeFlushes)
+	assert.Equal(t, uint64(2), s.BatchP99)
+}
+
+func TestStatsRTT(t *testing.T) {
+	st := newStats()
+	assert.Equal(t, time.Duration(0), st.smoothedRTT())
+
+	st.rtt(800 * time.Microsecond)
+	assert.Equal(t, 800*time.Microsecond, st.smoothedRTT())
+
+	st.rtt(1600 * time.Microsecond)
+	s := st.snapshot()
+	assert.Equal(t, 1600*time.Microsecond, s.LastRTT)
+	assert.Equal(t, 900*time.Microsecond, s.SmoothedRTT)
+}
+
+func TestOnFlush(t *testing.T) {
+	ctx := context.TODO()
+
+	tests := []struct {
+		name    string
+		hook    redis.Hook
+		wantErr string
+	}{
+		{name: "succeeded flush", hook: &mgetHook{}},
+		{name: "failed flush", hook: &failingHook{}, wantErr: "connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := redis.NewClient(&redis.Options{})
+			db.AddHook(tt.hook)
+			var flushes []FlushStats
+			c, err := NewAutoPipeline(db,
+				WithCacheTTL(time.Second),
+				WithOnFlush(func(s FlushStats) {
+					flushes = append(flushes, s)
+				}))
+			assert.Nil(t, err)
+
+			first, second := c.GetAsync(ctx, "key1"), c.GetAsync(ctx, "key1")
+			h := c.AwaitOnly().Get(ctx, "key1")
+			del := c.DelAsync(ctx, "key2")
+			start := time.Now()
+			c.Stop()
+			<-first
+			<-second
+			<-del
+			_, _ = h.Await(ctx)
+
+			assert.Len(t, flushes, 1)
+			s := flushes[0]
+			assert.Equal(t, "shutdown", s.Trigger)
+			assert.Equal(t, 2, s.Commands)
+			assert.Equal(t, 1, s.Pipelines)
+			assert.Equal(t, 2, s.Deduped)
+			assert.WithinDuration(t, start, s.Time, time.Second)
+			assert.Positive(t, s.Exec)
+			if tt.wantErr == "" {
+				assert.Nil(t, s.Err)
+			} else {
+				assert.EqualError(t, s.Err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+//go:build stress
+
+package redis_autopipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stress tests are run with: go test -tags stress -race -run Stress ./...
+
+const (
+	stressGoroutines = 2000
+	stressKeys       = 50
+)
+
+// stubHook replies to every pipelined command without redis server
+type stubHook struct{}
+
+func (stubHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errors.New("stub hook doesn't dial")
+	}
+}
+
+func (stubHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		return stubReply(cmd)
+	}
+}
+
+func (stubHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			if err := stubReply(cmd); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// stubReply sets the reply of command: value of key is the key itself, deleted fields number is 1
+func stubReply(cmd redis.Cmder) error {
+	switch c := cmd.(type) {
+	case *redis.StringCmd:
+		c.SetVal(fmt.Sprint(c.Args()[1]))
+	case *redis.IntCmd:
+		c.SetVal(1)
+	default:
+		return fmt.Errorf("unexpected command %s", cmd.Name())
+	}
+	return nil
+}
+
+func newStressClient(t *testing.T, options ...func(a *Autopipeline)) Client {
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(stubHook{})
+	c, err := NewAutoPipeline(db, append([]func(a *Autopipeline){
+		WithCacheTTL(50 * time.Microsecond),
+		WithMaxSize(100),
+		WithLogger(&errorRecorder{}),
+	}, options...)...)
+	assert.Nil(t, err)
+	return c
+}
+
+func TestStressEnqueue(t *testing.T) {
+	for _, shards := range []uint{1, 8} {
+		for _, lockFree := range []bool{false, true} {
+			for _, workers := range []uint{1, 4} {
+				// results are delivered concurrently along with concurrent pipelines
+				delivery := workers - 1
+				t.Run(fmt.Sprintf("%d shards, lock-free %t, %d workers, %d delivery workers", shards, lockFree, workers, delivery), func(t *testing.T) {
+					c := newStressClient(t, WithStorageShards(shards), WithLockFreeEnqueue(lockFree),
+						WithWorkers(workers), WithDeliveryWorkers(delivery))
+					defer c.Stop()
+
+					var wg sync.WaitGroup
+					for i := 0; i < stressGoroutines; i++ {
+						wg.Add(1)
+						go func(i int) {
+							defer wg.Done()
+							ctx := context.TODO()
+							key := fmt.Sprintf("key%d", i%stressKeys)
+							switch i % 5 {
+							case 0:
+								assert.Equal(t, key, c.Get(ctx, key).Val())
+							case 1:
+								res, ok := <-c.GetAsync(ctx, key)
+								assert.True(t, ok)
+								assert.Equal(t, key, res.(*redis.StringCmd).Val())
+							case 2:
+								// abandoned channel
+								c.HDelAsync(ctx, key, "f")
+							case 3:
+								cmd, err := c.GetFuture(ctx, key).Await(ctx)
+								assert.Nil(t, err)
+								assert.Equal(t, key, cmd.(*redis.StringCmd).Val())
+							case 4:
+								// abandoned handle
+								c.AwaitOnly().HDel(ctx, key, "f")
+							}
+						}(i)
+					}
+					// runtime reconfiguration while commands are enqueued
+					for i := 0; i < 100; i++ {
+						c.SetMaxSize(uint(1 + i%200))
+						c.SetCacheTTL(time.Duration(1+i%100) * time.Microsecond)
+					}
+					wg.Wait()
+
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					assert.Nil(t, c.Drain(ctx))
+					assert.Equal(t, 0, c.Pending())
+					assert.Equal(t, 0, c.Len())
+				})
+			}
+		}
+	}
+}
+
+func TestStressCancel(t *testing.T) {
+	c := newStressClient(t, WithDuplicatePolicy(DuplicateReject))
+	defer c.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < stressGoroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(i%100)*time.Microsecond)
+			defer cancel()
+			key := fmt.Sprintf("key%d", i%stressKeys)
+			cmd, err := c.GetFuture(ctx, key).Await(ctx)
+			if err != nil {
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+				return
+			}
+			assert.Equal(t, key, cmd.(*redis.StringCmd).Val())
+		}(i)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, c.Drain(ctx))
+	assert.Equal(t, 0, c.Pending())
+}
+
+func TestStressStopStart(t *testing.T) {
+	c := newStressClient(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < stressGoroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := context.TODO()
+			key := fmt.Sprintf("key%d", i%stressKeys)
+			for _, b := range c.NewBatch().Get(key).HDel(key, "f").Submit(ctx) {
+				if err := b.Err(); err != nil {
+					assert.ErrorIs(t, err, ErrCacheStopped)
+				}
+			}
+			cmd := c.Get(ctx, key)
+			if err := cmd.Err(); err != nil {
+				assert.ErrorIs(t, err, ErrChannelClosed)
+				return
+			}
+			assert.Equal(t, key, cmd.Val())
+		}(i)
+	}
+	// stop, start and pause concurrently with enqueueing
+	for i := 0; i < 50; i++ {
+		c.Pause()
+		c.Resume()
+		c.Stop()
+		assert.Nil(t, c.Start())
+	}
+	wg.Wait()
+
+	// queued commands are executed on stop
+	c.Stop()
+	assert.Equal(t, 0, c.Pending())
+	assert.False(t, c.IsRunning())
+}
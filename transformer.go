@@ -1,113 +1,172 @@
 package redis_autopipeline
 
 import (
+	"encoding"
+	"fmt"
+	"github.com/redis/go-redis/v9"
 	"strconv"
 	"time"
 )
 
-// transformHDel transforms HDel arguments to slice of strings
-func transformHDel(key string, fields ...string) []string {
-	if len(fields) == 0 {
-		return []string{key}
-	}
-	stringSlice := make([]string, len(fields)+1)
-	stringSlice[0] = key
-	for i, v := range fields {
-		stringSlice[i+1] = v
-	}
-	return stringSlice
+// transformHDel transforms HDel arguments to payload
+func transformHDel(key string, fields ...string) payload {
+	// fields are copied, as they may be a slice passed by caller
+	return hdelPayload{key: key, fields: copyStrings(fields)}
 }
 
-// normalizeHDel transforms string slice to a valid HDel redis arguments
-func normalizeHDel(values []string) (string, []string) {
-	// payload is a key string as first param and strings slice as an optional second param
-	if len(values) == 1 {
-		return values[0], nil
-	}
-	return values[0], values[1:]
+// transformDel transforms Del arguments to payload
+func transformDel(keys ...string) payload {
+	// payload is strings slice
+	return keysPayload(copyStrings(keys))
 }
 
-// transformDel transforms Del arguments to slice of strings
-func transformDel(keys ...string) []string {
+// transformMGet transforms MGet arguments to payload
+func transformMGet(keys ...string) payload {
 	// payload is strings slice
-	return keys
+	return keysPayload(copyStrings(keys))
 }
 
-// normalizeDel transforms string slice to a valid Del redis arguments
-func normalizeDel(values []string) []string {
-	// payload is strings slice
-	return values
+// transformHGet transforms HGet arguments to payload
+func transformHGet(key, field string) payload {
+	// payload is two strings
+	return hgetPayload{key: key, field: field}
 }
 
-// transformMGet transforms MGet arguments to slice of strings
-func transformMGet(keys ...string) []string {
-	// payload is strings slice
-	return keys
+// transformGet transforms Get arguments to payload
+func transformGet(key string) payload {
+	// payload is one string
+	return keyPayload{key: key}
 }
 
-// normalizeMGet transforms string slice to a valid MGet redis arguments
-func normalizeMGet(values []string) []string {
-	// payload is strings slice
-	return values
+// transformHGetAll transforms HGetAll arguments to payload
+func transformHGetAll(key string) payload {
+	// payload is one string
+	return keyPayload{key: key}
 }
 
-// transformHGet transforms HGet arguments to slice of strings
-func transformHGet(key, field string) []string {
-	// payload is two strings
-	return []string{key, field}
+// transformSMembers transforms SMembers arguments to payload
+func transformSMembers(key string) payload {
+	// payload is one string
+	return keyPayload{key: key}
 }
 
-// normalizeHGet transforms string slice to a valid HGet redis arguments
-func normalizeHGet(values []string) (string, string) {
-	// payload is two strings
-	return values[0], values[1]
+// transformExpire transforms Expire arguments to payload
+func transformExpire(key string, expiration time.Duration) payload {
+	// payload is a key string as first param and time.Duration as second param
+	return expirePayload{key: key, expiration: expiration}
 }
 
-// transformGet transforms Get arguments to slice of strings
-func transformGet(key string) []string {
-	// payload is one string
-	return []string{key}
+// transformScript transforms EvalRO and FCallRO arguments to payload
+func transformScript(script string, keys []string, args ...interface{}) payload {
+	// payload is a script (or function name), keys and args
+	return scriptPayload{script: script, keys: copyStrings(keys), args: argsToStrings(args)}
 }
 
-// normalizeGet transforms string slice to a valid Get redis arguments
-func normalizeGet(values []string) string {
-	// payload is one string
-	return values[0]
+// transformExists transforms Exists arguments to payload
+func transformExists(keys ...string) payload {
+	// payload is strings slice
+	return keysPayload(copyStrings(keys))
 }
 
-// transformHGetAll transforms HGetAll arguments to slice of strings
-func transformHGetAll(key string) []string {
-	// payload is one string
-	return []string{key}
+// transformTime transforms Time arguments to payload
+func transformTime() payload {
+	// no payload
+	return emptyPayload{}
 }
 
-// normalizeHGetAll transforms string slice to a valid HGetAll redis arguments
-func normalizeHGetAll(values []string) string {
-	// payload is one string
-	return values[0]
+// transformEcho transforms Echo arguments to payload
+func transformEcho(message interface{}) payload {
+	// payload is one value
+	return valuePayload{value: argToString(message)}
 }
 
-// transformSMembers transforms SMembers arguments to slice of strings
-func transformSMembers(key string) []string {
-	// payload is one string
-	return []string{key}
+// transformSafeDel transforms SafeDel arguments to payload
+func transformSafeDel(key, expectedType string) payload {
+	// payload is a key and type
+	return safeDelPayload{key: key, expectedType: expectedType}
 }
 
-// normalizeSMembers transforms string slice to a valid SMembers redis arguments
-func normalizeSMembers(values []string) string {
-	// payload is one string
-	return values[0]
+// transformSet transforms Set arguments to payload
+func transformSet(key string, value interface{}, expiration time.Duration) payload {
+	// payload is a key, value and expiration
+	return setPayload{key: key, value: argToString(value), expiration: expiration}
 }
 
-// transformExpire transforms Expire arguments to slice of strings
-func transformExpire(key string, expiration time.Duration) []string {
-	// payload is a key string as first param and time.Duration as second param
-	return []string{key, strconv.Itoa(int(expiration.Nanoseconds()))}
+// transformDo transforms Do arguments to payload
+func transformDo(args ...interface{}) payload {
+	// payload is a command name and its arguments
+	return opaquePayload(argsToStrings(args))
+}
+
+// transformCmd transforms pre-built redis command to payload,
+// its arguments are used for snapshots and exports only, as command itself is pipelined
+func transformCmd(cmd redis.Cmder) payload {
+	return cmdPayload{cmd: cmd}
 }
 
-// normalizeExpire transforms string slice to a valid Expire redis arguments
-func normalizeExpire(values []string) (string, time.Duration) {
-	// payload is string and time.Duration
-	nanoseconds, _ := strconv.Atoi(values[1])
-	return values[0], time.Duration(nanoseconds)
+// copyStrings returns a copy of strings, nil for an empty slice
+func copyStrings(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	return append([]string(nil), values...)
+}
+
+// argsToStrings formats redis command arguments the same way go-redis writes them to the wire
+func argsToStrings(args []interface{}) []string {
+	values := make([]string, len(args))
+	for i, arg := range args {
+		values[i] = argToString(arg)
+	}
+	return values
+}
+
+// argToString formats redis command argument the same way go-redis writes it to the wire
+func argToString(arg interface{}) string {
+	switch v := arg.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return strconv.FormatInt(int64(v), 10)
+	case int8:
+		return strconv.FormatInt(int64(v), 10)
+	case int16:
+		return strconv.FormatInt(int64(v), 10)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return strconv.FormatInt(v.Nanoseconds(), 10)
+	case encoding.BinaryMarshaler:
+		if b, err := v.MarshalBinary(); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(arg)
 }
@@ -1,7 +1,10 @@
 package redis_autopipeline
 
 import (
+	"context"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"strconv"
 	"testing"
 	"time"
@@ -28,7 +31,7 @@ func TestTransformDel(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := transformDel(tt.keys...)
-			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want, payloadArgs(got))
 		})
 	}
 }
@@ -54,7 +57,7 @@ func TestTransformMGet(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := transformMGet(tt.keys...)
-			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want, payloadArgs(got))
 		})
 	}
 }
@@ -85,7 +88,7 @@ func TestTransformHDel(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := transformHDel(tt.key, tt.fields...)
-			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want, payloadArgs(got))
 		})
 	}
 }
@@ -108,7 +111,7 @@ func TestTransformHGet(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := transformHGet(tt.key, tt.field)
-			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want, payloadArgs(got))
 		})
 	}
 }
@@ -129,7 +132,7 @@ func TestTransformGet(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := transformGet(tt.key)
-			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want, payloadArgs(got))
 		})
 	}
 }
@@ -150,7 +153,7 @@ func TestTransformHGetAll(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := transformHGetAll(tt.key)
-			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want, payloadArgs(got))
 		})
 	}
 }
@@ -171,7 +174,7 @@ func TestTransformSMembers(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := transformSMembers(tt.key)
-			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want, payloadArgs(got))
 		})
 	}
 }
@@ -191,206 +194,176 @@ func TestTransformExpire(t *testing.T) {
 			want:       []string{"somekey", strconv.Itoa(int(time.Microsecond))},
 			wantErr:    "",
 		},
+		{
+			name:       "Expire beyond int32",
+			key:        "somekey",
+			expiration: 100 * 365 * 24 * time.Hour,
+			want:       []string{"somekey", "3153600000000000000"},
+			wantErr:    "",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := transformExpire(tt.key, tt.expiration)
-			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want, payloadArgs(got))
 		})
 	}
 }
 
-func TestNormalizeHDel(t *testing.T) {
+func TestTransformScript(t *testing.T) {
 	tests := []struct {
-		name       string
-		values     []string
-		wantKey    string
-		wantFields []string
+		name    string
+		payload string
+		keys    []string
+		args    []interface{}
+		want    []string
 	}{
 		{
-			name:    "just key",
-			values:  []string{"key"},
-			wantKey: "key",
+			name:    "no keys and args",
+			payload: "return 1",
+			want:    []string{"return 1", "0"},
 		},
 		{
-			name:       "key and fields",
-			values:     []string{"key", "v1", "v2"},
-			wantKey:    "key",
-			wantFields: []string{"v1", "v2"},
+			name:    "keys and args",
+			payload: "myfunc",
+			keys:    []string{"k1", "k2"},
+			args:    []interface{}{"a", 1, true, []byte("b")},
+			want:    []string{"myfunc", "2", "k1", "k2", "a", "1", "1", "b"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			key, fs := normalizeHDel(tt.values)
-			assert.Equal(t, tt.wantKey, key)
-			assert.Equal(t, tt.wantFields, fs)
+			got := transformScript(tt.payload, tt.keys, tt.args...)
+			assert.Equal(t, tt.want, payloadArgs(got))
 		})
 	}
 }
 
-func TestNormalizeDel(t *testing.T) {
+func TestArgToString(t *testing.T) {
 	tests := []struct {
-		name   string
-		values []string
-		want   []string
+		name string
+		arg  interface{}
+		want string
 	}{
-		{
-			name:   "key and fields",
-			values: []string{"v0", "v1", "v2"},
-			want:   []string{"v0", "v1", "v2"},
-		},
+		{name: "nil", arg: nil, want: ""},
+		{name: "string", arg: "s", want: "s"},
+		{name: "bytes", arg: []byte("b"), want: "b"},
+		{name: "int", arg: -5, want: "-5"},
+		{name: "uint64", arg: uint64(5), want: "5"},
+		{name: "float", arg: 1.5, want: "1.5"},
+		{name: "bool", arg: false, want: "0"},
+		{name: "duration", arg: time.Microsecond, want: "1000"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := normalizeDel(tt.values)
-			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want, argToString(tt.arg))
 		})
 	}
 }
 
-func TestNormalizeMGet(t *testing.T) {
+func TestTransformExists(t *testing.T) {
 	tests := []struct {
-		name   string
-		values []string
-		want   []string
+		name string
+		keys []string
+		want []string
 	}{
 		{
-			name:   "key and fields",
-			values: []string{"v0", "v1", "v2"},
-			want:   []string{"v0", "v1", "v2"},
+			name: "Exists ok",
+			keys: []string{"k1", "k2"},
+			want: []string{"k1", "k2"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := normalizeMGet(tt.values)
-			assert.Equal(t, tt.want, got)
+			got := transformExists(tt.keys...)
+			assert.Equal(t, tt.want, payloadArgs(got))
 		})
 	}
 }
 
-func TestNormalizeHGet(t *testing.T) {
+func TestTransformEcho(t *testing.T) {
 	tests := []struct {
-		name   string
-		values []string
-		key    string
-		field  string
+		name    string
+		message interface{}
+		want    []string
 	}{
 		{
-			name:   "key and fields",
-			values: []string{"key", "f1"},
-			key:    "key",
-			field:  "f1",
+			name:    "string",
+			message: "ping",
+			want:    []string{"ping"},
+		},
+		{
+			name:    "int",
+			message: 42,
+			want:    []string{"42"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			key, field := normalizeHGet(tt.values)
-			assert.Equal(t, tt.key, key)
-			assert.Equal(t, tt.field, field)
+			got := transformEcho(tt.message)
+			assert.Equal(t, tt.want, payloadArgs(got))
 		})
 	}
 }
 
-func TestNormalizeGet(t *testing.T) {
-	tests := []struct {
-		name   string
-		values []string
-		want   string
-	}{
-		{
-			name:   "key and fields",
-			values: []string{"key"},
-			want:   "key",
-		},
-	}
+func TestTransformSafeDel(t *testing.T) {
+	got := transformSafeDel("key", "hash")
+	assert.Equal(t, []string{"key", "hash"}, payloadArgs(got))
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := normalizeGet(tt.values)
-			assert.Equal(t, tt.want, got)
-		})
-	}
+	assert.Equal(t, safeDelPayload{key: "key", expectedType: "hash"}, got)
 }
 
-func TestNormalizeHGetAll(t *testing.T) {
-	tests := []struct {
-		name   string
-		values []string
-		want   string
-	}{
-		{
-			name:   "key and fields",
-			values: []string{"key"},
-			want:   "key",
-		},
-	}
+func TestTransformSet(t *testing.T) {
+	got := transformSet("key", []byte("john"), time.Minute)
+	assert.Equal(t, []string{"key", "john", "60000000000"}, payloadArgs(got))
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := normalizeHGetAll(tt.values)
-			assert.Equal(t, tt.want, got)
-		})
-	}
+	// identical values with other expiration are other commands
+	assert.NotEqual(t, payloadArgs(transformSet("key", "john", 0)), payloadArgs(got))
 }
 
-func TestNormalizeSMembers(t *testing.T) {
-	tests := []struct {
-		name   string
-		values []string
-		want   string
-	}{
-		{
-			name:   "key and fields",
-			values: []string{"key"},
-			want:   "key",
-		},
-	}
+func TestTransformDo(t *testing.T) {
+	got := transformDo("setrange", "key", 6, []byte("redis"))
+	assert.Equal(t, []string{"setrange", "key", "6", "redis"}, payloadArgs(got))
+	assert.Equal(t, []interface{}{"setrange", "key", "6", "redis"}, interfaceArgs(got.(opaquePayload)))
+}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := normalizeGet(tt.values)
-			assert.Equal(t, tt.want, got)
-		})
-	}
+func TestTransformCmd(t *testing.T) {
+	cmd := redis.NewIntCmd(context.TODO(), "setrange", "key", 6, "redis")
+	assert.Equal(t, []string{"setrange", "key", "6", "redis"}, payloadArgs(transformCmd(cmd)))
 }
 
-func TestNormalizeExpire(t *testing.T) {
-	tests := []struct {
-		name   string
-		values []string
-		key    string
-		exp    time.Duration
-	}{
-		{
-			name:   "nanosecond",
-			values: []string{"key", "1"},
-			key:    "key",
-			exp:    time.Nanosecond,
-		},
-		{
-			name:   "1000 nanosecond",
-			values: []string{"key", "1000"},
-			key:    "key",
-			exp:    time.Nanosecond * 1000,
-		},
-		{
-			name:   "microsecond",
-			values: []string{"key", "1000"},
-			key:    "key",
-			exp:    time.Microsecond,
-		},
-	}
+func TestTransformTypedPayload(t *testing.T) {
+	// expiration is passed to go-redis as is, without formatting and parsing it back
+	assert.Equal(t, expirePayload{key: "key", expiration: math.MaxInt64}, transformExpire("key", math.MaxInt64))
+	assert.Equal(t,
+		scriptPayload{script: "myfunc", keys: []string{"k1"}, args: []string{"1"}},
+		transformScript("myfunc", []string{"k1"}, 1))
+	assert.Equal(t, hdelPayload{key: "key", fields: []string{"f1", "f2"}}, transformHDel("key", "f1", "f2"))
+}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			key, exp := normalizeExpire(tt.values)
-			assert.Equal(t, tt.key, key)
-			assert.Equal(t, tt.exp, exp)
-		})
-	}
+func TestTransformCopiesArgs(t *testing.T) {
+	keys := []string{"k1", "k2"}
+	message := []byte("ping")
+	del := transformDel(keys...)
+	script := transformScript("return 1", keys)
+	echo := transformEcho(message)
+
+	// caller may reuse its slices once the command is queued
+	keys[0] = "k3"
+	message[0] = 'x'
+	assert.Equal(t, []string{"k1", "k2"}, payloadArgs(del))
+	assert.Equal(t, []string{"return 1", "2", "k1", "k2"}, payloadArgs(script))
+	assert.Equal(t, []string{"ping"}, payloadArgs(echo))
+}
+
+func TestBinarySafePayload(t *testing.T) {
+	key, field := "k\x00\xff,\r\n", "f,\x00"
+	got := prefixPayload("t:", transformHGet(key, field))
+	assert.Equal(t, hgetPayload{key: "t:" + key, field: field}, got)
+	assert.NotEqual(t, hashPayload(HGet, got), hashPayload(HGet, transformHGet("t:"+key+","+field, "")))
 }
@@ -0,0 +1,112 @@
+package redis_autopipeline
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// defaultAutoTuneInterval is a default interval between adjustments of batching thresholds
+	defaultAutoTuneInterval = time.Second
+	// tuneStep is a factor, by which thresholds are grown or shrunk by single adjustment
+	tuneStep = 1.25
+	// tuneHysteresis is a fraction of target, below which observed value is considered under target,
+	// so thresholds don't flap around it
+	tuneHysteresis = 0.8
+)
+
+// AutoTuning contains targets and bounds of batching thresholds controller, see WithAutoTuning
+type AutoTuning struct {
+	// TargetLatency is a max latency of commands, estimated as ttl window plus p95 execution time of pipelines,
+	// zero means no latency target
+	TargetLatency time.Duration
+	// TargetRate is a desired number of pipelines sent to redis per second, zero means no rate target
+	TargetRate float64
+	// MinTTL and MaxTTL are bounds of ttl
+	MinTTL, MaxTTL time.Duration
+	// MinSize and MaxSize are bounds of max size
+	MinSize, MaxSize uint
+	// Interval is an interval between adjustments, zero means 1s
+	Interval time.Duration
+}
+
+// enabled reports whether any target is set
+func (t AutoTuning) enabled() bool {
+	return t.TargetLatency > 0 || t.TargetRate > 0
+}
+
+// tuner adjusts batching thresholds of the cache to the observed load, it's used by background goroutine only
+type tuner struct {
+	AutoTuning
+	report    func(setting string, old, new interface{}) // reports changes of thresholds
+	last      time.Time                                  // time of the last adjustment, zero until the first check
+	pipelines uint64                                     // number of pipelines at the last adjustment
+}
+
+func newTuner(t AutoTuning, report func(setting string, old, new interface{})) *tuner {
+	if t.Interval == 0 {
+		t.Interval = defaultAutoTuneInterval
+	}
+	return &tuner{AutoTuning: t, report: report}
+}
+
+// factor returns a factor to scale thresholds by, given estimated latency of commands and rate of pipelines:
+// thresholds are shrunk if latency exceeds its target or rate is under its target, so commands wait less,
+// and grown if rate exceeds its target or latency is well under its target, so batches are bigger
+func (t *tuner) factor(latency time.Duration, rate float64) float64 {
+	roomy := t.TargetLatency == 0 || float64(latency) < tuneHysteresis*float64(t.TargetLatency)
+	switch {
+	case t.TargetLatency > 0 && latency > t.TargetLatency:
+		return 1 / tuneStep
+	case t.TargetRate == 0:
+		if roomy {
+			return tuneStep
+		}
+	case rate > t.TargetRate:
+		if roomy {
+			return tuneStep
+		}
+	case rate < tuneHysteresis*t.TargetRate:
+		return 1 / tuneStep
+	}
+	return 1
+}
+
+// tune adjusts ttl and max size once per interval, idle intervals are skipped
+func (c *cache) tune() {
+	t := c.tuner
+	now := c.clock.Now()
+	if !t.last.IsZero() && now.Sub(t.last) < t.Interval {
+		return
+	}
+	s := c.stats.snapshot()
+	elapsed, pipelines := now.Sub(t.last), s.Pipelines-t.pipelines
+	first := t.last.IsZero()
+	t.last, t.pipelines = now, s.Pipelines
+	if first || pipelines == 0 {
+		return
+	}
+	factor := t.factor(c.window()+s.ExecP95, float64(pipelines)/elapsed.Seconds())
+	ttl := time.Duration(c.storageThresholdTime.Load())
+	if v := scale(ttl, factor, t.MinTTL, t.MaxTTL); v != ttl {
+		c.setThresholdTime(v)
+		t.report("ttl", Duration(ttl), Duration(v))
+	}
+	size := uint(c.storageThresholdSize.Load())
+	if v := scale(size, factor, t.MinSize, t.MaxSize); v != size {
+		c.setThresholdSize(v)
+		t.report("max_size", size, v)
+	}
+}
+
+// scale multiplies v by factor, so it changes by one at least, and clamps it to [lo, hi]
+func scale[T time.Duration | uint](v T, factor float64, lo, hi T) T {
+	scaled := T(math.Round(float64(v) * factor))
+	switch {
+	case factor > 1 && scaled == v:
+		scaled++
+	case factor < 1 && scaled == v && v > 0:
+		scaled--
+	}
+	return min(max(scaled, lo), hi)
+}
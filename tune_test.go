@@ -0,0 +1,167 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTunerFactor(t *testing.T) {
+	tests := []struct {
+		name    string
+		tuning  AutoTuning
+		latency time.Duration
+		rate    float64
+		want    float64
+	}{
+		{
+			name:    "latency over target",
+			tuning:  AutoTuning{TargetLatency: time.Millisecond},
+			latency: 2 * time.Millisecond,
+			want:    1 / tuneStep,
+		},
+		{
+			name:    "latency well under target",
+			tuning:  AutoTuning{TargetLatency: time.Millisecond},
+			latency: 500 * time.Microsecond,
+			want:    tuneStep,
+		},
+		{
+			name:    "latency close to target",
+			tuning:  AutoTuning{TargetLatency: time.Millisecond},
+			latency: 900 * time.Microsecond,
+			want:    1,
+		},
+		{
+			name:   "rate over target",
+			tuning: AutoTuning{TargetRate: 1000},
+			rate:   2000,
+			want:   tuneStep,
+		},
+		{
+			name:   "rate under target",
+			tuning: AutoTuning{TargetRate: 1000},
+			rate:   500,
+			want:   1 / tuneStep,
+		},
+		{
+			name:   "rate close to target",
+			tuning: AutoTuning{TargetRate: 1000},
+			rate:   900,
+			want:   1,
+		},
+		{
+			name:    "latency over target wins over rate",
+			tuning:  AutoTuning{TargetLatency: time.Millisecond, TargetRate: 1000},
+			latency: 2 * time.Millisecond,
+			rate:    2000,
+			want:    1 / tuneStep,
+		},
+		{
+			name:    "rate over target close to latency target",
+			tuning:  AutoTuning{TargetLatency: time.Millisecond, TargetRate: 1000},
+			latency: 900 * time.Microsecond,
+			rate:    2000,
+			want:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tn := newTuner(tt.tuning, nil)
+			assert.Equal(t, tt.want, tn.factor(tt.latency, tt.rate))
+		})
+	}
+}
+
+func TestScale(t *testing.T) {
+	assert.Equal(t, uint(2), scale(uint(1), tuneStep, 1, 100))
+	assert.Equal(t, uint(125), scale(uint(100), tuneStep, 1, 200))
+	assert.Equal(t, uint(150), scale(uint(140), tuneStep, 1, 150))
+	assert.Equal(t, uint(1), scale(uint(2), 1/tuneStep, 1, 100))
+	assert.Equal(t, uint(1), scale(uint(1), 1/tuneStep, 1, 100))
+	assert.Equal(t, 800*time.Microsecond, scale(time.Millisecond, 1/tuneStep, time.Microsecond, time.Second))
+	assert.Equal(t, time.Millisecond, scale(time.Millisecond, 1, time.Microsecond, time.Second))
+}
+
+// changesRecorder collects config changes reported by background goroutine
+type changesRecorder struct {
+	mx      sync.Mutex
+	changes []ConfigChange
+}
+
+func (r *changesRecorder) hook(change ConfigChange) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.changes = append(r.changes, change)
+}
+
+func (r *changesRecorder) get() []ConfigChange {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return append([]ConfigChange(nil), r.changes...)
+}
+
+func TestAutoTuning(t *testing.T) {
+	ctx := context.TODO()
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(&mgetHook{})
+
+	tests := []struct {
+		name     string
+		tuning   AutoTuning
+		wantTTL  time.Duration
+		wantSize uint
+	}{
+		{
+			name: "grown to the latency budget",
+			tuning: AutoTuning{
+				TargetLatency: time.Second,
+				MinTTL:        100 * time.Microsecond,
+				MaxTTL:        2 * time.Millisecond,
+				MinSize:       10,
+				MaxSize:       200,
+				Interval:      time.Millisecond,
+			},
+			wantTTL:  2 * time.Millisecond,
+			wantSize: 200,
+		},
+		{
+			name: "shrunk under the target rate",
+			tuning: AutoTuning{
+				TargetRate: 1e9,
+				MinTTL:     100 * time.Microsecond,
+				MaxTTL:     2 * time.Millisecond,
+				MinSize:    10,
+				MaxSize:    200,
+				Interval:   time.Millisecond,
+			},
+			wantTTL:  100 * time.Microsecond,
+			wantSize: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := &changesRecorder{}
+			c, err := NewAutoPipeline(db,
+				WithCacheTTL(time.Millisecond),
+				WithMaxSize(100),
+				WithAutoTuning(tt.tuning),
+				WithConfigChangeHook(changes.hook))
+			assert.Nil(t, err)
+			defer c.Stop()
+
+			assert.Eventually(t, func() bool {
+				assert.Equal(t, "key", c.Get(ctx, "key").Val())
+				cfg := c.Config()
+				return time.Duration(cfg.TTL) == tt.wantTTL && cfg.MaxSize == tt.wantSize
+			}, 5*time.Second, time.Microsecond)
+			got := changes.get()
+			assert.NotEmpty(t, got)
+			assert.Contains(t, []string{"ttl", "max_size"}, got[0].Setting)
+			assert.Equal(t, Duration(tt.tuning.Interval), c.Config().AutoTuneInterval)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+)
+
+// txClient builds transactional pipelines, so commands of every pipeline are wrapped into MULTI/EXEC,
+// see WithTransactional
+type txClient struct {
+	redis.UniversalClient
+}
+
+func (c txClient) Pipeline() redis.Pipeliner {
+	return c.TxPipeline()
+}
+
+// pipelineClient returns the client in use, which builds transactional pipelines, if tx is set
+func (c *cache) pipelineClient(tx bool) (int32, redis.UniversalClient) {
+	endpoint, client := c.endpoints.current()
+	if tx {
+		client = txClient{client}
+	}
+	return endpoint, client
+}
+
+// submitTx executes commands of the batch atomically in a single transaction without queueing
+func (c *cache) submitTx(ctx context.Context, cmds []batchCommand) []*Handle {
+	if err := c.accepts(); err != nil {
+		handles := make([]*Handle, len(cmds))
+		for i, cmd := range cmds {
+			c.rejected(cmd.kind, cmd.payload, err)
+			handles[i] = &Handle{err: err}
+		}
+		return handles
+	}
+	return c.directBatch(ctx, cmds, true)
+}
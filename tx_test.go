@@ -0,0 +1,69 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTransactional(t *testing.T) {
+	ctx := context.TODO()
+	hook := &mgetHook{}
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(hook)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithTransactional(true))
+	assert.Nil(t, err)
+	defer c.Stop()
+	assert.True(t, c.Config().Transactional)
+
+	c.Pause()
+	del := c.DelAsync(ctx, "key1")
+	expire := c.ExpireAsync(ctx, "key2", time.Minute)
+	c.Resume()
+	assert.Equal(t, int64(1), (<-del).(*redis.IntCmd).Val())
+	assert.Nil(t, (<-expire).(*redis.BoolCmd).Err())
+
+	// commands of the flush are wrapped into a single transaction
+	names := hook.commands()
+	assert.Len(t, names, 4)
+	assert.Equal(t, "multi", names[0])
+	assert.ElementsMatch(t, []string{"del", "expire"}, names[1:3])
+	assert.Equal(t, "exec", names[3])
+}
+
+func TestTxGroup(t *testing.T) {
+	ctx := context.TODO()
+	hook := &mgetHook{}
+	db := redis.NewClient(&redis.Options{})
+	db.AddHook(hook)
+
+	c, err := NewAutoPipeline(db, WithKeyPrefix("app:"))
+	assert.Nil(t, err)
+	c.Pause()
+	results := c.TxGroup(ctx, func(b *Batch) {
+		b.Del("key1")
+		b.Expire("key1", time.Minute)
+		b.Get("key2")
+	})
+	// commands are executed right away, though autopipeline is paused
+	assert.Len(t, results, 3)
+	cmd, err := results[0].Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), cmd.(*redis.IntCmd).Val())
+	cmd, err = results[2].Await(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "app:key2", cmd.(*redis.StringCmd).Val())
+	assert.Equal(t, []string{"multi", "del", "expire", "get", "exec"}, hook.commands())
+	c.Stop()
+
+	results = c.TxGroup(ctx, func(b *Batch) {
+		b.Del("key1")
+	})
+	_, err = results[0].Await(ctx)
+	assert.ErrorIs(t, err, ErrCacheStopped)
+}
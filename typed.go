@@ -0,0 +1,112 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// TypedClient is a typed mode of the Client, its async commands return channels of concrete redis commands,
+// f.e. HGetAsync returns <-chan *redis.StringCmd, so results don't need type assertions,
+// channel is closed without result in the same cases as the channel of *Async methods of Client
+type TypedClient interface {
+	HDelAsync(ctx context.Context, key string, fields ...string) <-chan *redis.IntCmd
+	ExpireAsync(ctx context.Context, key string, expiration time.Duration) <-chan *redis.BoolCmd
+	HGetAsync(ctx context.Context, key, field string) <-chan *redis.StringCmd
+	HGetAllAsync(ctx context.Context, key string) <-chan *redis.MapStringStringCmd
+	GetAsync(ctx context.Context, key string) <-chan *redis.StringCmd
+	DelAsync(ctx context.Context, keys ...string) <-chan *redis.IntCmd
+	SMembersAsync(ctx context.Context, key string) <-chan *redis.StringSliceCmd
+	MGetAsync(ctx context.Context, keys ...string) <-chan *redis.SliceCmd
+	EvalROAsync(ctx context.Context, script string, keys []string, args ...interface{}) <-chan *redis.Cmd
+	FCallROAsync(ctx context.Context, function string, keys []string, args ...interface{}) <-chan *redis.Cmd
+	ExistsAsync(ctx context.Context, keys ...string) <-chan *redis.IntCmd
+	TimeAsync(ctx context.Context) <-chan *redis.TimeCmd
+	EchoAsync(ctx context.Context, message interface{}) <-chan *redis.StringCmd
+	SafeDelAsync(ctx context.Context, key, expectedType string) <-chan *redis.IntCmd
+	SetAsync(ctx context.Context, key string, value interface{}, expiration time.Duration) <-chan *redis.StatusCmd
+	DoAsync(ctx context.Context, args ...interface{}) <-chan *redis.Cmd
+}
+
+type typedClient struct {
+	cache *cache
+}
+
+// typed forwards the result of untyped channel to the channel of concrete redis command,
+// typed channel is buffered, so abandoned channel doesn't block the forwarding goroutine
+func typed[T redis.Cmder](ch <-chan interface{}) <-chan T {
+	typedCh := make(chan T, resultChannelBufferSize)
+	go func() {
+		defer close(typedCh)
+		for res := range ch {
+			if cmd, ok := res.(T); ok {
+				typedCh <- cmd
+			}
+		}
+	}()
+	return typedCh
+}
+
+func (a typedClient) HDelAsync(ctx context.Context, key string, fields ...string) <-chan *redis.IntCmd {
+	return typed[*redis.IntCmd](a.cache.enqueue(ctx, HDel, transformHDel(key, fields...)))
+}
+
+func (a typedClient) ExpireAsync(ctx context.Context, key string, expiration time.Duration) <-chan *redis.BoolCmd {
+	return typed[*redis.BoolCmd](a.cache.enqueue(ctx, Expire, transformExpire(key, expiration)))
+}
+
+func (a typedClient) HGetAsync(ctx context.Context, key, field string) <-chan *redis.StringCmd {
+	return typed[*redis.StringCmd](a.cache.enqueue(ctx, HGet, transformHGet(key, field)))
+}
+
+func (a typedClient) HGetAllAsync(ctx context.Context, key string) <-chan *redis.MapStringStringCmd {
+	return typed[*redis.MapStringStringCmd](a.cache.enqueue(ctx, HGetAll, transformHGetAll(key)))
+}
+
+func (a typedClient) GetAsync(ctx context.Context, key string) <-chan *redis.StringCmd {
+	return typed[*redis.StringCmd](a.cache.enqueue(ctx, Get, transformGet(key)))
+}
+
+func (a typedClient) DelAsync(ctx context.Context, keys ...string) <-chan *redis.IntCmd {
+	return typed[*redis.IntCmd](a.cache.enqueue(ctx, Del, transformDel(keys...)))
+}
+
+func (a typedClient) SMembersAsync(ctx context.Context, key string) <-chan *redis.StringSliceCmd {
+	return typed[*redis.StringSliceCmd](a.cache.enqueue(ctx, SMembers, transformSMembers(key)))
+}
+
+func (a typedClient) MGetAsync(ctx context.Context, keys ...string) <-chan *redis.SliceCmd {
+	return typed[*redis.SliceCmd](a.cache.enqueue(ctx, MGet, transformMGet(keys...)))
+}
+
+func (a typedClient) EvalROAsync(ctx context.Context, script string, keys []string, args ...interface{}) <-chan *redis.Cmd {
+	return typed[*redis.Cmd](a.cache.enqueue(ctx, EvalRO, transformScript(script, keys, args...)))
+}
+
+func (a typedClient) FCallROAsync(ctx context.Context, function string, keys []string, args ...interface{}) <-chan *redis.Cmd {
+	return typed[*redis.Cmd](a.cache.enqueue(ctx, FCallRO, transformScript(function, keys, args...)))
+}
+
+func (a typedClient) ExistsAsync(ctx context.Context, keys ...string) <-chan *redis.IntCmd {
+	return typed[*redis.IntCmd](a.cache.enqueue(ctx, Exists, transformExists(keys...)))
+}
+
+func (a typedClient) TimeAsync(ctx context.Context) <-chan *redis.TimeCmd {
+	return typed[*redis.TimeCmd](a.cache.enqueue(ctx, Time, transformTime()))
+}
+
+func (a typedClient) EchoAsync(ctx context.Context, message interface{}) <-chan *redis.StringCmd {
+	return typed[*redis.StringCmd](a.cache.enqueue(ctx, Echo, transformEcho(message)))
+}
+
+func (a typedClient) SafeDelAsync(ctx context.Context, key, expectedType string) <-chan *redis.IntCmd {
+	return typed[*redis.IntCmd](a.cache.enqueue(ctx, SafeDel, transformSafeDel(key, expectedType)))
+}
+
+func (a typedClient) SetAsync(ctx context.Context, key string, value interface{}, expiration time.Duration) <-chan *redis.StatusCmd {
+	return typed[*redis.StatusCmd](a.cache.enqueue(ctx, Set, transformSet(key, value, expiration)))
+}
+
+func (a typedClient) DoAsync(ctx context.Context, args ...interface{}) <-chan *redis.Cmd {
+	return typed[*redis.Cmd](a.cache.enqueue(ctx, Do, transformDo(args...)))
+}
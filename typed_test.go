@@ -0,0 +1,43 @@
+package redis_autopipeline
+
+import (
+	"context"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTyped(t *testing.T) {
+	var ctx = context.TODO()
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHGet("key1", "name").SetVal("john")
+	mock.ExpectHDel("key1", "name").SetVal(1)
+	mock.ExpectExpire("key1", time.Second).SetVal(true)
+	mock.MatchExpectationsInOrder(false)
+
+	c, err := NewAutoPipeline(db,
+		WithCacheTTL(time.Microsecond*100),
+		WithMaxSize(200),
+		WithLogger(&errorRecorder{}))
+	assert.Nil(t, err)
+
+	c.Pause()
+	hGetCh := c.Typed().HGetAsync(ctx, "key1", "name")
+	hDelCh := c.Typed().HDelAsync(ctx, "key1", "name")
+	expireCh := c.Typed().ExpireAsync(ctx, "key1", time.Second)
+	c.Resume()
+
+	assert.Equal(t, "john", (<-hGetCh).Val())
+	assert.Equal(t, int64(1), (<-hDelCh).Val())
+	assert.True(t, (<-expireCh).Val())
+
+	// channel is closed after delivery
+	_, ok := <-hGetCh
+	assert.False(t, ok)
+
+	// channel is closed without result if cache is stopped
+	c.Stop()
+	_, ok = <-c.Typed().GetAsync(ctx, "key1")
+	assert.False(t, ok)
+}
@@ -0,0 +1,37 @@
+// Package zaplog adapts zap logger to the Logger interface of redis_autopipeline,
+// so zap isn't a dependency of autopipeline itself
+package zaplog
+
+import (
+	"go.uber.org/zap"
+)
+
+// Logger is a Logger of autopipeline, which writes to zap logger, it supports all levels of LeveledLogger, see WithLogLevel
+type Logger struct {
+	l *zap.SugaredLogger
+}
+
+// New returns Logger writing to l, f.e. redis_autopipeline.WithLogger(zaplog.New(l))
+func New(l *zap.Logger) *Logger {
+	return &Logger{l: l.Sugar()}
+}
+
+// Error logs args at error level, spaces are always added between them, as fmt.Println does
+func (l *Logger) Error(args ...interface{}) {
+	l.l.Errorln(args...)
+}
+
+// Debug logs args at debug level, spaces are always added between them, as fmt.Println does
+func (l *Logger) Debug(args ...interface{}) {
+	l.l.Debugln(args...)
+}
+
+// Info logs args at info level, spaces are always added between them, as fmt.Println does
+func (l *Logger) Info(args ...interface{}) {
+	l.l.Infoln(args...)
+}
+
+// Warn logs args at warn level, spaces are always added between them, as fmt.Println does
+func (l *Logger) Warn(args ...interface{}) {
+	l.l.Warnln(args...)
+}
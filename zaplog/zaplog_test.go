@@ -0,0 +1,32 @@
+package zaplog
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"redis-autopipeline"
+	"testing"
+)
+
+// Logger is passed to WithLogger as leveled one
+var _ redis_autopipeline.LeveledLogger = &Logger{}
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := New(zap.New(core))
+	l.Error("recovered:", 42)
+	// debug level is disabled by the logger
+	l.Debug("flush by ttl")
+	l.Info("ttl changed")
+	l.Warn("slow pipeline")
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 3)
+	assert.Equal(t, zapcore.ErrorLevel, entries[0].Level)
+	assert.Equal(t, "recovered: 42", entries[0].Message)
+	assert.Equal(t, zapcore.InfoLevel, entries[1].Level)
+	assert.Equal(t, "ttl changed", entries[1].Message)
+	assert.Equal(t, zapcore.WarnLevel, entries[2].Level)
+	assert.Equal(t, "slow pipeline", entries[2].Message)
+}